@@ -0,0 +1,570 @@
+// Package config loads and validates the server configuration.
+package config
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/mail"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-yaml"
+	"github.com/redis/go-redis/v9"
+)
+
+const redactedValue = "[redacted]"
+
+// Config is the server configuration. It is loaded once at startup from
+// defaults, an optional YAML file and the environment (including .env), in
+// increasing order of precedence, and handed to each subsystem.
+type Config struct {
+	Port          int    `yaml:"port"`
+	PublicURL     string `yaml:"public_url"`
+	FrontendURL   string `yaml:"frontend_url"`
+	EncryptionKey string `yaml:"encryption_key"`
+	RedisURL      string `yaml:"redis_url"`
+
+	HTTP     HTTPConfig     `yaml:"http"`
+	CORS     CORSConfig     `yaml:"cors"`
+	Log      LogConfig      `yaml:"log"`
+	Supabase SupabaseConfig `yaml:"supabase"`
+	Email    EmailConfig    `yaml:"email"`
+	Storage  StorageConfig  `yaml:"storage"`
+	Limits   LimitsConfig   `yaml:"limits"`
+	Send     SendConfig     `yaml:"send"`
+}
+
+type HTTPConfig struct {
+	ReadTimeout  Duration `yaml:"read_timeout"`
+	WriteTimeout Duration `yaml:"write_timeout"`
+	IdleTimeout  Duration `yaml:"idle_timeout"`
+	// How long a stopping server waits for in-flight requests and
+	// background tasks before cancelling them
+	ShutdownTimeout Duration `yaml:"shutdown_timeout"`
+}
+
+type CORSConfig struct {
+	// Origins allowed to call the API from a browser, besides frontend_url.
+	// Either exact ("https://replied.app") or a wildcard subdomain
+	// ("https://*.replied.app", which doesn't match the bare domain).
+	AllowedOrigins []string `yaml:"allowed_origins"`
+	// How long browsers may cache a preflight response
+	MaxAge Duration `yaml:"max_age"`
+	// Dev also allows any localhost origin. Never enable it in production.
+	Dev bool `yaml:"dev"`
+	// What cross-origin callers may send to routes outside any group
+	AllowedMethods []string `yaml:"allowed_methods"`
+	AllowedHeaders []string `yaml:"allowed_headers"`
+	// Groups replace the methods and headers above for routes under a path
+	// prefix; the longest prefix wins. A group without headers allows none.
+	Groups map[string]CORSRule `yaml:"groups"`
+}
+
+// CORSRule is what cross-origin callers may send to a group of routes.
+type CORSRule struct {
+	Methods []string `yaml:"methods"`
+	Headers []string `yaml:"headers,omitempty"`
+}
+
+type LogConfig struct {
+	Level string `yaml:"level"` // debug, info, warn or error
+	// Redact masks user IDs, emails, usernames, IPs and message content in
+	// logs. Only turn it off for local debugging.
+	Redact bool `yaml:"redact"`
+}
+
+type SupabaseConfig struct {
+	URL            string `yaml:"url"`
+	ServiceRoleKey string `yaml:"service_role_key"`
+}
+
+type EmailConfig struct {
+	ResendAPIKey string `yaml:"resend_api_key"`
+	From         string `yaml:"from"`
+}
+
+type StorageConfig struct {
+	Backend      string `yaml:"backend"` // supabase or local
+	AvatarBucket string `yaml:"avatar_bucket"`
+	ExportBucket string `yaml:"export_bucket"`
+	LocalDir     string `yaml:"local_dir"`
+}
+
+type LimitsConfig struct {
+	SendPerWindow        int      `yaml:"send_per_window"`
+	SendWindow           Duration `yaml:"send_window"`
+	AccountDeletionGrace Duration `yaml:"account_deletion_grace"`
+	// Background tasks (emails, exports, imports) allowed to run at once
+	BackgroundWorkers int `yaml:"background_workers"`
+}
+
+// SendConfig protects inboxes: the human verification anonymous senders
+// must pass, and how much one sender may send one receiver.
+type SendConfig struct {
+	Verification string `yaml:"verification"` // off or pow
+	// Proof of work difficulty in leading zero bits. Each bit doubles the
+	// expected work; difficulty rises from the base towards the max as a
+	// receiver gets busier.
+	BaseDifficulty int      `yaml:"base_difficulty"`
+	MaxDifficulty  int      `yaml:"max_difficulty"`
+	ChallengeTTL   Duration `yaml:"challenge_ttl"`
+
+	// Messages one sender may send one receiver per ReceiverWindow. Signed
+	// in senders are counted by account, anonymous ones by a fingerprint of
+	// their IP and browser.
+	PerReceiverAccount   int      `yaml:"per_receiver_account"`
+	PerReceiverAnonymous int      `yaml:"per_receiver_anonymous"`
+	ReceiverWindow       Duration `yaml:"receiver_window"`
+	// The same message (ignoring case, punctuation and repeated letters)
+	// can't be sent to the same receiver twice within this window
+	DuplicateWindow Duration `yaml:"duplicate_window"`
+}
+
+// Duration is a time.Duration written as a Go duration string ("10m") in YAML.
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return d.String(), nil
+}
+
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		Port:        8080,
+		PublicURL:   "http://localhost:8080",
+		FrontendURL: "http://localhost:3000",
+		HTTP: HTTPConfig{
+			ReadTimeout:     Duration{30 * time.Second},
+			WriteTimeout:    Duration{60 * time.Second},
+			IdleTimeout:     Duration{2 * time.Minute},
+			ShutdownTimeout: Duration{25 * time.Second},
+		},
+		CORS: CORSConfig{
+			MaxAge:         Duration{10 * time.Minute},
+			AllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE"},
+			AllowedHeaders: []string{"Authorization", "Content-Type", "X-Request-ID"},
+			Groups: map[string]CORSRule{
+				// Avatars are plain files
+				"/media/":  {Methods: []string{"GET", "HEAD"}},
+				"/healthz": {Methods: []string{"GET"}},
+				"/readyz":  {Methods: []string{"GET"}},
+				"/metrics": {Methods: []string{"GET"}},
+			},
+		},
+		Log: LogConfig{
+			Level:  "info",
+			Redact: true,
+		},
+		Email: EmailConfig{
+			From: "Replied <noreply@marvlock.dev>",
+		},
+		Storage: StorageConfig{
+			Backend:      "supabase",
+			AvatarBucket: "avatars",
+			ExportBucket: "exports",
+			LocalDir:     "./data/blobs",
+		},
+		Limits: LimitsConfig{
+			SendPerWindow:        5,
+			SendWindow:           Duration{10 * time.Minute},
+			AccountDeletionGrace: Duration{7 * 24 * time.Hour},
+			BackgroundWorkers:    8,
+		},
+		Send: SendConfig{
+			Verification:   "off",
+			BaseDifficulty: 16,
+			MaxDifficulty:  22,
+			ChallengeTTL:   Duration{2 * time.Minute},
+
+			PerReceiverAccount:   10,
+			PerReceiverAnonymous: 3,
+			ReceiverWindow:       Duration{time.Hour},
+			DuplicateWindow:      Duration{24 * time.Hour},
+		},
+	}
+}
+
+// envBindings maps environment variables onto config fields.
+func (c *Config) envBindings() map[string]interface{} {
+	return map[string]interface{}{
+		"PORT":                      &c.Port,
+		"PUBLIC_URL":                &c.PublicURL,
+		"FRONTEND_URL":              &c.FrontendURL,
+		"ENCRYPTION_KEY":            &c.EncryptionKey,
+		"UPSTASH_REDIS_URL":         &c.RedisURL,
+		"HTTP_READ_TIMEOUT":         &c.HTTP.ReadTimeout,
+		"HTTP_WRITE_TIMEOUT":        &c.HTTP.WriteTimeout,
+		"HTTP_IDLE_TIMEOUT":         &c.HTTP.IdleTimeout,
+		"SHUTDOWN_TIMEOUT":          &c.HTTP.ShutdownTimeout,
+		"CORS_ALLOWED_ORIGINS":      &c.CORS.AllowedOrigins,
+		"CORS_MAX_AGE":              &c.CORS.MaxAge,
+		"CORS_DEV":                  &c.CORS.Dev,
+		"CORS_ALLOWED_METHODS":      &c.CORS.AllowedMethods,
+		"CORS_ALLOWED_HEADERS":      &c.CORS.AllowedHeaders,
+		"CORS_GROUPS":               &c.CORS.Groups,
+		"LOG_LEVEL":                 &c.Log.Level,
+		"LOG_REDACT":                &c.Log.Redact,
+		"SUPABASE_URL":              &c.Supabase.URL,
+		"SUPABASE_SERVICE_ROLE_KEY": &c.Supabase.ServiceRoleKey,
+		"RESEND_API_KEY":            &c.Email.ResendAPIKey,
+		"EMAIL_FROM":                &c.Email.From,
+		"BLOB_STORE":                &c.Storage.Backend,
+		"AVATAR_BUCKET":             &c.Storage.AvatarBucket,
+		"EXPORT_BUCKET":             &c.Storage.ExportBucket,
+		"BLOB_LOCAL_DIR":            &c.Storage.LocalDir,
+		"SEND_RATE_LIMIT":           &c.Limits.SendPerWindow,
+		"SEND_RATE_WINDOW":          &c.Limits.SendWindow,
+		"ACCOUNT_DELETION_GRACE":    &c.Limits.AccountDeletionGrace,
+		"BACKGROUND_WORKERS":        &c.Limits.BackgroundWorkers,
+		"SEND_VERIFICATION":         &c.Send.Verification,
+		"SEND_POW_DIFFICULTY":       &c.Send.BaseDifficulty,
+		"SEND_POW_MAX_DIFFICULTY":   &c.Send.MaxDifficulty,
+		"SEND_CHALLENGE_TTL":        &c.Send.ChallengeTTL,
+		"SEND_PER_RECEIVER_ACCOUNT": &c.Send.PerReceiverAccount,
+		"SEND_PER_RECEIVER_ANON":    &c.Send.PerReceiverAnonymous,
+		"SEND_RECEIVER_WINDOW":      &c.Send.ReceiverWindow,
+		"SEND_DUPLICATE_WINDOW":     &c.Send.DuplicateWindow,
+	}
+}
+
+// Load builds the configuration from defaults, the YAML file at path (if
+// any) and the environment. It does not validate; call Validate.
+func Load(path string) (*Config, error) {
+	cfg := defaultConfig()
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading config file: %w", err)
+		}
+		if err := yaml.UnmarshalWithOptions(data, cfg, yaml.Strict()); err != nil {
+			return nil, fmt.Errorf("parsing config file %s: %w", path, err)
+		}
+	}
+
+	var problems []string
+	for name, field := range cfg.envBindings() {
+		value, ok := os.LookupEnv(name)
+		if !ok || value == "" {
+			continue
+		}
+		switch f := field.(type) {
+		case *string:
+			*f = value
+		case *int:
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s must be a number", name))
+				continue
+			}
+			*f = n
+		case *bool:
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s must be true or false", name))
+				continue
+			}
+			*f = b
+		case *[]string:
+			// Comma separated
+			*f = nil
+			for _, item := range strings.Split(value, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					*f = append(*f, item)
+				}
+			}
+		case *map[string]CORSRule:
+			// A YAML or JSON object, replacing the groups entirely
+			groups := map[string]CORSRule{}
+			if err := yaml.UnmarshalWithOptions([]byte(value), &groups, yaml.Strict()); err != nil {
+				problems = append(problems, fmt.Sprintf(`%s must be a map such as {"/media/": {"methods": ["GET"]}}`, name))
+				continue
+			}
+			*f = groups
+		case *Duration:
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s must be a duration such as 10m or 168h", name))
+				continue
+			}
+			f.Duration = d
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return nil, configError(problems)
+	}
+
+	cfg.PublicURL = strings.TrimRight(cfg.PublicURL, "/")
+	cfg.FrontendURL = strings.TrimRight(cfg.FrontendURL, "/")
+	for i, origin := range cfg.CORS.AllowedOrigins {
+		cfg.CORS.AllowedOrigins[i] = strings.TrimRight(origin, "/")
+	}
+	upper(cfg.CORS.AllowedMethods)
+	for _, rule := range cfg.CORS.Groups {
+		upper(rule.Methods)
+	}
+	return cfg, nil
+}
+
+// upper uppercases HTTP methods in place.
+func upper(methods []string) {
+	for i, m := range methods {
+		methods[i] = strings.ToUpper(strings.TrimSpace(m))
+	}
+}
+
+func configError(problems []string) error {
+	return errors.New("invalid configuration:\n  - " + strings.Join(problems, "\n  - "))
+}
+
+// validHTTPURL reports whether raw is an absolute http(s) URL.
+func validHTTPURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// validOrigin reports whether raw is a bare http(s) origin, optionally with
+// a "*." wildcard in front of the host.
+func validOrigin(raw string) bool {
+	u, err := url.Parse(strings.Replace(raw, "://*.", "://", 1))
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" &&
+		u.Path == "" && u.RawQuery == "" && u.Fragment == "" && u.User == nil && !strings.Contains(u.Host, "*")
+}
+
+// corsMethods are the methods a CORS rule may allow; OPTIONS is always
+// answered.
+var corsMethods = map[string]bool{"GET": true, "HEAD": true, "POST": true, "PUT": true, "PATCH": true, "DELETE": true}
+
+// validMethods returns the problems with the methods a CORS rule allows.
+func validMethods(name string, methods []string) []string {
+	if len(methods) == 0 {
+		return []string{name + " must allow at least one method"}
+	}
+	var problems []string
+	for _, m := range methods {
+		if !corsMethods[m] {
+			problems = append(problems, fmt.Sprintf("%s: %q must be one of GET, HEAD, POST, PUT, PATCH or DELETE", name, m))
+		}
+	}
+	return problems
+}
+
+// validHeaders returns the problems with the headers a CORS rule allows.
+func validHeaders(name string, headers []string) []string {
+	var problems []string
+	for _, h := range headers {
+		if !validHeaderName(h) {
+			problems = append(problems, fmt.Sprintf("%s: %q is not a valid header name", name, h))
+		}
+	}
+	return problems
+}
+
+// validHeaderName reports whether h is an HTTP header name (an RFC 9110
+// token).
+func validHeaderName(h string) bool {
+	if h == "" {
+		return false
+	}
+	for _, r := range h {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("!#$%&'*+-.^_`|~", r)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Validate checks every setting and reports all problems at once.
+func (c *Config) Validate() error {
+	var problems []string
+
+	if c.Supabase.URL == "" {
+		problems = append(problems, "supabase.url (SUPABASE_URL) is required")
+	} else if !validHTTPURL(c.Supabase.URL) {
+		problems = append(problems, "supabase.url (SUPABASE_URL) must be an http(s) URL")
+	}
+	if c.Supabase.ServiceRoleKey == "" {
+		problems = append(problems, "supabase.service_role_key (SUPABASE_SERVICE_ROLE_KEY) is required")
+	}
+
+	if c.EncryptionKey == "" {
+		problems = append(problems, "encryption_key (ENCRYPTION_KEY) is required")
+	} else if key, err := hex.DecodeString(c.EncryptionKey); err != nil {
+		problems = append(problems, "encryption_key (ENCRYPTION_KEY) must be hex encoded")
+	} else if len(key) != 32 {
+		problems = append(problems, fmt.Sprintf("encryption_key (ENCRYPTION_KEY) must be 32 bytes (64 hex characters) for AES-256, got %d bytes", len(key)))
+	}
+
+	if c.Port < 1 || c.Port > 65535 {
+		problems = append(problems, "port (PORT) must be between 1 and 65535")
+	}
+	if !validHTTPURL(c.PublicURL) {
+		problems = append(problems, "public_url (PUBLIC_URL) must be an http(s) URL")
+	}
+	if !validHTTPURL(c.FrontendURL) {
+		problems = append(problems, "frontend_url (FRONTEND_URL) must be an http(s) URL")
+	}
+	for _, t := range []struct {
+		name  string
+		value Duration
+	}{
+		{"http.read_timeout (HTTP_READ_TIMEOUT)", c.HTTP.ReadTimeout},
+		{"http.write_timeout (HTTP_WRITE_TIMEOUT)", c.HTTP.WriteTimeout},
+		{"http.idle_timeout (HTTP_IDLE_TIMEOUT)", c.HTTP.IdleTimeout},
+		{"http.shutdown_timeout (SHUTDOWN_TIMEOUT)", c.HTTP.ShutdownTimeout},
+	} {
+		if t.value.Duration <= 0 {
+			problems = append(problems, t.name+" must be positive")
+		}
+	}
+	for _, origin := range c.CORS.AllowedOrigins {
+		if !validOrigin(origin) {
+			problems = append(problems, fmt.Sprintf("cors.allowed_origins (CORS_ALLOWED_ORIGINS): %q must be a scheme and host such as https://replied.app or https://*.replied.app", origin))
+		}
+	}
+	if c.CORS.MaxAge.Duration < 0 {
+		problems = append(problems, "cors.max_age (CORS_MAX_AGE) cannot be negative")
+	}
+	problems = append(problems, validMethods("cors.allowed_methods (CORS_ALLOWED_METHODS)", c.CORS.AllowedMethods)...)
+	problems = append(problems, validHeaders("cors.allowed_headers (CORS_ALLOWED_HEADERS)", c.CORS.AllowedHeaders)...)
+	prefixes := make([]string, 0, len(c.CORS.Groups))
+	for prefix := range c.CORS.Groups {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	for _, prefix := range prefixes {
+		name := fmt.Sprintf("cors.groups[%q] (CORS_GROUPS)", prefix)
+		if !strings.HasPrefix(prefix, "/") {
+			problems = append(problems, name+" must be a path starting with /")
+		}
+		problems = append(problems, validMethods(name, c.CORS.Groups[prefix].Methods)...)
+		problems = append(problems, validHeaders(name, c.CORS.Groups[prefix].Headers)...)
+	}
+	switch c.Log.Level {
+	case "debug", "info", "warn", "error":
+	default:
+		problems = append(problems, "log.level (LOG_LEVEL) must be debug, info, warn or error")
+	}
+	if c.RedisURL != "" {
+		if _, err := redis.ParseURL(c.RedisURL); err != nil {
+			problems = append(problems, "redis_url (UPSTASH_REDIS_URL) is not a valid Redis URL")
+		}
+	}
+
+	if _, err := mail.ParseAddress(c.Email.From); err != nil {
+		problems = append(problems, "email.from (EMAIL_FROM) must be an email address such as \"Replied <noreply@example.com>\"")
+	}
+
+	switch c.Storage.Backend {
+	case "supabase":
+		if c.Storage.AvatarBucket == "" || c.Storage.ExportBucket == "" {
+			problems = append(problems, "storage.avatar_bucket and storage.export_bucket are required")
+		} else if c.Storage.AvatarBucket == c.Storage.ExportBucket {
+			problems = append(problems, "storage.export_bucket must differ from the public avatar bucket")
+		}
+	case "local":
+		if c.Storage.LocalDir == "" {
+			problems = append(problems, "storage.local_dir (BLOB_LOCAL_DIR) is required")
+		}
+	default:
+		problems = append(problems, "storage.backend (BLOB_STORE) must be supabase or local")
+	}
+
+	if c.Limits.SendPerWindow < 1 {
+		problems = append(problems, "limits.send_per_window (SEND_RATE_LIMIT) must be at least 1")
+	}
+	if c.Limits.SendWindow.Duration <= 0 {
+		problems = append(problems, "limits.send_window (SEND_RATE_WINDOW) must be positive")
+	}
+	if c.Limits.AccountDeletionGrace.Duration < 0 {
+		problems = append(problems, "limits.account_deletion_grace (ACCOUNT_DELETION_GRACE) cannot be negative")
+	}
+	if c.Limits.BackgroundWorkers < 1 {
+		problems = append(problems, "limits.background_workers (BACKGROUND_WORKERS) must be at least 1")
+	}
+
+	switch c.Send.Verification {
+	case "off":
+	case "pow":
+		if c.Send.BaseDifficulty < 1 || c.Send.MaxDifficulty > 32 || c.Send.BaseDifficulty > c.Send.MaxDifficulty {
+			problems = append(problems, "send.base_difficulty (SEND_POW_DIFFICULTY) and send.max_difficulty (SEND_POW_MAX_DIFFICULTY) must satisfy 1 <= base <= max <= 32")
+		}
+		if c.Send.ChallengeTTL.Duration <= 0 {
+			problems = append(problems, "send.challenge_ttl (SEND_CHALLENGE_TTL) must be positive")
+		}
+	default:
+		problems = append(problems, "send.verification (SEND_VERIFICATION) must be off or pow")
+	}
+	if c.Send.PerReceiverAccount < 1 || c.Send.PerReceiverAnonymous < 1 {
+		problems = append(problems, "send.per_receiver_account (SEND_PER_RECEIVER_ACCOUNT) and send.per_receiver_anonymous (SEND_PER_RECEIVER_ANON) must be at least 1")
+	}
+	if c.Send.ReceiverWindow.Duration <= 0 {
+		problems = append(problems, "send.receiver_window (SEND_RECEIVER_WINDOW) must be positive")
+	}
+	if c.Send.DuplicateWindow.Duration < 0 {
+		problems = append(problems, "send.duplicate_window (SEND_DUPLICATE_WINDOW) cannot be negative")
+	}
+
+	if len(problems) > 0 {
+		return configError(problems)
+	}
+	return nil
+}
+
+// Key returns the decoded content encryption key. Only valid after Validate.
+func (c *Config) Key() []byte {
+	key, _ := hex.DecodeString(c.EncryptionKey)
+	return key
+}
+
+// Redacted returns a copy safe to print, with secrets masked.
+func (c Config) Redacted() Config {
+	redact := func(s string) string {
+		if s == "" {
+			return ""
+		}
+		return redactedValue
+	}
+	c.EncryptionKey = redact(c.EncryptionKey)
+	c.Supabase.ServiceRoleKey = redact(c.Supabase.ServiceRoleKey)
+	c.Email.ResendAPIKey = redact(c.Email.ResendAPIKey)
+	if c.RedisURL != "" {
+		if u, err := url.Parse(c.RedisURL); err == nil {
+			c.RedisURL = u.Redacted()
+		} else {
+			c.RedisURL = redactedValue
+		}
+	}
+	return c
+}
+
+// Print writes the redacted configuration as YAML.
+func (c *Config) Print(w io.Writer) error {
+	out, err := yaml.Marshal(c.Redacted())
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out)
+	return err
+}
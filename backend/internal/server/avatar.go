@@ -0,0 +1,326 @@
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/supabase-community/gotrue-go/types"
+)
+
+const (
+	maxAvatarUploadBytes = 5 << 20
+	maxAvatarDimension   = 4096
+	avatarJPEGQuality    = 85
+)
+
+// avatarVariants are the square sizes every uploaded avatar is resized to.
+// The "medium" variant becomes the profile's avatar_url.
+var avatarVariants = []struct {
+	Name string
+	Size int
+}{
+	{"small", 64},
+	{"medium", 256},
+	{"large", 512},
+}
+
+var errUnsupportedImage = errors.New("unsupported image type")
+
+// sniffImageType checks the magic bytes of an upload, ignoring whatever
+// Content-Type or file extension the client claimed.
+func sniffImageType(data []byte) (string, error) {
+	switch contentType := http.DetectContentType(data); contentType {
+	case "image/jpeg", "image/png", "image/gif":
+		return contentType, nil
+	default:
+		return "", errUnsupportedImage
+	}
+}
+
+// decodeAvatar decodes an image after checking its dimensions, so huge
+// images are rejected before any pixel data is allocated.
+func decodeAvatar(data []byte, contentType string) (image.Image, error) {
+	var decodeConfig func(io.Reader) (image.Config, error)
+	var decode func(io.Reader) (image.Image, error)
+	switch contentType {
+	case "image/jpeg":
+		decodeConfig, decode = jpeg.DecodeConfig, jpeg.Decode
+	case "image/png":
+		decodeConfig, decode = png.DecodeConfig, png.Decode
+	case "image/gif":
+		// Animated GIFs keep only their first frame
+		decodeConfig, decode = gif.DecodeConfig, gif.Decode
+	default:
+		return nil, errUnsupportedImage
+	}
+
+	cfg, err := decodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width > maxAvatarDimension || cfg.Height > maxAvatarDimension {
+		return nil, fmt.Errorf("image is larger than %dx%d", maxAvatarDimension, maxAvatarDimension)
+	}
+
+	return decode(bytes.NewReader(data))
+}
+
+// resizeSquare center-crops src to a square and box-filters it down to size
+// pixels. Images smaller than size are not upscaled.
+func resizeSquare(src image.Image, size int) *image.RGBA {
+	b := src.Bounds()
+	side := b.Dx()
+	if b.Dy() < side {
+		side = b.Dy()
+	}
+	if side < size {
+		size = side
+	}
+
+	// Copy the crop into premultiplied RGBA so it can be averaged directly
+	crop := image.NewRGBA(image.Rect(0, 0, side, side))
+	offset := image.Pt(b.Min.X+(b.Dx()-side)/2, b.Min.Y+(b.Dy()-side)/2)
+	draw.Draw(crop, crop.Bounds(), src, offset, draw.Src)
+
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		sy0, sy1 := y*side/size, (y+1)*side/size
+		if sy1 <= sy0 {
+			sy1 = sy0 + 1
+		}
+		for x := 0; x < size; x++ {
+			sx0, sx1 := x*side/size, (x+1)*side/size
+			if sx1 <= sx0 {
+				sx1 = sx0 + 1
+			}
+
+			var r, g, bl, a, n uint32
+			for sy := sy0; sy < sy1; sy++ {
+				row := crop.Pix[sy*crop.Stride:]
+				for sx := sx0; sx < sx1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					r += uint32(p[0])
+					g += uint32(p[1])
+					bl += uint32(p[2])
+					a += uint32(p[3])
+					n++
+				}
+			}
+
+			i := y*dst.Stride + x*4
+			dst.Pix[i] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(bl / n)
+			dst.Pix[i+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
+
+// encodeAvatar re-encodes a variant. Re-encoding drops EXIF and any other
+// metadata from the original file. JPEGs stay JPEG, everything else becomes
+// PNG to keep transparency.
+func encodeAvatar(img image.Image, sourceType string) ([]byte, string, string, error) {
+	var buf bytes.Buffer
+	if sourceType == "image/jpeg" {
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: avatarJPEGQuality}); err != nil {
+			return nil, "", "", err
+		}
+		return buf.Bytes(), "image/jpeg", "jpg", nil
+	}
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, "", "", err
+	}
+	return buf.Bytes(), "image/png", "png", nil
+}
+
+// avatarAllowed reports whether avatar_url may be set directly: clearing it,
+// keeping the current value, or using the avatar from the user's login
+// provider. Anything else must be uploaded through POST /profile/avatar.
+func avatarAllowed(raw, current string, user types.User) bool {
+	if raw == "" || raw == current {
+		return true
+	}
+	for _, key := range []string{"avatar_url", "picture"} {
+		if provided, ok := user.UserMetadata[key].(string); ok && provided == raw {
+			return true
+		}
+	}
+	return false
+}
+
+// currentAvatar returns a profile's avatar URL and the blob keys of its
+// uploaded variants, if any.
+func (s *Server) currentAvatar(userID string) (string, []string, error) {
+	var profile struct {
+		AvatarURL  *string  `json:"avatar_url"`
+		AvatarKeys []string `json:"avatar_keys"`
+	}
+	_, err := s.db.From("profiles").
+		Select("avatar_url, avatar_keys", "", false).
+		Eq("id", userID).
+		Single().
+		ExecuteTo(&profile)
+	if err != nil {
+		return "", nil, err
+	}
+	current := ""
+	if profile.AvatarURL != nil {
+		current = *profile.AvatarURL
+	}
+	return current, profile.AvatarKeys, nil
+}
+
+// deleteAvatarBlobs removes replaced avatar variants. Failures only leave
+// orphaned files behind, so they are logged rather than returned.
+func (s *Server) deleteAvatarBlobs(keys []string) {
+	if len(keys) == 0 {
+		return
+	}
+	if err := s.blobs.Delete(s.ctx, keys...); err != nil {
+		slog.Error("Failed to delete old avatar blobs", "error", err)
+	}
+}
+
+func (s *Server) registerAvatarRoutes(r *gin.Engine, authMiddleware gin.HandlerFunc) {
+	// Upload Avatar: multipart form with an "avatar" file field
+	r.POST("/profile/avatar", authMiddleware, handle(func(c *gin.Context) error {
+		user, _ := c.Get("user")
+		supabaseUser := user.(types.User)
+		userID := supabaseUser.ID.String()
+
+		// Leave some room for the multipart envelope
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxAvatarUploadBytes+64<<10)
+
+		fileHeader, err := c.FormFile("avatar")
+		if err != nil {
+			return errValidation("Avatar file is required (max 5MB)")
+		}
+		if fileHeader.Size > maxAvatarUploadBytes {
+			return errTooLarge("Avatar must be at most 5MB")
+		}
+
+		file, err := fileHeader.Open()
+		if err != nil {
+			return errValidation("Invalid upload")
+		}
+		data, err := io.ReadAll(io.LimitReader(file, maxAvatarUploadBytes+1))
+		file.Close()
+		if err != nil || len(data) > maxAvatarUploadBytes {
+			return errValidation("Avatar must be at most 5MB")
+		}
+
+		sourceType, err := sniffImageType(data)
+		if err != nil {
+			return errUnsupportedMedia("Avatar must be a JPEG, PNG or GIF image")
+		}
+
+		img, err := decodeAvatar(data, sourceType)
+		if err != nil {
+			return errValidation("Could not read image: " + err.Error())
+		}
+
+		_, oldKeys, err := s.currentAvatar(userID)
+		if isNoRows(err) {
+			return errNotFound("Profile not found")
+		}
+		if err != nil {
+			return errInternal("Failed to upload avatar", fmt.Errorf("fetching avatar: %w", err))
+		}
+
+		// A fresh version per upload keeps CDN and browser caches correct
+		version := make([]byte, 8)
+		if _, err := rand.Read(version); err != nil {
+			return errInternal("Failed to upload avatar", err)
+		}
+		prefix := fmt.Sprintf("avatars/%s/%s", userID, hex.EncodeToString(version))
+
+		variants := make(map[string]string, len(avatarVariants))
+		keys := make([]string, 0, len(avatarVariants))
+		for _, v := range avatarVariants {
+			encoded, contentType, ext, err := encodeAvatar(resizeSquare(img, v.Size), sourceType)
+			if err != nil {
+				s.deleteAvatarBlobs(keys)
+				return errInternal("Failed to process avatar", nil)
+			}
+
+			key := fmt.Sprintf("%s/%s.%s", prefix, v.Name, ext)
+			if err := s.blobs.Put(c.Request.Context(), key, contentType, encoded); err != nil {
+				logFor(c).Error("Failed to store avatar", "error", err)
+				s.deleteAvatarBlobs(keys)
+				return errInternal("Failed to upload avatar", nil)
+			}
+			keys = append(keys, key)
+			variants[v.Name] = s.blobs.URL(key)
+		}
+
+		_, _, err = s.db.From("profiles").
+			Update(map[string]interface{}{
+				"avatar_url":      variants["medium"],
+				"avatar_variants": variants,
+				"avatar_keys":     keys,
+				"updated_at":      "now()",
+			}, "minimal", "").
+			Eq("id", userID).
+			Execute()
+
+		if err != nil {
+			logFor(c).Error("Supabase error saving avatar", "error", err)
+			s.deleteAvatarBlobs(keys)
+			return errInternal("Failed to upload avatar", nil)
+		}
+
+		s.deleteAvatarBlobs(oldKeys)
+		s.invalidateProfileCache(userID)
+
+		c.JSON(http.StatusOK, gin.H{
+			"avatar_url":      variants["medium"],
+			"avatar_variants": variants,
+		})
+		return nil
+	}))
+
+	// Remove Avatar
+	r.DELETE("/profile/avatar", authMiddleware, handle(func(c *gin.Context) error {
+		user, _ := c.Get("user")
+		supabaseUser := user.(types.User)
+		userID := supabaseUser.ID.String()
+
+		_, oldKeys, err := s.currentAvatar(userID)
+		if err != nil {
+			return errNotFound("Profile not found")
+		}
+
+		_, _, err = s.db.From("profiles").
+			Update(map[string]interface{}{
+				"avatar_url":      "",
+				"avatar_variants": nil,
+				"avatar_keys":     nil,
+				"updated_at":      "now()",
+			}, "minimal", "").
+			Eq("id", userID).
+			Execute()
+
+		if err != nil {
+			return errInternal("Failed to remove avatar", err)
+		}
+
+		s.deleteAvatarBlobs(oldKeys)
+		s.invalidateProfileCache(userID)
+
+		c.JSON(http.StatusOK, gin.H{"status": "removed"})
+		return nil
+	}))
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// avatarForm builds a multipart body with data in the "avatar" field.
+func avatarForm(t *testing.T, data []byte) ([]byte, string) {
+	t.Helper()
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	part, err := w.CreateFormFile("avatar", "avatar.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(data)
+	w.Close()
+	return buf.Bytes(), w.FormDataContentType()
+}
+
+func testPNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		img.Set(x, height/2, color.RGBA{R: 255, A: 255})
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestUploadAvatar(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.addUser("alice", nil)
+	upload := func(data []byte) response {
+		body, contentType := avatarForm(t, data)
+		return e.request("POST", "/profile/avatar", alice.Token, body, "Content-Type", contentType)
+	}
+
+	e.request("POST", "/profile/avatar", alice.Token, nil).expect(http.StatusBadRequest)
+	upload([]byte("definitely not an image")).expect(http.StatusUnsupportedMediaType)
+
+	first := upload(testPNG(t, 600, 400)).expect(http.StatusOK).object()
+	variants, _ := first["avatar_variants"].(map[string]interface{})
+	if len(variants) != 3 || first["avatar_url"] != variants["medium"] {
+		t.Fatalf("upload = %v", first)
+	}
+	url := first["avatar_url"].(string)
+	if !strings.HasPrefix(url, "http://api.test/media/avatars/"+alice.ID+"/") {
+		t.Fatalf("avatar_url = %q", url)
+	}
+
+	// Variants are square crops, re-encoded and served locally
+	res := e.request("GET", strings.TrimPrefix(url, "http://api.test"), "", nil).expect(http.StatusOK)
+	img, _, err := image.Decode(res.Body)
+	if err != nil {
+		t.Fatalf("decode medium variant: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != 256 || b.Dy() != 256 {
+		t.Errorf("medium variant is %dx%d, want 256x256", b.Dx(), b.Dy())
+	}
+	if got := e.db.rows("profiles", row{"id": alice.ID})[0]["avatar_url"]; got != url {
+		t.Errorf("profile avatar_url = %v", got)
+	}
+
+	// Saving the profile resends the local http URL unchanged
+	e.request("PATCH", "/profile", alice.Token, map[string]string{"avatar_url": url, "bio": "hi"}).expect(http.StatusOK)
+	if got := e.db.rows("profiles", row{"id": alice.ID})[0]; got["avatar_url"] != url || got["avatar_variants"] == nil {
+		t.Errorf("profile after resave = %v", got)
+	}
+
+	// A new upload replaces the old files
+	second := upload(testPNG(t, 64, 64)).expect(http.StatusOK).object()
+	if second["avatar_url"] == url {
+		t.Error("avatar URL was reused")
+	}
+	if files := avatarFiles(t, e.blobs); len(files) != 3 {
+		t.Errorf("stored files = %v, want only the 3 new variants", files)
+	}
+}
+
+func TestRemoveAvatar(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.addUser("alice", nil)
+	body, contentType := avatarForm(t, testPNG(t, 100, 100))
+	e.request("POST", "/profile/avatar", alice.Token, body, "Content-Type", contentType).expect(http.StatusOK)
+
+	e.request("DELETE", "/profile/avatar", alice.Token, nil).expect(http.StatusOK)
+	if files := avatarFiles(t, e.blobs); len(files) != 0 {
+		t.Errorf("files left after removal: %v", files)
+	}
+	if got := e.db.rows("profiles", row{"id": alice.ID})[0]["avatar_url"]; got != "" {
+		t.Errorf("avatar_url = %v, want empty", got)
+	}
+}
+
+func avatarFiles(t *testing.T, root string) []string {
+	t.Helper()
+	var files []string
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files
+}
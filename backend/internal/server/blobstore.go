@@ -0,0 +1,194 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BlobStore stores binary objects such as avatar images under slash-separated keys.
+type BlobStore interface {
+	// Put stores data under key, replacing any existing object.
+	Put(ctx context.Context, key, contentType string, data []byte) error
+	// Get reads the object under key.
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Delete removes the objects under keys. Missing objects are not an error.
+	Delete(ctx context.Context, keys ...string) error
+	// URL returns the public URL of a stored object.
+	URL(key string) string
+}
+
+// validBlobKey rejects keys that could escape the store's root.
+func validBlobKey(key string) bool {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return false
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// localBlobStore keeps objects on the local filesystem. Public objects are
+// served by the API itself under /media.
+type localBlobStore struct {
+	root    string
+	baseURL string
+}
+
+func newLocalBlobStore(root, baseURL string) (*localBlobStore, error) {
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, err
+	}
+	return &localBlobStore{root: root, baseURL: strings.TrimRight(baseURL, "/")}, nil
+}
+
+func (s *localBlobStore) path(key string) (string, error) {
+	if !validBlobKey(key) {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+	return filepath.Join(s.root, filepath.FromSlash(key)), nil
+}
+
+func (s *localBlobStore) Put(ctx context.Context, key, contentType string, data []byte) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+
+	// Write to a temp file first so readers never see a partial object
+	tmp := p + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+func (s *localBlobStore) Get(ctx context.Context, key string) ([]byte, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(p)
+}
+
+func (s *localBlobStore) Delete(ctx context.Context, keys ...string) error {
+	for _, key := range keys {
+		p, err := s.path(key)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *localBlobStore) URL(key string) string {
+	return s.baseURL + "/media/" + key
+}
+
+// supabaseBlobStore keeps objects in a Supabase Storage bucket, talking to
+// the Storage API directly with the service role key.
+type supabaseBlobStore struct {
+	baseURL    string
+	serviceKey string
+	bucket     string
+	httpClient *http.Client
+}
+
+func newSupabaseBlobStore(supabaseURL, serviceKey, bucket string, httpClient *http.Client) *supabaseBlobStore {
+	return &supabaseBlobStore{
+		baseURL:    strings.TrimRight(supabaseURL, "/") + "/storage/v1",
+		serviceKey: serviceKey,
+		bucket:     bucket,
+		httpClient: httpClient,
+	}
+}
+
+func (s *supabaseBlobStore) do(req *http.Request) ([]byte, error) {
+	req.Header.Set("apikey", s.serviceKey)
+	req.Header.Set("Authorization", "Bearer "+s.serviceKey)
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("storage API error: status %d: %s", resp.StatusCode, body)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func (s *supabaseBlobStore) Put(ctx context.Context, key, contentType string, data []byte) error {
+	if !validBlobKey(key) {
+		return fmt.Errorf("invalid blob key %q", key)
+	}
+
+	url := fmt.Sprintf("%s/object/%s/%s", s.baseURL, s.bucket, key)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Cache-Control", "max-age=31536000")
+	req.Header.Set("x-upsert", "true")
+
+	_, err = s.do(req)
+	return err
+}
+
+func (s *supabaseBlobStore) Get(ctx context.Context, key string) ([]byte, error) {
+	if !validBlobKey(key) {
+		return nil, fmt.Errorf("invalid blob key %q", key)
+	}
+
+	url := fmt.Sprintf("%s/object/%s/%s", s.baseURL, s.bucket, key)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.do(req)
+}
+
+func (s *supabaseBlobStore) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	jsonBody, err := json.Marshal(map[string]interface{}{"prefixes": keys})
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/object/%s", s.baseURL, s.bucket)
+	req, err := http.NewRequestWithContext(ctx, "DELETE", url, bytes.NewReader(jsonBody))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	_, err = s.do(req)
+	return err
+}
+
+func (s *supabaseBlobStore) URL(key string) string {
+	return fmt.Sprintf("%s/object/public/%s/%s", s.baseURL, s.bucket, key)
+}
@@ -0,0 +1,302 @@
+package server
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/supabase-community/postgrest-go"
+)
+
+const profileCacheTTL = 10 * time.Minute
+
+var errProfileNotFound = errors.New("profile not found")
+
+type publicProfile struct {
+	ID          string `json:"id"`
+	Username    string `json:"username"`
+	DisplayName string `json:"display_name"`
+	AvatarURL   string `json:"avatar_url"`
+	Bio         string `json:"bio"`
+	IsPaused    bool   `json:"is_paused"`
+	// How answers below the pinned ones are ordered; see sortProfileMessages
+	ProfileSort string `json:"profile_sort"`
+	// Who may send, so clients can explain it before a message is written
+	sendPolicy
+	// Sized copies of an uploaded avatar, keyed small/medium/large
+	AvatarVariants map[string]string `json:"avatar_variants,omitempty"`
+}
+
+// publicProfilePayload is the body of GET /profile/:username before any
+// viewer-specific fields are layered on top.
+type publicProfilePayload struct {
+	Profile  publicProfile `json:"profile"`
+	Messages []interface{} `json:"messages"`
+	// The profile's prompts and which of the messages answer each
+	Prompts []promptGroup `json:"prompts"`
+}
+
+// isNoRows reports whether a PostgREST error came from Single() matching no rows.
+func isNoRows(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "PGRST116")
+}
+
+func profileCacheKey(username string) string {
+	return "profilecache:" + username
+}
+
+// computeETag returns a strong ETag for a response body.
+func computeETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches checks an If-None-Match header against an ETag, accepting
+// lists and weak validators.
+func etagMatches(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchPublicProfile loads a profile and its answered messages from Supabase
+// and decrypts them. The payload is shared by every viewer, so it never
+// carries sender IDs; enrichForViewer marks the viewer's own messages.
+func (s *Server) fetchPublicProfile(username string) (*publicProfilePayload, error) {
+	var payload publicProfilePayload
+
+	_, err := s.db.From("profiles").
+		Select("*", "", false).
+		Eq("username", username).
+		Single().
+		ExecuteTo(&payload.Profile)
+
+	if isNoRows(err) {
+		return nil, errProfileNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("fetch profile: %w", err)
+	}
+
+	_, err = s.db.From("messages").
+		Select("id, receiver_id, prompt_id, content, created_at, pinned_at, thread_id, replies(content, created_at), likes(count), bookmarks(count)", "exact", false).
+		Eq("receiver_id", payload.Profile.ID).
+		Eq("status", "replied").
+		Is("is_hidden", "false").
+		Order("created_at", &postgrest.OrderOpts{Ascending: false}).
+		ExecuteTo(&payload.Messages)
+
+	if err != nil {
+		return nil, fmt.Errorf("fetch profile messages: %w", err)
+	}
+	if payload.Messages == nil {
+		payload.Messages = make([]interface{}, 0)
+	}
+
+	// Decrypt public conversations
+	for i, m := range payload.Messages {
+		payload.Messages[i] = s.decryptMessageMap(m)
+		msgMap, ok := payload.Messages[i].(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		// Extract counts
+		if lVal, ok := msgMap["likes"].([]interface{}); ok && len(lVal) > 0 {
+			if lMap, ok := lVal[0].(map[string]interface{}); ok {
+				msgMap["likes_count"] = lMap["count"]
+			}
+		} else {
+			msgMap["likes_count"] = 0
+		}
+
+		if bVal, ok := msgMap["bookmarks"].([]interface{}); ok && len(bVal) > 0 {
+			if bMap, ok := bVal[0].(map[string]interface{}); ok {
+				msgMap["bookmarks_count"] = bMap["count"]
+			}
+		} else {
+			msgMap["bookmarks_count"] = 0
+		}
+	}
+
+	sortProfileMessages(payload.Messages, payload.Profile.ProfileSort)
+
+	payload.Prompts, err = s.fetchPromptGroups(payload.Profile.ID, payload.Messages)
+	if err != nil {
+		return nil, fmt.Errorf("fetch profile prompts: %w", err)
+	}
+
+	return &payload, nil
+}
+
+// Orders a profile can pick for its answers, as stored in
+// profiles.profile_sort.
+const (
+	profileSortNewest         = "newest"
+	profileSortMostLiked      = "most_liked"
+	profileSortMostBookmarked = "most_bookmarked"
+)
+
+// sortProfileMessages puts pinned answers first, most recently pinned on
+// top, and orders the rest by sort. messages must already be newest first,
+// which breaks ties.
+func sortProfileMessages(messages []interface{}, sort string) {
+	countKey := ""
+	switch sort {
+	case profileSortMostLiked:
+		countKey = "likes_count"
+	case profileSortMostBookmarked:
+		countKey = "bookmarks_count"
+	}
+
+	field := func(m interface{}, key string) interface{} {
+		if msgMap, ok := m.(map[string]interface{}); ok {
+			return msgMap[key]
+		}
+		return nil
+	}
+	count := func(m interface{}) float64 {
+		switch n := field(m, countKey).(type) {
+		case float64:
+			return n
+		case int:
+			return float64(n)
+		}
+		return 0
+	}
+
+	slices.SortStableFunc(messages, func(a, b interface{}) int {
+		pinnedA, _ := field(a, "pinned_at").(string)
+		pinnedB, _ := field(b, "pinned_at").(string)
+		switch {
+		case pinnedA != "" && pinnedB != "":
+			return compareTimestamps(pinnedB, pinnedA)
+		case pinnedA != "":
+			return -1
+		case pinnedB != "":
+			return 1
+		case countKey != "":
+			return cmp.Compare(count(b), count(a))
+		}
+		return 0
+	})
+}
+
+// compareTimestamps compares two PostgREST timestamps, falling back to
+// comparing the strings when they don't parse.
+func compareTimestamps(a, b string) int {
+	ta, errA := time.Parse(time.RFC3339Nano, a)
+	tb, errB := time.Parse(time.RFC3339Nano, b)
+	if errA != nil || errB != nil {
+		return strings.Compare(a, b)
+	}
+	return ta.Compare(tb)
+}
+
+// loadPublicProfile returns the shared (viewer-independent) profile body and
+// its ETag, serving from Redis when possible.
+func (s *Server) loadPublicProfile(username string) ([]byte, string, error) {
+	key := profileCacheKey(username)
+	if s.rdb != nil {
+		if cached, err := s.rdb.Get(s.ctx, key).Bytes(); err == nil {
+			return cached, computeETag(cached), nil
+		}
+	}
+
+	payload, err := s.fetchPublicProfile(username)
+	if err != nil {
+		return nil, "", err
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if s.rdb != nil {
+		if err := s.rdb.Set(s.ctx, key, body, profileCacheTTL).Err(); err != nil {
+			slog.Error("Redis error", "error", err)
+		}
+	}
+
+	return body, computeETag(body), nil
+}
+
+// invalidateProfileCacheByUsername drops the cached public profile for a username.
+func (s *Server) invalidateProfileCacheByUsername(username string) {
+	if s.rdb == nil || username == "" {
+		return
+	}
+	if err := s.rdb.Del(s.ctx, profileCacheKey(username)).Err(); err != nil {
+		slog.Error("Redis error", "error", err)
+	}
+}
+
+// profileUsername resolves the current username of a profile, or "" if it
+// cannot be found.
+func (s *Server) profileUsername(profileID string) string {
+	var profile struct {
+		Username string `json:"username"`
+	}
+	_, err := s.db.From("profiles").
+		Select("username", "", false).
+		Eq("id", profileID).
+		Single().
+		ExecuteTo(&profile)
+	if err != nil {
+		if !isNoRows(err) {
+			slog.Error("Supabase error resolving profile username", "error", err)
+		}
+		return ""
+	}
+	return profile.Username
+}
+
+// invalidateProfileCache drops the cached public profile for a profile ID.
+func (s *Server) invalidateProfileCache(profileID string) {
+	if s.rdb == nil || profileID == "" {
+		return
+	}
+	s.invalidateProfileCacheByUsername(s.profileUsername(profileID))
+}
+
+// invalidateProfileCacheForMessage drops the cached public profile of the
+// receiver of a message.
+func (s *Server) invalidateProfileCacheForMessage(messageID string) {
+	if s.rdb == nil || messageID == "" {
+		return
+	}
+
+	var message struct {
+		Receiver struct {
+			Username string `json:"username"`
+		} `json:"receiver"`
+	}
+	_, err := s.db.From("messages").
+		Select("receiver:profiles!receiver_id(username)", "", false).
+		Eq("id", messageID).
+		Single().
+		ExecuteTo(&message)
+	if err != nil {
+		if !isNoRows(err) {
+			slog.Error("Supabase error resolving message for cache invalidation", "error", err)
+		}
+		return
+	}
+
+	s.invalidateProfileCacheByUsername(message.Receiver.Username)
+}
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"math/bits"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	sendChallengeKeyDomain = "replied/send-challenge/v1"
+	// How far back a receiver's sends count towards the challenge difficulty
+	sendVolumeWindow = 10 * time.Minute
+)
+
+// HumanVerifier decides whether an anonymous sender is likely a person
+// before their message is accepted. Proof of work is built in; a CAPTCHA
+// such as Turnstile or hCaptcha fits the same shape, with Challenge handing
+// out the site key and Verify checking the widget's token.
+type HumanVerifier interface {
+	// Challenge returns what the client has to solve before sending to
+	// receiverID. It is sent to the client as JSON.
+	Challenge(ctx context.Context, receiverID string) (interface{}, error)
+	// Verify checks the client's solution. It returns an *apiError for a
+	// missing or wrong solution.
+	Verify(ctx context.Context, receiverID, solution, ip string) error
+}
+
+// powChallenge is a hashcash-style puzzle: find a nonce such that
+// SHA-256(token + ":" + nonce) starts with Difficulty zero bits, then send
+// token + ":" + nonce as the solution.
+type powChallenge struct {
+	Type       string    `json:"type"`
+	Token      string    `json:"token"`
+	Difficulty int       `json:"difficulty"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// powClaims is what a challenge token commits to. The signature stops
+// clients picking an easier difficulty or reusing a token for another
+// receiver.
+type powClaims struct {
+	ID         string `json:"id"`
+	ReceiverID string `json:"r"`
+	Difficulty int    `json:"d"`
+	Expires    int64  `json:"e"`
+}
+
+// proofOfWork is the built-in HumanVerifier.
+type proofOfWork struct {
+	key  []byte
+	rdb  redis.Cmdable // nil: no volume scaling and no replay protection
+	base int
+	max  int
+	ttl  time.Duration
+}
+
+// Challenge issues a signed puzzle whose difficulty grows with the
+// receiver's recent send volume: one extra bit for every doubling beyond
+// twenty sends per window.
+func (p *proofOfWork) Challenge(ctx context.Context, receiverID string) (interface{}, error) {
+	difficulty := p.base
+	if p.rdb != nil {
+		sends, err := p.rdb.Get(ctx, sendVolumeKey(receiverID)).Int()
+		if err != nil && err != redis.Nil {
+			return nil, err
+		}
+		difficulty = min(p.base+bits.Len(uint(sends/20)), p.max)
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	expires := time.Now().Add(p.ttl).Truncate(time.Second)
+	claims, err := json.Marshal(powClaims{
+		ID:         hex.EncodeToString(id),
+		ReceiverID: receiverID,
+		Difficulty: difficulty,
+		Expires:    expires.Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(claims)
+	return powChallenge{
+		Type:       "pow",
+		Token:      payload + "." + p.sign(payload),
+		Difficulty: difficulty,
+		ExpiresAt:  expires.UTC(),
+	}, nil
+}
+
+func (p *proofOfWork) sign(payload string) string {
+	mac := hmac.New(sha256.New, p.key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature, expiry, receiver and work of a solution, and
+// that the challenge hasn't been used before.
+func (p *proofOfWork) Verify(ctx context.Context, receiverID, solution, _ string) error {
+	if solution == "" {
+		return errVerification("Solve the challenge from GET /send/challenge first")
+	}
+	token, nonce, _ := strings.Cut(solution, ":")
+	payload, signature, _ := strings.Cut(token, ".")
+	if nonce == "" || !hmac.Equal([]byte(signature), []byte(p.sign(payload))) {
+		return errVerification("Invalid challenge")
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return errVerification("Invalid challenge")
+	}
+	var claims powClaims
+	if err := json.Unmarshal(raw, &claims); err != nil {
+		return errVerification("Invalid challenge")
+	}
+	if claims.ReceiverID != receiverID {
+		return errVerification("Challenge was issued for another inbox")
+	}
+	if time.Now().Unix() > claims.Expires {
+		return errVerification("Challenge has expired")
+	}
+	if leadingZeroBits(sha256.Sum256([]byte(solution))) < claims.Difficulty {
+		return errVerification("Challenge solution is wrong")
+	}
+
+	if p.rdb != nil {
+		ttl := time.Until(time.Unix(claims.Expires, 0)) + time.Second
+		fresh, err := p.rdb.SetNX(ctx, "challenge:used:"+claims.ID, 1, ttl).Result()
+		if err != nil {
+			return errInternal("Could not verify challenge", err)
+		}
+		if !fresh {
+			return errVerification("Challenge has already been used")
+		}
+	}
+	return nil
+}
+
+func leadingZeroBits(sum [sha256.Size]byte) int {
+	n := 0
+	for _, b := range sum {
+		if b != 0 {
+			return n + bits.LeadingZeros8(b)
+		}
+		n += 8
+	}
+	return n
+}
+
+func sendVolumeKey(receiverID string) string {
+	return "sendvolume:" + receiverID
+}
+
+// recordSend counts a message towards its receiver's recent send volume.
+func (s *Server) recordSend(ctx context.Context, receiverID string) {
+	if s.rdb == nil {
+		return
+	}
+	key := sendVolumeKey(receiverID)
+	if n, err := s.rdb.Incr(ctx, key).Result(); err != nil {
+		loggerFrom(ctx).Error("Failed to record send volume", "error", err)
+	} else if n == 1 {
+		s.rdb.Expire(ctx, key, sendVolumeWindow)
+	}
+}
+
+func (s *Server) registerChallengeRoutes(r *gin.Engine) {
+	// Public: the puzzle an anonymous sender solves before POST /send
+	r.GET("/send/challenge", handle(func(c *gin.Context) error {
+		if s.verifier == nil {
+			c.JSON(http.StatusOK, gin.H{"type": "none"})
+			return nil
+		}
+		receiverID := c.Query("receiver_id")
+		if receiverID == "" {
+			return errValidation("receiver_id is required")
+		}
+
+		challenge, err := s.verifier.Challenge(c.Request.Context(), receiverID)
+		if err != nil {
+			return errInternal("Could not create challenge", err)
+		}
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusOK, challenge)
+		return nil
+	}))
+}
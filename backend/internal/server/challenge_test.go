@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// solve brute-forces a proof-of-work challenge.
+func solve(t *testing.T, token string, difficulty int) string {
+	t.Helper()
+	for nonce := 0; nonce < 1<<24; nonce++ {
+		solution := token + ":" + strconv.Itoa(nonce)
+		if leadingZeroBits(sha256.Sum256([]byte(solution))) >= difficulty {
+			return solution
+		}
+	}
+	t.Fatal("no solution found")
+	return ""
+}
+
+// withProofOfWork switches send verification on for the test server.
+func (e *testEnv) withProofOfWork(base, max int) *proofOfWork {
+	pow := &proofOfWork{key: []byte("test key"), rdb: e.rdb, base: base, max: max, ttl: time.Minute}
+	e.srv.verifier = pow
+	return pow
+}
+
+func TestSendChallenge(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.addUser("alice", nil)
+	bob := e.addUser("bob", nil)
+
+	if got := e.request("GET", "/send/challenge?receiver_id="+alice.ID, "", nil).expect(http.StatusOK).object()["type"]; got != "none" {
+		t.Fatalf("type = %v with verification off", got)
+	}
+
+	e.withProofOfWork(8, 12)
+	e.srv.cfg.Limits.SendPerWindow = 100
+	e.request("GET", "/send/challenge", "", nil).expect(http.StatusBadRequest)
+	var challenge powChallenge
+	e.request("GET", "/send/challenge?receiver_id="+alice.ID, "", nil).expect(http.StatusOK).json(&challenge)
+	if challenge.Type != "pow" || challenge.Difficulty != 8 {
+		t.Fatalf("challenge = %+v", challenge)
+	}
+
+	send := func(verification, token string) response {
+		return e.request("POST", "/send", token, map[string]string{"receiver_id": alice.ID, "content": "hello", "verification": verification})
+	}
+	if code := send("", "").expect(http.StatusForbidden).apiError().Code; code != codeVerification {
+		t.Errorf("missing solution: code = %q", code)
+	}
+	send(challenge.Token+":nope", "").expect(http.StatusForbidden)
+	forged := strings.Replace(challenge.Token, ".", "x.", 1)
+	send(solve(t, forged, 8), "").expect(http.StatusForbidden)
+
+	solution := solve(t, challenge.Token, 8)
+	e.request("POST", "/send", "", map[string]string{"receiver_id": bob.ID, "content": "hello", "verification": solution}).
+		expect(http.StatusForbidden)
+	send(solution, "").expect(http.StatusCreated)
+	if msg := send(solution, "").expect(http.StatusForbidden).errorMessage(); msg != "Challenge has already been used" {
+		t.Errorf("replay: error = %q", msg)
+	}
+
+	// Signed-in senders aren't anonymous and skip the challenge
+	e.request("POST", "/send", bob.Token, map[string]string{"receiver_id": alice.ID, "content": "hi from bob"}).
+		expect(http.StatusCreated)
+}
+
+func TestChallengeDifficultyScales(t *testing.T) {
+	e := newTestEnv(t)
+	pow := e.withProofOfWork(8, 10)
+	ctx := context.Background()
+
+	difficulty := func() int {
+		challenge, err := pow.Challenge(ctx, "receiver")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return challenge.(powChallenge).Difficulty
+	}
+	for sends, want := range map[int]int{0: 8, 19: 8, 20: 9, 40: 10, 1000: 10} {
+		e.rdb.Set(ctx, sendVolumeKey("receiver"), strconv.Itoa(sends), 0)
+		if got := difficulty(); got != want {
+			t.Errorf("%d sends: difficulty = %d, want %d", sends, got, want)
+		}
+	}
+
+	// Sends count towards the receiver's volume
+	e.rdb.Del(ctx, sendVolumeKey("receiver"))
+	for i := 0; i < 3; i++ {
+		e.srv.recordSend(ctx, "receiver")
+	}
+	if n, _ := e.rdb.Get(ctx, sendVolumeKey("receiver")).Int(); n != 3 {
+		t.Errorf("send volume = %d, want 3", n)
+	}
+}
+
+func TestExpiredChallenge(t *testing.T) {
+	e := newTestEnv(t)
+	pow := e.withProofOfWork(4, 4)
+	pow.ttl = -time.Minute
+
+	challenge, err := pow.Challenge(context.Background(), "receiver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	solution := solve(t, challenge.(powChallenge).Token, 4)
+	if err := pow.Verify(context.Background(), "receiver", solution, ""); err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Errorf("Verify = %v, want expired", err)
+	}
+}
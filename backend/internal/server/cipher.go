@@ -0,0 +1,86 @@
+package server
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+)
+
+// contentCipher encrypts message content, replies, notes and emails with
+// AES-256-GCM, and derives the keys for the blind indexes and signatures.
+type contentCipher struct {
+	key  []byte
+	aead cipher.AEAD
+}
+
+func newContentCipher(key []byte) (*contentCipher, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &contentCipher{key: key, aead: gcm}, nil
+}
+
+// deriveKey returns an independent key for one purpose, so the search
+// index, import hashes and download signatures never share key material
+// with the content encryption.
+func (c *contentCipher) deriveKey(domain string) []byte {
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write([]byte(domain))
+	return mac.Sum(nil)
+}
+
+func (c *contentCipher) Encrypt(text string) (string, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	ciphertext := c.aead.Seal(nonce, nonce, []byte(text), nil)
+	return hex.EncodeToString(ciphertext), nil
+}
+
+func (c *contentCipher) Decrypt(ciphertextHex string) (string, error) {
+	ciphertext, err := hex.DecodeString(ciphertextHex)
+	if err != nil {
+		return "", err
+	}
+
+	nonceSize := c.aead.NonceSize()
+	if len(ciphertext) < nonceSize {
+		return "", fmt.Errorf("ciphertext too short")
+	}
+
+	nonce, actualCiphertext := ciphertext[:nonceSize], ciphertext[nonceSize:]
+	plaintext, err := c.aead.Open(nil, nonce, actualCiphertext, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return string(plaintext), nil
+}
+
+func (s *Server) encrypt(text string) (string, error) {
+	out, err := s.cipher.Encrypt(text)
+	if err != nil {
+		s.metrics.cipherFailures.Inc("encrypt")
+	}
+	return out, err
+}
+
+func (s *Server) decrypt(ciphertextHex string) (string, error) {
+	out, err := s.cipher.Decrypt(ciphertextHex)
+	if err != nil {
+		s.metrics.cipherFailures.Inc("decrypt")
+	}
+	return out, err
+}
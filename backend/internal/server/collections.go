@@ -0,0 +1,358 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/supabase-community/gotrue-go/types"
+	"github.com/supabase-community/postgrest-go"
+)
+
+// The binding tags on the request bodies repeat these limits.
+const (
+	maxCollectionNameLength = 60
+	maxBookmarkNoteLength   = 500
+	maxReorderItems         = 200
+)
+
+// bookmarkRow is a bookmark joined with its message, as selected by
+// bookmarkSelect.
+type bookmarkRow struct {
+	MessageID    string      `json:"message_id"`
+	CollectionID *string     `json:"collection_id"`
+	Note         *string     `json:"note"`
+	Message      interface{} `json:"message"`
+}
+
+const bookmarkSelect = "message_id, collection_id, note, message:messages(" + publicMessageColumns + ", profiles:receiver_id(username, avatar_url), replies(content, created_at))"
+
+// bookmarkMessages decrypts the messages of a list of bookmarks and attaches
+// the bookmark's collection and (when includeNotes is set) its private note.
+func (s *Server) bookmarkMessages(rows []bookmarkRow, includeNotes bool) []interface{} {
+	messages := make([]interface{}, 0, len(rows))
+	for _, b := range rows {
+		if b.Message == nil {
+			continue
+		}
+		msg := s.decryptMessageMap(b.Message)
+		if msgMap, ok := msg.(map[string]interface{}); ok {
+			msgMap["collection_id"] = b.CollectionID
+			if includeNotes {
+				msgMap["bookmark_note"] = s.decryptNote(b.Note)
+			}
+		}
+		messages = append(messages, msg)
+	}
+	return messages
+}
+
+// visibleAnswer reports whether a decrypted message may be listed for the
+// viewer: its receiver sees it in any state, everyone else only once it is
+// answered and not hidden from the profile.
+func visibleAnswer(m interface{}, viewerID string) bool {
+	msgMap, ok := m.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	if viewerID != "" && stringField(msgMap, "receiver_id") == viewerID {
+		return true
+	}
+	hidden, _ := msgMap["is_hidden"].(bool)
+	return stringField(msgMap, "status") == "replied" && !hidden
+}
+
+// decryptNote returns the plaintext of an encrypted bookmark note, or nil.
+func (s *Server) decryptNote(note *string) interface{} {
+	if note == nil || *note == "" {
+		return nil
+	}
+	dec, err := s.decrypt(*note)
+	if err != nil {
+		slog.Error("Failed to decrypt bookmark note", "error", err)
+		return nil
+	}
+	return dec
+}
+
+// ownsCollection checks that a bookmark collection belongs to the user.
+func (s *Server) ownsCollection(collectionID, userID string) (bool, error) {
+	var rows []map[string]interface{}
+	_, err := s.db.From("bookmark_collections").
+		Select("id", "", false).
+		Eq("id", collectionID).
+		Eq("user_id", userID).
+		ExecuteTo(&rows)
+	if err != nil {
+		return false, err
+	}
+	return len(rows) > 0, nil
+}
+
+// validateCollectionName trims a collection name and reports whether it is usable.
+func validateCollectionName(name string) (string, bool) {
+	name = strings.TrimSpace(name)
+	return name, name != "" && len([]rune(name)) <= maxCollectionNameLength
+}
+
+func (s *Server) registerCollectionRoutes(r *gin.Engine, authMiddleware gin.HandlerFunc) {
+	// List my collections
+	r.GET("/collections", authMiddleware, handle(func(c *gin.Context) error {
+		user, _ := c.Get("user")
+		supabaseUser := user.(types.User)
+
+		var collections []interface{}
+		_, err := s.db.From("bookmark_collections").
+			Select("*, bookmarks(count)", "", false).
+			Eq("user_id", supabaseUser.ID.String()).
+			Order("position", &postgrest.OrderOpts{Ascending: true}).
+			Order("created_at", &postgrest.OrderOpts{Ascending: false}).
+			ExecuteTo(&collections)
+
+		if err != nil {
+			return errInternal("Failed to fetch collections", fmt.Errorf("fetching collections: %w", err))
+		}
+		if collections == nil {
+			collections = make([]interface{}, 0)
+		}
+
+		c.JSON(http.StatusOK, collections)
+		return nil
+	}))
+
+	// Create Collection
+	r.POST("/collections", authMiddleware, handle(func(c *gin.Context) error {
+		var body struct {
+			Name     string `json:"name" binding:"required,max=60"`
+			IsPublic bool   `json:"is_public"`
+		}
+		if err := bindJSON(c, &body); err != nil {
+			return err
+		}
+
+		name, ok := validateCollectionName(body.Name)
+		if !ok {
+			return errValidation("Collection name must be 1-60 characters")
+		}
+
+		user, _ := c.Get("user")
+		supabaseUser := user.(types.User)
+
+		var created []interface{}
+		_, err := s.db.From("bookmark_collections").
+			Insert(map[string]interface{}{
+				"user_id":   supabaseUser.ID.String(),
+				"name":      name,
+				"is_public": body.IsPublic,
+			}, false, "", "", "").
+			ExecuteTo(&created)
+
+		if err != nil {
+			return errInternal("Failed to create collection", fmt.Errorf("creating collection: %w", err))
+		}
+		if len(created) == 0 {
+			return errInternal("Failed to create collection", errors.New("creating collection: no row returned"))
+		}
+
+		c.JSON(http.StatusCreated, created[0])
+		return nil
+	}))
+
+	// Reorder my collections
+	r.PUT("/collections/order", authMiddleware, handle(func(c *gin.Context) error {
+		var body struct {
+			CollectionIDs []string `json:"collection_ids" binding:"required,max=200"`
+		}
+		if err := bindJSON(c, &body); err != nil {
+			return err
+		}
+
+		user, _ := c.Get("user")
+		supabaseUser := user.(types.User)
+
+		for i, id := range body.CollectionIDs {
+			_, _, err := s.db.From("bookmark_collections").
+				Update(map[string]interface{}{"position": i}, "minimal", "").
+				Eq("id", id).
+				Eq("user_id", supabaseUser.ID.String()).
+				Execute()
+			if err != nil {
+				return errInternal("Failed to reorder collections", fmt.Errorf("reordering collections: %w", err))
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{"status": "reordered"})
+		return nil
+	}))
+
+	// View a collection: owners always, everyone else only if it is public
+	r.GET("/collections/:id", handle(func(c *gin.Context) error {
+		collectionID := c.Param("id")
+
+		var collection struct {
+			ID        string `json:"id"`
+			UserID    string `json:"user_id"`
+			Name      string `json:"name"`
+			IsPublic  bool   `json:"is_public"`
+			CreatedAt string `json:"created_at"`
+			Owner     struct {
+				Username    string `json:"username"`
+				DisplayName string `json:"display_name"`
+				AvatarURL   string `json:"avatar_url"`
+			} `json:"owner"`
+		}
+		_, err := s.db.From("bookmark_collections").
+			Select("id, user_id, name, is_public, created_at, owner:profiles!user_id(username, display_name, avatar_url)", "", false).
+			Eq("id", collectionID).
+			Single().
+			ExecuteTo(&collection)
+
+		if isNoRows(err) {
+			return errNotFound("Collection not found")
+		}
+		if err != nil {
+			return errInternal("Failed to fetch collection", fmt.Errorf("fetching collection: %w", err))
+		}
+
+		viewerID := s.optionalViewerID(c)
+		isOwner := viewerID != "" && viewerID == collection.UserID
+		if !collection.IsPublic && !isOwner {
+			return errNotFound("Collection not found")
+		}
+
+		var rows []bookmarkRow
+		_, err = s.db.From("bookmarks").
+			Select(bookmarkSelect, "", false).
+			Eq("collection_id", collectionID).
+			Eq("user_id", collection.UserID).
+			Order("position", &postgrest.OrderOpts{Ascending: true}).
+			Order("created_at", &postgrest.OrderOpts{Ascending: false}).
+			ExecuteTo(&rows)
+
+		if err != nil {
+			return errInternal("Failed to fetch collection", fmt.Errorf("fetching collection bookmarks: %w", err))
+		}
+
+		// Notes are private to the owner, and only answers that are still
+		// public are listed
+		messages := s.bookmarkMessages(rows, isOwner)
+		messages = slices.DeleteFunc(messages, func(m interface{}) bool { return !visibleAnswer(m, viewerID) })
+
+		if err := s.enrichForViewer(viewerID, messages); err != nil {
+			return errInternal("Failed to fetch viewer state", fmt.Errorf("fetching viewer state: %w", err))
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"collection": collection,
+			"messages":   messages,
+		})
+		return nil
+	}))
+
+	// Rename / share a collection
+	r.PATCH("/collections/:id", authMiddleware, handle(func(c *gin.Context) error {
+		collectionID := c.Param("id")
+
+		var body struct {
+			Name     *string `json:"name" binding:"omitempty,max=60"`
+			IsPublic *bool   `json:"is_public"`
+		}
+		if err := bindJSON(c, &body); err != nil {
+			return err
+		}
+
+		updateData := map[string]interface{}{"updated_at": "now()"}
+		if body.Name != nil {
+			name, ok := validateCollectionName(*body.Name)
+			if !ok {
+				return errValidation("Collection name must be 1-60 characters")
+			}
+			updateData["name"] = name
+		}
+		if body.IsPublic != nil {
+			updateData["is_public"] = *body.IsPublic
+		}
+
+		user, _ := c.Get("user")
+		supabaseUser := user.(types.User)
+
+		var updated []interface{}
+		_, err := s.db.From("bookmark_collections").
+			Update(updateData, "", "").
+			Eq("id", collectionID).
+			Eq("user_id", supabaseUser.ID.String()).
+			ExecuteTo(&updated)
+
+		if err != nil {
+			return errInternal("Failed to update collection", fmt.Errorf("updating collection: %w", err))
+		}
+		if len(updated) == 0 {
+			return errNotFound("Collection not found")
+		}
+
+		c.JSON(http.StatusOK, updated[0])
+		return nil
+	}))
+
+	// Delete a collection (its bookmarks are kept, just unfiled)
+	r.DELETE("/collections/:id", authMiddleware, handle(func(c *gin.Context) error {
+		collectionID := c.Param("id")
+		user, _ := c.Get("user")
+		supabaseUser := user.(types.User)
+
+		_, _, err := s.db.From("bookmark_collections").
+			Delete("", "").
+			Eq("id", collectionID).
+			Eq("user_id", supabaseUser.ID.String()).
+			Execute()
+
+		if err != nil {
+			return errInternal("Failed to delete collection", fmt.Errorf("deleting collection: %w", err))
+		}
+
+		c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+		return nil
+	}))
+
+	// Reorder the bookmarks inside a collection
+	r.PUT("/collections/:id/order", authMiddleware, handle(func(c *gin.Context) error {
+		collectionID := c.Param("id")
+
+		var body struct {
+			MessageIDs []string `json:"message_ids" binding:"required,max=200"`
+		}
+		if err := bindJSON(c, &body); err != nil {
+			return err
+		}
+
+		user, _ := c.Get("user")
+		supabaseUser := user.(types.User)
+
+		owned, err := s.ownsCollection(collectionID, supabaseUser.ID.String())
+		if err != nil {
+			return errInternal("Failed to reorder collection", fmt.Errorf("fetching collection: %w", err))
+		}
+		if !owned {
+			return errNotFound("Collection not found")
+		}
+
+		for i, messageID := range body.MessageIDs {
+			_, _, err := s.db.From("bookmarks").
+				Update(map[string]interface{}{"position": i}, "minimal", "").
+				Eq("collection_id", collectionID).
+				Eq("message_id", messageID).
+				Eq("user_id", supabaseUser.ID.String()).
+				Execute()
+			if err != nil {
+				return errInternal("Failed to reorder collection", fmt.Errorf("reordering bookmarks: %w", err))
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{"status": "reordered"})
+		return nil
+	}))
+}
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCollectionLifecycle(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.addUser("alice", nil)
+	bob := e.addUser("bob", nil)
+
+	e.request("POST", "/collections", alice.Token, map[string]string{"name": "   "}).expect(http.StatusBadRequest)
+	e.request("POST", "/collections", alice.Token, map[string]string{"name": strings.Repeat("x", maxCollectionNameLength+1)}).
+		expect(http.StatusBadRequest)
+
+	created := e.request("POST", "/collections", alice.Token, map[string]string{"name": " Recipes "}).
+		expect(http.StatusCreated).object()
+	if created["name"] != "Recipes" || created["is_public"] != false {
+		t.Fatalf("created = %v", created)
+	}
+	id := created["id"].(string)
+	e.request("POST", "/collections", alice.Token, map[string]string{"name": "Films"}).expect(http.StatusCreated)
+
+	if list := e.request("GET", "/collections", alice.Token, nil).expect(http.StatusOK).list(); len(list) != 2 {
+		t.Fatalf("collections = %v, want 2", list)
+	}
+	if list := e.request("GET", "/collections", bob.Token, nil).expect(http.StatusOK).list(); len(list) != 0 {
+		t.Errorf("bob sees alice's collections: %v", list)
+	}
+
+	// Only the owner can edit
+	e.request("PATCH", "/collections/"+id, bob.Token, map[string]string{"name": "Mine"}).expect(http.StatusNotFound)
+	updated := e.request("PATCH", "/collections/"+id, alice.Token, map[string]interface{}{"name": "Cooking", "is_public": true}).
+		expect(http.StatusOK).object()
+	if updated["name"] != "Cooking" || updated["is_public"] != true {
+		t.Errorf("updated = %v", updated)
+	}
+
+	e.request("DELETE", "/collections/"+id, bob.Token, nil).expect(http.StatusOK)
+	if len(e.db.rows("bookmark_collections", row{"id": id})) != 1 {
+		t.Fatal("bob deleted alice's collection")
+	}
+	e.request("DELETE", "/collections/"+id, alice.Token, nil).expect(http.StatusOK)
+	if len(e.db.rows("bookmark_collections", row{"id": id})) != 0 {
+		t.Error("collection was not deleted")
+	}
+}
+
+func TestViewCollection(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.addUser("alice", nil)
+	bob := e.addUser("bob", nil)
+	msg := e.addMessage(bob, "best pasta shape?", "replied", nil)
+	e.addReply(msg, bob, "rigatoni")
+	collection := e.db.insert("bookmark_collections", row{"user_id": alice.ID, "name": "Food"})
+	path := "/collections/" + collection["id"].(string)
+	e.request("POST", "/messages/"+msg["id"].(string)+"/bookmark", alice.Token,
+		map[string]string{"collection_id": collection["id"].(string), "note": "try this"}).expect(http.StatusOK)
+
+	// Private collections are hidden from everyone but the owner
+	e.request("GET", path, "", nil).expect(http.StatusNotFound)
+	e.request("GET", path, bob.Token, nil).expect(http.StatusNotFound)
+
+	var owned struct {
+		Collection map[string]interface{}   `json:"collection"`
+		Messages   []map[string]interface{} `json:"messages"`
+	}
+	e.request("GET", path, alice.Token, nil).expect(http.StatusOK).json(&owned)
+	if len(owned.Messages) != 1 || owned.Messages[0]["bookmark_note"] != "try this" {
+		t.Fatalf("owner view = %v", owned.Messages)
+	}
+
+	e.request("PATCH", path, alice.Token, map[string]bool{"is_public": true}).expect(http.StatusOK)
+	var shared struct {
+		Collection map[string]interface{}   `json:"collection"`
+		Messages   []map[string]interface{} `json:"messages"`
+	}
+	e.request("GET", path, "", nil).expect(http.StatusOK).json(&shared)
+	if len(shared.Messages) != 1 || shared.Messages[0]["content"] != "best pasta shape?" {
+		t.Fatalf("public view = %v", shared.Messages)
+	}
+	if _, ok := shared.Messages[0]["bookmark_note"]; ok {
+		t.Error("public view leaks the owner's note")
+	}
+	if owner, _ := shared.Collection["owner"].(map[string]interface{}); owner["username"] != "alice" {
+		t.Errorf("owner = %v", shared.Collection["owner"])
+	}
+
+	// Visitors only see answers that are still public
+	archived := e.addMessage(bob, "archived later", "replied", nil)
+	e.request("POST", "/messages/"+archived["id"].(string)+"/bookmark", alice.Token,
+		map[string]string{"collection_id": collection["id"].(string)}).expect(http.StatusOK)
+	e.db.update("messages", row{"id": archived["id"]}, row{"status": "archived"})
+	hidden := e.addMessage(bob, "hidden later", "replied", nil)
+	e.request("POST", "/messages/"+hidden["id"].(string)+"/bookmark", alice.Token,
+		map[string]string{"collection_id": collection["id"].(string)}).expect(http.StatusOK)
+	e.db.update("messages", row{"id": hidden["id"]}, row{"is_hidden": true})
+	e.request("GET", path, "", nil).expect(http.StatusOK).json(&shared)
+	if len(shared.Messages) != 1 || shared.Messages[0]["id"] != msg["id"] {
+		t.Errorf("public view after archiving and hiding = %v", shared.Messages)
+	}
+	if _, ok := shared.Messages[0]["sender_id"]; ok {
+		t.Error("public view leaks sender_id")
+	}
+	if got := e.request("GET", path, bob.Token, nil).expect(http.StatusOK).object()["messages"].([]interface{}); len(got) != 3 {
+		t.Errorf("receiver view has %d messages, want 3", len(got))
+	}
+
+	e.request("GET", "/collections/00000000-0000-0000-0000-000000000000", "", nil).expect(http.StatusNotFound)
+}
+
+func TestReorderCollections(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.addUser("alice", nil)
+	bob := e.addUser("bob", nil)
+	first := e.db.insert("bookmark_collections", row{"user_id": alice.ID, "name": "First"})
+	second := e.db.insert("bookmark_collections", row{"user_id": alice.ID, "name": "Second"})
+
+	e.request("PUT", "/collections/order", alice.Token, map[string]interface{}{}).expect(http.StatusBadRequest)
+	e.request("PUT", "/collections/order", alice.Token, map[string][]string{
+		"collection_ids": {second["id"].(string), first["id"].(string)},
+	}).expect(http.StatusOK)
+
+	list := e.request("GET", "/collections", alice.Token, nil).expect(http.StatusOK).list()
+	if len(list) != 2 || list[0]["name"] != "Second" || list[1]["name"] != "First" {
+		t.Fatalf("collections = %v, want Second then First", list)
+	}
+
+	m1 := e.addMessage(bob, "one", "replied", nil)
+	m2 := e.addMessage(bob, "two", "replied", nil)
+	path := "/collections/" + first["id"].(string)
+	for _, m := range []row{m1, m2} {
+		e.request("POST", "/messages/"+m["id"].(string)+"/bookmark", alice.Token,
+			map[string]string{"collection_id": first["id"].(string)}).expect(http.StatusOK)
+	}
+
+	e.request("PUT", path+"/order", bob.Token, map[string][]string{"message_ids": {m1["id"].(string)}}).
+		expect(http.StatusNotFound)
+	e.request("PUT", path+"/order", alice.Token, map[string][]string{
+		"message_ids": {m2["id"].(string), m1["id"].(string)},
+	}).expect(http.StatusOK)
+
+	var view struct {
+		Messages []map[string]interface{} `json:"messages"`
+	}
+	e.request("GET", path, alice.Token, nil).expect(http.StatusOK).json(&view)
+	if len(view.Messages) != 2 || view.Messages[0]["id"] != m2["id"] || view.Messages[1]["id"] != m1["id"] {
+		t.Errorf("collection order = %v", view.Messages)
+	}
+}
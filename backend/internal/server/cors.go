@@ -0,0 +1,124 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pranav/replied-backend/internal/config"
+)
+
+// corsPolicy decides which browser origins may call the API. The API
+// authenticates with bearer tokens, not cookies, so credentials are never
+// allowed and a denied origin can't ride on a user's session either way.
+type corsPolicy struct {
+	exact     map[string]bool
+	wildcards []corsWildcard
+	dev       bool
+	maxAge    string
+	fallback  config.CORSRule // for routes outside any group
+	groups    map[string]config.CORSRule
+	prefixes  []string // keys of groups, longest first
+}
+
+// corsWildcard matches "scheme://*.suffix": any subdomain, but not suffix
+// itself.
+type corsWildcard struct {
+	scheme string
+	suffix string // ".replied.app", with the port if any
+}
+
+func newCORSPolicy(cfg config.CORSConfig, frontendURL string) *corsPolicy {
+	p := &corsPolicy{
+		exact:    map[string]bool{strings.ToLower(frontendURL): true},
+		dev:      cfg.Dev,
+		maxAge:   strconv.Itoa(int(cfg.MaxAge.Seconds())),
+		fallback: config.CORSRule{Methods: cfg.AllowedMethods, Headers: cfg.AllowedHeaders},
+		groups:   cfg.Groups,
+	}
+	for _, origin := range cfg.AllowedOrigins {
+		origin = strings.ToLower(origin)
+		if scheme, host, ok := strings.Cut(origin, "://*."); ok {
+			p.wildcards = append(p.wildcards, corsWildcard{scheme: scheme, suffix: "." + host})
+		} else {
+			p.exact[origin] = true
+		}
+	}
+	for prefix := range cfg.Groups {
+		p.prefixes = append(p.prefixes, prefix)
+	}
+	sort.Slice(p.prefixes, func(i, j int) bool { return len(p.prefixes[i]) > len(p.prefixes[j]) })
+	return p
+}
+
+// allowed reports whether a request from origin may be served.
+func (p *corsPolicy) allowed(origin string) bool {
+	origin = strings.ToLower(origin)
+	if p.exact[origin] {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" || u.Path != "" {
+		return false
+	}
+	for _, w := range p.wildcards {
+		if u.Scheme == w.scheme && strings.HasSuffix(u.Host, w.suffix) && len(u.Host) > len(w.suffix) {
+			return true
+		}
+	}
+	if p.dev && (u.Scheme == "http" || u.Scheme == "https") {
+		if u.Hostname() == "localhost" {
+			return true
+		}
+		if ip := net.ParseIP(u.Hostname()); ip != nil && ip.IsLoopback() {
+			return true
+		}
+	}
+	return false
+}
+
+// rule returns the CORS rule for a request path.
+func (p *corsPolicy) rule(path string) config.CORSRule {
+	for _, prefix := range p.prefixes {
+		if strings.HasPrefix(path, prefix) {
+			return p.groups[prefix]
+		}
+	}
+	return p.fallback
+}
+
+// handle answers preflights and tags responses for allowed origins. Any
+// request from an origin that isn't allowed is refused outright, rather than
+// served without CORS headers, so it can't have side effects either.
+// Requests without an Origin (same-origin, curl, probes) pass untouched.
+func (p *corsPolicy) handle(c *gin.Context) {
+	origin := c.GetHeader("Origin")
+	if origin == "" {
+		c.Next()
+		return
+	}
+	c.Header("Vary", "Origin")
+	if !p.allowed(origin) {
+		writeError(c, errForbidden("Origin not allowed"))
+		return
+	}
+
+	c.Header("Access-Control-Allow-Origin", origin)
+	if c.Request.Method != http.MethodOptions {
+		c.Header("Access-Control-Expose-Headers", requestIDHeader)
+		c.Next()
+		return
+	}
+
+	rule := p.rule(c.Request.URL.Path)
+	c.Header("Access-Control-Allow-Methods", strings.Join(rule.Methods, ", ")+", OPTIONS")
+	if len(rule.Headers) > 0 {
+		c.Header("Access-Control-Allow-Headers", strings.Join(rule.Headers, ", "))
+	}
+	c.Header("Access-Control-Max-Age", p.maxAge)
+	c.AbortWithStatus(http.StatusNoContent)
+}
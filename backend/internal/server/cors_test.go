@@ -0,0 +1,96 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/pranav/replied-backend/internal/config"
+)
+
+func TestCORSOrigins(t *testing.T) {
+	p := newCORSPolicy(config.CORSConfig{
+		AllowedOrigins: []string{"https://replied.app", "https://*.preview.replied.app"},
+	}, "http://app.test")
+
+	for origin, want := range map[string]bool{
+		"http://app.test":                   true,
+		"https://replied.app":               true,
+		"https://REPLIED.app":               true,
+		"https://pr-12.preview.replied.app": true,
+		"https://a.b.preview.replied.app":   true,
+		"https://preview.replied.app":       false,
+		"http://pr-12.preview.replied.app":  false,
+		"https://evilpreview.replied.app":   false,
+		"https://replied.app.evil.com":      false,
+		"https://replied.app:8443":          false,
+		"http://localhost:3000":             false,
+		"null":                              false,
+	} {
+		if got := p.allowed(origin); got != want {
+			t.Errorf("allowed(%q) = %v, want %v", origin, got, want)
+		}
+	}
+
+	p = newCORSPolicy(config.CORSConfig{Dev: true}, "http://app.test")
+	for _, origin := range []string{"http://localhost:3000", "http://127.0.0.1:5173", "http://[::1]:3000"} {
+		if !p.allowed(origin) {
+			t.Errorf("dev mode denied %q", origin)
+		}
+	}
+	if p.allowed("http://localhost.evil.com") {
+		t.Error("dev mode allowed a lookalike host")
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	e := newTestEnv(t)
+
+	res := e.request("OPTIONS", "/profile", "", nil, "Origin", "http://app.test", "Access-Control-Request-Method", "PATCH").
+		expect(http.StatusNoContent)
+	if got := res.Header().Get("Access-Control-Allow-Origin"); got != "http://app.test" {
+		t.Errorf("Allow-Origin = %q", got)
+	}
+	if got := res.Header().Get("Access-Control-Allow-Methods"); !strings.Contains(got, "PATCH") {
+		t.Errorf("Allow-Methods = %q, want PATCH included", got)
+	}
+	if got := res.Header().Get("Access-Control-Allow-Headers"); !strings.Contains(got, "Authorization") {
+		t.Errorf("Allow-Headers = %q, want Authorization included", got)
+	}
+	if got := res.Header().Get("Access-Control-Max-Age"); got != "600" {
+		t.Errorf("Max-Age = %q", got)
+	}
+	if got := res.Header().Get("Access-Control-Allow-Credentials"); got != "" {
+		t.Errorf("Allow-Credentials = %q, want none", got)
+	}
+
+	// Route groups narrow what may be sent
+	res = e.request("OPTIONS", "/media/avatars/a.webp", "", nil, "Origin", "http://app.test").expect(http.StatusNoContent)
+	if got := res.Header().Get("Access-Control-Allow-Methods"); got != "GET, HEAD, OPTIONS" {
+		t.Errorf("media Allow-Methods = %q", got)
+	}
+	if got := res.Header().Get("Access-Control-Allow-Headers"); got != "" {
+		t.Errorf("media Allow-Headers = %q, want none", got)
+	}
+}
+
+func TestCORSDeniesUnknownOrigins(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.addUser("alice", nil)
+
+	res := e.request("OPTIONS", "/inbox", "", nil, "Origin", "https://evil.example").expect(http.StatusForbidden)
+	if got := res.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("Allow-Origin = %q for a denied origin", got)
+	}
+	e.request("DELETE", "/profile", alice.Token, nil, "Origin", "https://evil.example").expect(http.StatusForbidden)
+
+	res = e.request("GET", "/inbox", alice.Token, nil, "Origin", "http://app.test").expect(http.StatusOK)
+	if got := res.Header().Get("Access-Control-Allow-Origin"); got != "http://app.test" {
+		t.Errorf("Allow-Origin = %q", got)
+	}
+	if got := res.Header().Get("Vary"); got != "Origin" {
+		t.Errorf("Vary = %q", got)
+	}
+	// No Origin: not a browser cross-origin call
+	e.request("GET", "/inbox", alice.Token, nil).expect(http.StatusOK)
+}
@@ -0,0 +1,623 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pranav/replied-backend/internal/config"
+	"github.com/supabase-community/gotrue-go/types"
+	"github.com/supabase-community/postgrest-go"
+)
+
+const (
+	// Scheduling a deletion requires a sign-in this recent
+	deletionReauthWindow   = 15 * time.Minute
+	deletionWorkerInterval = time.Minute
+	deletionMaxAttempts    = 5
+	// A running purge this long untouched is assumed dead and requeued
+	deletionStaleAfter = 30 * time.Minute
+	purgeBatchSize     = 100
+)
+
+// authAdminClient calls the GoTrue admin API with the service role key.
+type authAdminClient struct {
+	baseURL    string
+	serviceKey string
+	httpClient *http.Client
+}
+
+func newAuthAdmin(cfg config.SupabaseConfig, httpClient *http.Client) *authAdminClient {
+	return &authAdminClient{
+		baseURL:    cfg.URL,
+		serviceKey: cfg.ServiceRoleKey,
+		httpClient: httpClient,
+	}
+}
+
+// accountDeletion is a row of account_deletions. Rows are kept after the
+// account is gone so the deletion report survives.
+type accountDeletion struct {
+	ID           string          `json:"id"`
+	UserID       string          `json:"user_id"`
+	Username     string          `json:"username"`
+	Status       string          `json:"status"` // scheduled, running, completed, cancelled, failed
+	WasPaused    bool            `json:"was_paused"`
+	Attempts     int             `json:"attempts"`
+	RequestedAt  string          `json:"requested_at"`
+	ScheduledFor string          `json:"scheduled_for"`
+	CompletedAt  *string         `json:"completed_at"`
+	LastError    *string         `json:"last_error"`
+	Report       json.RawMessage `json:"report"`
+}
+
+// deletionReport records what the purge removed.
+type deletionReport struct {
+	StartedAt  time.Time        `json:"started_at"`
+	FinishedAt time.Time        `json:"finished_at"`
+	Counts     map[string]int64 `json:"counts"`
+}
+
+// tokenAuthTime returns when the user last actually authenticated, taken
+// from the amr claim of a Supabase access token. The token's signature has
+// already been checked by GoTrue in authMiddleware.
+func tokenAuthTime(token string) (time.Time, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	var claims struct {
+		AMR []struct {
+			Method    string `json:"method"`
+			Timestamp int64  `json:"timestamp"`
+		} `json:"amr"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return time.Time{}, false
+	}
+
+	var latest int64
+	for _, m := range claims.AMR {
+		if m.Timestamp > latest {
+			latest = m.Timestamp
+		}
+	}
+	if latest == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(latest, 0), true
+}
+
+// activeDeletion returns the user's scheduled or running deletion, if any.
+func (s *Server) activeDeletion(userID string) (*accountDeletion, error) {
+	var rows []accountDeletion
+	_, err := s.db.From("account_deletions").
+		Select("*", "", false).
+		Eq("user_id", userID).
+		In("status", []string{"scheduled", "running"}).
+		Order("requested_at", &postgrest.OrderOpts{Ascending: false}).
+		Limit(1, "").
+		ExecuteTo(&rows)
+	if err != nil || len(rows) == 0 {
+		return nil, err
+	}
+	return &rows[0], nil
+}
+
+// deleteUser removes the user from auth.users via the GoTrue admin API.
+// A user that is already gone counts as deleted.
+func (a *authAdminClient) deleteUser(userID string) error {
+	url := fmt.Sprintf("%s/auth/v1/admin/users/%s", a.baseURL, userID)
+	req, err := http.NewRequest("DELETE", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("apikey", a.serviceKey)
+	req.Header.Set("Authorization", "Bearer "+a.serviceKey)
+
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("auth service error: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// purgeIn deletes rows whose column is one of values, in batches, and
+// returns how many rows went.
+func (s *Server) purgeIn(table, column string, values []string) (int64, error) {
+	var total int64
+	for start := 0; start < len(values); start += purgeBatchSize {
+		end := start + purgeBatchSize
+		if end > len(values) {
+			end = len(values)
+		}
+		_, count, err := s.db.From(table).
+			Delete("minimal", "exact").
+			In(column, values[start:end]).
+			Execute()
+		if err != nil {
+			return total, fmt.Errorf("purging %s: %w", table, err)
+		}
+		total += count
+	}
+	return total, nil
+}
+
+// purgeWhere deletes rows matching a PostgREST or-filter.
+func (s *Server) purgeWhere(table, filter string) (int64, error) {
+	_, count, err := s.db.From(table).
+		Delete("minimal", "exact").
+		Or(filter, "").
+		Execute()
+	if err != nil {
+		return 0, fmt.Errorf("purging %s: %w", table, err)
+	}
+	return count, nil
+}
+
+// purgeMessages deletes messages matching column = userID together with the
+// replies, likes and bookmarks attached to them, batch by batch, counting
+// the messages under countKey. It returns the receivers of the deleted
+// messages so their cached profiles can be dropped.
+func (s *Server) purgeMessages(column, userID, countKey string, counts map[string]int64) (map[string]bool, error) {
+	receivers := map[string]bool{}
+	for {
+		var batch []struct {
+			ID         string `json:"id"`
+			ReceiverID string `json:"receiver_id"`
+		}
+		_, err := s.db.From("messages").
+			Select("id, receiver_id", "", false).
+			Eq(column, userID).
+			Limit(purgeBatchSize, "").
+			ExecuteTo(&batch)
+		if err != nil {
+			return receivers, fmt.Errorf("listing messages: %w", err)
+		}
+		if len(batch) == 0 {
+			return receivers, nil
+		}
+
+		ids := make([]string, len(batch))
+		for i, m := range batch {
+			ids[i] = m.ID
+			receivers[m.ReceiverID] = true
+		}
+
+		for _, table := range []string{"likes", "bookmarks", "replies"} {
+			n, err := s.purgeIn(table, "message_id", ids)
+			if err != nil {
+				return receivers, err
+			}
+			counts[table] += n
+		}
+		n, err := s.purgeIn("messages", "id", ids)
+		if err != nil {
+			return receivers, err
+		}
+		counts[countKey] += n
+	}
+}
+
+// purgeCacheKeys drops everything Redis holds for a user: their viewer
+// state, the cached public profile under their current and past usernames,
+// and their send rate limits, inbox caps and duplicate checks.
+func (s *Server) purgeCacheKeys(ctx context.Context, userID string, usernames []string) int64 {
+	if s.rdb == nil {
+		return 0
+	}
+
+	keys := []string{"viewerstate:ver:" + userID, "ratelimit:send:account:" + userID, sendVolumeKey(userID)}
+	for _, u := range usernames {
+		keys = append(keys, profileCacheKey(u))
+	}
+	for _, pattern := range []string{
+		"viewerstate:" + userID + ":*",
+		"inboxcap:" + userID + ":*",
+		"inboxcap:*:account:" + userID,
+		"dupe:" + userID + ":*",
+		"dupe:*:account:" + userID + ":*",
+	} {
+		iter := s.rdb.Scan(ctx, 0, pattern, 500).Iterator()
+		for iter.Next(ctx) {
+			keys = append(keys, iter.Val())
+		}
+		if err := iter.Err(); err != nil {
+			loggerFrom(ctx).Error("Redis error scanning keys", "pattern", pattern, "error", err)
+		}
+	}
+
+	n, err := s.rdb.Del(ctx, keys...).Result()
+	if err != nil {
+		loggerFrom(ctx).Error("Redis error purging keys", "error", err)
+	}
+	return n
+}
+
+// deletionPending reports whether the user's account is scheduled for
+// deletion or being purged. Lookup errors count as no.
+func (s *Server) deletionPending(ctx context.Context, userID string) bool {
+	d, err := s.activeDeletion(userID)
+	if err != nil {
+		loggerFrom(ctx).Error("Supabase error fetching deletion", "error", err)
+		return false
+	}
+	return d != nil
+}
+
+// purgeAccount removes everything belonging to a user and finally the auth
+// user itself. Each step is idempotent, so a failed purge can be retried
+// from the start.
+func (s *Server) purgeAccount(ctx context.Context, d accountDeletion) (*deletionReport, error) {
+	report := &deletionReport{StartedAt: time.Now().UTC(), Counts: map[string]int64{}}
+	uid := d.UserID
+
+	usernames := []string{d.Username}
+	var history []struct {
+		Username string `json:"username"`
+	}
+	if _, err := s.db.From("username_history").
+		Select("username", "", false).
+		Eq("profile_id", uid).
+		ExecuteTo(&history); err != nil {
+		return nil, fmt.Errorf("listing username history: %w", err)
+	}
+	for _, h := range history {
+		usernames = append(usernames, h.Username)
+	}
+
+	_, avatarKeys, err := s.currentAvatar(uid)
+	if err != nil && !isNoRows(err) {
+		return nil, fmt.Errorf("fetching avatar: %w", err)
+	}
+
+	// The user's own likes, bookmarks and collections
+	for _, table := range []string{"likes", "bookmarks", "bookmark_collections"} {
+		n, err := s.purgeIn(table, "user_id", []string{uid})
+		if err != nil {
+			return nil, err
+		}
+		report.Counts[table] += n
+	}
+
+	// Messages they received, then messages they sent to others
+	if _, err := s.purgeMessages("receiver_id", uid, "messages_received", report.Counts); err != nil {
+		return nil, err
+	}
+	receivers, err := s.purgeMessages("sender_id", uid, "messages_sent", report.Counts)
+	if err != nil {
+		return nil, err
+	}
+	for receiverID := range receivers {
+		s.invalidateProfileCache(receiverID)
+	}
+
+	n, err := s.purgeIn("replies", "sender_id", []string{uid})
+	if err != nil {
+		return nil, err
+	}
+	report.Counts["replies"] += n
+
+	var friends []struct {
+		SenderID   string `json:"sender_id"`
+		ReceiverID string `json:"receiver_id"`
+	}
+	if _, err := s.db.From("friendships").
+		Select("sender_id, receiver_id", "", false).
+		Or(fmt.Sprintf("sender_id.eq.%s,receiver_id.eq.%s", uid, uid), "").
+		ExecuteTo(&friends); err != nil {
+		return nil, fmt.Errorf("listing friendships: %w", err)
+	}
+	if report.Counts["friendships"], err = s.purgeWhere("friendships",
+		fmt.Sprintf("sender_id.eq.%s,receiver_id.eq.%s", uid, uid)); err != nil {
+		return nil, err
+	}
+	for _, f := range friends {
+		s.bumpViewerState(f.SenderID)
+		s.bumpViewerState(f.ReceiverID)
+	}
+
+	if report.Counts["user_blocks"], err = s.purgeWhere("user_blocks",
+		fmt.Sprintf("blocker_id.eq.%s,blocked_id.eq.%s", uid, uid)); err != nil {
+		return nil, err
+	}
+	if report.Counts["username_history"], err = s.purgeIn("username_history", "profile_id", []string{uid}); err != nil {
+		return nil, err
+	}
+	if report.Counts["prompts"], err = s.purgeIn("prompts", "profile_id", []string{uid}); err != nil {
+		return nil, err
+	}
+
+	var exports []dataExport
+	if _, err := s.db.From("data_exports").
+		Select("*", "", false).
+		Eq("user_id", uid).
+		ExecuteTo(&exports); err != nil {
+		return nil, fmt.Errorf("listing exports: %w", err)
+	}
+	var exportKeys []string
+	for _, e := range exports {
+		if e.BlobKey != nil {
+			exportKeys = append(exportKeys, *e.BlobKey)
+		}
+	}
+	if len(exportKeys) > 0 {
+		if err := s.exportBlobs.Delete(ctx, exportKeys...); err != nil {
+			return nil, fmt.Errorf("deleting export archives: %w", err)
+		}
+		report.Counts["export_files"] = int64(len(exportKeys))
+	}
+	if report.Counts["data_exports"], err = s.purgeIn("data_exports", "user_id", []string{uid}); err != nil {
+		return nil, err
+	}
+	if report.Counts["import_jobs"], err = s.purgeIn("import_jobs", "user_id", []string{uid}); err != nil {
+		return nil, err
+	}
+
+	if len(avatarKeys) > 0 {
+		if err := s.blobs.Delete(ctx, avatarKeys...); err != nil {
+			return nil, fmt.Errorf("deleting avatar files: %w", err)
+		}
+		report.Counts["avatar_files"] = int64(len(avatarKeys))
+	}
+
+	if report.Counts["profiles"], err = s.purgeIn("profiles", "id", []string{uid}); err != nil {
+		return nil, err
+	}
+	report.Counts["cache_keys"] = s.purgeCacheKeys(ctx, uid, usernames)
+
+	if err := s.authAdmin.deleteUser(uid); err != nil {
+		return nil, err
+	}
+	report.Counts["auth_users"] = 1
+
+	report.FinishedAt = time.Now().UTC()
+	return report, nil
+}
+
+// runDueDeletions claims every deletion whose grace period is over and purges
+// it. Claiming flips the status to running first, so several API instances
+// never purge the same account at once. Purges left running by a crashed
+// instance are requeued once stale; every step is idempotent, so they start
+// again from the top. It stops between accounts once ctx is cancelled.
+func (s *Server) runDueDeletions(ctx context.Context) {
+	stale := time.Now().Add(-deletionStaleAfter).UTC().Format(time.RFC3339)
+	_, _, err := s.db.From("account_deletions").
+		Update(map[string]interface{}{"status": "scheduled"}, "minimal", "").
+		Eq("status", "running").
+		Lte("updated_at", stale).
+		Execute()
+	if err != nil {
+		loggerFrom(ctx).Error("Supabase error requeueing deletions", "error", err)
+	}
+
+	var due []accountDeletion
+	_, err = s.db.From("account_deletions").
+		Select("*", "", false).
+		Eq("status", "scheduled").
+		Lte("scheduled_for", time.Now().UTC().Format(time.RFC3339)).
+		ExecuteTo(&due)
+	if err != nil {
+		loggerFrom(ctx).Error("Supabase error listing due deletions", "error", err)
+		return
+	}
+
+	for _, d := range due {
+		if ctx.Err() != nil {
+			return
+		}
+
+		var claimed []accountDeletion
+		_, err := s.db.From("account_deletions").
+			Update(map[string]interface{}{"status": "running", "updated_at": "now()"}, "representation", "").
+			Eq("id", d.ID).
+			Eq("status", "scheduled").
+			ExecuteTo(&claimed)
+		if err != nil || len(claimed) == 0 {
+			continue
+		}
+
+		report, err := s.purgeAccount(ctx, d)
+		if err != nil {
+			slog.Error("Account deletion failed", "deletion_id", d.ID, "error", err)
+			// Being interrupted by a shutdown is not the account's fault
+			attempts := d.Attempts + 1
+			if ctx.Err() != nil {
+				attempts = d.Attempts
+			}
+			status := "scheduled"
+			if attempts >= deletionMaxAttempts {
+				status = "failed"
+			}
+			_, _, uerr := s.db.From("account_deletions").
+				Update(map[string]interface{}{
+					"status":     status,
+					"attempts":   attempts,
+					"last_error": err.Error(),
+					"updated_at": "now()",
+				}, "minimal", "").
+				Eq("id", d.ID).
+				Execute()
+			if uerr != nil {
+				loggerFrom(ctx).Error("Supabase error recording deletion failure", "error", uerr)
+			}
+			continue
+		}
+
+		_, _, err = s.db.From("account_deletions").
+			Update(map[string]interface{}{
+				"status":       "completed",
+				"attempts":     d.Attempts + 1,
+				"completed_at": report.FinishedAt.Format(time.RFC3339),
+				"last_error":   nil,
+				"report":       report,
+				"updated_at":   "now()",
+			}, "minimal", "").
+			Eq("id", d.ID).
+			Execute()
+		if err != nil {
+			loggerFrom(ctx).Error("Supabase error recording deletion report", "error", err)
+		}
+		slog.Info("Account deletion completed", "deletion_id", d.ID, "counts", report.Counts)
+	}
+}
+
+// startDeletionWorker purges accounts whose grace period has run out.
+func (s *Server) startDeletionWorker() {
+	s.tasks.Every("account deletion", deletionWorkerInterval, s.runDueDeletions)
+}
+
+func (s *Server) registerDeletionRoutes(r *gin.Engine, authMiddleware gin.HandlerFunc) {
+	// Schedule Account Deletion: requires typing the username and a recent
+	// sign-in. The profile is paused until the grace period runs out.
+	scheduleDeletion := handle(func(c *gin.Context) error {
+		var body struct {
+			ConfirmUsername string `json:"confirm_username"`
+		}
+		// The body is optional so a bare request gets the confirmation error
+		_ = c.ShouldBindJSON(&body)
+
+		user, _ := c.Get("user")
+		supabaseUser := user.(types.User)
+		userID := supabaseUser.ID.String()
+
+		var profile struct {
+			Username string `json:"username"`
+			IsPaused bool   `json:"is_paused"`
+		}
+		_, err := s.db.From("profiles").
+			Select("username, is_paused", "", false).
+			Eq("id", userID).
+			Single().
+			ExecuteTo(&profile)
+		if isNoRows(err) {
+			return errNotFound("Profile not found")
+		}
+		if err != nil {
+			return errInternal("Failed to schedule deletion", fmt.Errorf("fetching profile: %w", err))
+		}
+
+		if normalizeUsername(body.ConfirmUsername) != profile.Username {
+			return errValidation("Type your username to confirm account deletion")
+		}
+
+		token, _ := bearerToken(c)
+		authTime, ok := tokenAuthTime(token)
+		if !ok || time.Since(authTime) > deletionReauthWindow {
+			return errUnauthorized("Please sign in again to confirm account deletion").
+				withDetails(gin.H{"reauth_required": true})
+		}
+
+		existing, err := s.activeDeletion(userID)
+		if err != nil {
+			return errInternal("Failed to schedule deletion", fmt.Errorf("fetching deletion: %w", err))
+		}
+		if existing != nil {
+			return errConflict("Account deletion is already scheduled").withDetails(gin.H{"deletion": existing})
+		}
+
+		now := time.Now().UTC()
+		var created []accountDeletion
+		_, err = s.db.From("account_deletions").
+			Insert(map[string]interface{}{
+				"user_id":       userID,
+				"username":      profile.Username,
+				"status":        "scheduled",
+				"was_paused":    profile.IsPaused,
+				"requested_at":  now.Format(time.RFC3339),
+				"scheduled_for": now.Add(s.cfg.Limits.AccountDeletionGrace.Duration).Format(time.RFC3339),
+			}, false, "", "representation", "").
+			ExecuteTo(&created)
+
+		if err != nil {
+			return errInternal("Failed to schedule deletion", fmt.Errorf("scheduling deletion: %w", err))
+		}
+		if len(created) == 0 {
+			return errInternal("Failed to schedule deletion", errors.New("scheduling deletion: no row returned"))
+		}
+
+		// Stop new messages (and their email notifications) during the grace period
+		_, _, err = s.db.From("profiles").
+			Update(map[string]interface{}{"is_paused": true}, "minimal", "").
+			Eq("id", userID).
+			Execute()
+		if err != nil {
+			logFor(c).Error("Supabase error pausing profile", "error", err)
+		}
+		s.invalidateProfileCache(userID)
+
+		c.JSON(http.StatusAccepted, created[0])
+		return nil
+	})
+
+	r.POST("/profile/deletion", authMiddleware, scheduleDeletion)
+	// Delete Profile (Account): kept for older clients, same as POST /profile/deletion
+	r.DELETE("/profile", authMiddleware, scheduleDeletion)
+
+	// Get Scheduled Deletion
+	r.GET("/profile/deletion", authMiddleware, handle(func(c *gin.Context) error {
+		user, _ := c.Get("user")
+		supabaseUser := user.(types.User)
+
+		deletion, err := s.activeDeletion(supabaseUser.ID.String())
+		if err != nil {
+			return errInternal("Failed to fetch deletion", fmt.Errorf("fetching deletion: %w", err))
+		}
+		if deletion == nil {
+			return errNotFound("No deletion scheduled")
+		}
+
+		c.JSON(http.StatusOK, deletion)
+		return nil
+	}))
+
+	// Cancel Scheduled Deletion: restores the profile's previous paused state
+	r.DELETE("/profile/deletion", authMiddleware, handle(func(c *gin.Context) error {
+		user, _ := c.Get("user")
+		supabaseUser := user.(types.User)
+		userID := supabaseUser.ID.String()
+
+		var cancelled []accountDeletion
+		_, err := s.db.From("account_deletions").
+			Update(map[string]interface{}{"status": "cancelled"}, "representation", "").
+			Eq("user_id", userID).
+			Eq("status", "scheduled").
+			ExecuteTo(&cancelled)
+
+		if err != nil {
+			return errInternal("Failed to cancel deletion", fmt.Errorf("cancelling deletion: %w", err))
+		}
+		if len(cancelled) == 0 {
+			return errNotFound("No deletion that can still be cancelled")
+		}
+
+		_, _, err = s.db.From("profiles").
+			Update(map[string]interface{}{"is_paused": cancelled[0].WasPaused}, "minimal", "").
+			Eq("id", userID).
+			Execute()
+		if err != nil {
+			logFor(c).Error("Supabase error restoring profile", "error", err)
+		}
+		s.invalidateProfileCache(userID)
+
+		c.JSON(http.StatusOK, gin.H{"status": "cancelled"})
+		return nil
+	}))
+}
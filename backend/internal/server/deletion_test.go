@@ -0,0 +1,155 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestScheduleDeletion(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.addUser("alice", nil)
+	fresh := e.db.signIn(alice.ID, time.Now())
+	stale := e.db.signIn(alice.ID, time.Now().Add(-time.Hour))
+
+	e.request("POST", "/profile/deletion", fresh, map[string]string{"confirm_username": "bob"}).
+		expect(http.StatusBadRequest)
+	// Opaque tokens carry no sign-in time, old sign-ins are too old
+	e.request("POST", "/profile/deletion", alice.Token, map[string]string{"confirm_username": "alice"}).
+		expect(http.StatusUnauthorized)
+	res := e.request("POST", "/profile/deletion", stale, map[string]string{"confirm_username": "alice"}).
+		expect(http.StatusUnauthorized)
+	if details, _ := res.apiError().Details.(map[string]interface{}); details["reauth_required"] != true {
+		t.Error("stale sign-in did not ask for reauthentication")
+	}
+
+	e.request("GET", "/profile/deletion", fresh, nil).expect(http.StatusNotFound)
+	deletion := e.request("POST", "/profile/deletion", fresh, map[string]string{"confirm_username": " Alice "}).
+		expect(http.StatusAccepted).object()
+	scheduled, err := time.Parse(time.RFC3339, deletion["scheduled_for"].(string))
+	if err != nil || time.Until(scheduled) < 6*24*time.Hour {
+		t.Errorf("scheduled_for = %v, want the 7 day grace period", deletion["scheduled_for"])
+	}
+	if paused := e.db.rows("profiles", row{"id": alice.ID})[0]["is_paused"]; paused != true {
+		t.Error("profile was not paused during the grace period")
+	}
+
+	// The legacy route is the same action
+	e.request("DELETE", "/profile", fresh, map[string]string{"confirm_username": "alice"}).expect(http.StatusConflict)
+	if got := e.request("GET", "/profile/deletion", fresh, nil).expect(http.StatusOK).object(); got["id"] != deletion["id"] {
+		t.Errorf("GET /profile/deletion = %v", got)
+	}
+
+	e.request("DELETE", "/profile/deletion", alice.Token, nil).expect(http.StatusOK)
+	e.request("DELETE", "/profile/deletion", alice.Token, nil).expect(http.StatusNotFound)
+	if paused := e.db.rows("profiles", row{"id": alice.ID})[0]["is_paused"]; paused != false {
+		t.Error("cancelling did not restore the paused state")
+	}
+}
+
+func TestDueDeletionPurgesAccount(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.addUser("alice", nil)
+	bob := e.addUser("bob", nil)
+	received := e.addMessage(alice, "to alice", "replied", nil)
+	e.addReply(received, alice, "hi")
+	sent := e.addMessage(bob, "from alice", "pending", row{"sender_id": alice.ID})
+	kept := e.addMessage(bob, "anonymous", "pending", nil)
+	e.db.insert("likes", row{"message_id": kept["id"], "user_id": alice.ID})
+	e.db.insert("friendships", row{"sender_id": alice.ID, "receiver_id": bob.ID, "status": "accepted"})
+	e.rdb.Set(context.Background(), profileCacheKey("alice"), "{}", 0)
+	limitKeys := []string{
+		"ratelimit:send:account:" + alice.ID,
+		"inboxcap:" + alice.ID + ":fp:abc",
+		"inboxcap:" + bob.ID + ":account:" + alice.ID,
+		"dupe:" + alice.ID + ":fp:abc:def",
+		"dupe:" + bob.ID + ":account:" + alice.ID + ":def",
+		sendVolumeKey(alice.ID),
+	}
+	for _, key := range append(limitKeys, "inboxcap:"+bob.ID+":fp:abc") {
+		e.rdb.Set(context.Background(), key, "1", 0)
+	}
+
+	token := e.db.signIn(alice.ID, time.Now())
+	e.request("POST", "/profile/deletion", token, map[string]string{"confirm_username": "alice"}).
+		expect(http.StatusAccepted)
+
+	// Nothing happens before the grace period is over
+	e.srv.runDueDeletions(context.Background())
+	if len(e.db.rows("profiles", row{"id": alice.ID})) != 1 {
+		t.Fatal("account purged during the grace period")
+	}
+
+	e.db.update("account_deletions", row{"user_id": alice.ID}, row{"scheduled_for": time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)})
+	e.srv.runDueDeletions(context.Background())
+
+	deletion := e.db.rows("account_deletions", row{"user_id": alice.ID})[0]
+	if deletion["status"] != "completed" {
+		t.Fatalf("deletion = %v", deletion)
+	}
+	for table, match := range map[string]row{
+		"profiles":    {"id": alice.ID},
+		"messages":    {"id": received["id"]},
+		"replies":     {"message_id": received["id"]},
+		"likes":       {"user_id": alice.ID},
+		"friendships": {"sender_id": alice.ID},
+	} {
+		if left := e.db.rows(table, match); len(left) != 0 {
+			t.Errorf("%s left behind: %v", table, left)
+		}
+	}
+	if len(e.db.rows("messages", row{"id": sent["id"]})) != 0 {
+		t.Error("messages alice sent were kept")
+	}
+	if len(e.db.rows("messages", row{"id": kept["id"]})) != 1 {
+		t.Error("unrelated message was deleted")
+	}
+	if keys := e.rdb.keys("profilecache:alice*"); len(keys) != 0 {
+		t.Errorf("cache keys left: %v", keys)
+	}
+	for _, key := range limitKeys {
+		if keys := e.rdb.keys(key); len(keys) != 0 {
+			t.Errorf("rate limit key left: %s", key)
+		}
+	}
+	if keys := e.rdb.keys("inboxcap:" + bob.ID + ":fp:*"); len(keys) != 1 {
+		t.Error("another sender's inbox cap was purged")
+	}
+	if len(e.db.deletedUsers) != 1 || e.db.deletedUsers[0] != alice.ID {
+		t.Errorf("deleted auth users = %v", e.db.deletedUsers)
+	}
+}
+
+func TestStaleDeletionIsRequeued(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.addUser("alice", nil)
+	past := time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+	e.db.insert("account_deletions", row{"user_id": alice.ID, "username": "alice", "status": "running", "scheduled_for": past,
+		"updated_at": time.Now().Add(-deletionStaleAfter - time.Minute).UTC().Format(time.RFC3339)})
+	bob := e.addUser("bob", nil)
+	e.db.insert("account_deletions", row{"user_id": bob.ID, "username": "bob", "status": "running", "scheduled_for": past,
+		"updated_at": time.Now().UTC().Format(time.RFC3339)})
+
+	e.srv.runDueDeletions(context.Background())
+	if d := e.db.rows("account_deletions", row{"user_id": alice.ID})[0]; d["status"] != "completed" {
+		t.Errorf("stale deletion = %v, want completed", d)
+	}
+	// A purge still in progress elsewhere is left alone
+	if d := e.db.rows("account_deletions", row{"user_id": bob.ID})[0]; d["status"] != "running" {
+		t.Errorf("fresh deletion = %v, want running", d)
+	}
+}
+
+func TestQueuedEmailsSkipDeletedAccounts(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.addUser("alice", row{"email": e.encrypt("alice@example.com")})
+	e.db.insert("account_deletions", row{"user_id": alice.ID, "username": "alice",
+		"scheduled_for": time.Now().Add(time.Hour).UTC().Format(time.RFC3339)})
+
+	e.request("POST", "/send", "", map[string]string{"receiver_id": alice.ID, "content": "hello"}).expect(http.StatusCreated)
+	e.eventually("email cancelled", func() bool { return e.srv.metrics.emails.Value("cancelled") == 1 })
+	if n := e.srv.metrics.emails.Value("skipped"); n != 0 {
+		t.Errorf("emails attempted = %v, want 0", n)
+	}
+}
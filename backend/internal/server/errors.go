@@ -0,0 +1,241 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// errorCode is the machine-readable kind of an API error. Clients branch on
+// it, so codes never change meaning; messages are for people.
+type errorCode string
+
+const (
+	codeValidation       errorCode = "validation"
+	codeUnauthorized     errorCode = "unauthorized"
+	codeForbidden        errorCode = "forbidden"
+	codeNotFound         errorCode = "not_found"
+	codeConflict         errorCode = "conflict"
+	codeTooLarge         errorCode = "too_large"
+	codeUnsupportedMedia errorCode = "unsupported_media_type"
+	codeRateLimited      errorCode = "rate_limited"
+	codeVerification     errorCode = "verification_failed" // anonymous sender didn't pass the challenge
+	codeBlocked          errorCode = "blocked"             // rejected by a block or content filter
+	codePaused           errorCode = "paused"              // the receiver paused their inbox
+	codeInternal         errorCode = "internal"
+)
+
+var errorStatus = map[errorCode]int{
+	codeValidation:       http.StatusBadRequest,
+	codeUnauthorized:     http.StatusUnauthorized,
+	codeForbidden:        http.StatusForbidden,
+	codeNotFound:         http.StatusNotFound,
+	codeConflict:         http.StatusConflict,
+	codeTooLarge:         http.StatusRequestEntityTooLarge,
+	codeUnsupportedMedia: http.StatusUnsupportedMediaType,
+	codeRateLimited:      http.StatusTooManyRequests,
+	codeVerification:     http.StatusForbidden,
+	codeBlocked:          http.StatusForbidden,
+	codePaused:           http.StatusForbidden,
+	codeInternal:         http.StatusInternalServerError,
+}
+
+// apiError is an error a handler returns to end the request. Message and
+// Details are sent to the client; cause is only logged.
+type apiError struct {
+	Code    errorCode
+	Message string
+	Details interface{}
+	cause   error
+}
+
+func (e *apiError) Error() string {
+	if e.cause != nil {
+		return fmt.Sprintf("%s: %s: %v", e.Code, e.Message, e.cause)
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+func (e *apiError) Unwrap() error {
+	return e.cause
+}
+
+// withDetails attaches structured details, such as the offending fields.
+func (e *apiError) withDetails(details interface{}) *apiError {
+	e.Details = details
+	return e
+}
+
+func errValidation(message string) *apiError {
+	return &apiError{Code: codeValidation, Message: message}
+}
+
+func errUnauthorized(message string) *apiError {
+	return &apiError{Code: codeUnauthorized, Message: message}
+}
+
+func errForbidden(message string) *apiError {
+	return &apiError{Code: codeForbidden, Message: message}
+}
+
+func errNotFound(message string) *apiError {
+	return &apiError{Code: codeNotFound, Message: message}
+}
+
+func errConflict(message string) *apiError {
+	return &apiError{Code: codeConflict, Message: message}
+}
+
+func errTooLarge(message string) *apiError {
+	return &apiError{Code: codeTooLarge, Message: message}
+}
+
+func errUnsupportedMedia(message string) *apiError {
+	return &apiError{Code: codeUnsupportedMedia, Message: message}
+}
+
+func errRateLimited(message string) *apiError {
+	return &apiError{Code: codeRateLimited, Message: message}
+}
+
+func errVerification(message string) *apiError {
+	return &apiError{Code: codeVerification, Message: message}
+}
+
+func errBlocked(message string) *apiError {
+	return &apiError{Code: codeBlocked, Message: message}
+}
+
+func errPaused(message string) *apiError {
+	return &apiError{Code: codePaused, Message: message}
+}
+
+// errInternal hides cause from the client behind a generic message; it is
+// logged with the request ID instead.
+func errInternal(message string, cause error) *apiError {
+	return &apiError{Code: codeInternal, Message: message, cause: cause}
+}
+
+// errorBody is the JSON shape of every error response.
+type errorBody struct {
+	Code      errorCode   `json:"code"`
+	Message   string      `json:"message"`
+	Details   interface{} `json:"details"`
+	RequestID string      `json:"request_id"`
+}
+
+// writeError aborts the request with err in the standard shape. Errors that
+// aren't an *apiError are unexpected and become a generic internal error.
+func writeError(c *gin.Context, err error) {
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) {
+		apiErr = errInternal("Internal server error", err)
+	}
+	if apiErr.Code == codeInternal && apiErr.cause != nil {
+		logFor(c).Error(apiErr.Message, "error", apiErr.cause)
+	}
+
+	status, ok := errorStatus[apiErr.Code]
+	if !ok {
+		status = http.StatusInternalServerError
+	}
+	c.AbortWithStatusJSON(status, errorBody{
+		Code:      apiErr.Code,
+		Message:   apiErr.Message,
+		Details:   apiErr.Details,
+		RequestID: c.GetString("request_id"),
+	})
+}
+
+// handlerFunc is a route handler that reports failure by returning an error
+// instead of writing it itself.
+type handlerFunc func(c *gin.Context) error
+
+// handle adapts a handlerFunc to gin, writing any error it returns.
+func handle(h handlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := h(c); err != nil {
+			writeError(c, err)
+		}
+	}
+}
+
+var registerJSONNames sync.Once
+
+// bindJSON decodes the request body into v and runs its binding rules. A
+// failed rule comes back as a validation error whose details map each JSON
+// field to the rule it broke; the message describes the first one.
+func bindJSON(c *gin.Context, v interface{}) error {
+	registerJSONNames.Do(func() {
+		if engine, ok := binding.Validator.Engine().(*validator.Validate); ok {
+			engine.RegisterTagNameFunc(jsonFieldName)
+		}
+	})
+
+	err := c.ShouldBindJSON(v)
+	if err == nil {
+		return nil
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return errTooLarge("Request body is too large")
+	}
+	var invalid validator.ValidationErrors
+	if errors.As(err, &invalid) {
+		fields := make(map[string]string, len(invalid))
+		for _, fe := range invalid {
+			fields[fe.Field()] = fe.Tag()
+		}
+		return errValidation(fieldErrorMessage(invalid[0])).withDetails(gin.H{"fields": fields})
+	}
+	return errValidation("Invalid body")
+}
+
+// fieldErrorMessage describes a broken binding rule, e.g. "Content must be
+// at most 1000 characters".
+func fieldErrorMessage(fe validator.FieldError) string {
+	// blocked_phrases[3] → Blocked phrases
+	name, _, _ := strings.Cut(fe.Field(), "[")
+	name = strings.ReplaceAll(name, "_", " ")
+	if name != "" {
+		name = strings.ToUpper(name[:1]) + name[1:]
+	}
+
+	unit := " characters"
+	switch fe.Kind() {
+	case reflect.Slice, reflect.Map:
+		unit = " items"
+	case reflect.Int, reflect.Int64, reflect.Float64:
+		unit = ""
+	}
+	switch fe.Tag() {
+	case "required":
+		return name + " is required"
+	case "max":
+		return fmt.Sprintf("%s must be at most %s%s", name, fe.Param(), unit)
+	case "min":
+		return fmt.Sprintf("%s must be at least %s%s", name, fe.Param(), unit)
+	case "oneof":
+		return fmt.Sprintf("%s must be one of %s", name, strings.ReplaceAll(fe.Param(), " ", ", "))
+	}
+	return "Invalid " + strings.ToLower(name)
+}
+
+// jsonFieldName names struct fields in validation errors by their JSON key.
+func jsonFieldName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "-" {
+		return ""
+	}
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestErrorShape(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.addUser("alice", nil)
+
+	res := e.request("GET", "/profile/nobody", "", nil, requestIDHeader, "req-7").expect(http.StatusNotFound)
+	body := res.apiError()
+	if body.Code != codeNotFound || body.Message == "" || body.RequestID != "req-7" {
+		t.Errorf("error = %+v", body)
+	}
+
+	res = e.request("POST", "/send", alice.Token, map[string]string{"receiver_id": alice.ID}).expect(http.StatusBadRequest)
+	body = res.apiError()
+	fields, _ := body.Details.(map[string]interface{})["fields"].(map[string]interface{})
+	if body.Code != codeValidation || fields["content"] != "required" {
+		t.Errorf("error = %+v, want content reported as required", body)
+	}
+
+	res = e.request("POST", "/send", alice.Token, `{"receiver_id":`).expect(http.StatusBadRequest)
+	if body := res.apiError(); body.Code != codeValidation || body.Details != nil {
+		t.Errorf("malformed JSON error = %+v", body)
+	}
+}
+
+func TestUnexpectedErrorsBecomeInternal(t *testing.T) {
+	e := newTestEnv(t)
+	e.srv.router.GET("/boom", handle(func(c *gin.Context) error {
+		return errors.New("driver exploded")
+	}))
+
+	body := e.request("GET", "/boom", "", nil).expect(http.StatusInternalServerError).apiError()
+	if body.Code != codeInternal || body.Message != "Internal server error" {
+		t.Errorf("error = %+v", body)
+	}
+}
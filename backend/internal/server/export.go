@@ -0,0 +1,583 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/supabase-community/gotrue-go/types"
+	"github.com/supabase-community/postgrest-go"
+)
+
+const (
+	// Finished archives are deleted after this long
+	exportRetention = 7 * 24 * time.Hour
+	// Signed download links stay valid for this long
+	exportDownloadTTL = 15 * time.Minute
+	// Running jobs that have not reported progress for this long are
+	// assumed to belong to a stopped server and are restarted
+	exportStaleAfter = 10 * time.Minute
+
+	exportSigningKeyDomain = "replied/export-download/v1"
+)
+
+// dataExport is a row of data_exports.
+type dataExport struct {
+	ID          string  `json:"id"`
+	UserID      string  `json:"user_id"`
+	Status      string  `json:"status"` // queued, running, ready, failed, expired
+	Progress    int     `json:"progress"`
+	Step        string  `json:"step"`
+	BlobKey     *string `json:"blob_key"`
+	SizeBytes   *int64  `json:"size_bytes"`
+	Error       *string `json:"error"`
+	CreatedAt   string  `json:"created_at"`
+	UpdatedAt   string  `json:"updated_at"`
+	CompletedAt *string `json:"completed_at"`
+	ExpiresAt   *string `json:"expires_at"`
+}
+
+// exportArchive is the content of data.json in an export.
+type exportArchive struct {
+	ExportedAt  time.Time                `json:"exported_at"`
+	Profile     map[string]interface{}   `json:"profile"`
+	Inbox       []interface{}            `json:"inbox"`
+	History     []interface{}            `json:"history"`
+	Sent        []interface{}            `json:"sent"`
+	Likes       []interface{}            `json:"likes"`
+	Bookmarks   []interface{}            `json:"bookmarks"`
+	Collections []map[string]interface{} `json:"collections"`
+	Friends     []map[string]interface{} `json:"friends"`
+	Prompts     []promptRow              `json:"prompts"`
+}
+
+func (s *Server) exportSigningKey() []byte {
+	return s.cipher.deriveKey(exportSigningKeyDomain)
+}
+
+// exportSignature signs an export ID together with the link's expiry.
+func (s *Server) exportSignature(exportID string, expires int64) string {
+	mac := hmac.New(sha256.New, s.exportSigningKey())
+	mac.Write([]byte(exportID + "|" + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// exportDownloadURL returns a signed, time-limited link to an archive.
+func (s *Server) exportDownloadURL(exportID string) (string, time.Time) {
+	expiresAt := time.Now().Add(exportDownloadTTL)
+	url := fmt.Sprintf("%s/profile/export/%s/download?expires=%d&signature=%s",
+		s.cfg.PublicURL, exportID, expiresAt.Unix(), s.exportSignature(exportID, expiresAt.Unix()))
+	return url, expiresAt
+}
+
+// fetchSentMessages returns the messages a user sent while logged in, with
+// the recipient and any reply, decrypted.
+func (s *Server) fetchSentMessages(userID string) ([]interface{}, error) {
+	var messages []interface{}
+	_, err := s.db.From("messages").
+		Select("*, replies(*), receiver:profiles!receiver_id(username)", "", false).
+		Eq("sender_id", userID).
+		Order("created_at", &postgrest.OrderOpts{Ascending: false}).
+		ExecuteTo(&messages)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, m := range messages {
+		messages[i] = s.decryptMessageMap(m)
+	}
+	return messages, nil
+}
+
+func (s *Server) getExport(exportID string) (*dataExport, error) {
+	var job dataExport
+	_, err := s.db.From("data_exports").
+		Select("*", "", false).
+		Eq("id", exportID).
+		Single().
+		ExecuteTo(&job)
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (s *Server) updateExport(exportID string, data map[string]interface{}) {
+	data["updated_at"] = "now()"
+	_, _, err := s.db.From("data_exports").
+		Update(data, "minimal", "").
+		Eq("id", exportID).
+		Execute()
+	if err != nil {
+		slog.Error("Supabase error updating export", "export_id", exportID, "error", err)
+	}
+}
+
+// claimExport moves a queued export to running. Only one caller wins, so
+// the same job is never built twice.
+func (s *Server) claimExport(exportID string) bool {
+	var claimed []dataExport
+	_, err := s.db.From("data_exports").
+		Update(map[string]interface{}{"status": "running", "updated_at": "now()"}, "representation", "").
+		Eq("id", exportID).
+		Eq("status", "queued").
+		ExecuteTo(&claimed)
+	return err == nil && len(claimed) > 0
+}
+
+// buildExportArchive collects a user's data, reporting progress as it goes.
+func (s *Server) buildExportArchive(job *dataExport) (*exportArchive, error) {
+	uid := job.UserID
+	archive := &exportArchive{ExportedAt: time.Now().UTC()}
+
+	steps := []struct {
+		name string
+		run  func() error
+	}{
+		{"profile", func() (err error) {
+			archive.Profile, err = s.fetchOwnProfile(uid)
+			if err == nil {
+				// Storage keys are internal; the avatar URLs stay
+				delete(archive.Profile, "avatar_keys")
+			}
+			return err
+		}},
+		{"inbox", func() (err error) {
+			archive.Inbox, err = s.fetchInbox(uid, inboxFilter{IncludeSnoozed: true})
+			return err
+		}},
+		{"history", func() (err error) {
+			archive.History, err = s.fetchHistory(uid, "")
+			return err
+		}},
+		{"sent messages", func() (err error) {
+			archive.Sent, err = s.fetchSentMessages(uid)
+			return err
+		}},
+		{"likes", func() (err error) {
+			archive.Likes, err = s.fetchLikedMessages(uid)
+			return err
+		}},
+		{"bookmarks", func() error {
+			var rows []bookmarkRow
+			_, err := s.db.From("bookmarks").
+				Select(bookmarkSelect, "", false).
+				Eq("user_id", uid).
+				Order("created_at", &postgrest.OrderOpts{Ascending: false}).
+				ExecuteTo(&rows)
+			if err != nil {
+				return err
+			}
+			archive.Bookmarks = s.bookmarkMessages(rows, true)
+
+			_, err = s.db.From("bookmark_collections").
+				Select("id, name, is_public, position, created_at", "", false).
+				Eq("user_id", uid).
+				Order("position", &postgrest.OrderOpts{Ascending: true}).
+				ExecuteTo(&archive.Collections)
+			return err
+		}},
+		{"friends", func() (err error) {
+			archive.Friends, err = s.fetchFriends(uid)
+			return err
+		}},
+		{"prompts", func() error {
+			_, err := s.db.From("prompts").
+				Select("*", "", false).
+				Eq("profile_id", uid).
+				Order("created_at", &postgrest.OrderOpts{Ascending: false}).
+				ExecuteTo(&archive.Prompts)
+			return err
+		}},
+	}
+
+	for i, step := range steps {
+		s.updateExport(job.ID, map[string]interface{}{
+			"progress": i * 90 / len(steps),
+			"step":     "Collecting " + step.name,
+		})
+		if err := step.run(); err != nil {
+			return nil, fmt.Errorf("collecting %s: %w", step.name, err)
+		}
+	}
+
+	return archive, nil
+}
+
+// zipExport packs an archive as data.json plus a self-contained HTML viewer.
+func zipExport(archive *exportArchive) ([]byte, error) {
+	data, err := json.MarshalIndent(archive, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	var page bytes.Buffer
+	if err := exportViewer.Execute(&page, archive); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	files := []struct {
+		name string
+		body []byte
+	}{
+		{"data.json", data},
+		{"index.html", page.Bytes()},
+	}
+	for _, f := range files {
+		w, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     f.name,
+			Method:   zip.Deflate,
+			Modified: archive.ExportedAt,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(f.body); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// runExport builds and stores an export. The job must already be claimed.
+func (s *Server) runExport(ctx context.Context, job *dataExport) {
+	fail := func(err error) {
+		if ctx.Err() != nil {
+			// Left running, so it is restarted once stale
+			slog.Warn("Data export interrupted by shutdown", "export_id", job.ID)
+			return
+		}
+		slog.Error("Data export failed", "export_id", job.ID, "error", err)
+		s.updateExport(job.ID, map[string]interface{}{
+			"status": "failed",
+			"error":  "Export failed, please try again",
+		})
+	}
+
+	archive, err := s.buildExportArchive(job)
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	s.updateExport(job.ID, map[string]interface{}{"progress": 90, "step": "Building archive"})
+	zipped, err := zipExport(archive)
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	key := fmt.Sprintf("exports/%s/%s.zip", job.UserID, job.ID)
+	if err := s.exportBlobs.Put(ctx, key, "application/zip", zipped); err != nil {
+		fail(err)
+		return
+	}
+
+	now := time.Now().UTC()
+	s.updateExport(job.ID, map[string]interface{}{
+		"status":       "ready",
+		"progress":     100,
+		"step":         "Ready",
+		"blob_key":     key,
+		"size_bytes":   len(zipped),
+		"completed_at": now.Format(time.RFC3339),
+		"expires_at":   now.Add(exportRetention).Format(time.RFC3339),
+	})
+}
+
+// expireExports deletes the archives of a user's finished exports, or of
+// every user's exports past their retention when userID is empty.
+func (s *Server) expireExports(userID string) {
+	query := s.db.From("data_exports").
+		Select("*", "", false).
+		Eq("status", "ready")
+	if userID != "" {
+		query = query.Eq("user_id", userID)
+	} else {
+		query = query.Lte("expires_at", time.Now().UTC().Format(time.RFC3339))
+	}
+
+	var jobs []dataExport
+	if _, err := query.ExecuteTo(&jobs); err != nil {
+		slog.Error("Supabase error listing exports", "error", err)
+		return
+	}
+
+	for _, job := range jobs {
+		if job.BlobKey != nil {
+			if err := s.exportBlobs.Delete(s.ctx, *job.BlobKey); err != nil {
+				slog.Error("Failed to delete export archive", "error", err)
+				continue
+			}
+		}
+		s.updateExport(job.ID, map[string]interface{}{"status": "expired", "blob_key": nil})
+	}
+}
+
+// resumeExports restarts exports left behind by a stopped server and drops
+// expired archives. It runs once at startup and then hourly.
+func (s *Server) resumeExports() {
+	stale := time.Now().Add(-exportStaleAfter).UTC().Format(time.RFC3339)
+	_, _, err := s.db.From("data_exports").
+		Update(map[string]interface{}{"status": "queued"}, "minimal", "").
+		Eq("status", "running").
+		Lte("updated_at", stale).
+		Execute()
+	if err != nil {
+		slog.Error("Supabase error requeueing exports", "error", err)
+	}
+
+	var queued []dataExport
+	if _, err := s.db.From("data_exports").
+		Select("*", "", false).
+		Eq("status", "queued").
+		ExecuteTo(&queued); err != nil {
+		slog.Error("Supabase error listing exports", "error", err)
+		return
+	}
+	for i := range queued {
+		if s.claimExport(queued[i].ID) {
+			s.startExport(&queued[i])
+		}
+	}
+
+	s.expireExports("")
+}
+
+// startExport runs a claimed export on the task pool. If the pool is
+// shutting down the job stays claimed and is restarted once stale.
+func (s *Server) startExport(job *dataExport) {
+	s.tasks.Go("export "+job.ID, func(ctx context.Context) {
+		s.runExport(ctx, job)
+	})
+}
+
+func (s *Server) startExportWorker() {
+	s.tasks.Every("export maintenance", time.Hour, func(context.Context) {
+		s.resumeExports()
+	})
+}
+
+// exportStatus is the client view of an export, with a fresh download link
+// once it is ready.
+func (s *Server) exportStatus(job *dataExport) gin.H {
+	status := gin.H{
+		"id":           job.ID,
+		"status":       job.Status,
+		"progress":     job.Progress,
+		"step":         job.Step,
+		"size_bytes":   job.SizeBytes,
+		"error":        job.Error,
+		"created_at":   job.CreatedAt,
+		"completed_at": job.CompletedAt,
+		"expires_at":   job.ExpiresAt,
+	}
+	if job.Status == "ready" {
+		url, expiresAt := s.exportDownloadURL(job.ID)
+		status["download_url"] = url
+		status["download_expires_at"] = expiresAt.UTC().Format(time.RFC3339)
+	}
+	return status
+}
+
+func (s *Server) registerExportRoutes(r *gin.Engine, authMiddleware gin.HandlerFunc) {
+	// Start Data Export: one export at a time; a new export replaces the last archive
+	r.POST("/profile/export", authMiddleware, handle(func(c *gin.Context) error {
+		user, _ := c.Get("user")
+		supabaseUser := user.(types.User)
+		userID := supabaseUser.ID.String()
+
+		var active []dataExport
+		_, err := s.db.From("data_exports").
+			Select("*", "", false).
+			Eq("user_id", userID).
+			In("status", []string{"queued", "running"}).
+			ExecuteTo(&active)
+		if err != nil {
+			return errInternal("Failed to start export", fmt.Errorf("fetching exports: %w", err))
+		}
+		if len(active) > 0 {
+			return errConflict("An export is already in progress").withDetails(gin.H{"export": s.exportStatus(&active[0])})
+		}
+
+		s.expireExports(userID)
+
+		var created []dataExport
+		_, err = s.db.From("data_exports").
+			Insert(map[string]interface{}{
+				"user_id":  userID,
+				"status":   "queued",
+				"progress": 0,
+				"step":     "Queued",
+			}, false, "", "representation", "").
+			ExecuteTo(&created)
+
+		if err != nil {
+			return errInternal("Failed to start export", fmt.Errorf("creating export: %w", err))
+		}
+		if len(created) == 0 {
+			return errInternal("Failed to start export", errors.New("creating export: no row returned"))
+		}
+
+		job := created[0]
+		if s.claimExport(job.ID) {
+			job.Status = "running"
+			s.startExport(&job)
+		}
+
+		c.JSON(http.StatusAccepted, s.exportStatus(&job))
+		return nil
+	}))
+
+	// Get Data Export Status
+	r.GET("/profile/export/:id", authMiddleware, handle(func(c *gin.Context) error {
+		user, _ := c.Get("user")
+		supabaseUser := user.(types.User)
+
+		job, err := s.getExport(c.Param("id"))
+		if err != nil || job.UserID != supabaseUser.ID.String() {
+			return errNotFound("Export not found")
+		}
+
+		c.JSON(http.StatusOK, s.exportStatus(job))
+		return nil
+	}))
+
+	// Download Data Export: authorized by the signed link rather than a
+	// bearer token, so it works as a plain browser download
+	r.GET("/profile/export/:id/download", handle(func(c *gin.Context) error {
+		exportID := c.Param("id")
+		expires, err := strconv.ParseInt(c.Query("expires"), 10, 64)
+		if err != nil || time.Now().Unix() > expires {
+			return errForbidden("Download link has expired")
+		}
+
+		expected := s.exportSignature(exportID, expires)
+		if !hmac.Equal([]byte(expected), []byte(c.Query("signature"))) {
+			return errForbidden("Invalid download link")
+		}
+
+		job, err := s.getExport(exportID)
+		if err != nil || job.Status != "ready" || job.BlobKey == nil {
+			return errNotFound("Export not found")
+		}
+
+		data, err := s.exportBlobs.Get(c.Request.Context(), *job.BlobKey)
+		if err != nil {
+			return errInternal("Failed to download export", fmt.Errorf("reading export archive: %w", err))
+		}
+
+		filename := "replied-export.zip"
+		if job.CompletedAt != nil && len(*job.CompletedAt) >= 10 {
+			filename = "replied-export-" + (*job.CompletedAt)[:10] + ".zip"
+		}
+		c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+		c.Header("Cache-Control", "private, no-store")
+		c.Data(http.StatusOK, "application/zip", data)
+		return nil
+	}))
+}
+
+// replyContent returns the reply text of a message whether PostgREST
+// embedded replies as an object or a list.
+func replyContent(message interface{}) string {
+	m, ok := message.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	switch r := m["replies"].(type) {
+	case map[string]interface{}:
+		return stringField(r, "content")
+	case []interface{}:
+		if len(r) > 0 {
+			return stringField(r[0], "content")
+		}
+	}
+	return ""
+}
+
+// nestedField reads m[outer][key] for embedded rows such as a message's
+// recipient profile.
+func nestedField(m interface{}, outer, key string) string {
+	obj, ok := m.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	return stringField(obj[outer], key)
+}
+
+var exportViewer = template.Must(template.New("export").Funcs(template.FuncMap{
+	"field":  stringField,
+	"nested": nestedField,
+	"reply":  replyContent,
+}).Parse(`<!doctype html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Replied data export</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 760px; margin: 2rem auto; padding: 0 1rem; color: #111; }
+h1 { margin-bottom: 0; }
+h2 { border-bottom: 3px solid #111; padding-bottom: .25rem; margin-top: 2.5rem; }
+article { border: 2px solid #111; padding: .75rem 1rem; margin: .75rem 0; }
+.reply { border-left: 4px solid #1C7BFF; padding-left: .75rem; margin-top: .5rem; }
+.meta { color: #666; font-size: .85rem; }
+dt { font-weight: bold; }
+</style>
+</head>
+<body>
+{{define "message"}}<article>
+<p>{{field . "content"}}</p>
+{{with reply .}}<p class="reply">{{.}}</p>{{end}}
+<p class="meta">{{field . "created_at"}}{{with field . "status"}} · {{.}}{{end}}</p>
+</article>{{end}}
+<h1>@{{field .Profile "username"}}</h1>
+<p class="meta">Exported {{.ExportedAt.Format "2006-01-02 15:04 MST"}}. The same data is in data.json.</p>
+
+<h2>Profile</h2>
+<dl>
+<dt>Display name</dt><dd>{{field .Profile "display_name"}}</dd>
+<dt>Bio</dt><dd>{{field .Profile "bio"}}</dd>
+<dt>Email</dt><dd>{{field .Profile "email"}}</dd>
+<dt>Joined</dt><dd>{{field .Profile "created_at"}}</dd>
+</dl>
+
+<h2>Inbox ({{len .Inbox}})</h2>
+{{range .Inbox}}{{template "message" .}}{{else}}<p>Nothing here.</p>{{end}}
+
+<h2>History ({{len .History}})</h2>
+{{range .History}}{{template "message" .}}{{else}}<p>Nothing here.</p>{{end}}
+
+<h2>Sent ({{len .Sent}})</h2>
+{{range .Sent}}<p class="meta">To @{{nested . "receiver" "username"}}</p>{{template "message" .}}{{else}}<p>Nothing here.</p>{{end}}
+
+<h2>Likes ({{len .Likes}})</h2>
+{{range .Likes}}<p class="meta">On @{{nested . "profiles" "username"}}</p>{{template "message" .}}{{else}}<p>Nothing here.</p>{{end}}
+
+<h2>Bookmarks ({{len .Bookmarks}})</h2>
+{{range .Bookmarks}}<p class="meta">On @{{nested . "profiles" "username"}}{{with field . "bookmark_note"}} · Note: {{.}}{{end}}</p>{{template "message" .}}{{else}}<p>Nothing here.</p>{{end}}
+
+<h2>Friends ({{len .Friends}})</h2>
+<ul>
+{{range .Friends}}<li>@{{field . "username"}}{{with field . "display_name"}} ({{.}}){{end}}</li>{{else}}<li>No friends yet.</li>{{end}}
+</ul>
+</body>
+</html>
+`))
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestDataExport(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.addUser("alice", row{"bio": "hello"})
+	bob := e.addUser("bob", nil)
+	answered := e.addMessage(alice, "favourite season?", "replied", nil)
+	e.addReply(answered, alice, "autumn")
+	e.addMessage(bob, "sent by alice", "pending", row{"sender_id": alice.ID})
+
+	started := e.request("POST", "/profile/export", alice.Token, nil).expect(http.StatusAccepted).object()
+	id := started["id"].(string)
+
+	var status map[string]interface{}
+	e.eventually("export to finish", func() bool {
+		status = e.request("GET", "/profile/export/"+id, alice.Token, nil).expect(http.StatusOK).object()
+		return status["status"] == "ready" || status["status"] == "failed"
+	})
+	if status["status"] != "ready" {
+		t.Fatalf("export = %v", status)
+	}
+	e.request("GET", "/profile/export/"+id, bob.Token, nil).expect(http.StatusNotFound)
+
+	link, err := url.Parse(status["download_url"].(string))
+	if err != nil || link.Host != "api.test" {
+		t.Fatalf("download_url = %v", status["download_url"])
+	}
+
+	// The signed link works without a token, but not once tampered with
+	res := e.request("GET", link.RequestURI(), "", nil).expect(http.StatusOK)
+	if !strings.Contains(res.Header().Get("Content-Disposition"), "replied-export-") {
+		t.Errorf("Content-Disposition = %q", res.Header().Get("Content-Disposition"))
+	}
+	query := link.Query()
+	query.Set("signature", strings.Repeat("0", len(query.Get("signature"))))
+	e.request("GET", link.Path+"?"+query.Encode(), "", nil).expect(http.StatusForbidden)
+	query = link.Query()
+	query.Set("expires", "1")
+	e.request("GET", link.Path+"?"+query.Encode(), "", nil).expect(http.StatusForbidden)
+
+	zr, err := zip.NewReader(bytes.NewReader(res.Body.Bytes()), int64(res.Body.Len()))
+	if err != nil {
+		t.Fatalf("open archive: %v", err)
+	}
+	files := map[string][]byte{}
+	for _, f := range zr.File {
+		rc, _ := f.Open()
+		files[f.Name], _ = io.ReadAll(rc)
+		rc.Close()
+	}
+	if _, ok := files["index.html"]; !ok {
+		t.Error("archive has no index.html")
+	}
+	var archive exportArchive
+	if err := json.Unmarshal(files["data.json"], &archive); err != nil {
+		t.Fatalf("data.json: %v", err)
+	}
+	if archive.Profile["username"] != "alice" || archive.Profile["email"] != "alice@example.com" {
+		t.Errorf("profile = %v", archive.Profile)
+	}
+	if len(archive.History) != 1 || len(archive.Sent) != 1 {
+		t.Errorf("history %d, sent %d, want 1 each", len(archive.History), len(archive.Sent))
+	}
+	if !bytes.Contains(files["data.json"], []byte("autumn")) {
+		t.Error("replies were not decrypted in the archive")
+	}
+
+	// A new export replaces the previous archive
+	e.request("POST", "/profile/export", alice.Token, nil).expect(http.StatusAccepted)
+	e.eventually("second export to finish", func() bool {
+		return len(e.db.rows("data_exports", row{"user_id": alice.ID, "status": "ready"})) == 1 &&
+			len(e.db.rows("data_exports", row{"id": id, "status": "expired"})) == 1
+	})
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// readinessCheckTimeout bounds each dependency check, so a hanging
+// dependency fails the probe instead of stalling it.
+const readinessCheckTimeout = 2 * time.Second
+
+// dependencyCheck probes one dependency. Only critical checks make the
+// server unready; email is best-effort, so Resend being down is reported
+// but keeps traffic flowing.
+type dependencyCheck struct {
+	name     string
+	critical bool
+	check    func(ctx context.Context) error
+}
+
+type checkResult struct {
+	Status     string `json:"status"` // ok, error or disabled
+	Critical   bool   `json:"critical"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+func (s *Server) dependencyChecks() []dependencyCheck {
+	checks := []dependencyCheck{{name: "supabase", critical: true, check: s.checkSupabase}}
+	if s.rdb != nil {
+		checks = append(checks, dependencyCheck{name: "redis", critical: true, check: func(ctx context.Context) error {
+			return s.rdb.Ping(ctx).Err()
+		}})
+	}
+	if s.mailer.apiKey != "" {
+		checks = append(checks, dependencyCheck{name: "resend", check: s.mailer.ping})
+	}
+	return checks
+}
+
+// checkSupabase runs the cheapest possible PostgREST query.
+func (s *Server) checkSupabase(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", s.cfg.Supabase.URL+"/rest/v1/profiles?select=id&limit=1", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("apikey", s.cfg.Supabase.ServiceRoleKey)
+	req.Header.Set("Authorization", "Bearer "+s.cfg.Supabase.ServiceRoleKey)
+
+	resp, err := s.outbound.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// runChecks probes every dependency concurrently.
+func (s *Server) runChecks(ctx context.Context) map[string]checkResult {
+	checks := s.dependencyChecks()
+	results := make(map[string]checkResult, len(checks)+2)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, dc := range checks {
+		wg.Add(1)
+		go func(dc dependencyCheck) {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, readinessCheckTimeout)
+			defer cancel()
+
+			start := time.Now()
+			err := dc.check(checkCtx)
+			result := checkResult{Status: "ok", Critical: dc.critical, DurationMS: time.Since(start).Milliseconds()}
+			if err != nil {
+				// The cause can name internal hosts, so it is only logged
+				loggerFrom(ctx).Warn("Readiness check failed", "check", dc.name, "error", err)
+				result.Status = "error"
+			}
+
+			mu.Lock()
+			results[dc.name] = result
+			mu.Unlock()
+		}(dc)
+	}
+	wg.Wait()
+
+	if s.rdb == nil {
+		results["redis"] = checkResult{Status: "disabled"}
+	}
+	if s.mailer.apiKey == "" {
+		results["resend"] = checkResult{Status: "disabled"}
+	}
+	return results
+}
+
+func (s *Server) registerHealthRoutes(r *gin.Engine) {
+	// Liveness: the process is up and serving. Deliberately checks nothing
+	// else, so a dependency outage doesn't get the server restarted.
+	r.GET("/healthz", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+
+	// Readiness: whether the server can do useful work right now
+	r.GET("/readyz", func(c *gin.Context) {
+		results := s.runChecks(c.Request.Context())
+
+		status, code := "ok", http.StatusOK
+		for _, result := range results {
+			if result.Status != "error" {
+				continue
+			}
+			if result.Critical {
+				status, code = "unavailable", http.StatusServiceUnavailable
+				break
+			}
+			status = "degraded"
+		}
+
+		c.JSON(code, gin.H{"status": status, "checks": results})
+	})
+
+	r.GET("/metrics", s.metrics.serveMetrics)
+}
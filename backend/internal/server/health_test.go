@@ -0,0 +1,66 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHealthz(t *testing.T) {
+	e := newTestEnv(t)
+	e.db.failTable("profiles")
+	e.rdb.down = true
+
+	// Liveness ignores dependencies
+	if status := e.request("GET", "/healthz", "", nil).expect(http.StatusOK).object()["status"]; status != "ok" {
+		t.Errorf("status = %v", status)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	e := newTestEnv(t)
+
+	checks := func(res response) (string, map[string]interface{}) {
+		t.Helper()
+		body := res.object()
+		return body["status"].(string), body["checks"].(map[string]interface{})
+	}
+	checkStatus := func(all map[string]interface{}, name string) string {
+		t.Helper()
+		return all[name].(map[string]interface{})["status"].(string)
+	}
+
+	status, all := checks(e.request("GET", "/readyz", "", nil).expect(http.StatusOK))
+	if status != "ok" || checkStatus(all, "supabase") != "ok" || checkStatus(all, "redis") != "ok" || checkStatus(all, "resend") != "disabled" {
+		t.Fatalf("ready = %s %v", status, all)
+	}
+
+	// Email is best-effort: Resend failing degrades but stays ready
+	resend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer resend.Close()
+	e.srv.mailer.apiKey = "re_test"
+	e.srv.mailer.baseURL = resend.URL
+	status, all = checks(e.request("GET", "/readyz", "", nil).expect(http.StatusOK))
+	if status != "degraded" || checkStatus(all, "resend") != "error" {
+		t.Fatalf("with Resend down = %s %v", status, all)
+	}
+
+	e.rdb.mu.Lock()
+	e.rdb.down = true
+	e.rdb.mu.Unlock()
+	status, all = checks(e.request("GET", "/readyz", "", nil).expect(http.StatusServiceUnavailable))
+	if status != "unavailable" || checkStatus(all, "redis") != "error" {
+		t.Fatalf("with Redis down = %s %v", status, all)
+	}
+
+	e.rdb.mu.Lock()
+	e.rdb.down = false
+	e.rdb.mu.Unlock()
+	e.db.failTable("profiles")
+	status, all = checks(e.request("GET", "/readyz", "", nil).expect(http.StatusServiceUnavailable))
+	if status != "unavailable" || checkStatus(all, "supabase") != "error" {
+		t.Fatalf("with Supabase down = %s %v", status, all)
+	}
+}
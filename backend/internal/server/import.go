@@ -0,0 +1,775 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/supabase-community/gotrue-go/types"
+)
+
+const (
+	maxImportFileBytes      = 2 << 20
+	maxImportRows           = 5000
+	maxImportQuestionLength = maxMessageLength
+	maxImportAnswerLength   = maxReplyLength
+	// At most this many row problems are reported back
+	maxImportIssues = 100
+	importBatchSize = 50
+	// Running imports that have not reported progress for this long are
+	// assumed to belong to a stopped server and are resumed
+	importStaleAfter = 10 * time.Minute
+	// Dry runs keep their rows this long so they can be committed
+	importDryRunRetention = 24 * time.Hour
+
+	importHashKeyDomain = "replied/import-hash/v1"
+)
+
+// Accepted column names, lowercased, for each imported field.
+var importFieldAliases = map[string][]string{
+	"question":  {"question", "q", "prompt", "message"},
+	"answer":    {"answer", "a", "reply", "response"},
+	"timestamp": {"timestamp", "created_at", "date", "time", "answered_at"},
+}
+
+var importTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"01/02/2006 15:04",
+	"01/02/2006",
+}
+
+// importRow is one validated question/answer pair.
+type importRow struct {
+	Question  string    `json:"question"`
+	Answer    string    `json:"answer"`
+	Timestamp time.Time `json:"timestamp"`
+	Hash      string    `json:"hash"`
+}
+
+type importIssue struct {
+	Row    int    `json:"row"`
+	Reason string `json:"reason"`
+}
+
+// importJob is a row of import_jobs. The validated rows are kept encrypted
+// in payload so an interrupted import can resume from cursor.
+type importJob struct {
+	ID          string        `json:"id"`
+	UserID      string        `json:"user_id"`
+	Status      string        `json:"status"` // queued, running, completed, failed, cancelled
+	DryRun      bool          `json:"dry_run"`
+	Source      string        `json:"source"`
+	Payload     *string       `json:"payload"`
+	Total       int           `json:"total"`
+	Cursor      int           `json:"cursor"`
+	Imported    int           `json:"imported"`
+	Duplicates  int           `json:"duplicates"`
+	Invalid     int           `json:"invalid"`
+	Issues      []importIssue `json:"issues"`
+	Error       *string       `json:"error"`
+	CreatedAt   string        `json:"created_at"`
+	UpdatedAt   string        `json:"updated_at"`
+	CompletedAt *string       `json:"completed_at"`
+}
+
+func (s *Server) importHashKey() []byte {
+	return s.cipher.deriveKey(importHashKeyDomain)
+}
+
+// importHash identifies a question/answer pair for deduplication. It is
+// keyed so the stored hash reveals nothing about the plaintext.
+func importHash(key []byte, userID string, row importRow) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(strings.Join([]string{
+		userID, row.Question, row.Answer, strconv.FormatInt(row.Timestamp.Unix(), 10),
+	}, "\x00")))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// parseImportTime accepts RFC 3339 and common date formats as well as Unix
+// timestamps in seconds or milliseconds.
+func parseImportTime(raw string) (time.Time, error) {
+	raw = strings.TrimSpace(raw)
+	if n, err := strconv.ParseInt(raw, 10, 64); err == nil {
+		if n > 1e12 {
+			return time.UnixMilli(n).UTC(), nil
+		}
+		return time.Unix(n, 0).UTC(), nil
+	}
+	for _, layout := range importTimeLayouts {
+		if t, err := time.Parse(layout, raw); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, errors.New("unrecognized timestamp")
+}
+
+// importField picks a field from a record by any of its accepted names.
+func importField(record map[string]string, field string) string {
+	for _, name := range importFieldAliases[field] {
+		if v, ok := record[name]; ok {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
+
+// parseImportJSON reads a JSON array of objects, or an object holding one
+// under "items", "questions" or "data".
+func parseImportJSON(data []byte) ([]map[string]string, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	items, ok := doc.([]interface{})
+	if obj, isObj := doc.(map[string]interface{}); isObj {
+		for _, key := range []string{"items", "questions", "data"} {
+			if items, ok = obj[key].([]interface{}); ok {
+				break
+			}
+		}
+	}
+	if !ok {
+		return nil, errors.New("expected a JSON array of question/answer objects")
+	}
+
+	records := make([]map[string]string, 0, len(items))
+	for _, item := range items {
+		obj, _ := item.(map[string]interface{})
+		record := make(map[string]string, len(obj))
+		for k, v := range obj {
+			switch val := v.(type) {
+			case string:
+				record[strings.ToLower(k)] = val
+			case json.Number:
+				record[strings.ToLower(k)] = val.String()
+			}
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// parseImportCSV reads a CSV file whose first row names the columns.
+func parseImportCSV(data []byte) ([]map[string]string, error) {
+	r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV: %w", err)
+	}
+	for i, h := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(h))
+	}
+
+	var records []map[string]string
+	for {
+		fields, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV: %w", err)
+		}
+		record := make(map[string]string, len(header))
+		for i, f := range fields {
+			if i < len(header) {
+				record[header[i]] = f
+			}
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// validateImportRows checks each record and drops duplicates within the
+// file. Row numbers in issues are 1-based data rows.
+func (s *Server) validateImportRows(userID string, records []map[string]string) ([]importRow, []importIssue, int) {
+	key := s.importHashKey()
+
+	var rows []importRow
+	var issues []importIssue
+	invalid := 0
+	seen := map[string]bool{}
+	earliest := time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC)
+	latest := time.Now().Add(time.Hour)
+
+	for i, record := range records {
+		reason := ""
+		row := importRow{
+			Question: importField(record, "question"),
+			Answer:   importField(record, "answer"),
+		}
+		ts, tsErr := parseImportTime(importField(record, "timestamp"))
+		row.Timestamp = ts
+
+		switch {
+		case row.Question == "":
+			reason = "Question is missing"
+		case row.Answer == "":
+			reason = "Answer is missing"
+		case len([]rune(row.Question)) > maxImportQuestionLength:
+			reason = "Question is longer than 1000 characters"
+		case len([]rune(row.Answer)) > maxImportAnswerLength:
+			reason = "Answer is longer than 2000 characters"
+		case tsErr != nil:
+			reason = "Timestamp is missing or not a recognized date"
+		case ts.Before(earliest) || ts.After(latest):
+			reason = "Timestamp is out of range"
+		}
+		if reason != "" {
+			invalid++
+			if len(issues) < maxImportIssues {
+				issues = append(issues, importIssue{Row: i + 1, Reason: reason})
+			}
+			continue
+		}
+
+		row.Hash = importHash(key, userID, row)
+		if seen[row.Hash] {
+			continue
+		}
+		seen[row.Hash] = true
+		rows = append(rows, row)
+	}
+
+	return rows, issues, invalid
+}
+
+func (s *Server) getImportJob(jobID string) (*importJob, error) {
+	var job importJob
+	_, err := s.db.From("import_jobs").
+		Select("*", "", false).
+		Eq("id", jobID).
+		Single().
+		ExecuteTo(&job)
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (s *Server) updateImportJob(jobID string, data map[string]interface{}) error {
+	data["updated_at"] = "now()"
+	_, _, err := s.db.From("import_jobs").
+		Update(data, "minimal", "").
+		Eq("id", jobID).
+		Execute()
+	if err != nil {
+		slog.Error("Supabase error updating import", "import_id", jobID, "error", err)
+	}
+	return err
+}
+
+// claimImportJob moves a queued import to running. Only one caller wins.
+func (s *Server) claimImportJob(jobID string) bool {
+	var claimed []importJob
+	_, err := s.db.From("import_jobs").
+		Update(map[string]interface{}{"status": "running", "updated_at": "now()"}, "representation", "").
+		Eq("id", jobID).
+		Eq("status", "queued").
+		ExecuteTo(&claimed)
+	return err == nil && len(claimed) > 0
+}
+
+// importBatch imports one batch of rows and returns how many were new and
+// how many were already there. Messages that were inserted without their
+// reply (an interrupted batch) get the reply now, so re-running a batch is
+// safe. Dry runs only count.
+func (s *Server) importBatch(job *importJob, rows []importRow) (int, int, error) {
+	hashes := make([]string, len(rows))
+	for i, row := range rows {
+		hashes[i] = row.Hash
+	}
+
+	var existing []struct {
+		ID         string      `json:"id"`
+		ImportHash string      `json:"import_hash"`
+		Replies    interface{} `json:"replies"`
+	}
+	_, err := s.db.From("messages").
+		Select("id, import_hash, replies(id)", "", false).
+		Eq("receiver_id", job.UserID).
+		In("import_hash", hashes).
+		ExecuteTo(&existing)
+	if err != nil {
+		return 0, 0, fmt.Errorf("checking duplicates: %w", err)
+	}
+
+	messageIDs := map[string]string{}
+	duplicates := map[string]bool{}
+	for _, m := range existing {
+		messageIDs[m.ImportHash] = m.ID
+		switch r := m.Replies.(type) {
+		case map[string]interface{}:
+			duplicates[m.ImportHash] = true
+		case []interface{}:
+			duplicates[m.ImportHash] = len(r) > 0
+		}
+	}
+
+	imported := 0
+	var newMessages []map[string]interface{}
+	for _, row := range rows {
+		if duplicates[row.Hash] {
+			continue
+		}
+		imported++
+		if job.DryRun || messageIDs[row.Hash] != "" {
+			continue
+		}
+
+		content, err := s.encrypt(row.Question)
+		if err != nil {
+			return 0, 0, fmt.Errorf("encrypting question: %w", err)
+		}
+		message := map[string]interface{}{
+			"receiver_id": job.UserID,
+			"content":     content,
+			"status":      "replied",
+			"import_hash": row.Hash,
+			"created_at":  row.Timestamp.Format(time.RFC3339),
+		}
+		message["search_tokens"] = s.blindTokens(row.Question, row.Answer)
+		newMessages = append(newMessages, message)
+	}
+	if job.DryRun {
+		return imported, len(rows) - imported, nil
+	}
+
+	if len(newMessages) > 0 {
+		var inserted []struct {
+			ID         string `json:"id"`
+			ImportHash string `json:"import_hash"`
+		}
+		_, err := s.db.From("messages").
+			Insert(newMessages, false, "", "representation", "").
+			ExecuteTo(&inserted)
+		if err != nil {
+			return 0, 0, fmt.Errorf("inserting messages: %w", err)
+		}
+		for _, m := range inserted {
+			messageIDs[m.ImportHash] = m.ID
+		}
+	}
+
+	var replies []map[string]interface{}
+	for _, row := range rows {
+		if duplicates[row.Hash] {
+			continue
+		}
+		content, err := s.encrypt(row.Answer)
+		if err != nil {
+			return 0, 0, fmt.Errorf("encrypting answer: %w", err)
+		}
+		replies = append(replies, map[string]interface{}{
+			"message_id": messageIDs[row.Hash],
+			"sender_id":  job.UserID,
+			"content":    content,
+			"created_at": row.Timestamp.Format(time.RFC3339),
+		})
+	}
+	if len(replies) > 0 {
+		_, _, err := s.db.From("replies").
+			Insert(replies, false, "", "minimal", "").
+			Execute()
+		if err != nil {
+			return 0, 0, fmt.Errorf("inserting replies: %w", err)
+		}
+	}
+
+	return imported, len(rows) - imported, nil
+}
+
+// runImport processes a claimed job from its cursor, saving progress after
+// every batch so a restart picks up where it stopped.
+func (s *Server) runImport(ctx context.Context, job *importJob) {
+	fail := func(err error) {
+		slog.Error("Import failed", "import_id", job.ID, "error", err)
+		s.updateImportJob(job.ID, map[string]interface{}{
+			"status": "failed",
+			"error":  "Import failed, please try again",
+		})
+	}
+
+	if job.Payload == nil {
+		fail(errors.New("import rows are missing"))
+		return
+	}
+	plaintext, err := s.decrypt(*job.Payload)
+	if err != nil {
+		fail(fmt.Errorf("decrypting rows: %w", err))
+		return
+	}
+	var rows []importRow
+	if err := json.Unmarshal([]byte(plaintext), &rows); err != nil {
+		fail(fmt.Errorf("decoding rows: %w", err))
+		return
+	}
+
+	for job.Cursor < len(rows) {
+		// Progress is saved, so a stale job picks up from here later
+		if ctx.Err() != nil {
+			slog.Warn("Import interrupted by shutdown", "import_id", job.ID, "row", job.Cursor)
+			return
+		}
+		// Stop if the user cancelled the import
+		if current, err := s.getImportJob(job.ID); err == nil && current.Status != "running" {
+			return
+		}
+
+		end := job.Cursor + importBatchSize
+		if end > len(rows) {
+			end = len(rows)
+		}
+		imported, duplicates, err := s.importBatch(job, rows[job.Cursor:end])
+		if err != nil {
+			fail(err)
+			return
+		}
+
+		job.Cursor = end
+		job.Imported += imported
+		job.Duplicates += duplicates
+		if err := s.updateImportJob(job.ID, map[string]interface{}{
+			"cursor":     job.Cursor,
+			"imported":   job.Imported,
+			"duplicates": job.Duplicates,
+		}); err != nil {
+			// Progress could not be saved; the stale job is resumed later
+			return
+		}
+	}
+
+	done := map[string]interface{}{
+		"status":       "completed",
+		"completed_at": time.Now().UTC().Format(time.RFC3339),
+		"updated_at":   "now()",
+	}
+	if !job.DryRun {
+		// Dry runs keep their rows so they can be committed
+		done["payload"] = nil
+		s.invalidateProfileCache(job.UserID)
+	}
+	// Only finish jobs that were not cancelled in the meantime
+	_, _, err = s.db.From("import_jobs").
+		Update(done, "minimal", "").
+		Eq("id", job.ID).
+		Eq("status", "running").
+		Execute()
+	if err != nil {
+		slog.Error("Supabase error finishing import", "import_id", job.ID, "error", err)
+	}
+}
+
+// resumeImports restarts imports left behind by a stopped server and drops
+// the rows of old dry runs. It runs once at startup and then hourly.
+func (s *Server) resumeImports() {
+	stale := time.Now().Add(-importStaleAfter).UTC().Format(time.RFC3339)
+	_, _, err := s.db.From("import_jobs").
+		Update(map[string]interface{}{"status": "queued"}, "minimal", "").
+		Eq("status", "running").
+		Lte("updated_at", stale).
+		Execute()
+	if err != nil {
+		slog.Error("Supabase error requeueing imports", "error", err)
+	}
+
+	var queued []importJob
+	if _, err := s.db.From("import_jobs").
+		Select("*", "", false).
+		Eq("status", "queued").
+		ExecuteTo(&queued); err != nil {
+		slog.Error("Supabase error listing imports", "error", err)
+		return
+	}
+	for i := range queued {
+		if s.claimImportJob(queued[i].ID) {
+			s.startImport(&queued[i])
+		}
+	}
+
+	_, _, err = s.db.From("import_jobs").
+		Update(map[string]interface{}{"payload": nil}, "minimal", "").
+		Eq("dry_run", "true").
+		In("status", []string{"completed", "failed", "cancelled"}).
+		Lte("updated_at", time.Now().Add(-importDryRunRetention).UTC().Format(time.RFC3339)).
+		Execute()
+	if err != nil {
+		slog.Error("Supabase error clearing dry runs", "error", err)
+	}
+}
+
+// startImport runs a claimed import on the task pool. If the pool is
+// shutting down the job stays claimed and is resumed once stale.
+func (s *Server) startImport(job *importJob) {
+	s.tasks.Go("import "+job.ID, func(ctx context.Context) {
+		s.runImport(ctx, job)
+	})
+}
+
+func (s *Server) startImportWorker() {
+	s.tasks.Every("import maintenance", time.Hour, func(context.Context) {
+		s.resumeImports()
+	})
+}
+
+// importStatus is the client view of an import job.
+func importStatus(job *importJob) gin.H {
+	return gin.H{
+		"id":           job.ID,
+		"status":       job.Status,
+		"dry_run":      job.DryRun,
+		"source":       job.Source,
+		"total":        job.Total,
+		"processed":    job.Cursor,
+		"imported":     job.Imported,
+		"duplicates":   job.Duplicates,
+		"invalid":      job.Invalid,
+		"issues":       job.Issues,
+		"error":        job.Error,
+		"created_at":   job.CreatedAt,
+		"completed_at": job.CompletedAt,
+	}
+}
+
+// activeImport returns the user's queued or running import, if any.
+func (s *Server) activeImport(userID string) (*importJob, error) {
+	var active []importJob
+	_, err := s.db.From("import_jobs").
+		Select("*", "", false).
+		Eq("user_id", userID).
+		In("status", []string{"queued", "running"}).
+		ExecuteTo(&active)
+	if err != nil || len(active) == 0 {
+		return nil, err
+	}
+	return &active[0], nil
+}
+
+func (s *Server) registerImportRoutes(r *gin.Engine, authMiddleware gin.HandlerFunc) {
+	// Start Import: multipart form with a "file" (JSON or CSV), an optional
+	// "format", "source" and "dry_run=true" to only validate and count
+	r.POST("/profile/import", authMiddleware, handle(func(c *gin.Context) error {
+		user, _ := c.Get("user")
+		supabaseUser := user.(types.User)
+		userID := supabaseUser.ID.String()
+
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportFileBytes+64<<10)
+
+		fileHeader, err := c.FormFile("file")
+		if err != nil {
+			return errValidation("Import file is required (max 2MB)")
+		}
+		file, err := fileHeader.Open()
+		if err != nil {
+			return errValidation("Invalid upload")
+		}
+		data, err := io.ReadAll(io.LimitReader(file, maxImportFileBytes+1))
+		file.Close()
+		if err != nil || len(data) > maxImportFileBytes {
+			return errTooLarge("Import file must be at most 2MB")
+		}
+
+		format := strings.ToLower(c.PostForm("format"))
+		if format == "" {
+			switch strings.ToLower(filepath.Ext(fileHeader.Filename)) {
+			case ".csv":
+				format = "csv"
+			case ".json":
+				format = "json"
+			default:
+				if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && (trimmed[0] == '[' || trimmed[0] == '{') {
+					format = "json"
+				} else {
+					format = "csv"
+				}
+			}
+		}
+
+		var records []map[string]string
+		switch format {
+		case "json":
+			records, err = parseImportJSON(data)
+		case "csv":
+			records, err = parseImportCSV(data)
+		default:
+			return errValidation("Format must be json or csv")
+		}
+		if err != nil {
+			return errValidation(err.Error())
+		}
+		if len(records) > maxImportRows {
+			return errValidation("You can import at most 5000 questions at a time")
+		}
+
+		rows, issues, invalid := s.validateImportRows(userID, records)
+		if len(rows) == 0 {
+			return errValidation("No valid questions to import").withDetails(gin.H{"invalid": invalid, "issues": issues})
+		}
+
+		existing, err := s.activeImport(userID)
+		if err != nil {
+			return errInternal("Failed to start import", fmt.Errorf("fetching imports: %w", err))
+		}
+		if existing != nil {
+			return errConflict("Another import is still running").withDetails(gin.H{"import": importStatus(existing)})
+		}
+
+		plaintext, err := json.Marshal(rows)
+		if err != nil {
+			return errInternal("Failed to start import", err)
+		}
+		payload, err := s.encrypt(string(plaintext))
+		if err != nil {
+			return errInternal("Failed to start import", fmt.Errorf("encryption error: %w", err))
+		}
+
+		source := strings.TrimSpace(c.PostForm("source"))
+		if len(source) > 50 {
+			source = source[:50]
+		}
+		if issues == nil {
+			issues = []importIssue{}
+		}
+
+		var created []importJob
+		_, err = s.db.From("import_jobs").
+			Insert(map[string]interface{}{
+				"user_id": userID,
+				"status":  "queued",
+				"dry_run": c.PostForm("dry_run") == "true",
+				"source":  source,
+				"payload": payload,
+				"total":   len(rows),
+				"invalid": invalid,
+				"issues":  issues,
+			}, false, "", "representation", "").
+			ExecuteTo(&created)
+
+		if err != nil {
+			return errInternal("Failed to start import", fmt.Errorf("creating import: %w", err))
+		}
+		if len(created) == 0 {
+			return errInternal("Failed to start import", errors.New("creating import: no row returned"))
+		}
+
+		job := created[0]
+		if s.claimImportJob(job.ID) {
+			job.Status = "running"
+			s.startImport(&job)
+		}
+
+		c.JSON(http.StatusAccepted, importStatus(&job))
+		return nil
+	}))
+
+	// Get Import Status
+	r.GET("/profile/import/:id", authMiddleware, handle(func(c *gin.Context) error {
+		user, _ := c.Get("user")
+		supabaseUser := user.(types.User)
+
+		job, err := s.getImportJob(c.Param("id"))
+		if err != nil || job.UserID != supabaseUser.ID.String() {
+			return errNotFound("Import not found")
+		}
+
+		c.JSON(http.StatusOK, importStatus(job))
+		return nil
+	}))
+
+	// Commit Dry Run: imports the rows a finished dry run validated
+	r.POST("/profile/import/:id/commit", authMiddleware, handle(func(c *gin.Context) error {
+		user, _ := c.Get("user")
+		supabaseUser := user.(types.User)
+		userID := supabaseUser.ID.String()
+
+		job, err := s.getImportJob(c.Param("id"))
+		if err != nil || job.UserID != userID {
+			return errNotFound("Import not found")
+		}
+		if !job.DryRun || job.Status != "completed" || job.Payload == nil {
+			return errConflict("Only a finished dry run can be committed")
+		}
+
+		if existing, err := s.activeImport(userID); err != nil || existing != nil {
+			return errConflict("Another import is still running")
+		}
+
+		var requeued []importJob
+		_, err = s.db.From("import_jobs").
+			Update(map[string]interface{}{
+				"status":       "queued",
+				"dry_run":      false,
+				"cursor":       0,
+				"imported":     0,
+				"duplicates":   0,
+				"completed_at": nil,
+				"updated_at":   "now()",
+			}, "representation", "").
+			Eq("id", job.ID).
+			Eq("status", "completed").
+			ExecuteTo(&requeued)
+
+		if err != nil || len(requeued) == 0 {
+			return errConflict("Only a finished dry run can be committed")
+		}
+
+		job = &requeued[0]
+		if s.claimImportJob(job.ID) {
+			job.Status = "running"
+			s.startImport(job)
+		}
+
+		c.JSON(http.StatusAccepted, importStatus(job))
+		return nil
+	}))
+
+	// Cancel Import: rows already imported are kept
+	r.DELETE("/profile/import/:id", authMiddleware, handle(func(c *gin.Context) error {
+		user, _ := c.Get("user")
+		supabaseUser := user.(types.User)
+
+		var cancelled []importJob
+		_, err := s.db.From("import_jobs").
+			Update(map[string]interface{}{"status": "cancelled", "payload": nil, "updated_at": "now()"}, "representation", "").
+			Eq("id", c.Param("id")).
+			Eq("user_id", supabaseUser.ID.String()).
+			In("status", []string{"queued", "running"}).
+			ExecuteTo(&cancelled)
+
+		if err != nil {
+			return errInternal("Failed to cancel import", fmt.Errorf("cancelling import: %w", err))
+		}
+		if len(cancelled) == 0 {
+			return errNotFound("No running import to cancel")
+		}
+
+		s.invalidateProfileCache(supabaseUser.ID.String())
+		c.JSON(http.StatusOK, gin.H{"status": "cancelled"})
+		return nil
+	}))
+}
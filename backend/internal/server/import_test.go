@@ -0,0 +1,147 @@
+package server
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"testing"
+)
+
+// importForm builds a multipart import upload; fields are extra form values.
+func importForm(t *testing.T, filename, content string, fields map[string]string) ([]byte, string) {
+	t.Helper()
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	for k, v := range fields {
+		w.WriteField(k, v)
+	}
+	part, err := w.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write([]byte(content))
+	w.Close()
+	return buf.Bytes(), w.FormDataContentType()
+}
+
+const importCSV = `Question,Answer,Date
+"Favourite colour?",Green,2023-04-01
+"Cats or dogs?","Both, obviously",2023-04-02 10:30
+"Cats or dogs?","Both, obviously",2023-04-02 10:30
+"No answer",,2023-04-03
+"Bad date",Yes,someday
+`
+
+func (e *testEnv) startImport(token, filename, content string, fields map[string]string) response {
+	e.t.Helper()
+	body, contentType := importForm(e.t, filename, content, fields)
+	return e.request("POST", "/profile/import", token, body, "Content-Type", contentType)
+}
+
+// waitForImport polls an import until it is no longer queued or running.
+func (e *testEnv) waitForImport(token, id string) map[string]interface{} {
+	e.t.Helper()
+	var status map[string]interface{}
+	e.eventually("import to finish", func() bool {
+		status = e.request("GET", "/profile/import/"+id, token, nil).expect(http.StatusOK).object()
+		return status["status"] != "queued" && status["status"] != "running"
+	})
+	return status
+}
+
+func TestImportDryRunAndCommit(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.addUser("alice", nil)
+	bob := e.addUser("bob", nil)
+
+	started := e.startImport(alice.Token, "answers.csv", importCSV, map[string]string{"dry_run": "true", "source": "old site"}).
+		expect(http.StatusAccepted).object()
+	id := started["id"].(string)
+	if started["invalid"] != float64(2) || started["total"] != float64(2) {
+		t.Fatalf("started = %v, want 2 valid rows and 2 invalid", started)
+	}
+	if issues, _ := started["issues"].([]interface{}); len(issues) != 2 {
+		t.Errorf("issues = %v", started["issues"])
+	}
+
+	status := e.waitForImport(alice.Token, id)
+	if status["status"] != "completed" || status["imported"] != float64(2) {
+		t.Fatalf("dry run = %v", status)
+	}
+	if messages := e.db.rows("messages", row{"receiver_id": alice.ID}); len(messages) != 0 {
+		t.Fatalf("dry run imported %d messages", len(messages))
+	}
+
+	e.request("GET", "/profile/import/"+id, bob.Token, nil).expect(http.StatusNotFound)
+	e.request("POST", "/profile/import/"+id+"/commit", bob.Token, nil).expect(http.StatusNotFound)
+
+	e.request("POST", "/profile/import/"+id+"/commit", alice.Token, nil).expect(http.StatusAccepted)
+	status = e.waitForImport(alice.Token, id)
+	if status["status"] != "completed" || status["dry_run"] != false || status["imported"] != float64(2) {
+		t.Fatalf("commit = %v", status)
+	}
+	e.request("POST", "/profile/import/"+id+"/commit", alice.Token, nil).expect(http.StatusConflict)
+
+	history := e.request("GET", "/history", alice.Token, nil).expect(http.StatusOK).list()
+	if len(history) != 2 {
+		t.Fatalf("history = %v, want the 2 imported answers", history)
+	}
+	if results := e.request("GET", "/inbox/search?q=dogs", alice.Token, nil).expect(http.StatusOK).list(); len(results) != 1 {
+		t.Errorf("imported answers are not searchable: %v", results)
+	}
+}
+
+func TestImportSkipsDuplicates(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.addUser("alice", nil)
+	const data = `{"items": [
+		{"q": "First?", "a": "Yes", "timestamp": 1680307200},
+		{"q": "Second?", "a": "No", "timestamp": "2023-04-02T00:00:00Z"}
+	]}`
+
+	first := e.startImport(alice.Token, "export", data, nil).expect(http.StatusAccepted).object()
+	e.waitForImport(alice.Token, first["id"].(string))
+
+	second := e.startImport(alice.Token, "export", data, nil).expect(http.StatusAccepted).object()
+	status := e.waitForImport(alice.Token, second["id"].(string))
+	if status["imported"] != float64(0) || status["duplicates"] != float64(2) {
+		t.Errorf("re-import = %v, want everything skipped", status)
+	}
+	if messages := e.db.rows("messages", row{"receiver_id": alice.ID}); len(messages) != 2 {
+		t.Errorf("stored %d messages, want 2", len(messages))
+	}
+}
+
+func TestImportRejectsBadFiles(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.addUser("alice", nil)
+
+	e.request("POST", "/profile/import", alice.Token, nil).expect(http.StatusBadRequest)
+	e.startImport(alice.Token, "a.json", `{"nope": 1}`, nil).expect(http.StatusBadRequest)
+	e.startImport(alice.Token, "a.txt", "question,answer\n", map[string]string{"format": "xml"}).expect(http.StatusBadRequest)
+	res := e.startImport(alice.Token, "a.csv", "question,answer,date\n,,\n", nil).expect(http.StatusBadRequest)
+	if details, _ := res.apiError().Details.(map[string]interface{}); details["invalid"] != float64(1) {
+		t.Errorf("response = %s", res.Body.String())
+	}
+}
+
+func TestCancelImport(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.addUser("alice", nil)
+	bob := e.addUser("bob", nil)
+	payload := e.encrypt("[]")
+	job := e.db.insert("import_jobs", row{"user_id": alice.ID, "payload": payload, "total": 10})
+	path := "/profile/import/" + job["id"].(string)
+
+	// A queued import blocks a new one until it is cancelled
+	e.startImport(alice.Token, "a.csv", importCSV, nil).expect(http.StatusConflict)
+
+	e.request("DELETE", path, bob.Token, nil).expect(http.StatusNotFound)
+	e.request("DELETE", path, alice.Token, nil).expect(http.StatusOK)
+	e.request("DELETE", path, alice.Token, nil).expect(http.StatusNotFound)
+
+	stored := e.db.rows("import_jobs", row{"id": job["id"]})[0]
+	if stored["status"] != "cancelled" || stored["payload"] != nil {
+		t.Errorf("job = %v, want cancelled without its rows", stored)
+	}
+}
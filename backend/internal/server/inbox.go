@@ -0,0 +1,579 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/supabase-community/gotrue-go/types"
+	"github.com/supabase-community/postgrest-go"
+)
+
+// Lengths in characters, repeated in the binding tags. Imports are held to
+// the same limits.
+const (
+	maxMessageLength = 1000
+	maxReplyLength   = 2000
+)
+
+// At most this many answers can be pinned to the top of a profile.
+const maxPinnedAnswers = 3
+
+func (s *Server) decryptRecursive(m interface{}) interface{} {
+	if m == nil {
+		return nil
+	}
+
+	switch v := m.(type) {
+	case []interface{}:
+		for i, item := range v {
+			v[i] = s.decryptRecursive(item)
+		}
+		return v
+	case map[string]interface{}:
+		// The blind search index is never sent back to clients
+		delete(v, "search_tokens")
+
+		// If this map has a "content" field, try to decrypt it
+		if content, ok := v["content"].(string); ok && len(content) > 20 {
+			if dec, err := s.decrypt(content); err == nil {
+				v["content"] = dec
+			} else {
+				// Only log if it looks like it might be an encrypted hex string (no spaces, even length)
+				if !strings.Contains(content, " ") && len(content)%2 == 0 {
+					slog.Debug("Decryption failed for content", "length", len(content), "error", err)
+				}
+			}
+		}
+
+		// Recursively process all fields (including "replies", "message", "profiles", etc.)
+		for key, val := range v {
+			if val != nil {
+				v[key] = s.decryptRecursive(val)
+			}
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+func (s *Server) decryptMessageMap(m interface{}) interface{} {
+	return s.decryptRecursive(m)
+}
+
+// formatWindow renders a rate limit window for error messages, e.g.
+// "10 minutes" or "1 hour".
+func formatWindow(d time.Duration) string {
+	unit, n := "second", int(d.Round(time.Second)/time.Second)
+	switch {
+	case d >= time.Hour && d%time.Hour == 0:
+		unit, n = "hour", int(d/time.Hour)
+	case d >= time.Minute && d%time.Minute == 0:
+		unit, n = "minute", int(d/time.Minute)
+	}
+	if n != 1 {
+		unit += "s"
+	}
+	return fmt.Sprintf("%d %s", n, unit)
+}
+
+// fetchInbox returns the user's pending messages that match filter,
+// decrypted.
+func (s *Server) fetchInbox(userID string, filter inboxFilter) ([]interface{}, error) {
+	var messages []interface{}
+	q := s.db.From("messages").
+		Select("*", "exact", false).
+		Eq("receiver_id", userID).
+		Eq("status", "pending")
+	_, err := filter.apply(q).
+		Order("created_at", &postgrest.OrderOpts{Ascending: false}).
+		ExecuteTo(&messages)
+	if err != nil {
+		return nil, err
+	}
+
+	// Decrypt messages
+	for i, m := range messages {
+		messages[i] = s.decryptMessageMap(m)
+	}
+	return messages, nil
+}
+
+// fetchHistory returns a user's replied and archived messages with their
+// replies, decrypted. A non-empty promptID narrows them as byPrompt does.
+func (s *Server) fetchHistory(userID, promptID string) ([]interface{}, error) {
+	var messages []interface{}
+	q := s.db.From("messages").
+		Select("*, replies(*)", "exact", false).
+		Eq("receiver_id", userID).
+		Neq("status", "pending")
+	_, err := byPrompt(q, promptID).
+		Order("created_at", &postgrest.OrderOpts{Ascending: false}).
+		ExecuteTo(&messages)
+	if err != nil {
+		return nil, err
+	}
+
+	// Decrypt messages and their replies
+	for i, m := range messages {
+		messages[i] = s.decryptMessageMap(m)
+	}
+	return messages, nil
+}
+
+// updateOwnMessage applies changes to one of the caller's received
+// messages and answers with status. Their public profile is refreshed.
+func (s *Server) updateOwnMessage(c *gin.Context, changes map[string]interface{}, status string) error {
+	user, _ := c.Get("user")
+	supabaseUser := user.(types.User)
+
+	var updated []map[string]interface{}
+	_, err := s.db.From("messages").
+		Update(changes, "", "").
+		Eq("id", c.Param("id")).
+		Eq("receiver_id", supabaseUser.ID.String()).
+		ExecuteTo(&updated)
+	if err != nil {
+		return errInternal("Failed to update message", fmt.Errorf("updating message: %w", err))
+	}
+	if len(updated) == 0 {
+		return errNotFound("Message not found")
+	}
+
+	s.invalidateProfileCache(supabaseUser.ID.String())
+	c.JSON(http.StatusOK, gin.H{"status": status})
+	return nil
+}
+
+// registerInboxRoutes covers sending, reading and answering messages.
+func (s *Server) registerInboxRoutes(r *gin.Engine, authMiddleware gin.HandlerFunc) {
+	// Inbox: Get pending messages, filtered by ?prompt_id= (or "none" for
+	// the general inbox), ?label=, ?starred=, ?unread= and ?snoozed=
+	r.GET("/inbox", authMiddleware, handle(func(c *gin.Context) error {
+		user, _ := c.Get("user")
+		supabaseUser := user.(types.User)
+
+		filter, err := inboxFilterFrom(c)
+		if err != nil {
+			return err
+		}
+		messages, err := s.fetchInbox(supabaseUser.ID.String(), filter)
+		if err != nil {
+			return errInternal("Failed to fetch inbox", err)
+		}
+
+		c.JSON(http.StatusOK, messages)
+		return nil
+	}))
+
+	// History: Get non-pending messages (replied, archived)
+	r.GET("/history", authMiddleware, handle(func(c *gin.Context) error {
+		user, _ := c.Get("user")
+		supabaseUser := user.(types.User)
+
+		messages, err := s.fetchHistory(supabaseUser.ID.String(), c.Query("prompt_id"))
+		if err != nil {
+			return errInternal("Failed to fetch history", err)
+		}
+
+		c.JSON(http.StatusOK, messages)
+		return nil
+	}))
+
+	// Reply: Publish a response
+	r.POST("/reply", authMiddleware, handle(func(c *gin.Context) error {
+		var body struct {
+			MessageID string `json:"message_id" binding:"required"`
+			Content   string `json:"content" binding:"required,max=2000"` // maxReplyLength
+		}
+
+		if err := bindJSON(c, &body); err != nil {
+			return err
+		}
+
+		user, _ := c.Get("user")
+		supabaseUser := user.(types.User)
+
+		// Only the receiver can reply to a message
+		var message struct {
+			Content string `json:"content"`
+		}
+		_, err := s.db.From("messages").
+			Select("content", "", false).
+			Eq("id", body.MessageID).
+			Eq("receiver_id", supabaseUser.ID.String()).
+			Single().
+			ExecuteTo(&message)
+
+		if isNoRows(err) {
+			return errNotFound("Message not found")
+		}
+		if err != nil {
+			return errInternal("Failed to fetch message", err)
+		}
+
+		// Encrypt the content
+		encryptedContent, err := s.encrypt(body.Content)
+		if err != nil {
+			return errInternal("Encryption failed", err)
+		}
+
+		// 1. Create the reply
+		replyData := map[string]interface{}{
+			"message_id": body.MessageID,
+			"sender_id":  supabaseUser.ID.String(),
+			"content":    encryptedContent,
+		}
+
+		var newReply []interface{}
+		_, err = s.db.From("replies").Insert(replyData, false, "", "", "").ExecuteTo(&newReply)
+		if err != nil {
+			return errInternal("Failed to create reply", err)
+		}
+
+		// 2. Update message status to 'replied' and index the reply for search
+		messageUpdate := map[string]interface{}{"status": "replied"}
+		if original, err := s.decrypt(message.Content); err == nil {
+			messageUpdate["search_tokens"] = s.blindTokens(original, body.Content)
+		}
+
+		_, _, err = s.db.From("messages").
+			Update(messageUpdate, "", "").
+			Eq("id", body.MessageID).
+			Execute()
+
+		if err != nil {
+			return errInternal("Failed to update message", err)
+		}
+
+		s.invalidateProfileCache(supabaseUser.ID.String())
+
+		c.JSON(http.StatusOK, gin.H{"status": "published", "reply": newReply})
+		return nil
+	}))
+
+	// Public: Send a message to a user (Allows anonymous if rate limited)
+	r.POST("/send", handle(func(c *gin.Context) error {
+		var body struct {
+			ReceiverID string `json:"receiver_id" binding:"required"`
+			Content    string `json:"content" binding:"required,max=1000"` // maxMessageLength
+			ThreadID   string `json:"thread_id"`
+			// Sends to one of the receiver's prompts instead of their general inbox
+			PromptID string `json:"prompt_id" binding:"max=64"`
+			// Solution to GET /send/challenge, for anonymous senders
+			Verification string `json:"verification" binding:"max=1024"`
+		}
+
+		if err := bindJSON(c, &body); err != nil {
+			return err
+		}
+
+		// Optional Auth: If token provided, link to sender
+		viewer := s.optionalViewer(c)
+		var senderID *string
+		if viewer != nil {
+			id := viewer.ID.String()
+			senderID = &id
+		}
+
+		// 🛡️ Anonymous senders prove they're human before anything else
+		if s.verifier != nil && senderID == nil {
+			if err := s.verifier.Verify(c.Request.Context(), body.ReceiverID, body.Verification, c.ClientIP()); err != nil {
+				s.metrics.filtered.Inc("verification")
+				return err
+			}
+		}
+
+		// 🛡️ Rate Limiting Check: per IP and account, and per receiver
+		currID := ""
+		if senderID != nil {
+			currID = *senderID
+		}
+		if err := s.checkSendLimits(c, body.ReceiverID, currID); err != nil {
+			return err
+		}
+
+		// 🛡️ Safety check 1: Global Profanity
+		if containsProfanity(body.Content) {
+			s.metrics.filtered.Inc("profanity")
+			return errBlocked("Message contains prohibited content")
+		}
+
+		// 🛡️ Safety check 2: Check if receiver is paused or has custom blocks
+		var receiverProfile struct {
+			IsPaused       bool     `json:"is_paused"`
+			BlockedPhrases []string `json:"blocked_phrases"`
+			Email          string   `json:"email"`
+			Username       string   `json:"username"`
+			sendPolicy
+		}
+
+		_, err := s.db.From("profiles").
+			Select("is_paused, blocked_phrases, email, username, who_can_send, min_account_age_days", "", false).
+			Eq("id", body.ReceiverID).
+			Single().
+			ExecuteTo(&receiverProfile)
+
+		if isNoRows(err) {
+			return errNotFound("User not found")
+		}
+		if err != nil {
+			return errInternal("Could not verify receiver status", fmt.Errorf("fetching receiver: %w", err))
+		}
+
+		// Decrypt email for notification
+		if receiverProfile.Email != "" {
+			if decryptedEmail, err := s.decrypt(receiverProfile.Email); err == nil {
+				receiverProfile.Email = decryptedEmail
+			} else {
+				logFor(c).Warn("Failed to decrypt email", "receiver_id", body.ReceiverID, "error", err)
+			}
+		}
+
+		if receiverProfile.IsPaused {
+			return errPaused("This inbox is currently paused by the owner")
+		}
+
+		// Prompts must be open and can narrow who may send. Their policy
+		// applies on top of the profile's, so it never widens it.
+		policies := []sendPolicy{receiverProfile.sendPolicy}
+		blockedPhrases := receiverProfile.BlockedPhrases
+		if body.PromptID != "" {
+			prompt, err := s.openPrompt(body.ReceiverID, body.PromptID)
+			if err != nil {
+				return err
+			}
+			if prompt.WhoCanSend != nil {
+				policies = append(policies, sendPolicy{WhoCanSend: *prompt.WhoCanSend})
+			}
+			blockedPhrases = append(blockedPhrases, prompt.BlockedPhrases...)
+		}
+
+		// The receiver decides who may send: signed-in users, friends or
+		// followers only, and how old their account must be
+		for _, policy := range policies {
+			if err := s.checkSendPolicy(policy, body.ReceiverID, viewer); err != nil {
+				s.metrics.filtered.Inc("send_policy")
+				return err
+			}
+		}
+
+		// 🛡️ Safety check 3: User-specific blocked phrases
+		contentLower := strings.ToLower(body.Content)
+		for _, phrase := range blockedPhrases {
+			if strings.Contains(contentLower, strings.ToLower(phrase)) {
+				s.metrics.filtered.Inc("blocked_phrase")
+				return errBlocked("Message contains a phrase blocked by the user")
+			}
+		}
+
+		// 🛡️ Safety check 4: For threaded follow-ups, verify sender
+		if body.ThreadID != "" {
+			var originalThread []map[string]interface{}
+			_, err := s.db.From("messages").
+				Select("sender_id", "", false).
+				Eq("thread_id", body.ThreadID).
+				Order("created_at", &postgrest.OrderOpts{Ascending: true}).
+				Limit(1, "").
+				ExecuteTo(&originalThread)
+
+			if err == nil && len(originalThread) > 0 {
+				rootSenderID := originalThread[0]["sender_id"]
+				// If the original sender was logged in, we must ensure the follow-up is from them
+				if rootSenderID != nil {
+					if rootSenderID.(string) != currID {
+						return errForbidden("Only the original sender can ask a follow-up")
+					}
+				} else {
+					// Root was anonymous and not logged in - technically anyone could follow up if they have the thread_id
+					// but we'll allow it for now as "thread_id" is a secure UUID.
+				}
+			}
+		}
+
+		// 🛡️ Safety check 5: The same sender pasting the same message into
+		// the same inbox again
+		if s.isDuplicate(c, body.ReceiverID, currID, body.Content) {
+			s.metrics.filtered.Inc("duplicate")
+			return errConflict("You already sent this message")
+		}
+
+		messageData := map[string]interface{}{
+			"receiver_id": body.ReceiverID,
+			"content":     body.Content,
+			"status":      "pending",
+		}
+		if senderID != nil {
+			messageData["sender_id"] = *senderID
+		}
+		if body.ThreadID != "" {
+			messageData["thread_id"] = body.ThreadID
+		}
+		if body.PromptID != "" {
+			messageData["prompt_id"] = body.PromptID
+		}
+
+		// Encrypt message content
+		encryptedContent, err := s.encrypt(body.Content)
+		if err == nil {
+			messageData["content"] = encryptedContent
+		} else {
+			logFor(c).Error("Encryption error", "error", err)
+		}
+
+		// Index the message for the receiver's inbox search
+		messageData["search_tokens"] = s.blindTokens(body.Content)
+
+		var newMessage []interface{}
+		_, err = s.db.From("messages").Insert(messageData, false, "", "", "").ExecuteTo(&newMessage)
+
+		if err != nil {
+			return errInternal("Failed to send", err)
+		}
+		s.recordSend(c.Request.Context(), body.ReceiverID)
+		s.rememberContent(c, body.ReceiverID, currID, body.Content)
+
+		// 📧 Send Email Notification (Non-blocking)
+		if receiverProfile.Email != "" {
+			logger := logFor(c)
+			s.tasks.Go("new message email", func(ctx context.Context) {
+				ctx = withLogger(ctx, logger)
+				// Emails still queued when the receiver deletes their account are dropped
+				if s.deletionPending(ctx, body.ReceiverID) {
+					s.metrics.emails.Inc("cancelled")
+					return
+				}
+				s.mailer.sendNewMessage(ctx, receiverProfile.Email, receiverProfile.Username, body.Content)
+			})
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"status": "sent"})
+		return nil
+	}))
+
+	// Archive Message (Discard)
+	r.POST("/messages/:id/archive", authMiddleware, handle(func(c *gin.Context) error {
+		id := c.Param("id")
+		user, _ := c.Get("user")
+		supabaseUser := user.(types.User)
+
+		_, _, err := s.db.From("messages").
+			Update(map[string]interface{}{"status": "archived", "pinned_at": nil}, "", "").
+			Eq("id", id).
+			Eq("receiver_id", supabaseUser.ID.String()).
+			Execute()
+
+		if err != nil {
+			return errInternal("Failed to archive message", err)
+		}
+
+		s.invalidateProfileCache(supabaseUser.ID.String())
+
+		c.JSON(http.StatusOK, gin.H{"status": "archived"})
+		return nil
+	}))
+
+	// Pin an answer to the top of my profile
+	r.POST("/messages/:id/pin", authMiddleware, handle(func(c *gin.Context) error {
+		user, _ := c.Get("user")
+		supabaseUser := user.(types.User)
+		userID := supabaseUser.ID.String()
+
+		var message struct {
+			Status   string  `json:"status"`
+			IsHidden bool    `json:"is_hidden"`
+			PinnedAt *string `json:"pinned_at"`
+		}
+		_, err := s.db.From("messages").
+			Select("status, is_hidden, pinned_at", "", false).
+			Eq("id", c.Param("id")).
+			Eq("receiver_id", userID).
+			Single().
+			ExecuteTo(&message)
+		if isNoRows(err) {
+			return errNotFound("Message not found")
+		}
+		if err != nil {
+			return errInternal("Failed to pin message", fmt.Errorf("fetching message: %w", err))
+		}
+		if message.Status != "replied" {
+			return errValidation("Only answered messages can be pinned")
+		}
+		if message.IsHidden {
+			return errValidation("Hidden answers can't be pinned")
+		}
+		if message.PinnedAt != nil {
+			c.JSON(http.StatusOK, gin.H{"status": "pinned"})
+			return nil
+		}
+
+		var pinned []map[string]interface{}
+		_, err = s.db.From("messages").
+			Select("id", "", false).
+			Eq("receiver_id", userID).
+			Eq("status", "replied").
+			Not("pinned_at", "is", "null").
+			ExecuteTo(&pinned)
+		if err != nil {
+			return errInternal("Failed to pin message", fmt.Errorf("counting pinned messages: %w", err))
+		}
+		if len(pinned) >= maxPinnedAnswers {
+			return errValidation(fmt.Sprintf("You can pin at most %d answers", maxPinnedAnswers))
+		}
+
+		_, _, err = s.db.From("messages").
+			Update(map[string]interface{}{"pinned_at": time.Now().UTC().Format(time.RFC3339Nano)}, "", "").
+			Eq("id", c.Param("id")).
+			Eq("receiver_id", userID).
+			Execute()
+		if err != nil {
+			return errInternal("Failed to pin message", fmt.Errorf("pinning message: %w", err))
+		}
+
+		s.invalidateProfileCache(userID)
+		c.JSON(http.StatusOK, gin.H{"status": "pinned"})
+		return nil
+	}))
+
+	// Unpin an answer
+	r.DELETE("/messages/:id/pin", authMiddleware, handle(func(c *gin.Context) error {
+		return s.updateOwnMessage(c, map[string]interface{}{"pinned_at": nil}, "unpinned")
+	}))
+
+	// Hide an answer from my profile without archiving it
+	r.POST("/messages/:id/hide", authMiddleware, handle(func(c *gin.Context) error {
+		return s.updateOwnMessage(c, map[string]interface{}{"is_hidden": true, "pinned_at": nil}, "hidden")
+	}))
+
+	// Show a hidden answer on my profile again
+	r.DELETE("/messages/:id/hide", authMiddleware, handle(func(c *gin.Context) error {
+		return s.updateOwnMessage(c, map[string]interface{}{"is_hidden": false}, "shown")
+	}))
+
+	// Delete Message
+	r.DELETE("/messages/:id", authMiddleware, handle(func(c *gin.Context) error {
+		id := c.Param("id")
+		user, _ := c.Get("user")
+		supabaseUser := user.(types.User)
+
+		_, _, err := s.db.From("messages").
+			Delete("", "").
+			Eq("id", id).
+			Eq("receiver_id", supabaseUser.ID.String()).
+			Execute()
+
+		if err != nil {
+			return errInternal("Failed to delete message", err)
+		}
+
+		s.invalidateProfileCache(supabaseUser.ID.String())
+
+		c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+		return nil
+	}))
+}
@@ -0,0 +1,181 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestInboxAndHistory(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.addUser("alice", nil)
+	bob := e.addUser("bob", nil)
+
+	pending := e.addMessage(alice, "what is your favourite colour?", "pending", nil)
+	answered := e.addMessage(alice, "tea or coffee?", "replied", nil)
+	e.addReply(answered, alice, "tea")
+	e.addMessage(bob, "not for alice", "pending", nil)
+
+	inbox := e.request("GET", "/inbox", alice.Token, nil).expect(http.StatusOK).list()
+	if len(inbox) != 1 || inbox[0]["id"] != pending["id"] {
+		t.Fatalf("inbox = %v, want only the pending message", inbox)
+	}
+	if inbox[0]["content"] != "what is your favourite colour?" {
+		t.Errorf("content = %v, want decrypted text", inbox[0]["content"])
+	}
+	if _, ok := inbox[0]["search_tokens"]; ok {
+		t.Error("inbox leaks search_tokens")
+	}
+
+	history := e.request("GET", "/history", alice.Token, nil).expect(http.StatusOK).list()
+	if len(history) != 1 || history[0]["id"] != answered["id"] {
+		t.Fatalf("history = %v, want the answered message", history)
+	}
+	replies, _ := history[0]["replies"].([]interface{})
+	if len(replies) != 1 || replies[0].(map[string]interface{})["content"] != "tea" {
+		t.Errorf("replies = %v, want decrypted reply", replies)
+	}
+}
+
+func TestReply(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.addUser("alice", nil)
+	bob := e.addUser("bob", nil)
+	msg := e.addMessage(alice, "favourite book?", "pending", nil)
+
+	e.request("POST", "/reply", alice.Token, map[string]string{"message_id": msg["id"].(string)}).
+		expect(http.StatusBadRequest)
+
+	// Only the receiver may answer
+	e.request("POST", "/reply", bob.Token, map[string]string{"message_id": msg["id"].(string), "content": "hijack"}).
+		expect(http.StatusNotFound)
+
+	e.request("POST", "/reply", alice.Token, map[string]string{"message_id": msg["id"].(string), "content": "Dune"}).
+		expect(http.StatusOK)
+
+	stored := e.db.rows("messages", row{"id": msg["id"]})[0]
+	if stored["status"] != "replied" {
+		t.Errorf("status = %v, want replied", stored["status"])
+	}
+	if tokens, _ := stored["search_tokens"].([]interface{}); len(tokens) == 0 {
+		t.Error("reply was not indexed for search")
+	}
+	replies := e.db.rows("replies", row{"message_id": msg["id"]})
+	if len(replies) != 1 || replies[0]["content"] == "Dune" {
+		t.Fatalf("replies = %v, want one encrypted reply", replies)
+	}
+}
+
+func TestSend(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.addUser("alice", row{"blocked_phrases": []string{"pineapple"}})
+	bob := e.addUser("bob", nil)
+
+	send := func(token string, body map[string]string) response {
+		return e.request("POST", "/send", token, body, "X-Forwarded-For", "203.0.113.7")
+	}
+
+	send("", map[string]string{"content": "hi"}).expect(http.StatusBadRequest)
+	send("", map[string]string{"receiver_id": alice.ID, "content": "you are offensive"}).expect(http.StatusForbidden)
+	send("", map[string]string{"receiver_id": alice.ID, "content": "Pineapple on pizza?"}).expect(http.StatusForbidden)
+
+	send("", map[string]string{"receiver_id": alice.ID, "content": "anonymous hello"}).expect(http.StatusCreated)
+	send(bob.Token, map[string]string{"receiver_id": alice.ID, "content": "hello from bob"}).expect(http.StatusCreated)
+
+	messages := e.db.rows("messages", row{"receiver_id": alice.ID})
+	if len(messages) != 2 {
+		t.Fatalf("stored %d messages, want 2", len(messages))
+	}
+	for _, m := range messages {
+		if m["content"] == "anonymous hello" || m["content"] == "hello from bob" {
+			t.Errorf("message stored in plaintext: %v", m)
+		}
+	}
+	if len(e.db.rows("messages", row{"sender_id": bob.ID})) != 1 {
+		t.Error("logged-in sender was not recorded")
+	}
+}
+
+func TestSendRejectsPausedInbox(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.addUser("alice", row{"is_paused": true})
+
+	e.request("POST", "/send", "", map[string]string{"receiver_id": alice.ID, "content": "hello"}).
+		expect(http.StatusForbidden)
+	e.request("POST", "/send", "", map[string]string{"receiver_id": "00000000-0000-0000-0000-000000000000", "content": "hello"}).
+		expect(http.StatusNotFound)
+
+	// An outage is not a missing receiver
+	e.db.failTable("profiles")
+	e.request("POST", "/send", "", map[string]string{"receiver_id": alice.ID, "content": "hello"}).
+		expect(http.StatusInternalServerError)
+}
+
+func TestSendRateLimit(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.addUser("alice", nil)
+	e.srv.cfg.Send.PerReceiverAnonymous = 100
+
+	for i := 0; i < 5; i++ {
+		e.request("POST", "/send", "", map[string]string{"receiver_id": alice.ID, "content": fmt.Sprintf("hello %d", i)}).
+			expect(http.StatusCreated)
+	}
+	res := e.request("POST", "/send", "", map[string]string{"receiver_id": alice.ID, "content": "hello again"}).
+		expect(http.StatusTooManyRequests)
+	if msg := res.errorMessage(); msg != "Too many messages. Please wait 10 minutes." {
+		t.Errorf("error = %q", msg)
+	}
+}
+
+func TestSendFollowUpRequiresOriginalSender(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.addUser("alice", nil)
+	bob := e.addUser("bob", nil)
+	mallory := e.addUser("mallory", nil)
+	thread := "11111111-1111-1111-1111-111111111111"
+	e.addMessage(alice, "first", "replied", row{"sender_id": bob.ID, "thread_id": thread})
+
+	e.request("POST", "/send", mallory.Token, map[string]string{"receiver_id": alice.ID, "content": "follow up", "thread_id": thread}).
+		expect(http.StatusForbidden)
+	e.request("POST", "/send", bob.Token, map[string]string{"receiver_id": alice.ID, "content": "follow up", "thread_id": thread}).
+		expect(http.StatusCreated)
+}
+
+func TestArchiveAndDeleteMessage(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.addUser("alice", nil)
+	bob := e.addUser("bob", nil)
+	first := e.addMessage(alice, "one", "pending", nil)
+	second := e.addMessage(alice, "two", "pending", nil)
+
+	e.request("POST", "/messages/"+first["id"].(string)+"/archive", alice.Token, nil).expect(http.StatusOK)
+	if got := e.db.rows("messages", row{"id": first["id"]})[0]["status"]; got != "archived" {
+		t.Errorf("status = %v, want archived", got)
+	}
+
+	// Someone else's delete is a no-op
+	e.request("DELETE", "/messages/"+second["id"].(string), bob.Token, nil).expect(http.StatusOK)
+	if len(e.db.rows("messages", row{"id": second["id"]})) != 1 {
+		t.Fatal("another user deleted the message")
+	}
+	e.request("DELETE", "/messages/"+second["id"].(string), alice.Token, nil).expect(http.StatusOK)
+	if len(e.db.rows("messages", row{"id": second["id"]})) != 0 {
+		t.Error("message was not deleted")
+	}
+}
+
+func TestFormatWindow(t *testing.T) {
+	cases := map[string]string{
+		"10m": "10 minutes",
+		"1h":  "1 hour",
+		"90s": "90 seconds",
+		"1m":  "1 minute",
+	}
+	for in, want := range cases {
+		d, _ := time.ParseDuration(in)
+		if got := formatWindow(d); got != want {
+			t.Errorf("formatWindow(%s) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/pranav/replied-backend/internal/config"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// Log attributes holding personal data. Identifiers are replaced with a
+// keyed hash, so one user's lines can still be correlated; free text is
+// dropped entirely.
+var (
+	pseudonymizedLogKeys = map[string]bool{
+		"user_id": true, "receiver_id": true, "sender_id": true,
+		"profile_id": true, "ip": true,
+	}
+	maskedLogKeys = map[string]bool{
+		"email": true, "username": true, "content": true, "note": true,
+	}
+)
+
+// NewLogger returns the JSON logger the server writes to. Unless redaction
+// is switched off, personal data in the attributes above is masked.
+func NewLogger(w io.Writer, cfg *config.Config) *slog.Logger {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.Log.Level)); err != nil {
+		level = slog.LevelInfo
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	if cfg.Log.Redact {
+		mac := hmac.New(sha256.New, cfg.Key())
+		mac.Write([]byte("log pseudonyms"))
+		opts.ReplaceAttr = logRedactor(mac.Sum(nil))
+	}
+	return slog.New(slog.NewJSONHandler(w, opts))
+}
+
+func logRedactor(key []byte) func([]string, slog.Attr) slog.Attr {
+	return func(_ []string, a slog.Attr) slog.Attr {
+		if a.Value.Kind() != slog.KindString || a.Value.String() == "" {
+			return a
+		}
+		switch {
+		case maskedLogKeys[a.Key]:
+			return slog.String(a.Key, "[redacted]")
+		case pseudonymizedLogKeys[a.Key]:
+			mac := hmac.New(sha256.New, key)
+			mac.Write([]byte(a.Value.String()))
+			return slog.String(a.Key, "anon:"+hex.EncodeToString(mac.Sum(nil))[:12])
+		}
+		return a
+	}
+}
+
+type loggerKey struct{}
+
+// withLogger attaches a logger to ctx, so work started by a request keeps
+// logging its request ID.
+func withLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// loggerFrom returns the logger attached to ctx, or the default logger.
+func loggerFrom(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// logFor returns the request's logger, tagged with its request ID.
+func logFor(c *gin.Context) *slog.Logger {
+	return loggerFrom(c.Request.Context())
+}
+
+// validRequestID accepts IDs from a proxy or the client as long as they are
+// short and can't inject anything into logs or headers.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// requestID reuses the caller's X-Request-ID or assigns one, echoes it in
+// the response and tags every log line of the request with it.
+func requestID(c *gin.Context) {
+	id := c.GetHeader(requestIDHeader)
+	if !validRequestID(id) {
+		id = uuid.NewString()
+	}
+	c.Set("request_id", id)
+	c.Header(requestIDHeader, id)
+	c.Request = c.Request.WithContext(withLogger(c.Request.Context(), slog.Default().With("request_id", id)))
+	c.Next()
+}
+
+// routeLabel is the route pattern that matched, so paths with IDs or
+// usernames in them don't end up in logs and metrics.
+func routeLabel(c *gin.Context) string {
+	if route := c.FullPath(); route != "" {
+		return route
+	}
+	return "unmatched"
+}
+
+// probeRoutes are polled constantly; their access logs are debug only.
+var probeRoutes = map[string]bool{"/healthz": true, "/readyz": true, "/metrics": true}
+
+// accessLog logs one line per request.
+func accessLog(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+
+	route := routeLabel(c)
+	status := c.Writer.Status()
+	level := slog.LevelInfo
+	switch {
+	case status >= 500:
+		level = slog.LevelError
+	case probeRoutes[route]:
+		level = slog.LevelDebug
+	}
+	logFor(c).LogAttrs(c.Request.Context(), level, "Request",
+		slog.String("method", c.Request.Method),
+		slog.String("route", route),
+		slog.Int("status", status),
+		slog.Int64("duration_ms", time.Since(start).Milliseconds()),
+		slog.Int("bytes", c.Writer.Size()),
+		slog.String("ip", c.ClientIP()),
+	)
+}
+
+// recovery turns a panicking handler into a generic 500, logging the panic
+// and stack against the request ID.
+func recovery(c *gin.Context) {
+	defer func() {
+		if r := recover(); r != nil {
+			if r == http.ErrAbortHandler {
+				panic(r)
+			}
+			logFor(c).Error("Panic serving request", "panic", r, "stack", string(debug.Stack()))
+			writeError(c, errInternal("Internal server error", nil))
+		}
+	}()
+	c.Next()
+}
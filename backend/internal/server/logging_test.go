@@ -0,0 +1,128 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/pranav/replied-backend/internal/config"
+)
+
+// captureLogs sends the default logger to a buffer for the rest of the test.
+func captureLogs(t *testing.T, cfg *config.Config) *bytes.Buffer {
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(NewLogger(&buf, cfg))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	return &buf
+}
+
+// logLines decodes JSON log output.
+func logLines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	var lines []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("log line %q is not JSON: %v", line, err)
+		}
+		lines = append(lines, entry)
+	}
+	return lines
+}
+
+func TestLoggerRedactsPersonalData(t *testing.T) {
+	cfg := &config.Config{EncryptionKey: strings.Repeat("ab", 32), Log: config.LogConfig{Level: "info", Redact: true}}
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, cfg)
+
+	logger.Info("first", "user_id", "user-1", "email", "alice@example.com", "content", "a secret", "export_id", "exp-1")
+	logger.Info("second", "user_id", "user-1")
+	logger.Debug("hidden below the level")
+
+	lines := logLines(t, &buf)
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2: %s", len(lines), buf.String())
+	}
+	first := lines[0]
+	if first["email"] != "[redacted]" || first["content"] != "[redacted]" {
+		t.Errorf("free text not masked: %v", first)
+	}
+	if first["export_id"] != "exp-1" {
+		t.Errorf("export_id = %v, want it untouched", first["export_id"])
+	}
+	pseudonym, _ := first["user_id"].(string)
+	if !strings.HasPrefix(pseudonym, "anon:") || strings.Contains(buf.String(), "user-1") {
+		t.Errorf("user_id = %q, want a pseudonym", pseudonym)
+	}
+	if lines[1]["user_id"] != pseudonym {
+		t.Errorf("pseudonyms differ between lines: %v and %v", pseudonym, lines[1]["user_id"])
+	}
+
+	buf.Reset()
+	cfg.Log.Redact = false
+	NewLogger(&buf, cfg).Info("debugging", "email", "alice@example.com")
+	if !strings.Contains(buf.String(), "alice@example.com") {
+		t.Errorf("redaction off still masked: %s", buf.String())
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	e := newTestEnv(t)
+
+	generated := e.request("GET", "/healthz", "", nil).expect(http.StatusOK).Header().Get(requestIDHeader)
+	if len(generated) != 36 {
+		t.Errorf("generated request ID = %q, want a UUID", generated)
+	}
+
+	res := e.request("GET", "/healthz", "", nil, requestIDHeader, "edge-1234.abc")
+	if got := res.Header().Get(requestIDHeader); got != "edge-1234.abc" {
+		t.Errorf("request ID = %q, want the caller's", got)
+	}
+
+	res = e.request("GET", "/healthz", "", nil, requestIDHeader, "bad id\r\nX-Injected: 1")
+	if got := res.Header().Get(requestIDHeader); got == "" || strings.ContainsAny(got, " \r\n") {
+		t.Errorf("request ID = %q, want a fresh one", got)
+	}
+}
+
+func TestInternalErrorsAreGeneric(t *testing.T) {
+	e := newTestEnv(t)
+	logs := captureLogs(t, e.srv.cfg)
+	alice := e.addUser("alice", nil)
+	e.db.failTable("messages")
+
+	res := e.request("GET", "/inbox", alice.Token, nil, requestIDHeader, "req-42").
+		expect(http.StatusInternalServerError)
+	if body := res.Body.String(); strings.Contains(body, "simulated failure") {
+		t.Errorf("response leaks the database error: %s", body)
+	}
+	if msg := res.errorMessage(); msg != "Failed to fetch inbox" {
+		t.Errorf("error = %q", msg)
+	}
+
+	var logged, accessed bool
+	for _, line := range logLines(t, logs) {
+		if line["request_id"] != "req-42" {
+			continue
+		}
+		if line["msg"] == "Failed to fetch inbox" && strings.Contains(line["error"].(string), "simulated failure") {
+			logged = true
+		}
+		if line["msg"] == "Request" && line["route"] == "/inbox" && line["status"] == float64(500) {
+			accessed = true
+			if ip, _ := line["ip"].(string); !strings.HasPrefix(ip, "anon:") {
+				t.Errorf("access log ip = %q, want it pseudonymized", ip)
+			}
+		}
+	}
+	if !logged || !accessed {
+		t.Errorf("missing error or access log for the request: %s", logs.String())
+	}
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+
+	"github.com/pranav/replied-backend/internal/config"
+)
+
+const resendAPIURL = "https://api.resend.com"
+
+// mailer sends notification emails through Resend.
+type mailer struct {
+	apiKey      string
+	from        string
+	frontendURL string
+	baseURL     string
+	httpClient  *http.Client
+	outcomes    *counterVec
+}
+
+func newMailer(cfg config.EmailConfig, frontendURL string, httpClient *http.Client, outcomes *counterVec) *mailer {
+	return &mailer{
+		apiKey:      cfg.ResendAPIKey,
+		from:        cfg.From,
+		frontendURL: frontendURL,
+		baseURL:     resendAPIURL,
+		httpClient:  httpClient,
+		outcomes:    outcomes,
+	}
+}
+
+// ping checks that Resend answers. Sending-only API keys may not read any
+// resource, so any response short of a server error counts as reachable.
+func (m *mailer) ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", m.baseURL+"/domains", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+m.apiKey)
+
+	resp, err := m.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (m *mailer) sendNewMessage(ctx context.Context, toEmail, username, content string) {
+	if m.apiKey == "" {
+		loggerFrom(ctx).Debug("RESEND_API_KEY not set, skipping email notification")
+		m.outcomes.Inc("skipped")
+		return
+	}
+
+	url := m.baseURL + "/emails"
+
+	body := map[string]interface{}{
+		"from":    m.from,
+		"to":      []string{toEmail},
+		"subject": "New Anonymous Message Received!",
+		"html": fmt.Sprintf("<strong>Hi %s!</strong><br><br>You just received a new anonymous message:<br><br><em>\"%s\"</em><br><br><a href=\"%s/inbox\">Go to your inbox to reply</a>",
+			html.EscapeString(username), html.EscapeString(content), html.EscapeString(m.frontendURL)),
+	}
+
+	jsonBody, _ := json.Marshal(body)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonBody))
+	if err != nil {
+		loggerFrom(ctx).Error("Failed to build email request", "error", err)
+		m.outcomes.Inc("failed")
+		return
+	}
+	req.Header.Set("Authorization", "Bearer "+m.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := m.httpClient.Do(req)
+	if err != nil {
+		loggerFrom(ctx).Error("Failed to send email", "error", err)
+		m.outcomes.Inc("failed")
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		loggerFrom(ctx).Error("Resend API error", "status", resp.StatusCode)
+		m.outcomes.Inc("failed")
+	} else {
+		loggerFrom(ctx).Info("Email notification sent")
+		m.outcomes.Inc("sent")
+	}
+}
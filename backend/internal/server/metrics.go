@@ -0,0 +1,319 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// metricsRegistry is a small Prometheus registry: labelled counters and
+// histograms, written in the text exposition format. It covers what the
+// server reports without pulling in the client library.
+type metricsRegistry struct {
+	mu       sync.Mutex
+	families []metricFamily
+}
+
+type metricFamily interface {
+	writeTo(w *bufio.Writer)
+}
+
+func (r *metricsRegistry) register(f metricFamily) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.families = append(r.families, f)
+}
+
+// Write renders every metric in registration order.
+func (r *metricsRegistry) Write(w io.Writer) error {
+	r.mu.Lock()
+	families := append([]metricFamily(nil), r.families...)
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		f.writeTo(bw)
+	}
+	return bw.Flush()
+}
+
+// seriesKey joins label values into a map key.
+func seriesKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels renders {name="value",...}, with extra appended as-is.
+func formatLabels(names, values []string, extra string) string {
+	if len(names) == 0 && extra == "" {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, name, labelEscaper.Replace(values[i]))
+	}
+	if extra != "" {
+		if len(names) > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(extra)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func writeHeader(w *bufio.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// counterVec is a counter partitioned by labels.
+type counterVec struct {
+	name, help string
+	labels     []string
+
+	mu     sync.Mutex
+	series map[string]*counterSeries
+}
+
+type counterSeries struct {
+	values []string
+	count  float64
+}
+
+func (r *metricsRegistry) counter(name, help string, labels ...string) *counterVec {
+	c := &counterVec{name: name, help: help, labels: labels, series: map[string]*counterSeries{}}
+	r.register(c)
+	return c
+}
+
+// Inc adds one to the series with the given label values.
+func (c *counterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+func (c *counterVec) Add(delta float64, values ...string) {
+	if len(values) != len(c.labels) {
+		panic(fmt.Sprintf("metric %s: got %d label values, want %d", c.name, len(values), len(c.labels)))
+	}
+	key := seriesKey(values)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.series[key]
+	if !ok {
+		s = &counterSeries{values: append([]string(nil), values...)}
+		c.series[key] = s
+	}
+	s.count += delta
+}
+
+// Value returns the current count for the given label values.
+func (c *counterVec) Value(values ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if s, ok := c.series[seriesKey(values)]; ok {
+		return s.count
+	}
+	return 0
+}
+
+func (c *counterVec) writeTo(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	writeHeader(w, c.name, c.help, "counter")
+	for _, key := range sortedKeys(c.series) {
+		s := c.series[key]
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, s.values, ""), formatFloat(s.count))
+	}
+}
+
+// histogramVec is a histogram partitioned by labels.
+type histogramVec struct {
+	name, help string
+	labels     []string
+	buckets    []float64 // upper bounds, ascending, without +Inf
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	values []string
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+func (r *metricsRegistry) histogram(name, help string, buckets []float64, labels ...string) *histogramVec {
+	h := &histogramVec{name: name, help: help, labels: labels, buckets: buckets, series: map[string]*histogramSeries{}}
+	r.register(h)
+	return h
+}
+
+func (h *histogramVec) Observe(v float64, values ...string) {
+	if len(values) != len(h.labels) {
+		panic(fmt.Sprintf("metric %s: got %d label values, want %d", h.name, len(values), len(h.labels)))
+	}
+	key := seriesKey(values)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{values: append([]string(nil), values...), counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.count++
+	s.sum += v
+}
+
+func (h *histogramVec) writeTo(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	writeHeader(w, h.name, h.help, "histogram")
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			le := `le="` + formatFloat(bound) + `"`
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, s.values, le), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, s.values, `le="+Inf"`), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, s.values, ""), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, s.values, ""), s.count)
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// serverMetrics are the metrics exported on /metrics.
+type serverMetrics struct {
+	registry *metricsRegistry
+
+	requests        *counterVec   // method, route, status
+	requestDuration *histogramVec // method, route
+	rateLimited     *counterVec   // limit
+	filtered        *counterVec   // reason
+	cipherFailures  *counterVec   // op
+	emails          *counterVec   // outcome
+	redisErrors     *counterVec   // command
+}
+
+func newServerMetrics() *serverMetrics {
+	r := &metricsRegistry{}
+	return &serverMetrics{
+		registry: r,
+		requests: r.counter("replied_http_requests_total",
+			"HTTP requests by method, route and status code.", "method", "route", "status"),
+		requestDuration: r.histogram("replied_http_request_duration_seconds",
+			"HTTP request latency by method and route.",
+			[]float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}, "method", "route"),
+		rateLimited: r.counter("replied_rate_limit_rejections_total",
+			"Requests rejected by a rate limit.", "limit"),
+		filtered: r.counter("replied_filter_rejections_total",
+			"Messages rejected by the content filters.", "reason"),
+		cipherFailures: r.counter("replied_cipher_failures_total",
+			"Content encryption and decryption failures.", "op"),
+		emails: r.counter("replied_emails_total",
+			"Notification emails by outcome.", "outcome"),
+		redisErrors: r.counter("replied_redis_errors_total",
+			"Failed Redis commands, excluding cache misses.", "command"),
+	}
+}
+
+// instrument records the count and latency of every request. Routes are
+// labelled by their pattern so IDs in paths don't create new series.
+func (m *serverMetrics) instrument(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+
+	route := routeLabel(c)
+	method := c.Request.Method
+	m.requests.Inc(method, route, strconv.Itoa(c.Writer.Status()))
+	m.requestDuration.Observe(time.Since(start).Seconds(), method, route)
+}
+
+// serveMetrics writes the metrics in the Prometheus text format.
+func (m *serverMetrics) serveMetrics(c *gin.Context) {
+	c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.Status(http.StatusOK)
+	if err := m.registry.Write(c.Writer); err != nil {
+		c.Error(err)
+	}
+}
+
+// redisErrorHook counts failed Redis commands. redis.Nil is a cache miss,
+// not an error.
+type redisErrorHook struct {
+	errors *counterVec
+}
+
+func (h redisErrorHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h redisErrorHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		err := next(ctx, cmd)
+		if err != nil && err != redis.Nil {
+			h.errors.Inc(cmd.Name())
+		}
+		return err
+	}
+}
+
+func (h redisErrorHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		err := next(ctx, cmds)
+		if err == nil || err == redis.Nil {
+			return err
+		}
+		// A connection failure fails the whole pipeline without setting
+		// each command's error
+		failed := 0
+		for _, cmd := range cmds {
+			if cmdErr := cmd.Err(); cmdErr != nil && cmdErr != redis.Nil {
+				h.errors.Inc(cmd.Name())
+				failed++
+			}
+		}
+		if failed == 0 {
+			for _, cmd := range cmds {
+				h.errors.Inc(cmd.Name())
+			}
+		}
+		return err
+	}
+}
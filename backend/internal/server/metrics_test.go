@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestMetricsRegistryFormat(t *testing.T) {
+	r := &metricsRegistry{}
+	hits := r.counter("test_hits_total", "Hits.", "path")
+	latency := r.histogram("test_latency_seconds", "Latency.", []float64{0.1, 1})
+
+	hits.Inc(`/a"b`)
+	hits.Add(2, "/c")
+	latency.Observe(0.05)
+	latency.Observe(0.5)
+	latency.Observe(3)
+
+	var out strings.Builder
+	if err := r.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP test_hits_total Hits.
+# TYPE test_hits_total counter
+test_hits_total{path="/a\"b"} 1
+test_hits_total{path="/c"} 2
+# HELP test_latency_seconds Latency.
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{le="0.1"} 1
+test_latency_seconds_bucket{le="1"} 2
+test_latency_seconds_bucket{le="+Inf"} 3
+test_latency_seconds_sum 3.55
+test_latency_seconds_count 3
+`
+	if out.String() != want {
+		t.Errorf("exposition =\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.addUser("alice", row{"blocked_phrases": []string{"pineapple"}})
+	e.srv.cfg.Send.PerReceiverAnonymous = 100
+
+	e.request("GET", "/profile/alice", "", nil).expect(http.StatusOK)
+	e.request("POST", "/send", "", map[string]string{"receiver_id": alice.ID, "content": "hello"}).expect(http.StatusCreated)
+	e.request("POST", "/send", "", map[string]string{"receiver_id": alice.ID, "content": "badword1"}).expect(http.StatusForbidden)
+	e.request("POST", "/send", "", map[string]string{"receiver_id": alice.ID, "content": "Pineapple pizza?"}).expect(http.StatusForbidden)
+	for i := 0; i < 3; i++ {
+		e.request("POST", "/send", "", map[string]string{"receiver_id": alice.ID, "content": fmt.Sprintf("hello %d", i)})
+	}
+	e.request("GET", "/nowhere", "", nil).expect(http.StatusNotFound)
+	if _, err := e.srv.decrypt("not hex"); err == nil {
+		t.Fatal("decrypt accepted garbage")
+	}
+	// No Resend key in tests, so notifications are skipped
+	e.eventually("email outcome", func() bool { return e.srv.metrics.emails.Value("skipped") == 3 })
+
+	res := e.request("GET", "/metrics", "", nil).expect(http.StatusOK)
+	if ct := res.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	body := res.Body.String()
+	for _, line := range []string{
+		`replied_http_requests_total{method="GET",route="/profile/:username",status="200"} 1`,
+		`replied_http_requests_total{method="POST",route="/send",status="201"} 3`,
+		`replied_http_requests_total{method="POST",route="/send",status="403"} 2`,
+		`replied_http_requests_total{method="POST",route="/send",status="429"} 1`,
+		`replied_http_requests_total{method="GET",route="unmatched",status="404"} 1`,
+		`replied_http_request_duration_seconds_count{method="POST",route="/send"} 6`,
+		`replied_rate_limit_rejections_total{limit="send"} 1`,
+		`replied_filter_rejections_total{reason="profanity"} 1`,
+		`replied_filter_rejections_total{reason="blocked_phrase"} 1`,
+		`replied_cipher_failures_total{op="decrypt"} 1`,
+		`replied_emails_total{outcome="skipped"} 3`,
+		"# TYPE replied_redis_errors_total counter",
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("metrics missing %q", line)
+		}
+	}
+}
+
+func TestRedisErrorHook(t *testing.T) {
+	// A port nothing listens on
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	m := newServerMetrics()
+	client := redis.NewClient(&redis.Options{Addr: addr, MaxRetries: -1, DialTimeout: time.Second})
+	defer client.Close()
+	client.AddHook(redisErrorHook{m.redisErrors})
+
+	if err := client.Ping(context.Background()).Err(); err == nil {
+		t.Fatal("ping succeeded without a server")
+	}
+	client.Pipelined(context.Background(), func(p redis.Pipeliner) error {
+		p.Incr(context.Background(), "a")
+		p.Expire(context.Background(), "a", time.Minute)
+		return nil
+	})
+
+	for _, command := range []string{"ping", "incr", "expire"} {
+		if got := m.redisErrors.Value(command); got != 1 {
+			t.Errorf("redis errors for %s = %v, want 1", command, got)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/supabase-community/gotrue-go/types"
+)
+
+var bannedWords = []string{"badword1", "badword2", "spamlink", "offensive"} // We can expand this
+
+func containsProfanity(text string) bool {
+	lowered := strings.ToLower(text)
+	for _, word := range bannedWords {
+		if strings.Contains(lowered, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// registerModerationRoutes covers reports and the inbox safety controls.
+func (s *Server) registerModerationRoutes(r *gin.Engine, authMiddleware gin.HandlerFunc) {
+	// Report: Flag a message for review
+	r.POST("/report", authMiddleware, handle(func(c *gin.Context) error {
+		var body struct {
+			MessageID string `json:"message_id" binding:"required"`
+		}
+
+		if err := bindJSON(c, &body); err != nil {
+			return err
+		}
+
+		_, _, err := s.db.From("messages").
+			Update(map[string]interface{}{"status": "reported"}, "", "").
+			Eq("id", body.MessageID).
+			Execute()
+
+		if err != nil {
+			return errInternal("Failed to report", err)
+		}
+
+		s.invalidateProfileCacheForMessage(body.MessageID)
+
+		c.JSON(http.StatusOK, gin.H{"status": "reported"})
+		return nil
+	}))
+
+	// Toggle Inbox Pause
+	r.POST("/profile/toggle-pause", authMiddleware, handle(func(c *gin.Context) error {
+		user, _ := c.Get("user")
+		supabaseUser := user.(types.User)
+
+		var body struct {
+			IsPaused bool `json:"is_paused"`
+		}
+		if err := bindJSON(c, &body); err != nil {
+			return err
+		}
+
+		_, _, err := s.db.From("profiles").
+			Update(map[string]interface{}{"is_paused": body.IsPaused}, "", "").
+			Eq("id", supabaseUser.ID.String()).
+			Execute()
+
+		if err != nil {
+			return errInternal("Failed to toggle pause", err)
+		}
+
+		s.invalidateProfileCache(supabaseUser.ID.String())
+
+		c.JSON(http.StatusOK, gin.H{"status": "updated"})
+		return nil
+	}))
+
+	// Update Blocked Phrases
+	r.POST("/profile/blocked-phrases", authMiddleware, handle(func(c *gin.Context) error {
+		user, _ := c.Get("user")
+		supabaseUser := user.(types.User)
+
+		var body struct {
+			Phrases []string `json:"phrases" binding:"max=50,dive,max=100"`
+		}
+		if err := bindJSON(c, &body); err != nil {
+			return err
+		}
+
+		phrases, reason := cleanBlockedPhrases(body.Phrases)
+		if reason != "" {
+			return errValidation(reason)
+		}
+
+		_, _, err := s.db.From("profiles").
+			Update(map[string]interface{}{"blocked_phrases": phrases}, "", "").
+			Eq("id", supabaseUser.ID.String()).
+			Execute()
+
+		if err != nil {
+			return errInternal("Failed to update blocked phrases", err)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"status": "updated"})
+		return nil
+	}))
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestReport(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.addUser("alice", nil)
+	bob := e.addUser("bob", nil)
+	msg := e.addMessage(alice, "hello", "replied", nil)
+
+	e.request("POST", "/report", bob.Token, map[string]string{}).expect(http.StatusBadRequest)
+	e.request("POST", "/report", bob.Token, map[string]string{"message_id": msg["id"].(string)}).expect(http.StatusOK)
+
+	if got := e.db.rows("messages", row{"id": msg["id"]})[0]["status"]; got != "reported" {
+		t.Errorf("status = %v, want reported", got)
+	}
+}
+
+func TestTogglePause(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.addUser("alice", nil)
+
+	e.request("POST", "/profile/toggle-pause", alice.Token, "{").expect(http.StatusBadRequest)
+	e.request("POST", "/profile/toggle-pause", alice.Token, map[string]bool{"is_paused": true}).expect(http.StatusOK)
+
+	if got := e.db.rows("profiles", row{"id": alice.ID})[0]["is_paused"]; got != true {
+		t.Errorf("is_paused = %v, want true", got)
+	}
+}
+
+func TestBlockedPhrases(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.addUser("alice", nil)
+
+	e.request("POST", "/profile/blocked-phrases", alice.Token, map[string][]string{
+		"phrases": {" Spoilers ", "spoilers", "", "politics"},
+	}).expect(http.StatusOK)
+
+	got := e.db.rows("profiles", row{"id": alice.ID})[0]["blocked_phrases"]
+	if want := []interface{}{"Spoilers", "politics"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("blocked_phrases = %v, want %v", got, want)
+	}
+
+	tooMany := make([]string, 51)
+	for i := range tooMany {
+		tooMany[i] = string(rune('a'+i%26)) + string(rune('a'+i/26))
+	}
+	e.request("POST", "/profile/blocked-phrases", alice.Token, map[string][]string{"phrases": tooMany}).
+		expect(http.StatusBadRequest)
+}
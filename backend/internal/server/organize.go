@@ -0,0 +1,206 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/supabase-community/gotrue-go/types"
+	"github.com/supabase-community/postgrest-go"
+)
+
+// The binding tag on the labels body repeats these limits.
+const (
+	maxLabelsPerMessage = 10
+	maxLabelLength      = 30
+	// Messages can be snoozed at most this far ahead
+	maxSnooze = 365 * 24 * time.Hour
+
+	snoozeWorkerInterval = time.Minute
+)
+
+// inboxFilter narrows the pending messages of GET /inbox. The zero value is
+// the default view: everything except messages that are still snoozed.
+type inboxFilter struct {
+	PromptID string // see byPrompt
+	Label    string
+	Starred  bool
+	Unread   bool
+	// Only messages that are still snoozed
+	Snoozed bool
+	// Snoozed messages too, for exports
+	IncludeSnoozed bool
+}
+
+// inboxFilterFrom reads the filter from the query string of GET /inbox.
+func inboxFilterFrom(c *gin.Context) (inboxFilter, error) {
+	filter := inboxFilter{
+		PromptID: c.Query("prompt_id"),
+		Label:    strings.ToLower(strings.TrimSpace(c.Query("label"))),
+	}
+	for name, dst := range map[string]*bool{"starred": &filter.Starred, "unread": &filter.Unread, "snoozed": &filter.Snoozed} {
+		raw := c.Query(name)
+		if raw == "" {
+			continue
+		}
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			return inboxFilter{}, errValidation(name + " must be true or false")
+		}
+		*dst = v
+	}
+	return filter, nil
+}
+
+// apply adds the filter's conditions to a query on messages.
+func (f inboxFilter) apply(q *postgrest.FilterBuilder) *postgrest.FilterBuilder {
+	q = byPrompt(q, f.PromptID)
+	if f.Label != "" {
+		q = q.Contains("labels", []string{f.Label})
+	}
+	if f.Starred {
+		q = q.Is("is_starred", "true")
+	}
+	if f.Unread {
+		q = q.Is("read_at", "null")
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	switch {
+	case f.Snoozed:
+		q = q.Gt("snoozed_until", now)
+	case !f.IncludeSnoozed:
+		// Due snoozes count as back even before the worker clears them
+		q = q.Or(fmt.Sprintf(`snoozed_until.is.null,snoozed_until.lte."%s"`, now), "")
+	}
+	return q
+}
+
+// cleanLabels trims, lowercases and de-duplicates labels, and returns a
+// user-facing reason if the list is not allowed.
+func cleanLabels(labels []string) ([]string, string) {
+	cleaned := make([]string, 0, len(labels))
+	seen := make(map[string]bool, len(labels))
+	for _, l := range labels {
+		l = strings.ToLower(strings.TrimSpace(l))
+		if l == "" || seen[l] {
+			continue
+		}
+		if len([]rune(l)) > maxLabelLength {
+			return nil, fmt.Sprintf("Labels must be at most %d characters", maxLabelLength)
+		}
+		seen[l] = true
+		cleaned = append(cleaned, l)
+	}
+	if len(cleaned) > maxLabelsPerMessage {
+		return nil, fmt.Sprintf("A message can have at most %d labels", maxLabelsPerMessage)
+	}
+	return cleaned, ""
+}
+
+// countUnread counts the pending messages a user hasn't read, leaving out
+// snoozed ones.
+func (s *Server) countUnread(userID string) (int64, error) {
+	q := s.db.From("messages").
+		Select("id", "exact", true).
+		Eq("receiver_id", userID).
+		Eq("status", "pending")
+	_, count, err := inboxFilter{Unread: true}.apply(q).Execute()
+	return count, err
+}
+
+// runDueSnoozes brings back every message whose snooze is over, as unread.
+// It is a single update, so several API instances can run it at once.
+func (s *Server) runDueSnoozes(ctx context.Context) {
+	var woken []struct {
+		ID string `json:"id"`
+	}
+	_, err := s.db.From("messages").
+		Update(map[string]interface{}{"snoozed_until": nil, "read_at": nil}, "representation", "").
+		Lte("snoozed_until", time.Now().UTC().Format(time.RFC3339)).
+		ExecuteTo(&woken)
+	if err != nil {
+		loggerFrom(ctx).Error("Supabase error waking snoozed messages", "error", err)
+		return
+	}
+	if len(woken) > 0 {
+		loggerFrom(ctx).Info("Snoozed messages are back", "count", len(woken))
+	}
+}
+
+// startSnoozeWorker brings snoozed messages back when their time comes.
+func (s *Server) startSnoozeWorker() {
+	s.tasks.Every("snooze", snoozeWorkerInterval, s.runDueSnoozes)
+}
+
+func (s *Server) registerOrganizeRoutes(r *gin.Engine, authMiddleware gin.HandlerFunc) {
+	// Unread pending messages, for badges
+	r.GET("/inbox/unread-count", authMiddleware, handle(func(c *gin.Context) error {
+		user, _ := c.Get("user")
+		supabaseUser := user.(types.User)
+
+		count, err := s.countUnread(supabaseUser.ID.String())
+		if err != nil {
+			return errInternal("Failed to count unread messages", fmt.Errorf("counting unread: %w", err))
+		}
+
+		c.Header("Cache-Control", "private, no-cache")
+		c.JSON(http.StatusOK, gin.H{"unread": count})
+		return nil
+	}))
+
+	// Mark read / unread
+	r.POST("/messages/:id/read", authMiddleware, handle(func(c *gin.Context) error {
+		return s.updateOwnMessage(c, map[string]interface{}{"read_at": time.Now().UTC().Format(time.RFC3339Nano)}, "read")
+	}))
+	r.DELETE("/messages/:id/read", authMiddleware, handle(func(c *gin.Context) error {
+		return s.updateOwnMessage(c, map[string]interface{}{"read_at": nil}, "unread")
+	}))
+
+	// Star / unstar
+	r.POST("/messages/:id/star", authMiddleware, handle(func(c *gin.Context) error {
+		return s.updateOwnMessage(c, map[string]interface{}{"is_starred": true}, "starred")
+	}))
+	r.DELETE("/messages/:id/star", authMiddleware, handle(func(c *gin.Context) error {
+		return s.updateOwnMessage(c, map[string]interface{}{"is_starred": false}, "unstarred")
+	}))
+
+	// Replace a message's labels
+	r.PUT("/messages/:id/labels", authMiddleware, handle(func(c *gin.Context) error {
+		var body struct {
+			Labels []string `json:"labels" binding:"max=10,dive,max=30"`
+		}
+		if err := bindJSON(c, &body); err != nil {
+			return err
+		}
+		labels, reason := cleanLabels(body.Labels)
+		if reason != "" {
+			return errValidation(reason)
+		}
+		return s.updateOwnMessage(c, map[string]interface{}{"labels": labels}, "labeled")
+	}))
+
+	// Snooze a message until a time; it comes back unread
+	r.POST("/messages/:id/snooze", authMiddleware, handle(func(c *gin.Context) error {
+		var body struct {
+			Until string `json:"until" binding:"required,max=64"`
+		}
+		if err := bindJSON(c, &body); err != nil {
+			return err
+		}
+		until, err := time.Parse(time.RFC3339, body.Until)
+		if err != nil {
+			return errValidation("Until must be an RFC 3339 date")
+		}
+		if wait := time.Until(until); wait <= 0 || wait > maxSnooze {
+			return errValidation("Messages can be snoozed for up to a year")
+		}
+		return s.updateOwnMessage(c, map[string]interface{}{"snoozed_until": until.UTC().Format(time.RFC3339)}, "snoozed")
+	}))
+	r.DELETE("/messages/:id/snooze", authMiddleware, handle(func(c *gin.Context) error {
+		return s.updateOwnMessage(c, map[string]interface{}{"snoozed_until": nil}, "unsnoozed")
+	}))
+}
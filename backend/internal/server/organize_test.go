@@ -0,0 +1,120 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestInboxOrganization(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.addUser("alice", nil)
+	bob := e.addUser("bob", nil)
+	first := e.addMessage(alice, "first", "pending", nil)
+	second := e.addMessage(alice, "second", "pending", nil)
+	e.addMessage(alice, "third", "pending", nil)
+	path := func(m row, action string) string { return "/messages/" + m["id"].(string) + "/" + action }
+	inbox := func(query string) []string {
+		var contents []string
+		for _, m := range e.request("GET", "/inbox"+query, alice.Token, nil).expect(http.StatusOK).list() {
+			contents = append(contents, m["content"].(string))
+		}
+		return contents
+	}
+	unread := func() float64 {
+		return e.request("GET", "/inbox/unread-count", alice.Token, nil).expect(http.StatusOK).object()["unread"].(float64)
+	}
+
+	if n := unread(); n != 3 {
+		t.Errorf("unread = %v, want 3", n)
+	}
+	e.request("POST", path(first, "read"), alice.Token, nil).expect(http.StatusOK)
+	if got := inbox("?unread=true"); len(got) != 2 {
+		t.Errorf("unread inbox = %v", got)
+	}
+	if n := unread(); n != 2 {
+		t.Errorf("unread after reading = %v, want 2", n)
+	}
+	e.request("DELETE", path(first, "read"), alice.Token, nil).expect(http.StatusOK)
+	if n := unread(); n != 3 {
+		t.Errorf("unread after marking unread = %v, want 3", n)
+	}
+
+	e.request("POST", path(second, "star"), alice.Token, nil).expect(http.StatusOK)
+	if got := inbox("?starred=true"); len(got) != 1 || got[0] != "second" {
+		t.Errorf("starred inbox = %v", got)
+	}
+
+	e.request("PUT", path(first, "labels"), alice.Token, map[string][]string{"labels": {" Work ", "work", "later"}}).expect(http.StatusOK)
+	if labels := e.db.rows("messages", row{"id": first["id"]})[0]["labels"]; len(labels.([]interface{})) != 2 {
+		t.Errorf("labels = %v, want work and later", labels)
+	}
+	if got := inbox("?label=WORK"); len(got) != 1 || got[0] != "first" {
+		t.Errorf("labeled inbox = %v", got)
+	}
+	e.request("PUT", path(first, "labels"), alice.Token, map[string][]string{"labels": {"this label is far too long to be useful"}}).
+		expect(http.StatusBadRequest)
+
+	e.request("GET", "/inbox?starred=maybe", alice.Token, nil).expect(http.StatusBadRequest)
+	e.request("POST", path(first, "star"), bob.Token, nil).expect(http.StatusNotFound)
+}
+
+func TestSnooze(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.addUser("alice", nil)
+	message := e.addMessage(alice, "later", "pending", nil)
+	e.addMessage(alice, "now", "pending", nil)
+	snooze := "/messages/" + message["id"].(string) + "/snooze"
+
+	e.request("POST", snooze, alice.Token, map[string]string{"until": time.Now().Add(-time.Hour).Format(time.RFC3339)}).
+		expect(http.StatusBadRequest)
+	e.request("POST", snooze, alice.Token, map[string]string{"until": "tomorrow"}).expect(http.StatusBadRequest)
+
+	e.request("POST", "/messages/"+message["id"].(string)+"/read", alice.Token, nil).expect(http.StatusOK)
+	e.request("POST", snooze, alice.Token, map[string]string{"until": time.Now().Add(time.Hour).Format(time.RFC3339)}).
+		expect(http.StatusOK)
+	if got := e.request("GET", "/inbox", alice.Token, nil).expect(http.StatusOK).list(); len(got) != 1 || got[0]["content"] != "now" {
+		t.Errorf("inbox while snoozed = %v", got)
+	}
+	if got := e.request("GET", "/inbox?snoozed=true", alice.Token, nil).expect(http.StatusOK).list(); len(got) != 1 || got[0]["content"] != "later" {
+		t.Errorf("snoozed inbox = %v", got)
+	}
+
+	// The worker leaves future snoozes alone and brings due ones back unread
+	e.srv.runDueSnoozes(context.Background())
+	if got := e.request("GET", "/inbox", alice.Token, nil).expect(http.StatusOK).list(); len(got) != 1 {
+		t.Errorf("inbox after early run = %v", got)
+	}
+	e.db.update("messages", row{"id": message["id"]}, row{"snoozed_until": time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)})
+	e.srv.runDueSnoozes(context.Background())
+	back := e.db.rows("messages", row{"id": message["id"]})[0]
+	if back["snoozed_until"] != nil || back["read_at"] != nil {
+		t.Errorf("woken message = %v, want unsnoozed and unread", back)
+	}
+	if n := e.request("GET", "/inbox/unread-count", alice.Token, nil).expect(http.StatusOK).object()["unread"]; n != float64(2) {
+		t.Errorf("unread = %v, want 2", n)
+	}
+}
+
+func TestOrganizationStaysPrivate(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.addUser("alice", nil)
+	bob := e.addUser("bob", nil)
+	msg := e.addMessage(alice, "favourite band?", "replied", row{"is_starred": true, "labels": []string{"embarrassing"}, "sender_id": bob.ID})
+	e.addReply(msg, alice, "abba")
+	e.request("POST", "/messages/"+msg["id"].(string)+"/like", bob.Token, nil).expect(http.StatusOK)
+	e.request("POST", "/messages/"+msg["id"].(string)+"/bookmark", bob.Token, nil).expect(http.StatusOK)
+
+	for _, path := range []string{"/likes", "/bookmarks"} {
+		got := e.request("GET", path, bob.Token, nil).expect(http.StatusOK).list()
+		if len(got) != 1 {
+			t.Fatalf("%s = %v", path, got)
+		}
+		for _, column := range []string{"read_at", "is_starred", "labels", "snoozed_until", "sender_id"} {
+			if _, ok := got[0][column]; ok {
+				t.Errorf("%s leaks %s", path, column)
+			}
+		}
+	}
+}
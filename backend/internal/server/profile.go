@@ -0,0 +1,380 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/supabase-community/gotrue-go/types"
+)
+
+// The binding tags on profileFields repeat these limits.
+const (
+	maxDisplayNameLength   = 50
+	maxBioLength           = 160
+	maxAvatarURLLength     = 2048
+	maxBlockedPhrases      = 50
+	maxBlockedPhraseLength = 100
+)
+
+// profileFields are the user-editable profile fields. Nil pointers mean
+// "not sent" and are left untouched by PATCH.
+type profileFields struct {
+	Username       *string   `json:"username" binding:"omitempty,max=64"`
+	DisplayName    *string   `json:"display_name" binding:"omitempty,max=50"`
+	Bio            *string   `json:"bio" binding:"omitempty,max=160"`
+	AvatarURL      *string   `json:"avatar_url" binding:"omitempty,max=2048"`
+	Email          *string   `json:"email" binding:"omitempty,max=254"`
+	IsPaused       *bool     `json:"is_paused"`
+	BlockedPhrases *[]string `json:"blocked_phrases" binding:"omitempty,max=50,dive,max=100"`
+	// Who may send messages; see sendPolicy
+	WhoCanSend        *string `json:"who_can_send" binding:"omitempty,oneof=anyone signed_in friends followers"`
+	MinAccountAgeDays *int    `json:"min_account_age_days" binding:"omitempty,min=0,max=365"`
+	// Order of the answers below the pinned ones; see sortProfileMessages
+	ProfileSort *string `json:"profile_sort" binding:"omitempty,oneof=newest most_liked most_bookmarked"`
+}
+
+// validateAvatarURL accepts an empty string (no avatar) or an absolute https URL.
+func validateAvatarURL(raw string) string {
+	if raw == "" {
+		return ""
+	}
+	if len(raw) > maxAvatarURLLength {
+		return "Avatar URL is too long"
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return "Avatar URL must be an https URL"
+	}
+	return ""
+}
+
+// cleanBlockedPhrases trims, drops empty and de-duplicates phrases, and
+// returns a user-facing reason if the list is not allowed.
+func cleanBlockedPhrases(phrases []string) ([]string, string) {
+	cleaned := make([]string, 0, len(phrases))
+	seen := make(map[string]bool, len(phrases))
+	for _, p := range phrases {
+		p = strings.TrimSpace(p)
+		if p == "" || seen[strings.ToLower(p)] {
+			continue
+		}
+		if len([]rune(p)) > maxBlockedPhraseLength {
+			return nil, "Blocked phrases must be at most 100 characters"
+		}
+		seen[strings.ToLower(p)] = true
+		cleaned = append(cleaned, p)
+	}
+	if len(cleaned) > maxBlockedPhrases {
+		return nil, "You can block at most 50 phrases"
+	}
+	return cleaned, ""
+}
+
+// profileUpdateData validates the fields that were sent and converts them
+// into a column map. Username is handled separately by the callers.
+func (s *Server) profileUpdateData(body profileFields) (map[string]interface{}, error) {
+	data := map[string]interface{}{}
+
+	if body.DisplayName != nil {
+		data["display_name"] = strings.TrimSpace(*body.DisplayName)
+	}
+	if body.Bio != nil {
+		data["bio"] = strings.TrimSpace(*body.Bio)
+	}
+	if body.AvatarURL != nil {
+		if reason := validateAvatarURL(*body.AvatarURL); reason != "" {
+			return nil, errValidation(reason)
+		}
+		data["avatar_url"] = *body.AvatarURL
+	}
+	if body.Email != nil {
+		email := strings.TrimSpace(*body.Email)
+		if email == "" {
+			data["email"] = nil
+		} else {
+			if _, err := mail.ParseAddress(email); err != nil {
+				return nil, errValidation("Invalid email address")
+			}
+			encrypted, err := s.encrypt(email)
+			if err != nil {
+				return nil, errInternal("Could not store email address", fmt.Errorf("encrypting email: %w", err))
+			}
+			data["email"] = encrypted
+		}
+	}
+	if body.IsPaused != nil {
+		data["is_paused"] = *body.IsPaused
+	}
+	if body.BlockedPhrases != nil {
+		phrases, reason := cleanBlockedPhrases(*body.BlockedPhrases)
+		if reason != "" {
+			return nil, errValidation(reason)
+		}
+		data["blocked_phrases"] = phrases
+	}
+	if body.WhoCanSend != nil {
+		data["who_can_send"] = *body.WhoCanSend
+	}
+	if body.MinAccountAgeDays != nil {
+		data["min_account_age_days"] = *body.MinAccountAgeDays
+	}
+	if body.ProfileSort != nil {
+		data["profile_sort"] = *body.ProfileSort
+	}
+
+	return data, nil
+}
+
+// fetchOwnProfile loads a full profile row with the email decrypted.
+func (s *Server) fetchOwnProfile(userID string) (map[string]interface{}, error) {
+	var profile map[string]interface{}
+	_, err := s.db.From("profiles").
+		Select("*", "", false).
+		Eq("id", userID).
+		Single().
+		ExecuteTo(&profile)
+	if err != nil {
+		return nil, err
+	}
+
+	// Decrypt email if present
+	if email, ok := profile["email"].(string); ok && email != "" {
+		if dec, err := s.decrypt(email); err == nil {
+			profile["email"] = dec
+		}
+	}
+
+	return profile, nil
+}
+
+func (s *Server) registerProfileRoutes(r *gin.Engine, authMiddleware gin.HandlerFunc) {
+	// Public Profile: Fetch profile and decrypted conversations
+	r.GET("/profile/:username", handle(func(c *gin.Context) error {
+		// Usernames are stored lowercase, so any casing finds the profile
+		// and shares one cache entry
+		username := normalizeUsername(c.Param("username"))
+
+		body, etag, err := s.loadPublicProfile(username)
+		if errors.Is(err, errProfileNotFound) {
+			// Recently renamed profiles answer with a redirect hint
+			newUsername, err := s.renamedUsername(username)
+			if err != nil {
+				logFor(c).Error("Supabase error fetching username history", "error", err)
+			}
+			if newUsername != "" {
+				c.Header("Location", "/profile/"+newUsername)
+				c.JSON(http.StatusTemporaryRedirect, gin.H{"message": "Profile has moved", "redirect_to": newUsername})
+				return nil
+			}
+			return errNotFound("Profile not found")
+		}
+		if err != nil {
+			return errInternal("Failed to fetch messages", fmt.Errorf("fetching profile: %w", err))
+		}
+
+		c.Header("Vary", "Authorization")
+
+		viewerID := s.optionalViewerID(c)
+		if viewerID == "" {
+			c.Header("Cache-Control", "public, no-cache")
+			c.Header("ETag", etag)
+			if etagMatches(c.GetHeader("If-None-Match"), etag) {
+				c.Status(http.StatusNotModified)
+				return nil
+			}
+			c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+			return nil
+		}
+
+		// Layer the viewer's own likes/bookmarks on top of the shared body
+		var payload publicProfilePayload
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return errInternal("Failed to fetch messages", fmt.Errorf("decoding cached profile: %w", err))
+		}
+
+		if err := s.enrichForViewer(viewerID, payload.Messages); err != nil {
+			return errInternal("Failed to fetch viewer state", fmt.Errorf("fetching viewer state: %w", err))
+		}
+
+		viewerBody, err := json.Marshal(payload)
+		if err != nil {
+			return errInternal("Failed to encode profile", err)
+		}
+
+		viewerETag := computeETag(viewerBody)
+		c.Header("Cache-Control", "private, no-cache")
+		c.Header("ETag", viewerETag)
+		if etagMatches(c.GetHeader("If-None-Match"), viewerETag) {
+			c.Status(http.StatusNotModified)
+			return nil
+		}
+		c.Data(http.StatusOK, "application/json; charset=utf-8", viewerBody)
+		return nil
+	}))
+
+	// Get My Profile (Decrypted)
+	r.GET("/profile", authMiddleware, handle(func(c *gin.Context) error {
+		user, _ := c.Get("user")
+		supabaseUser := user.(types.User)
+
+		profile, err := s.fetchOwnProfile(supabaseUser.ID.String())
+		if isNoRows(err) {
+			return errNotFound("Profile not found")
+		}
+		if err != nil {
+			return errInternal("Failed to fetch profile", fmt.Errorf("fetching own profile: %w", err))
+		}
+
+		c.JSON(http.StatusOK, profile)
+		return nil
+	}))
+
+	// Create Profile: explicit setup step, fails if a profile already exists
+	r.POST("/profile", authMiddleware, handle(func(c *gin.Context) error {
+		var body profileFields
+		if err := bindJSON(c, &body); err != nil {
+			return err
+		}
+		if body.Username == nil {
+			return errValidation("Username is required")
+		}
+
+		user, _ := c.Get("user")
+		supabaseUser := user.(types.User)
+		userID := supabaseUser.ID.String()
+
+		if s.profileUsername(userID) != "" {
+			return errConflict("Profile already exists")
+		}
+
+		if body.AvatarURL != nil && !avatarAllowed(*body.AvatarURL, "", supabaseUser) {
+			return errValidation("Upload avatars with POST /profile/avatar")
+		}
+
+		username := normalizeUsername(*body.Username)
+		if err := s.checkUsernameChange(userID, "", username); err != nil {
+			return err
+		}
+
+		insertData, err := s.profileUpdateData(body)
+		if err != nil {
+			return err
+		}
+		insertData["id"] = userID
+		insertData["username"] = username
+
+		_, _, err = s.db.From("profiles").
+			Insert(insertData, false, "", "minimal", "").
+			Execute()
+
+		if isUniqueViolation(err) {
+			return errConflict("This username is already taken")
+		}
+		if err != nil {
+			return errInternal("Failed to create profile", fmt.Errorf("creating profile: %w", err))
+		}
+
+		profile, err := s.fetchOwnProfile(userID)
+		if err != nil {
+			return errInternal("Failed to fetch profile", err)
+		}
+
+		c.JSON(http.StatusCreated, profile)
+		return nil
+	}))
+
+	// Update Profile: only the fields present in the body are changed
+	r.PATCH("/profile", authMiddleware, handle(func(c *gin.Context) error {
+		var body profileFields
+		if err := bindJSON(c, &body); err != nil {
+			return err
+		}
+
+		user, _ := c.Get("user")
+		supabaseUser := user.(types.User)
+		userID := supabaseUser.ID.String()
+
+		// Remember the current username so its cached profile can be dropped
+		// and it can keep redirecting if the username changes.
+		oldUsername := s.profileUsername(userID)
+		if oldUsername == "" {
+			return errNotFound("Profile not found")
+		}
+
+		// Resending the current avatar leaves it alone, even if it predates
+		// the https rule (e.g. a local blob store URL). Switching away from
+		// an uploaded avatar drops its stored variants.
+		var staleAvatarKeys []string
+		if body.AvatarURL != nil {
+			currentURL, keys, err := s.currentAvatar(userID)
+			if err != nil {
+				return errInternal("Failed to update profile", fmt.Errorf("fetching avatar: %w", err))
+			}
+			if *body.AvatarURL == currentURL {
+				body.AvatarURL = nil
+			} else if !avatarAllowed(*body.AvatarURL, currentURL, supabaseUser) {
+				return errValidation("Upload avatars with POST /profile/avatar")
+			} else {
+				staleAvatarKeys = keys
+			}
+		}
+
+		updateData, err := s.profileUpdateData(body)
+		if err != nil {
+			return err
+		}
+		if body.AvatarURL != nil {
+			updateData["avatar_variants"] = nil
+			updateData["avatar_keys"] = nil
+		}
+
+		newUsername := oldUsername
+		if body.Username != nil {
+			newUsername = normalizeUsername(*body.Username)
+		}
+		usernameChanged := newUsername != oldUsername
+		if usernameChanged {
+			if err := s.checkUsernameChange(userID, oldUsername, newUsername); err != nil {
+				return err
+			}
+			updateData["username"] = newUsername
+		}
+
+		if len(updateData) > 0 {
+			updateData["updated_at"] = "now()"
+
+			_, _, err := s.db.From("profiles").
+				Update(updateData, "minimal", "").
+				Eq("id", userID).
+				Execute()
+
+			if isUniqueViolation(err) {
+				return errConflict("This username is already taken")
+			}
+			if err != nil {
+				return errInternal("Failed to update profile", fmt.Errorf("updating profile: %w", err))
+			}
+
+			if usernameChanged {
+				s.recordUsernameChange(userID, oldUsername)
+			}
+			s.deleteAvatarBlobs(staleAvatarKeys)
+			s.invalidateProfileCacheByUsername(oldUsername)
+			s.invalidateProfileCacheByUsername(newUsername)
+		}
+
+		profile, err := s.fetchOwnProfile(userID)
+		if err != nil {
+			return errInternal("Failed to fetch profile", err)
+		}
+
+		c.JSON(http.StatusOK, profile)
+		return nil
+	}))
+}
@@ -0,0 +1,273 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestGetOwnProfile(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.addUser("alice", nil)
+
+	profile := e.request("GET", "/profile", alice.Token, nil).expect(http.StatusOK).object()
+	if profile["username"] != "alice" || profile["email"] != "alice@example.com" {
+		t.Errorf("profile = %v, want alice with decrypted email", profile)
+	}
+
+	// Signed in but not set up yet
+	_, token := e.db.addUser("new@example.com", nil)
+	e.request("GET", "/profile", token, nil).expect(http.StatusNotFound)
+
+	// An outage is not a missing profile
+	e.db.failTable("profiles")
+	e.request("GET", "/profile", alice.Token, nil).expect(http.StatusInternalServerError)
+}
+
+func TestCreateProfile(t *testing.T) {
+	e := newTestEnv(t)
+	e.addUser("taken", nil)
+	user, token := e.db.addUser("new@example.com", map[string]interface{}{"avatar_url": "https://lh3.example.com/me.png"})
+
+	e.request("POST", "/profile", token, map[string]string{"display_name": "New"}).expect(http.StatusBadRequest)
+	e.request("POST", "/profile", token, map[string]string{"username": "Taken"}).expect(http.StatusConflict)
+	e.request("POST", "/profile", token, map[string]string{"username": "x"}).expect(http.StatusBadRequest)
+	e.request("POST", "/profile", token, map[string]string{"username": "newbie", "avatar_url": "https://evil.example.com/a.png"}).
+		expect(http.StatusBadRequest)
+
+	profile := e.request("POST", "/profile", token, map[string]string{
+		"username":     " NewBie ",
+		"display_name": "New Person",
+		"email":        "new@example.com",
+		"avatar_url":   "https://lh3.example.com/me.png",
+	}).expect(http.StatusCreated).object()
+
+	if profile["id"] != user.ID.String() || profile["username"] != "newbie" || profile["email"] != "new@example.com" {
+		t.Errorf("profile = %v", profile)
+	}
+	if stored := e.db.rows("profiles", row{"id": user.ID.String()})[0]; stored["email"] == "new@example.com" {
+		t.Error("email stored in plaintext")
+	}
+
+	e.request("POST", "/profile", token, map[string]string{"username": "another"}).expect(http.StatusConflict)
+}
+
+func TestUpdateProfile(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.addUser("alice", nil)
+
+	e.request("PATCH", "/profile", alice.Token, map[string]string{"bio": string(make([]rune, 161))}).
+		expect(http.StatusBadRequest)
+	e.request("PATCH", "/profile", alice.Token, map[string]string{"email": "not an email"}).
+		expect(http.StatusBadRequest)
+
+	profile := e.request("PATCH", "/profile", alice.Token, map[string]interface{}{
+		"display_name": "Alice",
+		"bio":          "hi",
+		"is_paused":    true,
+	}).expect(http.StatusOK).object()
+	if profile["display_name"] != "Alice" || profile["bio"] != "hi" || profile["is_paused"] != true {
+		t.Errorf("profile = %v", profile)
+	}
+
+	// Renaming keeps the old name reserved and redirecting
+	e.request("PATCH", "/profile", alice.Token, map[string]string{"username": "alice2"}).expect(http.StatusOK)
+	if history := e.db.rows("username_history", row{"profile_id": alice.ID}); len(history) != 1 || history[0]["username"] != "alice" {
+		t.Errorf("username_history = %v", history)
+	}
+	e.request("PATCH", "/profile", alice.Token, map[string]string{"username": "alice3"}).expect(http.StatusOK)
+	e.request("PATCH", "/profile", alice.Token, map[string]string{"username": "alice4"}).expect(http.StatusTooManyRequests)
+
+	_, token := e.db.addUser("ghost@example.com", nil)
+	e.request("PATCH", "/profile", token, map[string]string{"bio": "boo"}).expect(http.StatusNotFound)
+}
+
+func TestPublicProfile(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.addUser("alice", row{"display_name": "Alice"})
+	bob := e.addUser("bob", nil)
+	carol := e.addUser("carol", nil)
+	answered := e.addMessage(alice, "tea or coffee?", "replied", row{"sender_id": carol.ID})
+	e.addReply(answered, alice, "tea")
+	e.addMessage(alice, "still pending", "pending", nil)
+	e.db.insert("likes", row{"message_id": answered["id"], "user_id": bob.ID})
+
+	res := e.request("GET", "/profile/alice", "", nil).expect(http.StatusOK)
+	// Senders stay anonymous even when they had to sign in to send
+	if strings.Contains(res.Body.String(), "sender_id") || strings.Contains(res.Body.String(), carol.ID) {
+		t.Errorf("public profile leaks the sender: %s", res.Body.String())
+	}
+	var payload struct {
+		Profile  map[string]interface{}   `json:"profile"`
+		Messages []map[string]interface{} `json:"messages"`
+	}
+	res.json(&payload)
+	if payload.Profile["username"] != "alice" {
+		t.Errorf("profile = %v", payload.Profile)
+	}
+	if _, ok := payload.Profile["email"]; ok {
+		t.Error("public profile leaks email")
+	}
+	if len(payload.Messages) != 1 || payload.Messages[0]["content"] != "tea or coffee?" {
+		t.Fatalf("messages = %v, want the answered message only", payload.Messages)
+	}
+	if payload.Messages[0]["likes_count"] != float64(1) {
+		t.Errorf("likes_count = %v, want 1", payload.Messages[0]["likes_count"])
+	}
+
+	// Anonymous responses are cached and support conditional requests
+	etag := res.Header().Get("ETag")
+	if etag == "" || len(e.rdb.keys("profilecache:*")) != 1 {
+		t.Fatalf("etag %q, cache keys %v", etag, e.rdb.keys("*"))
+	}
+	e.request("GET", "/profile/alice", "", nil, "If-None-Match", etag).expect(http.StatusNotModified)
+
+	// Signed-in viewers get their own reaction state
+	var viewed struct {
+		Messages []map[string]interface{} `json:"messages"`
+	}
+	e.request("GET", "/profile/alice", bob.Token, nil).expect(http.StatusOK).json(&viewed)
+	if viewed.Messages[0]["is_liked"] != true || viewed.Messages[0]["is_bookmarked"] != false || viewed.Messages[0]["is_sender"] != false {
+		t.Errorf("viewer state = %v", viewed.Messages[0])
+	}
+	// The sender alone learns the message is theirs, to ask a follow-up
+	var own struct {
+		Messages []map[string]interface{} `json:"messages"`
+	}
+	e.request("GET", "/profile/alice", carol.Token, nil).expect(http.StatusOK).json(&own)
+	if own.Messages[0]["is_sender"] != true || own.Messages[0]["sender_id"] != nil {
+		t.Errorf("sender's view = %v", own.Messages[0])
+	}
+
+	// Any casing resolves to the same profile and cache entry
+	e.request("GET", "/profile/Alice", "", nil, "If-None-Match", etag).expect(http.StatusNotModified)
+	if keys := e.rdb.keys("profilecache:*"); len(keys) != 1 {
+		t.Errorf("cache keys = %v, want one per profile", keys)
+	}
+
+	e.request("GET", "/profile/nobody", "", nil).expect(http.StatusNotFound)
+}
+
+func TestPublicProfileRedirectsRenamedUsername(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.addUser("alice", nil)
+	e.request("PATCH", "/profile", alice.Token, map[string]string{"username": "alice_new"}).expect(http.StatusOK)
+
+	res := e.request("GET", "/profile/ALICE", "", nil).expect(http.StatusTemporaryRedirect)
+	if got := res.object()["redirect_to"]; got != "alice_new" {
+		t.Errorf("redirect_to = %v", got)
+	}
+	if got := res.Header().Get("Location"); got != "/profile/alice_new" {
+		t.Errorf("Location = %q", got)
+	}
+}
+
+func TestProfileCacheInvalidatedOnReply(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.addUser("alice", nil)
+	msg := e.addMessage(alice, "question", "pending", nil)
+
+	e.request("GET", "/profile/alice", "", nil).expect(http.StatusOK)
+	if len(e.rdb.keys("profilecache:*")) != 1 {
+		t.Fatal("profile was not cached")
+	}
+	e.request("POST", "/reply", alice.Token, map[string]string{"message_id": msg["id"].(string), "content": "answer"}).
+		expect(http.StatusOK)
+	if keys := e.rdb.keys("profilecache:*"); len(keys) != 0 {
+		t.Errorf("cache keys after reply = %v, want none", keys)
+	}
+}
+
+func TestPinAndHideAnswers(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.addUser("alice", nil)
+	bob := e.addUser("bob", nil)
+	var answers []row
+	for i := 0; i < maxPinnedAnswers+1; i++ {
+		answers = append(answers, e.addMessage(alice, fmt.Sprintf("answer %d", i), "replied", nil))
+	}
+	pending := e.addMessage(alice, "pending", "pending", nil)
+	id := func(m row) string { return m["id"].(string) }
+	profileContents := func() []string {
+		var payload struct {
+			Messages []map[string]interface{} `json:"messages"`
+		}
+		e.request("GET", "/profile/alice", "", nil).expect(http.StatusOK).json(&payload)
+		contents := make([]string, len(payload.Messages))
+		for i, m := range payload.Messages {
+			contents[i] = m["content"].(string)
+		}
+		return contents
+	}
+
+	e.request("POST", "/messages/"+id(answers[0])+"/pin", alice.Token, nil).expect(http.StatusOK)
+	e.request("POST", "/messages/"+id(answers[1])+"/pin", alice.Token, nil).expect(http.StatusOK)
+	if got := profileContents(); got[0] != "answer 1" || got[1] != "answer 0" || got[2] != "answer 3" {
+		t.Errorf("profile order = %v, want the pins first, latest pin on top", got)
+	}
+
+	e.request("POST", "/messages/"+id(answers[2])+"/pin", alice.Token, nil).expect(http.StatusOK)
+	res := e.request("POST", "/messages/"+id(answers[3])+"/pin", alice.Token, nil).expect(http.StatusBadRequest)
+	if msg := res.errorMessage(); msg != "You can pin at most 3 answers" {
+		t.Errorf("error = %q", msg)
+	}
+	e.request("POST", "/messages/"+id(pending)+"/pin", alice.Token, nil).expect(http.StatusBadRequest)
+	e.request("POST", "/messages/"+id(answers[3])+"/pin", bob.Token, nil).expect(http.StatusNotFound)
+
+	// Hiding takes an answer off the profile and frees its pin
+	e.request("POST", "/messages/"+id(answers[0])+"/hide", alice.Token, nil).expect(http.StatusOK)
+	if got := profileContents(); len(got) != 3 || slices.Contains(got, "answer 0") {
+		t.Errorf("profile after hide = %v", got)
+	}
+	e.request("POST", "/messages/"+id(answers[0])+"/pin", alice.Token, nil).expect(http.StatusBadRequest)
+	e.request("POST", "/messages/"+id(answers[3])+"/pin", alice.Token, nil).expect(http.StatusOK)
+
+	e.request("DELETE", "/messages/"+id(answers[0])+"/hide", alice.Token, nil).expect(http.StatusOK)
+	e.request("DELETE", "/messages/"+id(answers[3])+"/pin", alice.Token, nil).expect(http.StatusOK)
+	if got := profileContents(); len(got) != 4 || got[len(got)-1] != "answer 0" {
+		t.Errorf("profile after unhide = %v", got)
+	}
+	e.request("POST", "/messages/"+id(answers[0])+"/hide", bob.Token, nil).expect(http.StatusNotFound)
+
+	// Hidden answers are still in the owner's history
+	if history := e.request("GET", "/history", alice.Token, nil).expect(http.StatusOK).list(); len(history) != 4 {
+		t.Errorf("history has %d messages, want 4", len(history))
+	}
+}
+
+func TestProfileSortOrder(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.addUser("alice", nil)
+	bob := e.addUser("bob", nil)
+	carol := e.addUser("carol", nil)
+	liked := e.addMessage(alice, "liked", "replied", nil)
+	bookmarked := e.addMessage(alice, "bookmarked", "replied", nil)
+	e.addMessage(alice, "newest", "replied", nil)
+	for _, u := range []testUser{bob, carol} {
+		e.db.insert("likes", row{"message_id": liked["id"], "user_id": u.ID})
+	}
+	e.db.insert("bookmarks", row{"message_id": bookmarked["id"], "user_id": bob.ID})
+
+	first := func() string {
+		var payload struct {
+			Profile  map[string]interface{}   `json:"profile"`
+			Messages []map[string]interface{} `json:"messages"`
+		}
+		e.request("GET", "/profile/alice", "", nil).expect(http.StatusOK).json(&payload)
+		return payload.Profile["profile_sort"].(string) + ": " + payload.Messages[0]["content"].(string)
+	}
+
+	for sort, want := range map[string]string{
+		"most_liked":      "most_liked: liked",
+		"most_bookmarked": "most_bookmarked: bookmarked",
+		"newest":          "newest: newest",
+	} {
+		e.request("PATCH", "/profile", alice.Token, map[string]string{"profile_sort": sort}).expect(http.StatusOK)
+		if got := first(); got != want {
+			t.Errorf("first answer = %q, want %q", got, want)
+		}
+	}
+	e.request("PATCH", "/profile", alice.Token, map[string]string{"profile_sort": "random"}).expect(http.StatusBadRequest)
+}
@@ -0,0 +1,431 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/supabase-community/gotrue-go/types"
+	"github.com/supabase-community/postgrest-go"
+)
+
+// The binding tags on promptFields repeat these limits.
+const (
+	maxPromptSlugLength        = 40
+	maxPromptTitleLength       = 100
+	maxPromptDescriptionLength = 500
+	maxPromptsPerProfile       = 50
+)
+
+var promptSlugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// Where a prompt is in its open/close window.
+const (
+	promptScheduled = "scheduled"
+	promptOpen      = "open"
+	promptClosed    = "closed"
+)
+
+// publicPrompt is what anyone may see of a prompt: a themed question box on
+// a profile with its own link at /:username/:slug.
+type publicPrompt struct {
+	ID          string     `json:"id"`
+	Slug        string     `json:"slug"`
+	Title       string     `json:"title"`
+	Description *string    `json:"description"`
+	OpensAt     *time.Time `json:"opens_at"`
+	ClosesAt    *time.Time `json:"closes_at"`
+	// Applies on top of the profile's who_can_send when set, so a prompt
+	// can only narrow who may send
+	WhoCanSend *string `json:"who_can_send"`
+}
+
+// promptRow is a full prompt as its owner sees it.
+type promptRow struct {
+	publicPrompt
+	ProfileID      string   `json:"profile_id"`
+	BlockedPhrases []string `json:"blocked_phrases"`
+}
+
+// status reports whether the prompt takes messages at now.
+func (p publicPrompt) status(now time.Time) string {
+	switch {
+	case p.OpensAt != nil && now.Before(*p.OpensAt):
+		return promptScheduled
+	case p.ClosesAt != nil && !now.Before(*p.ClosesAt):
+		return promptClosed
+	}
+	return promptOpen
+}
+
+// promptGroup lists the answered messages of a profile that were sent to
+// one prompt, by ID, in GET /profile/:username.
+type promptGroup struct {
+	publicPrompt
+	MessageIDs []string `json:"message_ids"`
+}
+
+// promptFields are the editable prompt fields. Nil pointers mean "not sent"
+// and are left untouched by PATCH; an empty description, date or
+// who_can_send clears it.
+type promptFields struct {
+	Slug           *string   `json:"slug" binding:"omitempty,max=40"`
+	Title          *string   `json:"title" binding:"omitempty,max=100"`
+	Description    *string   `json:"description" binding:"omitempty,max=500"`
+	OpensAt        *string   `json:"opens_at" binding:"omitempty,max=64"`
+	ClosesAt       *string   `json:"closes_at" binding:"omitempty,max=64"`
+	BlockedPhrases *[]string `json:"blocked_phrases" binding:"omitempty,max=50,dive,max=100"`
+	WhoCanSend     *string   `json:"who_can_send" binding:"omitempty,oneof=anyone signed_in friends followers"`
+}
+
+// parsePromptTime parses an RFC 3339 open or close date. An empty string
+// clears the date and returns nil.
+func parsePromptTime(raw, field string) (*time.Time, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return nil, errValidation(field + " must be an RFC 3339 date")
+	}
+	t = t.UTC()
+	return &t, nil
+}
+
+// promptUpdateData validates body against the prompt it changes (zero for a
+// new prompt) and returns the columns to write.
+func promptUpdateData(body promptFields, current promptRow) (map[string]interface{}, error) {
+	data := map[string]interface{}{}
+
+	if body.Slug != nil {
+		slug := strings.ToLower(strings.TrimSpace(*body.Slug))
+		if !promptSlugPattern.MatchString(slug) {
+			return nil, errValidation("Prompt links must be lowercase letters, numbers and dashes")
+		}
+		data["slug"] = slug
+	}
+	if body.Title != nil {
+		title := strings.TrimSpace(*body.Title)
+		if title == "" {
+			return nil, errValidation("Title is required")
+		}
+		data["title"] = title
+	}
+	if body.Description != nil {
+		if description := strings.TrimSpace(*body.Description); description != "" {
+			data["description"] = description
+		} else {
+			data["description"] = nil
+		}
+	}
+
+	opensAt, closesAt := current.OpensAt, current.ClosesAt
+	if body.OpensAt != nil {
+		t, err := parsePromptTime(*body.OpensAt, "Opens at")
+		if err != nil {
+			return nil, err
+		}
+		opensAt = t
+		data["opens_at"] = formatPromptTime(t)
+	}
+	if body.ClosesAt != nil {
+		t, err := parsePromptTime(*body.ClosesAt, "Closes at")
+		if err != nil {
+			return nil, err
+		}
+		closesAt = t
+		data["closes_at"] = formatPromptTime(t)
+	}
+	if opensAt != nil && closesAt != nil && !closesAt.After(*opensAt) {
+		return nil, errValidation("A prompt must close after it opens")
+	}
+
+	if body.BlockedPhrases != nil {
+		phrases, reason := cleanBlockedPhrases(*body.BlockedPhrases)
+		if reason != "" {
+			return nil, errValidation(reason)
+		}
+		data["blocked_phrases"] = phrases
+	}
+	if body.WhoCanSend != nil {
+		if *body.WhoCanSend != "" {
+			data["who_can_send"] = *body.WhoCanSend
+		} else {
+			data["who_can_send"] = nil
+		}
+	}
+	return data, nil
+}
+
+func formatPromptTime(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.Format(time.RFC3339)
+}
+
+// fetchPrompt loads one of profileID's prompts, or returns errNotFound.
+func (s *Server) fetchPrompt(profileID, promptID string) (*promptRow, error) {
+	var p promptRow
+	_, err := s.db.From("prompts").
+		Select("*", "", false).
+		Eq("id", promptID).
+		Eq("profile_id", profileID).
+		Single().
+		ExecuteTo(&p)
+	if isNoRows(err) {
+		return nil, errNotFound("Prompt not found")
+	}
+	if err != nil {
+		return nil, errInternal("Failed to fetch prompt", fmt.Errorf("fetching prompt: %w", err))
+	}
+	return &p, nil
+}
+
+// openPrompt loads a prompt a message is being sent to and checks that it
+// is taking messages.
+func (s *Server) openPrompt(receiverID, promptID string) (*promptRow, error) {
+	p, err := s.fetchPrompt(receiverID, promptID)
+	if err != nil {
+		return nil, err
+	}
+	details := gin.H{"opens_at": p.OpensAt, "closes_at": p.ClosesAt}
+	switch p.status(time.Now()) {
+	case promptScheduled:
+		return nil, errForbidden("This prompt isn't open yet").withDetails(details)
+	case promptClosed:
+		return nil, errForbidden("This prompt has closed").withDetails(details)
+	}
+	return p, nil
+}
+
+// fetchPromptGroups returns a profile's prompts that are still to come or
+// open, or that have answered messages, each with the IDs of those
+// messages. messages are the decrypted answered messages of the profile.
+func (s *Server) fetchPromptGroups(profileID string, messages []interface{}) ([]promptGroup, error) {
+	var prompts []publicPrompt
+	_, err := s.db.From("prompts").
+		Select("id, slug, title, description, opens_at, closes_at, who_can_send", "", false).
+		Eq("profile_id", profileID).
+		Order("created_at", &postgrest.OrderOpts{Ascending: false}).
+		ExecuteTo(&prompts)
+	if err != nil {
+		return nil, err
+	}
+
+	answered := make(map[string][]string)
+	for _, m := range messages {
+		msgMap, ok := m.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if promptID := stringField(msgMap, "prompt_id"); promptID != "" {
+			answered[promptID] = append(answered[promptID], stringField(msgMap, "id"))
+		}
+	}
+
+	now := time.Now()
+	groups := make([]promptGroup, 0, len(prompts))
+	for _, p := range prompts {
+		ids := answered[p.ID]
+		if len(ids) == 0 && p.status(now) == promptClosed {
+			continue
+		}
+		if ids == nil {
+			ids = make([]string, 0)
+		}
+		groups = append(groups, promptGroup{publicPrompt: p, MessageIDs: ids})
+	}
+	return groups, nil
+}
+
+// byPrompt narrows a message query to one prompt, or with "none" to the
+// messages sent to the profile's general inbox.
+func byPrompt(q *postgrest.FilterBuilder, promptID string) *postgrest.FilterBuilder {
+	switch promptID {
+	case "":
+		return q
+	case "none":
+		return q.Is("prompt_id", "null")
+	default:
+		return q.Eq("prompt_id", promptID)
+	}
+}
+
+func (s *Server) registerPromptRoutes(r *gin.Engine, authMiddleware gin.HandlerFunc) {
+	// List my prompts
+	r.GET("/prompts", authMiddleware, handle(func(c *gin.Context) error {
+		user, _ := c.Get("user")
+		supabaseUser := user.(types.User)
+
+		var prompts []promptRow
+		_, err := s.db.From("prompts").
+			Select("*", "", false).
+			Eq("profile_id", supabaseUser.ID.String()).
+			Order("created_at", &postgrest.OrderOpts{Ascending: false}).
+			ExecuteTo(&prompts)
+		if err != nil {
+			return errInternal("Failed to fetch prompts", fmt.Errorf("fetching prompts: %w", err))
+		}
+		if prompts == nil {
+			prompts = make([]promptRow, 0)
+		}
+
+		c.JSON(http.StatusOK, prompts)
+		return nil
+	}))
+
+	// Create a prompt
+	r.POST("/prompts", authMiddleware, handle(func(c *gin.Context) error {
+		var body promptFields
+		if err := bindJSON(c, &body); err != nil {
+			return err
+		}
+		if body.Slug == nil || body.Title == nil {
+			return errValidation("Slug and title are required")
+		}
+
+		user, _ := c.Get("user")
+		supabaseUser := user.(types.User)
+		userID := supabaseUser.ID.String()
+
+		data, err := promptUpdateData(body, promptRow{})
+		if err != nil {
+			return err
+		}
+
+		var existing []map[string]interface{}
+		_, err = s.db.From("prompts").
+			Select("id", "", false).
+			Eq("profile_id", userID).
+			ExecuteTo(&existing)
+		if err != nil {
+			return errInternal("Failed to create prompt", fmt.Errorf("counting prompts: %w", err))
+		}
+		if len(existing) >= maxPromptsPerProfile {
+			return errValidation(fmt.Sprintf("You can have at most %d prompts", maxPromptsPerProfile))
+		}
+
+		data["profile_id"] = userID
+		var created []promptRow
+		_, err = s.db.From("prompts").
+			Insert(data, false, "", "", "").
+			ExecuteTo(&created)
+		if isUniqueViolation(err) {
+			return errConflict("You already have a prompt with this link")
+		}
+		if err != nil {
+			return errInternal("Failed to create prompt", fmt.Errorf("creating prompt: %w", err))
+		}
+		if len(created) == 0 {
+			return errInternal("Failed to create prompt", errors.New("creating prompt: no row returned"))
+		}
+
+		s.invalidateProfileCache(userID)
+		c.JSON(http.StatusCreated, created[0])
+		return nil
+	}))
+
+	// Edit, schedule or close a prompt
+	r.PATCH("/prompts/:id", authMiddleware, handle(func(c *gin.Context) error {
+		var body promptFields
+		if err := bindJSON(c, &body); err != nil {
+			return err
+		}
+
+		user, _ := c.Get("user")
+		supabaseUser := user.(types.User)
+		userID := supabaseUser.ID.String()
+
+		current, err := s.fetchPrompt(userID, c.Param("id"))
+		if err != nil {
+			return err
+		}
+		data, err := promptUpdateData(body, *current)
+		if err != nil {
+			return err
+		}
+		data["updated_at"] = "now()"
+
+		var updated []promptRow
+		_, err = s.db.From("prompts").
+			Update(data, "", "").
+			Eq("id", current.ID).
+			Eq("profile_id", userID).
+			ExecuteTo(&updated)
+		if isUniqueViolation(err) {
+			return errConflict("You already have a prompt with this link")
+		}
+		if err != nil {
+			return errInternal("Failed to update prompt", fmt.Errorf("updating prompt: %w", err))
+		}
+		if len(updated) == 0 {
+			return errNotFound("Prompt not found")
+		}
+
+		s.invalidateProfileCache(userID)
+		c.JSON(http.StatusOK, updated[0])
+		return nil
+	}))
+
+	// Delete a prompt (its messages stay in the general inbox)
+	r.DELETE("/prompts/:id", authMiddleware, handle(func(c *gin.Context) error {
+		user, _ := c.Get("user")
+		supabaseUser := user.(types.User)
+		userID := supabaseUser.ID.String()
+
+		_, _, err := s.db.From("prompts").
+			Delete("", "").
+			Eq("id", c.Param("id")).
+			Eq("profile_id", userID).
+			Execute()
+		if err != nil {
+			return errInternal("Failed to delete prompt", fmt.Errorf("deleting prompt: %w", err))
+		}
+
+		s.invalidateProfileCache(userID)
+		c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+		return nil
+	}))
+
+	// Public: a prompt's own page, with whether it is taking messages now
+	r.GET("/profile/:username/prompts/:slug", handle(func(c *gin.Context) error {
+		var profile publicProfile
+		_, err := s.db.From("profiles").
+			Select("*", "", false).
+			Eq("username", normalizeUsername(c.Param("username"))).
+			Single().
+			ExecuteTo(&profile)
+		if isNoRows(err) {
+			return errNotFound("Profile not found")
+		}
+		if err != nil {
+			return errInternal("Failed to fetch prompt", fmt.Errorf("fetching profile: %w", err))
+		}
+
+		var p publicPrompt
+		_, err = s.db.From("prompts").
+			Select("id, slug, title, description, opens_at, closes_at, who_can_send", "", false).
+			Eq("profile_id", profile.ID).
+			Eq("slug", strings.ToLower(c.Param("slug"))).
+			Single().
+			ExecuteTo(&p)
+		if isNoRows(err) {
+			return errNotFound("Prompt not found")
+		}
+		if err != nil {
+			return errInternal("Failed to fetch prompt", fmt.Errorf("fetching prompt: %w", err))
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"profile": profile,
+			"prompt":  p,
+			"status":  p.status(time.Now()),
+		})
+		return nil
+	}))
+}
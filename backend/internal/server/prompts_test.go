@@ -0,0 +1,155 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestPromptCRUD(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.addUser("alice", nil)
+	bob := e.addUser("bob", nil)
+
+	created := e.request("POST", "/prompts", alice.Token, map[string]string{"slug": "AMA-job", "title": " AMA about my job "}).
+		expect(http.StatusCreated).object()
+	if created["slug"] != "ama-job" || created["title"] != "AMA about my job" || created["profile_id"] != alice.ID {
+		t.Fatalf("created = %v", created)
+	}
+	id := created["id"].(string)
+
+	e.request("POST", "/prompts", alice.Token, map[string]string{"slug": "ama-job", "title": "Again"}).expect(http.StatusConflict)
+	e.request("POST", "/prompts", alice.Token, map[string]string{"slug": "no spaces", "title": "Bad"}).expect(http.StatusBadRequest)
+	e.request("POST", "/prompts", alice.Token, map[string]string{"title": "No slug"}).expect(http.StatusBadRequest)
+	e.request("POST", "/prompts", bob.Token, map[string]string{"slug": "ama-job", "title": "Bob's own"}).expect(http.StatusCreated)
+
+	res := e.request("PATCH", "/prompts/"+id, alice.Token, map[string]string{
+		"opens_at":  "2030-01-02T00:00:00Z",
+		"closes_at": "2030-01-01T00:00:00Z",
+	}).expect(http.StatusBadRequest)
+	if msg := res.errorMessage(); msg != "A prompt must close after it opens" {
+		t.Errorf("error = %q", msg)
+	}
+	updated := e.request("PATCH", "/prompts/"+id, alice.Token, map[string]interface{}{
+		"closes_at":       "2030-01-01T00:00:00+02:00",
+		"blocked_phrases": []string{"salary"},
+		"who_can_send":    "signed_in",
+	}).expect(http.StatusOK).object()
+	if updated["closes_at"] != "2029-12-31T22:00:00Z" || updated["who_can_send"] != "signed_in" {
+		t.Errorf("updated = %v", updated)
+	}
+	e.request("PATCH", "/prompts/"+id, bob.Token, map[string]string{"title": "Mine now"}).expect(http.StatusNotFound)
+
+	if prompts := e.request("GET", "/prompts", alice.Token, nil).expect(http.StatusOK).list(); len(prompts) != 1 {
+		t.Errorf("prompts = %v", prompts)
+	}
+	e.request("DELETE", "/prompts/"+id, alice.Token, nil).expect(http.StatusOK)
+	if prompts := e.request("GET", "/prompts", alice.Token, nil).expect(http.StatusOK).list(); len(prompts) != 0 {
+		t.Errorf("prompts after delete = %v", prompts)
+	}
+}
+
+func TestSendToPrompt(t *testing.T) {
+	e := newTestEnv(t)
+	e.srv.cfg.Limits.SendPerWindow = 100
+	e.srv.cfg.Send.PerReceiverAnonymous = 100
+	alice := e.addUser("alice", nil)
+	bob := e.addUser("bob", nil)
+	now := time.Now().UTC()
+	open := e.db.insert("prompts", row{"profile_id": alice.ID, "slug": "ama", "title": "AMA", "blocked_phrases": []string{"salary"}})
+	scheduled := e.db.insert("prompts", row{"profile_id": alice.ID, "slug": "later", "title": "Later", "opens_at": now.Add(time.Hour).Format(time.RFC3339)})
+	closed := e.db.insert("prompts", row{"profile_id": alice.ID, "slug": "done", "title": "Done", "closes_at": now.Add(-time.Hour).Format(time.RFC3339)})
+	members := e.db.insert("prompts", row{"profile_id": alice.ID, "slug": "members", "title": "Members", "who_can_send": "signed_in"})
+	bobs := e.db.insert("prompts", row{"profile_id": bob.ID, "slug": "ama", "title": "Bob's AMA"})
+
+	send := func(token string, prompt row, content string) response {
+		return e.request("POST", "/send", token, map[string]string{"receiver_id": alice.ID, "prompt_id": prompt["id"].(string), "content": content})
+	}
+
+	send("", open, "what do you do all day?").expect(http.StatusCreated)
+	if msg := send("", open, "what is your salary?").expect(http.StatusForbidden).errorMessage(); msg != "Message contains a phrase blocked by the user" {
+		t.Errorf("prompt filter: %q", msg)
+	}
+	if msg := send("", scheduled, "too early").expect(http.StatusForbidden).errorMessage(); msg != "This prompt isn't open yet" {
+		t.Errorf("scheduled: %q", msg)
+	}
+	if msg := send("", closed, "too late").expect(http.StatusForbidden).errorMessage(); msg != "This prompt has closed" {
+		t.Errorf("closed: %q", msg)
+	}
+	send("", bobs, "wrong inbox").expect(http.StatusNotFound)
+	send("", members, "anonymous").expect(http.StatusForbidden)
+	send(bob.Token, members, "signed in").expect(http.StatusCreated)
+
+	// The general inbox stays open to everyone
+	e.request("POST", "/send", "", map[string]string{"receiver_id": alice.ID, "content": "general question"}).expect(http.StatusCreated)
+
+	inbox := func(promptID string) []map[string]interface{} {
+		return e.request("GET", "/inbox?prompt_id="+promptID, alice.Token, nil).expect(http.StatusOK).list()
+	}
+	if got := inbox(open["id"].(string)); len(got) != 1 || got[0]["content"] != "what do you do all day?" {
+		t.Errorf("prompt inbox = %v", got)
+	}
+	if got := inbox("none"); len(got) != 1 || got[0]["content"] != "general question" {
+		t.Errorf("general inbox = %v", got)
+	}
+	if got := inbox(""); len(got) != 3 {
+		t.Errorf("whole inbox has %d messages, want 3", len(got))
+	}
+}
+
+func TestProfileGroupsByPrompt(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.addUser("alice", nil)
+	now := time.Now().UTC()
+	ama := e.db.insert("prompts", row{"profile_id": alice.ID, "slug": "ama", "title": "AMA"})
+	e.db.insert("prompts", row{"profile_id": alice.ID, "slug": "old", "title": "Old", "closes_at": now.Add(-time.Hour).Format(time.RFC3339)})
+	inPrompt := e.addMessage(alice, "in the prompt", "replied", row{"prompt_id": ama["id"]})
+	e.addMessage(alice, "general", "replied", nil)
+	e.addMessage(alice, "unanswered", "pending", row{"prompt_id": ama["id"]})
+
+	var payload struct {
+		Messages []map[string]interface{} `json:"messages"`
+		Prompts  []map[string]interface{} `json:"prompts"`
+	}
+	e.request("GET", "/profile/alice", "", nil).expect(http.StatusOK).json(&payload)
+	if len(payload.Messages) != 2 {
+		t.Errorf("messages = %v", payload.Messages)
+	}
+	// Closed prompts without answers are left out
+	if len(payload.Prompts) != 1 || payload.Prompts[0]["slug"] != "ama" {
+		t.Fatalf("prompts = %v", payload.Prompts)
+	}
+	if ids, _ := payload.Prompts[0]["message_ids"].([]interface{}); len(ids) != 1 || ids[0] != inPrompt["id"] {
+		t.Errorf("message_ids = %v", payload.Prompts[0]["message_ids"])
+	}
+
+	page := e.request("GET", "/profile/alice/prompts/AMA", "", nil).expect(http.StatusOK).object()
+	if page["status"] != "open" || page["prompt"].(map[string]interface{})["title"] != "AMA" {
+		t.Errorf("prompt page = %v", page)
+	}
+	if _, ok := page["prompt"].(map[string]interface{})["blocked_phrases"]; ok {
+		t.Error("prompt page leaks blocked phrases")
+	}
+	if got := e.request("GET", "/profile/alice/prompts/old", "", nil).expect(http.StatusOK).object(); got["status"] != "closed" {
+		t.Errorf("old prompt status = %v", got["status"])
+	}
+	e.request("GET", "/profile/alice/prompts/missing", "", nil).expect(http.StatusNotFound)
+}
+
+func TestPromptCannotWidenSendPolicy(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.addUser("alice", row{"who_can_send": "friends"})
+	bob := e.addUser("bob", nil)
+	carol := e.addUser("carol", nil)
+	e.db.insert("friendships", row{"sender_id": alice.ID, "receiver_id": carol.ID, "status": "accepted"})
+	open := e.db.insert("prompts", row{"profile_id": alice.ID, "slug": "ama", "title": "AMA", "who_can_send": "anyone"})
+	send := func(token string) response {
+		return e.request("POST", "/send", token, map[string]string{"receiver_id": alice.ID, "prompt_id": open["id"].(string), "content": "hi"})
+	}
+
+	send("").expect(http.StatusForbidden)
+	if msg := send(bob.Token).expect(http.StatusForbidden).errorMessage(); msg != "Only friends can message this inbox" {
+		t.Errorf("non-friend: %q", msg)
+	}
+	send(carol.Token).expect(http.StatusCreated)
+}
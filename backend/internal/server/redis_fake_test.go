@@ -0,0 +1,148 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// fakeRedis is an in-memory stand-in for the handful of Redis commands the
+// server uses. Calling any other command panics on the nil embedded
+// interface, which points straight at the missing method.
+type fakeRedis struct {
+	redis.Cmdable
+
+	mu      sync.Mutex
+	data    map[string]string
+	expires map[string]time.Time
+	down    bool // fail Ping, as an unreachable server would
+}
+
+func newFakeRedis() *fakeRedis {
+	return &fakeRedis{data: map[string]string{}, expires: map[string]time.Time{}}
+}
+
+// lookup returns a key's value, dropping it first if it has expired.
+// Callers hold mu.
+func (f *fakeRedis) lookup(key string) (string, bool) {
+	if exp, ok := f.expires[key]; ok && time.Now().After(exp) {
+		delete(f.data, key)
+		delete(f.expires, key)
+	}
+	v, ok := f.data[key]
+	return v, ok
+}
+
+func (f *fakeRedis) Ping(_ context.Context) *redis.StatusCmd {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.down {
+		return redis.NewStatusResult("", errors.New("dial tcp: connection refused"))
+	}
+	return redis.NewStatusResult("PONG", nil)
+}
+
+func (f *fakeRedis) Get(_ context.Context, key string) *redis.StringCmd {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	v, ok := f.lookup(key)
+	if !ok {
+		return redis.NewStringResult("", redis.Nil)
+	}
+	return redis.NewStringResult(v, nil)
+}
+
+func (f *fakeRedis) Set(_ context.Context, key string, value interface{}, ttl time.Duration) *redis.StatusCmd {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch v := value.(type) {
+	case []byte:
+		f.data[key] = string(v)
+	case string:
+		f.data[key] = v
+	default:
+		panic("fakeRedis.Set: unsupported value type")
+	}
+	delete(f.expires, key)
+	if ttl > 0 {
+		f.expires[key] = time.Now().Add(ttl)
+	}
+	return redis.NewStatusResult("OK", nil)
+}
+
+func (f *fakeRedis) SetNX(_ context.Context, key string, value interface{}, ttl time.Duration) *redis.BoolCmd {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.lookup(key); ok {
+		return redis.NewBoolResult(false, nil)
+	}
+	f.data[key] = fmt.Sprint(value)
+	if ttl > 0 {
+		f.expires[key] = time.Now().Add(ttl)
+	}
+	return redis.NewBoolResult(true, nil)
+}
+
+func (f *fakeRedis) Del(_ context.Context, keys ...string) *redis.IntCmd {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var n int64
+	for _, key := range keys {
+		if _, ok := f.lookup(key); ok {
+			delete(f.data, key)
+			delete(f.expires, key)
+			n++
+		}
+	}
+	return redis.NewIntResult(n, nil)
+}
+
+func (f *fakeRedis) Incr(_ context.Context, key string) *redis.IntCmd {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	v, _ := f.lookup(key)
+	n, _ := strconv.ParseInt(v, 10, 64)
+	n++
+	f.data[key] = strconv.FormatInt(n, 10)
+	return redis.NewIntResult(n, nil)
+}
+
+func (f *fakeRedis) Expire(_ context.Context, key string, ttl time.Duration) *redis.BoolCmd {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.lookup(key); !ok {
+		return redis.NewBoolResult(false, nil)
+	}
+	f.expires[key] = time.Now().Add(ttl)
+	return redis.NewBoolResult(true, nil)
+}
+
+// Scan returns every matching key in a single page.
+func (f *fakeRedis) Scan(_ context.Context, _ uint64, match string, _ int64) *redis.ScanCmd {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var keys []string
+	for key := range f.data {
+		if _, ok := f.lookup(key); !ok {
+			continue
+		}
+		if ok, _ := path.Match(match, key); ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return redis.NewScanCmdResult(keys, 0, nil)
+}
+
+// keys lists the live keys matching a glob pattern.
+func (f *fakeRedis) keys(pattern string) []string {
+	keys, _ := f.Scan(context.Background(), 0, pattern, 0).Val()
+	return keys
+}
@@ -0,0 +1,208 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"github.com/supabase-community/gotrue-go/types"
+	"github.com/supabase-community/postgrest-go"
+)
+
+const (
+	minSearchWordLength = 2
+	maxSearchTokens     = 256
+	maxSearchQueryWords = 8
+	inboxSearchLimit    = 50
+	searchBackfillBatch = 200
+	// Legacy messages are indexed in the background, not during searches
+	searchBackfillInterval = 10 * time.Minute
+	searchIndexKeyDomain   = "replied/search-index/v1"
+)
+
+// searchIndexKey derives the HMAC key used for blind index tokens from the
+// encryption key, so the index cannot be reversed without it.
+func (s *Server) searchIndexKey() []byte {
+	return s.cipher.deriveKey(searchIndexKeyDomain)
+}
+
+// normalizeWords lowercases text and splits it into unique words made of
+// letters and digits.
+func normalizeWords(text string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	seen := make(map[string]bool, len(fields))
+	words := make([]string, 0, len(fields))
+	for _, f := range fields {
+		if len([]rune(f)) < minSearchWordLength || seen[f] {
+			continue
+		}
+		seen[f] = true
+		words = append(words, f)
+	}
+	return words
+}
+
+// blindTokens turns the words of each text into keyed HMAC tokens. Only these
+// tokens are stored, never the plaintext words.
+func (s *Server) blindTokens(texts ...string) []string {
+	key := s.searchIndexKey()
+
+	seen := make(map[string]bool)
+	tokens := make([]string, 0)
+	for _, text := range texts {
+		for _, word := range normalizeWords(text) {
+			mac := hmac.New(sha256.New, key)
+			mac.Write([]byte(word))
+			token := hex.EncodeToString(mac.Sum(nil)[:16])
+			if seen[token] {
+				continue
+			}
+			seen[token] = true
+			tokens = append(tokens, token)
+			if len(tokens) >= maxSearchTokens {
+				return tokens
+			}
+		}
+	}
+	return tokens
+}
+
+// backfillSearchTokens indexes one batch of messages that predate the blind
+// index and reports how many it indexed.
+func (s *Server) backfillSearchTokens() (int, error) {
+	var pending []struct {
+		ID      string `json:"id"`
+		Content string `json:"content"`
+		Replies []struct {
+			Content string `json:"content"`
+		} `json:"replies"`
+	}
+	_, err := s.db.From("messages").
+		Select("id, content, replies(content)", "", false).
+		Is("search_tokens", "null").
+		Limit(searchBackfillBatch, "").
+		ExecuteTo(&pending)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, m := range pending {
+		texts := make([]string, 0, 1+len(m.Replies))
+		if dec, err := s.decrypt(m.Content); err == nil {
+			texts = append(texts, dec)
+		}
+		for _, r := range m.Replies {
+			if dec, err := s.decrypt(r.Content); err == nil {
+				texts = append(texts, dec)
+			}
+		}
+
+		// Undecryptable messages get an empty index so they aren't retried
+		tokens := s.blindTokens(texts...)
+
+		_, _, err := s.db.From("messages").
+			Update(map[string]interface{}{"search_tokens": tokens}, "minimal", "").
+			Eq("id", m.ID).
+			Execute()
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(pending), nil
+}
+
+// runSearchBackfill indexes legacy messages batch by batch until none are
+// left or ctx is cancelled. New messages are indexed when they are written,
+// so once the backlog is gone each run is a single empty query.
+func (s *Server) runSearchBackfill(ctx context.Context) {
+	total := 0
+	for ctx.Err() == nil {
+		n, err := s.backfillSearchTokens()
+		total += n
+		if err != nil {
+			loggerFrom(ctx).Error("Search index backfill failed", "error", err)
+			break
+		}
+		if n < searchBackfillBatch {
+			break
+		}
+	}
+	if total > 0 {
+		loggerFrom(ctx).Info("Search index backfilled", "count", total)
+	}
+}
+
+// startSearchBackfillWorker indexes messages stored before the blind index.
+func (s *Server) startSearchBackfillWorker() {
+	s.tasks.Every("search backfill", searchBackfillInterval, s.runSearchBackfill)
+}
+
+func (s *Server) registerSearchRoutes(r *gin.Engine, authMiddleware gin.HandlerFunc) {
+	// Search my own inbox and history. Matches whole words only: every word
+	// of the query must appear in the message or its reply. Messages the
+	// backfill worker hasn't indexed yet don't match.
+	r.GET("/inbox/search", authMiddleware, handle(func(c *gin.Context) error {
+		user, _ := c.Get("user")
+		supabaseUser := user.(types.User)
+
+		words := normalizeWords(c.Query("q"))
+		if len(words) == 0 {
+			c.JSON(http.StatusOK, []interface{}{})
+			return nil
+		}
+		if len(words) > maxSearchQueryWords {
+			words = words[:maxSearchQueryWords]
+		}
+
+		statuses := []string{"pending", "replied", "archived"}
+		if status := c.Query("status"); status != "" {
+			valid := false
+			for _, st := range statuses {
+				if st == status {
+					valid = true
+				}
+			}
+			if !valid {
+				return errValidation("Invalid status")
+			}
+			statuses = []string{status}
+		}
+
+		tokens := s.blindTokens(strings.Join(words, " "))
+
+		var messages []interface{}
+		q := s.db.From("messages").
+			Select("*, replies(*)", "", false).
+			Eq("receiver_id", supabaseUser.ID.String()).
+			In("status", statuses).
+			Contains("search_tokens", tokens)
+		_, err := byPrompt(q, c.Query("prompt_id")).
+			Order("created_at", &postgrest.OrderOpts{Ascending: false}).
+			Limit(inboxSearchLimit, "").
+			ExecuteTo(&messages)
+
+		if err != nil {
+			return errInternal("Search failed", fmt.Errorf("searching inbox: %w", err))
+		}
+
+		for i, m := range messages {
+			messages[i] = s.decryptMessageMap(m)
+		}
+		if messages == nil {
+			messages = make([]interface{}, 0)
+		}
+
+		c.JSON(http.StatusOK, messages)
+		return nil
+	}))
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestInboxSearch(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.addUser("alice", nil)
+	bob := e.addUser("bob", nil)
+
+	// Stored before the index existed, so only found once backfilled
+	old := e.addMessage(alice, "Favourite pizza topping?", "replied", nil)
+	e.addReply(old, alice, "Mushrooms, obviously")
+	e.addMessage(alice, "Pizza or pasta tonight?", "pending", nil)
+	e.addMessage(bob, "pizza party at bob's", "pending", nil)
+
+	e.request("POST", "/send", "", map[string]string{"receiver_id": alice.ID, "content": "Where do you get your pizza?"}).
+		expect(http.StatusCreated)
+
+	if results := e.request("GET", "/inbox/search?q=a", alice.Token, nil).expect(http.StatusOK).list(); len(results) != 0 {
+		t.Errorf("short query matched %v", results)
+	}
+	e.request("GET", "/inbox/search?q=pizza&status=deleted", alice.Token, nil).expect(http.StatusBadRequest)
+
+	if results := e.request("GET", "/inbox/search?q=pizza", alice.Token, nil).expect(http.StatusOK).list(); len(results) != 1 {
+		t.Errorf("before backfill pizza matched %d messages, want the new one", len(results))
+	}
+	if len(e.db.rows("messages", row{"search_tokens": nil})) != 3 {
+		t.Error("search wrote to the index")
+	}
+
+	e.srv.runSearchBackfill(context.Background())
+	if left := e.db.rows("messages", row{"search_tokens": nil}); len(left) != 0 {
+		t.Errorf("unindexed after backfill: %v", left)
+	}
+	if results := e.request("GET", "/inbox/search?q=PIZZA", alice.Token, nil).expect(http.StatusOK).list(); len(results) != 3 {
+		t.Fatalf("pizza matched %d messages, want alice's 3", len(results))
+	}
+
+	// Every word must match, in the message or its reply
+	results := e.request("GET", "/inbox/search?q=pizza+mushrooms", alice.Token, nil).expect(http.StatusOK).list()
+	if len(results) != 1 || results[0]["id"] != old["id"] || results[0]["content"] != "Favourite pizza topping?" {
+		t.Fatalf("pizza mushrooms = %v", results)
+	}
+
+	pending := e.request("GET", "/inbox/search?q=pizza&status=pending", alice.Token, nil).expect(http.StatusOK).list()
+	if len(pending) != 2 {
+		t.Errorf("pending matches = %d, want 2", len(pending))
+	}
+	// Substrings are not words
+	if results := e.request("GET", "/inbox/search?q=pizz", alice.Token, nil).expect(http.StatusOK).list(); len(results) != 0 {
+		t.Errorf("partial word matched %v", results)
+	}
+}
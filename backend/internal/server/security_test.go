@@ -0,0 +1,81 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	e := newTestEnv(t)
+
+	res := e.request("GET", "/healthz", "", nil).expect(http.StatusOK)
+	for header, want := range map[string]string{
+		"X-Content-Type-Options": "nosniff",
+		"Referrer-Policy":        "no-referrer",
+		"X-Frame-Options":        "DENY",
+	} {
+		if got := res.Header().Get(header); got != want {
+			t.Errorf("%s = %q, want %q", header, got, want)
+		}
+	}
+	// The test server runs on plain http
+	if got := res.Header().Get("Strict-Transport-Security"); got != "" {
+		t.Errorf("HSTS sent over http: %q", got)
+	}
+}
+
+func TestBodyLimits(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.addUser("alice", nil)
+
+	res := e.request("POST", "/send", "", map[string]string{"receiver_id": alice.ID, "content": strings.Repeat("é", maxMessageLength+1)}).
+		expect(http.StatusBadRequest)
+	if msg := res.errorMessage(); msg != "Content must be at most 1000 characters" {
+		t.Errorf("error = %q", msg)
+	}
+	e.request("POST", "/send", "", map[string]string{"receiver_id": alice.ID, "content": strings.Repeat("é", maxMessageLength)}).
+		expect(http.StatusCreated)
+
+	phrases := make([]string, maxBlockedPhrases+1)
+	for i := range phrases {
+		phrases[i] = "phrase"
+	}
+	res = e.request("POST", "/profile/blocked-phrases", alice.Token, map[string]interface{}{"phrases": phrases}).
+		expect(http.StatusBadRequest)
+	if msg := res.errorMessage(); msg != "Phrases must be at most 50 items" {
+		t.Errorf("error = %q", msg)
+	}
+	e.request("POST", "/profile/blocked-phrases", alice.Token, map[string]interface{}{"phrases": []string{strings.Repeat("x", maxBlockedPhraseLength+1)}}).
+		expect(http.StatusBadRequest)
+
+	huge := `{"receiver_id": "` + alice.ID + `", "content": "` + strings.Repeat("x", maxJSONBodyBytes) + `"}`
+	e.request("POST", "/send", "", huge).expect(http.StatusRequestEntityTooLarge)
+
+	// Without a Content-Length the body is cut off while it is read
+	req := httptest.NewRequest("POST", "/send", io.MultiReader(strings.NewReader(huge)))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	e.client.ServeHTTP(rec, req)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("unsized body: status = %d, want 413", rec.Code)
+	}
+}
+
+func TestJSONContentType(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.addUser("alice", nil)
+	body := []byte(`{"receiver_id": "` + alice.ID + `", "content": "hi"}`)
+
+	res := e.request("POST", "/send", "", body, "Content-Type", "text/plain").expect(http.StatusUnsupportedMediaType)
+	if code := res.apiError().Code; code != codeUnsupportedMedia {
+		t.Errorf("code = %q", code)
+	}
+	e.request("POST", "/send", "", body, "Content-Type", "application/json; charset=utf-8").expect(http.StatusCreated)
+
+	// Bodiless POSTs don't need a content type
+	message := e.addMessage(alice, "question", "pending", nil)
+	e.request("POST", "/messages/"+message["id"].(string)+"/archive", alice.Token, nil).expect(http.StatusOK)
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	senderFingerprintKeyDomain = "replied/sender-fingerprint/v1"
+	contentHashKeyDomain       = "replied/content-hash/v1"
+)
+
+// overLimit counts a hit against key and reports whether it went past
+// limit within window. The window starts with the first hit. Redis errors
+// are logged and let the request through.
+func (s *Server) overLimit(ctx context.Context, key string, limit int, window time.Duration) bool {
+	count, err := s.rdb.Incr(ctx, key).Result()
+	if err != nil {
+		loggerFrom(ctx).Error("Redis error", "error", err)
+		return false
+	}
+	if count == 1 {
+		s.rdb.Expire(ctx, key, window)
+	}
+	return count > int64(limit)
+}
+
+// senderFingerprint tells anonymous senders apart for the per-receiver caps
+// without storing their IP.
+func (s *Server) senderFingerprint(c *gin.Context) string {
+	mac := hmac.New(sha256.New, s.cipher.deriveKey(senderFingerprintKeyDomain))
+	mac.Write([]byte(c.ClientIP() + "\x00" + c.Request.UserAgent()))
+	return hex.EncodeToString(mac.Sum(nil)[:12])
+}
+
+// checkSendLimits applies the per-sender limits to a message for
+// receiverID: the per-IP and per-account send rates across all inboxes, and
+// the cap on what one sender may send one receiver. senderID is empty for
+// anonymous senders.
+func (s *Server) checkSendLimits(c *gin.Context, receiverID, senderID string) error {
+	if s.rdb == nil {
+		return nil
+	}
+	ctx := c.Request.Context()
+	limit := s.cfg.Limits.SendPerWindow
+	window := s.cfg.Limits.SendWindow.Duration
+
+	if s.overLimit(ctx, "ratelimit:send:"+c.ClientIP(), limit, window) {
+		s.metrics.rateLimited.Inc("send")
+		return errRateLimited(fmt.Sprintf("Too many messages. Please wait %s.", formatWindow(window)))
+	}
+	if senderID != "" && s.overLimit(ctx, "ratelimit:send:account:"+senderID, limit, window) {
+		s.metrics.rateLimited.Inc("send_account")
+		return errRateLimited(fmt.Sprintf("Too many messages. Please wait %s.", formatWindow(window)))
+	}
+
+	send := s.cfg.Send
+	capKey, capLimit := "inboxcap:"+receiverID+":fp:"+s.senderFingerprint(c), send.PerReceiverAnonymous
+	if senderID != "" {
+		capKey, capLimit = "inboxcap:"+receiverID+":account:"+senderID, send.PerReceiverAccount
+	}
+	if s.overLimit(ctx, capKey, capLimit, send.ReceiverWindow.Duration) {
+		s.metrics.rateLimited.Inc("send_receiver")
+		return errRateLimited(fmt.Sprintf("You've sent this inbox enough for now. Please wait %s.", formatWindow(send.ReceiverWindow.Duration)))
+	}
+	return nil
+}
+
+// normalizeContent reduces a message to what matters for spotting copy-paste
+// spam: lowercase letters and digits, single spaces between words and no
+// repeated letters, so "Hi!!  THERE" and "hiii there" compare equal.
+// Repeated digits are kept, since "1" and "11" are different messages.
+func normalizeContent(content string) string {
+	var b strings.Builder
+	var last rune
+	space := false
+	for _, r := range strings.ToLower(content) {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			space = b.Len() > 0
+			continue
+		}
+		if space {
+			b.WriteByte(' ')
+			space = false
+			last = ' '
+		}
+		if r != last || unicode.IsDigit(r) {
+			b.WriteRune(r)
+			last = r
+		}
+	}
+	return b.String()
+}
+
+// minDuplicateLength is the shortest normalized message checked for
+// duplicates. Greetings like "hi" and emoji-only messages (which normalize to
+// nothing) are too common to count as copy-paste spam.
+const minDuplicateLength = 8
+
+// duplicateKey is where a message from one sender to receiverID is
+// remembered for duplicate detection, or "" when the message is too short to
+// check. senderID is empty for anonymous senders, who are told apart by
+// fingerprint as for the per-receiver caps. The content is hashed with a
+// keyed MAC, so Redis never holds anything derived from it that could be
+// reversed.
+func (s *Server) duplicateKey(c *gin.Context, receiverID, senderID, content string) string {
+	normalized := normalizeContent(content)
+	if utf8.RuneCountInString(normalized) < minDuplicateLength {
+		return ""
+	}
+	sender := "fp:" + s.senderFingerprint(c)
+	if senderID != "" {
+		sender = "account:" + senderID
+	}
+	mac := hmac.New(sha256.New, s.cipher.deriveKey(contentHashKeyDomain))
+	mac.Write([]byte(receiverID + "\x00" + normalized))
+	return "dupe:" + receiverID + ":" + sender + ":" + hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// isDuplicate reports whether the sender recently sent receiverID the same
+// message.
+func (s *Server) isDuplicate(c *gin.Context, receiverID, senderID, content string) bool {
+	if s.rdb == nil || s.cfg.Send.DuplicateWindow.Duration <= 0 {
+		return false
+	}
+	key := s.duplicateKey(c, receiverID, senderID, content)
+	if key == "" {
+		return false
+	}
+	ctx := c.Request.Context()
+	_, err := s.rdb.Get(ctx, key).Result()
+	if err != nil && err != redis.Nil {
+		loggerFrom(ctx).Error("Redis error", "error", err)
+	}
+	return err == nil
+}
+
+// rememberContent records a delivered message for duplicate detection.
+func (s *Server) rememberContent(c *gin.Context, receiverID, senderID, content string) {
+	if s.rdb == nil || s.cfg.Send.DuplicateWindow.Duration <= 0 {
+		return
+	}
+	key := s.duplicateKey(c, receiverID, senderID, content)
+	if key == "" {
+		return
+	}
+	if err := s.rdb.Set(c.Request.Context(), key, "1", s.cfg.Send.DuplicateWindow.Duration).Err(); err != nil {
+		logFor(c).Error("Failed to remember message for duplicate detection", "error", err)
+	}
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestNormalizeContent(t *testing.T) {
+	for in, want := range map[string]string{
+		"Hi!!  THERE":      "hi there",
+		"hiii there":       "hi there",
+		"  are you ok? 🙂 ": "are you ok",
+		"100%":             "100",
+		"...":              "",
+	} {
+		if got := normalizeContent(in); got != want {
+			t.Errorf("normalizeContent(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestSendPerReceiverCaps(t *testing.T) {
+	e := newTestEnv(t)
+	e.srv.cfg.Limits.SendPerWindow = 100
+	alice := e.addUser("alice", nil)
+	carol := e.addUser("carol", nil)
+	bob := e.addUser("bob", nil)
+
+	send := func(token, receiverID string, i int, headers ...string) response {
+		return e.request("POST", "/send", token, map[string]string{"receiver_id": receiverID, "content": fmt.Sprintf("message %d", i)}, headers...)
+	}
+
+	// Anonymous: three per receiver from one browser
+	for i := 0; i < 3; i++ {
+		send("", alice.ID, i).expect(http.StatusCreated)
+	}
+	send("", alice.ID, 3).expect(http.StatusTooManyRequests)
+	send("", carol.ID, 3).expect(http.StatusCreated)
+	send("", alice.ID, 4, "User-Agent", "another browser").expect(http.StatusCreated)
+
+	// Accounts are counted on their own, whatever the IP
+	e.srv.cfg.Send.PerReceiverAccount = 2
+	send(bob.Token, alice.ID, 10, "X-Forwarded-For", "203.0.113.1").expect(http.StatusCreated)
+	send(bob.Token, alice.ID, 11, "X-Forwarded-For", "203.0.113.2").expect(http.StatusCreated)
+	res := send(bob.Token, alice.ID, 12, "X-Forwarded-For", "203.0.113.3").expect(http.StatusTooManyRequests)
+	if msg := res.errorMessage(); msg != "You've sent this inbox enough for now. Please wait 1 hour." {
+		t.Errorf("error = %q", msg)
+	}
+	if got := e.srv.metrics.rateLimited.Value("send_receiver"); got != 2 {
+		t.Errorf("send_receiver rejections = %v, want 2", got)
+	}
+}
+
+func TestSendAccountRateLimit(t *testing.T) {
+	e := newTestEnv(t)
+	e.srv.cfg.Limits.SendPerWindow = 2
+	bob := e.addUser("bob", nil)
+	receivers := []testUser{e.addUser("alice", nil), e.addUser("carol", nil), e.addUser("dave", nil)}
+
+	// Rotating IPs doesn't reset a signed-in sender's limit
+	for i, receiver := range receivers {
+		want := http.StatusCreated
+		if i == 2 {
+			want = http.StatusTooManyRequests
+		}
+		e.request("POST", "/send", bob.Token, map[string]string{"receiver_id": receiver.ID, "content": "hello"},
+			"X-Forwarded-For", fmt.Sprintf("203.0.113.%d", i)).expect(want)
+	}
+}
+
+func TestSendRejectsDuplicates(t *testing.T) {
+	e := newTestEnv(t)
+	e.srv.cfg.Limits.SendPerWindow = 100
+	e.srv.cfg.Send.PerReceiverAnonymous = 100
+	alice := e.addUser("alice", nil)
+	bob := e.addUser("bob", nil)
+	carol := e.addUser("carol", nil)
+	send := func(token, receiverID, content string, headers ...string) response {
+		return e.request("POST", "/send", token, map[string]string{"receiver_id": receiverID, "content": content}, headers...)
+	}
+
+	send("", alice.ID, "Follow me on example.com!!").expect(http.StatusCreated)
+	res := send("", alice.ID, "follow me on EXAMPLE.COM").expect(http.StatusConflict)
+	if code := res.apiError().Code; code != codeConflict {
+		t.Errorf("code = %q", code)
+	}
+	// Other inboxes may get the same text
+	send("", carol.ID, "Follow me on example.com!!").expect(http.StatusCreated)
+
+	// Other senders may ask the same question
+	send("", alice.ID, "follow me on example.com", "User-Agent", "another browser").expect(http.StatusCreated)
+	send(bob.Token, alice.ID, "What's your favourite movie?").expect(http.StatusCreated)
+	send(carol.Token, alice.ID, "what's your favourite movie?").expect(http.StatusCreated)
+	send(bob.Token, alice.ID, "WHAT'S YOUR FAVOURITE MOVIE").expect(http.StatusConflict)
+
+	// Short and emoji-only messages are never duplicates
+	for _, content := range []string{"hi", "hi", "🙂🙂", "🔥", "🔥", "?!"} {
+		send("", alice.ID, content).expect(http.StatusCreated)
+	}
+}
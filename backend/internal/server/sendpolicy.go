@@ -0,0 +1,113 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/supabase-community/gotrue-go/types"
+)
+
+// Who may send to an inbox, as stored in profiles.who_can_send.
+const (
+	sendFromAnyone    = "anyone"
+	sendFromSignedIn  = "signed_in"
+	sendFromFriends   = "friends"
+	sendFromFollowers = "followers"
+)
+
+// The binding tag on profileFields.MinAccountAgeDays repeats this limit.
+const maxMinAccountAgeDays = 365
+
+// sendPolicy is a receiver's requirements for senders. Messages from
+// signed-in senders are still shown as anonymous; the account is only used
+// to check these.
+type sendPolicy struct {
+	WhoCanSend        string `json:"who_can_send"`
+	MinAccountAgeDays int    `json:"min_account_age_days"`
+}
+
+// requiresAccount reports whether anonymous senders are turned away.
+func (p sendPolicy) requiresAccount() bool {
+	return (p.WhoCanSend != "" && p.WhoCanSend != sendFromAnyone) || p.MinAccountAgeDays > 0
+}
+
+// refusal is the error for a sender who doesn't meet the policy. The details
+// carry the policy so clients can explain what is needed.
+func (p sendPolicy) refusal(message string) error {
+	return errForbidden(message).withDetails(gin.H{
+		"who_can_send":         p.WhoCanSend,
+		"min_account_age_days": p.MinAccountAgeDays,
+	})
+}
+
+// checkSendPolicy reports whether viewer (nil when anonymous) may send to
+// receiverID under policy.
+func (s *Server) checkSendPolicy(policy sendPolicy, receiverID string, viewer *types.User) error {
+	if !policy.requiresAccount() {
+		return nil
+	}
+	if viewer == nil {
+		return policy.refusal("Sign in to send messages to this inbox")
+	}
+	senderID := viewer.ID.String()
+
+	if days := policy.MinAccountAgeDays; days > 0 && time.Since(viewer.CreatedAt) < time.Duration(days)*24*time.Hour {
+		age := fmt.Sprintf("%d days", days)
+		if days == 1 {
+			age = "1 day"
+		}
+		return policy.refusal("Your account must be at least " + age + " old to message this inbox")
+	}
+
+	switch policy.WhoCanSend {
+	case sendFromFriends:
+		friends, err := s.areFriends(receiverID, senderID)
+		if err != nil {
+			return errInternal("Could not verify receiver status", fmt.Errorf("checking friendship: %w", err))
+		}
+		if !friends {
+			return policy.refusal("Only friends can message this inbox")
+		}
+	case sendFromFollowers:
+		following, err := s.isFollowing(senderID, receiverID)
+		if err != nil {
+			return errInternal("Could not verify receiver status", fmt.Errorf("checking follow: %w", err))
+		}
+		if !following {
+			return policy.refusal("Only followers can message this inbox")
+		}
+	}
+	return nil
+}
+
+// areFriends reports whether two users have an accepted friendship in
+// either direction.
+func (s *Server) areFriends(a, b string) (bool, error) {
+	var rows []map[string]interface{}
+	_, err := s.db.From("friendships").
+		Select("id", "", false).
+		Eq("status", "accepted").
+		Or(fmt.Sprintf("and(sender_id.eq.%s,receiver_id.eq.%s),and(sender_id.eq.%s,receiver_id.eq.%s)", a, b, b, a), "").
+		ExecuteTo(&rows)
+	if err != nil {
+		return false, err
+	}
+	return len(rows) > 0, nil
+}
+
+// isFollowing reports whether followerID follows profileID, with the same
+// meaning as is_following: a friend request sent (pending or accepted) or
+// one accepted from that profile.
+func (s *Server) isFollowing(followerID, profileID string) (bool, error) {
+	var rows []map[string]interface{}
+	_, err := s.db.From("friendships").
+		Select("id", "", false).
+		Or(fmt.Sprintf("and(sender_id.eq.%s,receiver_id.eq.%s),and(sender_id.eq.%s,receiver_id.eq.%s,status.eq.accepted)",
+			followerID, profileID, profileID, followerID), "").
+		ExecuteTo(&rows)
+	if err != nil {
+		return false, err
+	}
+	return len(rows) > 0, nil
+}
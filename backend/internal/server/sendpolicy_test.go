@@ -0,0 +1,84 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSendPolicy(t *testing.T) {
+	e := newTestEnv(t)
+	e.srv.cfg.Limits.SendPerWindow = 100
+	e.srv.cfg.Send.PerReceiverAccount = 100
+	alice := e.addUser("alice", nil)
+	bob := e.addUser("bob", nil)
+	carol := e.addUser("carol", nil)
+	send := func(token, content string) response {
+		return e.request("POST", "/send", token, map[string]string{"receiver_id": alice.ID, "content": content})
+	}
+
+	// Bob follows alice; carol and alice are friends
+	e.db.insert("friendships", row{"sender_id": bob.ID, "receiver_id": alice.ID})
+	e.db.insert("friendships", row{"sender_id": alice.ID, "receiver_id": carol.ID, "status": "accepted"})
+
+	e.request("PATCH", "/profile", alice.Token, map[string]string{"who_can_send": "signed_in"}).expect(http.StatusOK)
+	res := send("", "anonymous").expect(http.StatusForbidden)
+	if details, _ := res.apiError().Details.(map[string]interface{}); details["who_can_send"] != "signed_in" {
+		t.Errorf("details = %v", res.apiError().Details)
+	}
+	send(bob.Token, "signed in").expect(http.StatusCreated)
+
+	e.request("PATCH", "/profile", alice.Token, map[string]string{"who_can_send": "followers"}).expect(http.StatusOK)
+	send(bob.Token, "follower").expect(http.StatusCreated)
+	send(carol.Token, "friend, and so a follower").expect(http.StatusCreated)
+
+	e.request("PATCH", "/profile", alice.Token, map[string]string{"who_can_send": "friends"}).expect(http.StatusOK)
+	if msg := send(bob.Token, "pending request").expect(http.StatusForbidden).errorMessage(); msg != "Only friends can message this inbox" {
+		t.Errorf("error = %q", msg)
+	}
+	send(carol.Token, "friend").expect(http.StatusCreated)
+
+	// Messages stay anonymous to the receiver
+	for _, m := range e.db.rows("messages", row{"receiver_id": alice.ID}) {
+		if m["sender_id"] == nil {
+			t.Errorf("message %v has no sender for follow-ups", m["id"])
+		}
+	}
+
+	e.request("PATCH", "/profile", alice.Token, map[string]string{"who_can_send": "everyone"}).expect(http.StatusBadRequest)
+}
+
+func TestSendMinimumAccountAge(t *testing.T) {
+	e := newTestEnv(t)
+	e.srv.cfg.Send.PerReceiverAccount = 100
+	alice := e.addUser("alice", row{"min_account_age_days": float64(7)})
+	bob := e.addUser("bob", nil)
+	send := func(token, content string) response {
+		return e.request("POST", "/send", token, map[string]string{"receiver_id": alice.ID, "content": content})
+	}
+
+	send("", "anonymous").expect(http.StatusForbidden)
+	if msg := send(bob.Token, "too new").expect(http.StatusForbidden).errorMessage(); msg != "Your account must be at least 7 days old to message this inbox" {
+		t.Errorf("error = %q", msg)
+	}
+	e.db.backdateUser(bob.ID, 8*24*time.Hour)
+	send(bob.Token, "old enough").expect(http.StatusCreated)
+
+	res := e.request("PATCH", "/profile", alice.Token, map[string]int{"min_account_age_days": 400}).expect(http.StatusBadRequest)
+	if msg := res.errorMessage(); msg != "Min account age days must be at most 365" {
+		t.Errorf("error = %q", msg)
+	}
+}
+
+func TestPublicProfileShowsSendPolicy(t *testing.T) {
+	e := newTestEnv(t)
+	e.addUser("alice", row{"who_can_send": "followers", "min_account_age_days": float64(30)})
+
+	var payload struct {
+		Profile map[string]interface{} `json:"profile"`
+	}
+	e.request("GET", "/profile/alice", "", nil).expect(http.StatusOK).json(&payload)
+	if payload.Profile["who_can_send"] != "followers" || payload.Profile["min_account_age_days"] != float64(30) {
+		t.Errorf("profile = %v", payload.Profile)
+	}
+}
@@ -0,0 +1,257 @@
+// Package server implements the Replied HTTP API.
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pranav/replied-backend/internal/config"
+	"github.com/redis/go-redis/v9"
+	"github.com/supabase-community/supabase-go"
+)
+
+// Server holds the API's dependencies and routes. Build one with New.
+type Server struct {
+	cfg    *config.Config
+	db     *supabase.Client
+	rdb    redis.Cmdable // nil disables rate limiting and caching
+	cipher *contentCipher
+	// metrics is served on /metrics
+	metrics *serverMetrics
+
+	// ctx lives as long as the server and is cancelled once shutdown gives
+	// up waiting; work outside a request uses it instead of Background.
+	ctx   context.Context
+	stop  context.CancelFunc
+	tasks *taskPool
+
+	// outbound is shared by the clients for Resend, GoTrue and Storage
+	outbound  *http.Client
+	mailer    *mailer
+	authAdmin *authAdminClient
+
+	blobs BlobStore
+	// exportBlobs stores export archives. Unlike avatars they are never
+	// public and are only served through signed download links.
+	exportBlobs BlobStore
+	// verifier checks anonymous senders on /send; nil when it is off
+	verifier HumanVerifier
+
+	router *gin.Engine
+}
+
+// New connects the dependencies described by a validated config and
+// registers every route.
+func New(cfg *config.Config) (*Server, error) {
+	s := &Server{
+		cfg:      cfg,
+		outbound: &http.Client{Timeout: 30 * time.Second},
+		metrics:  newServerMetrics(),
+	}
+	s.ctx, s.stop = context.WithCancel(context.Background())
+	s.tasks = newTaskPool(s.ctx, cfg.Limits.BackgroundWorkers)
+	s.mailer = newMailer(cfg.Email, cfg.FrontendURL, s.outbound, s.metrics.emails)
+	s.authAdmin = newAuthAdmin(cfg.Supabase, s.outbound)
+
+	var err error
+	s.cipher, err = newContentCipher(cfg.Key())
+	if err != nil {
+		return nil, err
+	}
+
+	s.db, err = supabase.NewClient(cfg.Supabase.URL, cfg.Supabase.ServiceRoleKey, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Redis backs rate limiting and the response caches
+	if cfg.RedisURL != "" {
+		opt, err := redis.ParseURL(cfg.RedisURL)
+		if err != nil {
+			return nil, err
+		}
+		client := redis.NewClient(opt)
+		client.AddHook(redisErrorHook{s.metrics.redisErrors})
+		s.rdb = client
+		slog.Info("Connected to Redis for rate limiting")
+	} else {
+		slog.Warn("UPSTASH_REDIS_URL not set, rate limiting and caching are disabled")
+	}
+
+	if cfg.Send.Verification == "pow" {
+		s.verifier = &proofOfWork{
+			key:  s.cipher.deriveKey(sendChallengeKeyDomain),
+			rdb:  s.rdb,
+			base: cfg.Send.BaseDifficulty,
+			max:  cfg.Send.MaxDifficulty,
+			ttl:  cfg.Send.ChallengeTTL.Duration,
+		}
+	}
+
+	s.router = gin.New()
+	cors := newCORSPolicy(cfg.CORS, cfg.FrontendURL)
+	hsts := strings.HasPrefix(cfg.PublicURL, "https://")
+	s.router.Use(requestID, accessLog, s.metrics.instrument, recovery, securityHeaders(hsts), cors.handle, limitBody)
+
+	// Blob storage for uploaded avatars and data exports
+	switch cfg.Storage.Backend {
+	case "supabase":
+		s.blobs = newSupabaseBlobStore(cfg.Supabase.URL, cfg.Supabase.ServiceRoleKey, cfg.Storage.AvatarBucket, s.outbound)
+		// Must be a private bucket
+		s.exportBlobs = newSupabaseBlobStore(cfg.Supabase.URL, cfg.Supabase.ServiceRoleKey, cfg.Storage.ExportBucket, s.outbound)
+	case "local":
+		local, err := newLocalBlobStore(cfg.Storage.LocalDir, cfg.PublicURL)
+		if err != nil {
+			return nil, err
+		}
+		s.blobs = local
+		// Only avatars are served; exports/ stays private
+		s.exportBlobs = local
+		s.router.Static("/media/avatars", filepath.Join(cfg.Storage.LocalDir, "avatars"))
+	}
+
+	s.routes()
+	return s, nil
+}
+
+// Handler returns the HTTP handler serving the API.
+func (s *Server) Handler() http.Handler {
+	return s.router
+}
+
+// StartWorkers starts the background jobs: account deletion, exports,
+// imports, snoozed messages and the search index backfill.
+func (s *Server) StartWorkers() {
+	s.startDeletionWorker()
+	s.startExportWorker()
+	s.startImportWorker()
+	s.startSnoozeWorker()
+	s.startSearchBackfillWorker()
+}
+
+// Run listens on the configured port and serves until ctx is cancelled, then
+// shuts down gracefully.
+func (s *Server) Run(ctx context.Context) error {
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", s.cfg.Port))
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, ln)
+}
+
+// Serve accepts connections on ln until ctx is cancelled. It then stops
+// accepting, lets in-flight requests and background tasks finish within
+// the shutdown timeout, and closes the server's clients.
+func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
+	httpServer := &http.Server{
+		Handler:      s.Handler(),
+		ReadTimeout:  s.cfg.HTTP.ReadTimeout.Duration,
+		WriteTimeout: s.cfg.HTTP.WriteTimeout.Duration,
+		IdleTimeout:  s.cfg.HTTP.IdleTimeout.Duration,
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- httpServer.Serve(ln)
+	}()
+
+	select {
+	case err := <-serveErr:
+		// The listener failed; still stop the background work
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), s.cfg.HTTP.ShutdownTimeout.Duration)
+		defer cancel()
+		s.Shutdown(shutdownCtx)
+		return err
+	case <-ctx.Done():
+	}
+
+	slog.Info("Shutting down: draining requests and background tasks")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.cfg.HTTP.ShutdownTimeout.Duration)
+	defer cancel()
+
+	var errs []error
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		errs = append(errs, fmt.Errorf("draining requests: %w", err))
+	}
+	if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+		errs = append(errs, err)
+	}
+	if err := s.Shutdown(shutdownCtx); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// Shutdown stops the periodic workers and waits for background tasks until
+// ctx expires, then cancels whatever is still running and closes Redis and
+// the outbound HTTP clients. Call it once no more requests are coming in.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.tasks.Close()
+	err := s.tasks.Wait(ctx)
+	if err != nil {
+		err = fmt.Errorf("waiting for background tasks: %w", err)
+	}
+	s.stop()
+
+	if closer, ok := s.rdb.(io.Closer); ok {
+		if cerr := closer.Close(); cerr != nil {
+			loggerFrom(ctx).Error("Redis close error", "error", cerr)
+		}
+	}
+	s.outbound.CloseIdleConnections()
+	return err
+}
+
+func (s *Server) routes() {
+	r := s.router
+
+	s.registerHealthRoutes(r)
+	s.registerInboxRoutes(r, s.authMiddleware)
+	s.registerPromptRoutes(r, s.authMiddleware)
+	s.registerOrganizeRoutes(r, s.authMiddleware)
+	s.registerChallengeRoutes(r)
+	s.registerModerationRoutes(r, s.authMiddleware)
+	s.registerSocialRoutes(r, s.authMiddleware)
+	s.registerCollectionRoutes(r, s.authMiddleware)
+	s.registerSearchRoutes(r, s.authMiddleware)
+	s.registerUserRoutes(r, s.authMiddleware)
+	s.registerUsernameRoutes(r)
+	s.registerProfileRoutes(r, s.authMiddleware)
+	s.registerAvatarRoutes(r, s.authMiddleware)
+	s.registerDeletionRoutes(r, s.authMiddleware)
+	s.registerExportRoutes(r, s.authMiddleware)
+	s.registerImportRoutes(r, s.authMiddleware)
+}
+
+// authMiddleware checks the Supabase JWT and stores the user under "user".
+func (s *Server) authMiddleware(c *gin.Context) {
+	if c.GetHeader("Authorization") == "" {
+		writeError(c, errUnauthorized("Authorization header required"))
+		return
+	}
+	token, ok := bearerToken(c)
+	if !ok {
+		writeError(c, errUnauthorized("Authorization header must be a Bearer token"))
+		return
+	}
+
+	// Map token to user using Gotrue (Supabase Auth)
+	user, err := s.db.Auth.WithToken(token).GetUser()
+	if err != nil {
+		logFor(c).Debug("Rejected token", "error", err)
+		writeError(c, errUnauthorized("Invalid token"))
+		return
+	}
+
+	c.Set("user", user.User)
+	c.Next()
+}
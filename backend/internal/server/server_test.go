@@ -0,0 +1,312 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pranav/replied-backend/internal/config"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	gin.DefaultWriter = io.Discard
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	os.Exit(m.Run())
+}
+
+// testEnv is a Server wired to in-memory fakes.
+type testEnv struct {
+	t      *testing.T
+	srv    *Server
+	db     *fakeSupabase
+	rdb    *fakeRedis
+	blobs  string // local blob directory
+	client http.Handler
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+	db := newFakeSupabase(t)
+
+	cfg := &config.Config{
+		Port:          8080,
+		PublicURL:     "http://api.test",
+		FrontendURL:   "http://app.test",
+		EncryptionKey: strings.Repeat("ab", 32),
+		Supabase:      config.SupabaseConfig{URL: db.URL, ServiceRoleKey: "service-key"},
+		Email:         config.EmailConfig{From: "Replied <noreply@example.com>"},
+		Log:           config.LogConfig{Level: "info", Redact: true},
+		CORS: config.CORSConfig{
+			MaxAge:         config.Duration{Duration: 10 * time.Minute},
+			AllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE"},
+			AllowedHeaders: []string{"Authorization", "Content-Type", requestIDHeader},
+			Groups:         map[string]config.CORSRule{"/media/": {Methods: []string{"GET", "HEAD"}}},
+		},
+		Send: config.SendConfig{
+			Verification:         "off",
+			PerReceiverAccount:   10,
+			PerReceiverAnonymous: 3,
+			ReceiverWindow:       config.Duration{Duration: time.Hour},
+			DuplicateWindow:      config.Duration{Duration: 24 * time.Hour},
+		},
+		Storage: config.StorageConfig{Backend: "local", LocalDir: t.TempDir()},
+		HTTP: config.HTTPConfig{
+			ReadTimeout:     config.Duration{Duration: 5 * time.Second},
+			WriteTimeout:    config.Duration{Duration: 5 * time.Second},
+			IdleTimeout:     config.Duration{Duration: 5 * time.Second},
+			ShutdownTimeout: config.Duration{Duration: 5 * time.Second},
+		},
+		Limits: config.LimitsConfig{
+			SendPerWindow:        5,
+			SendWindow:           config.Duration{Duration: 10 * time.Minute},
+			AccountDeletionGrace: config.Duration{Duration: 7 * 24 * time.Hour},
+			BackgroundWorkers:    4,
+		},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("invalid test config: %v", err)
+	}
+
+	srv, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	rdb := newFakeRedis()
+	srv.rdb = rdb
+	if pow, ok := srv.verifier.(*proofOfWork); ok {
+		pow.rdb = rdb
+	}
+	t.Cleanup(func() {
+		// Let background tasks finish before the fakes go away
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			t.Errorf("Shutdown: %v", err)
+		}
+	})
+
+	return &testEnv{t: t, srv: srv, db: db, rdb: rdb, blobs: cfg.Storage.LocalDir, client: srv.Handler()}
+}
+
+// testUser is an auth user with a profile row.
+type testUser struct {
+	ID       string
+	Username string
+	Token    string
+}
+
+// addUser creates an auth user and their profile.
+func (e *testEnv) addUser(username string, profile row) testUser {
+	e.t.Helper()
+	user, token := e.db.addUser(username+"@example.com", map[string]interface{}{})
+	data := row{"id": user.ID.String(), "username": username, "email": e.encrypt(username + "@example.com")}
+	for k, v := range profile {
+		data[k] = v
+	}
+	e.db.insert("profiles", data)
+	return testUser{ID: user.ID.String(), Username: username, Token: token}
+}
+
+func (e *testEnv) encrypt(text string) string {
+	e.t.Helper()
+	enc, err := e.srv.encrypt(text)
+	if err != nil {
+		e.t.Fatalf("encrypt: %v", err)
+	}
+	return enc
+}
+
+// addMessage stores an encrypted message to receiver.
+func (e *testEnv) addMessage(receiver testUser, content, status string, extra row) row {
+	data := row{"receiver_id": receiver.ID, "content": e.encrypt(content), "status": status}
+	for k, v := range extra {
+		data[k] = v
+	}
+	return e.db.insert("messages", data)
+}
+
+// addReply answers a message.
+func (e *testEnv) addReply(message row, sender testUser, content string) row {
+	return e.db.insert("replies", row{"message_id": message["id"], "sender_id": sender.ID, "content": e.encrypt(content)})
+}
+
+// eventually polls cond until it holds, for work done by background jobs.
+func (e *testEnv) eventually(what string, cond func() bool) {
+	e.t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			e.t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+type response struct {
+	*httptest.ResponseRecorder
+	t *testing.T
+}
+
+// request sends a request through the handler. body may be nil, a string,
+// []byte or a value encoded as JSON.
+func (e *testEnv) request(method, path, token string, body interface{}, headers ...string) response {
+	e.t.Helper()
+	var reader io.Reader
+	contentType := ""
+	switch b := body.(type) {
+	case nil:
+	case string:
+		reader = strings.NewReader(b)
+		contentType = "application/json"
+	case []byte:
+		reader = bytes.NewReader(b)
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			e.t.Fatalf("encode body: %v", err)
+		}
+		reader = bytes.NewReader(data)
+		contentType = "application/json"
+	}
+	req := httptest.NewRequest(method, path, reader)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	rec := httptest.NewRecorder()
+	e.client.ServeHTTP(rec, req)
+	return response{rec, e.t}
+}
+
+func (r response) expect(status int) response {
+	r.t.Helper()
+	if r.Code != status {
+		r.t.Fatalf("status = %d, want %d; body: %s", r.Code, status, r.Body.String())
+	}
+	return r
+}
+
+// json decodes the body into v.
+func (r response) json(v interface{}) {
+	r.t.Helper()
+	if err := json.Unmarshal(r.Body.Bytes(), v); err != nil {
+		r.t.Fatalf("decode %q: %v", r.Body.String(), err)
+	}
+}
+
+func (r response) object() map[string]interface{} {
+	r.t.Helper()
+	var out map[string]interface{}
+	r.json(&out)
+	return out
+}
+
+func (r response) list() []map[string]interface{} {
+	r.t.Helper()
+	var out []map[string]interface{}
+	r.json(&out)
+	return out
+}
+
+// apiError decodes a response in the standard error shape.
+func (r response) apiError() errorBody {
+	r.t.Helper()
+	var out errorBody
+	r.json(&out)
+	return out
+}
+
+func (r response) errorMessage() string {
+	r.t.Helper()
+	return r.apiError().Message
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	e := newTestEnv(t)
+
+	if msg := e.request("GET", "/inbox", "", nil).expect(http.StatusUnauthorized).errorMessage(); msg != "Authorization header required" {
+		t.Errorf("error = %q", msg)
+	}
+	e.request("GET", "/inbox", "not-a-token", nil).expect(http.StatusUnauthorized)
+	// A header without the Bearer scheme is rejected, not sliced
+	if msg := e.request("GET", "/inbox", "", nil, "Authorization", "abc").expect(http.StatusUnauthorized).errorMessage(); msg != "Authorization header must be a Bearer token" {
+		t.Errorf("error = %q", msg)
+	}
+
+	alice := e.addUser("alice", nil)
+	e.request("GET", "/inbox", alice.Token, nil).expect(http.StatusOK)
+}
+
+func TestServeDrainsOnShutdown(t *testing.T) {
+	e := newTestEnv(t)
+
+	started := make(chan struct{})
+	e.srv.router.GET("/test/slow", func(c *gin.Context) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		c.String(http.StatusOK, "done")
+	})
+	taskDone := make(chan struct{})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- e.srv.Serve(ctx, ln) }()
+
+	type result struct {
+		body string
+		err  error
+	}
+	got := make(chan result, 1)
+	go func() {
+		res, err := http.Get("http://" + ln.Addr().String() + "/test/slow")
+		if err != nil {
+			got <- result{err: err}
+			return
+		}
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		got <- result{string(body), err}
+	}()
+
+	<-started
+	e.srv.tasks.Go("slow task", func(context.Context) {
+		time.Sleep(100 * time.Millisecond)
+		close(taskDone)
+	})
+	cancel()
+
+	if err := <-served; err != nil {
+		t.Fatalf("Serve: %v", err)
+	}
+	if r := <-got; r.err != nil || r.body != "done" {
+		t.Errorf("in-flight request = %q, %v; want it to finish", r.body, r.err)
+	}
+	select {
+	case <-taskDone:
+	default:
+		t.Error("Serve returned before the background task finished")
+	}
+	if _, err := http.Get("http://" + ln.Addr().String() + "/test/slow"); err == nil {
+		t.Error("server still accepting connections after shutdown")
+	}
+}
@@ -0,0 +1,553 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"github.com/supabase-community/gotrue-go/types"
+	"github.com/supabase-community/postgrest-go"
+)
+
+// publicMessageColumns are the message columns shown to anyone other than
+// the receiver. Read state, stars, labels and snoozes are the receiver's
+// own, and the sender stays anonymous.
+const publicMessageColumns = "id, receiver_id, prompt_id, content, status, is_hidden, created_at, thread_id"
+
+// fetchLikedMessages returns the messages a user liked that are still
+// visible to them, decrypted.
+func (s *Server) fetchLikedMessages(userID string) ([]interface{}, error) {
+	var likedData []struct {
+		MessageID string      `json:"message_id"`
+		Message   interface{} `json:"message"`
+	}
+
+	_, err := s.db.From("likes").
+		Select("message_id, message:messages("+publicMessageColumns+", profiles:receiver_id(username, avatar_url), replies(content, created_at))", "exact", false).
+		Eq("user_id", userID).
+		Order("created_at", &postgrest.OrderOpts{Ascending: false}).
+		ExecuteTo(&likedData)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]interface{}, 0)
+	for _, l := range likedData {
+		if msg := s.decryptMessageMap(l.Message); visibleAnswer(msg, userID) {
+			messages = append(messages, msg)
+		}
+	}
+	return messages, nil
+}
+
+// fetchFriends returns the other side of each accepted friendship.
+func (s *Server) fetchFriends(userID string) ([]map[string]interface{}, error) {
+	var friendships []map[string]interface{}
+	_, err := s.db.From("friendships").
+		Select("*, sender:profiles!sender_id(id, username, display_name, avatar_url), receiver:profiles!receiver_id(id, username, display_name, avatar_url)", "", false).
+		Eq("status", "accepted").
+		Or(fmt.Sprintf("sender_id.eq.%s,receiver_id.eq.%s", userID, userID), "").
+		ExecuteTo(&friendships)
+	if err != nil {
+		return nil, err
+	}
+
+	friends := make([]map[string]interface{}, 0)
+	for _, f := range friendships {
+		sender, _ := f["sender"].(map[string]interface{})
+		receiver, _ := f["receiver"].(map[string]interface{})
+
+		if stringField(f, "sender_id") != userID && sender != nil {
+			sender["friendship_id"] = f["id"]
+			friends = append(friends, sender)
+		} else if receiver != nil {
+			receiver["friendship_id"] = f["id"]
+			friends = append(friends, receiver)
+		}
+	}
+	return friends, nil
+}
+
+// registerSocialRoutes covers likes, bookmarks and friendships.
+func (s *Server) registerSocialRoutes(r *gin.Engine, authMiddleware gin.HandlerFunc) {
+	// Like Message
+	r.POST("/messages/:id/like", authMiddleware, handle(func(c *gin.Context) error {
+		messageID := c.Param("id")
+		user, _ := c.Get("user")
+		supabaseUser := user.(types.User)
+
+		// Check if already liked
+		var existing []map[string]interface{}
+		s.db.From("likes").
+			Select("*", "exact", false).
+			Eq("message_id", messageID).
+			Eq("user_id", supabaseUser.ID.String()).
+			ExecuteTo(&existing)
+
+		if len(existing) > 0 {
+			return errConflict("Already liked")
+		}
+
+		_, _, err := s.db.From("likes").
+			Insert(map[string]interface{}{
+				"message_id": messageID,
+				"user_id":    supabaseUser.ID.String(),
+			}, false, "", "", "").
+			Execute()
+
+		if err != nil {
+			return errInternal("Failed to like", err)
+		}
+		s.invalidateProfileCacheForMessage(messageID)
+		s.bumpViewerState(supabaseUser.ID.String())
+		c.JSON(http.StatusOK, gin.H{"status": "liked"})
+		return nil
+	}))
+
+	// Unlike Message
+	r.DELETE("/messages/:id/like", authMiddleware, handle(func(c *gin.Context) error {
+		messageID := c.Param("id")
+		user, _ := c.Get("user")
+		supabaseUser := user.(types.User)
+
+		_, _, err := s.db.From("likes").
+			Delete("", "").
+			Eq("message_id", messageID).
+			Eq("user_id", supabaseUser.ID.String()).
+			Execute()
+
+		if err != nil {
+			return errInternal("Failed to unlike", err)
+		}
+		s.invalidateProfileCacheForMessage(messageID)
+		s.bumpViewerState(supabaseUser.ID.String())
+		c.JSON(http.StatusOK, gin.H{"status": "unliked"})
+		return nil
+	}))
+
+	// Bookmark Message
+	r.POST("/messages/:id/bookmark", authMiddleware, handle(func(c *gin.Context) error {
+		messageID := c.Param("id")
+		user, _ := c.Get("user")
+		supabaseUser := user.(types.User)
+
+		// Optional: file into a collection and attach a private note
+		var body struct {
+			CollectionID string `json:"collection_id"`
+			Note         string `json:"note" binding:"max=500"`
+		}
+		if c.Request.ContentLength != 0 {
+			if err := bindJSON(c, &body); err != nil {
+				return err
+			}
+		}
+
+		// Check if already bookmarked
+		var existing []map[string]interface{}
+		s.db.From("bookmarks").
+			Select("*", "exact", false).
+			Eq("message_id", messageID).
+			Eq("user_id", supabaseUser.ID.String()).
+			ExecuteTo(&existing)
+
+		if len(existing) > 0 {
+			return errConflict("Already bookmarked")
+		}
+
+		bookmarkData := map[string]interface{}{
+			"message_id": messageID,
+			"user_id":    supabaseUser.ID.String(),
+		}
+
+		if body.CollectionID != "" {
+			owned, err := s.ownsCollection(body.CollectionID, supabaseUser.ID.String())
+			if err != nil {
+				return errInternal("Failed to bookmark", err)
+			}
+			if !owned {
+				return errNotFound("Collection not found")
+			}
+			bookmarkData["collection_id"] = body.CollectionID
+		}
+
+		if body.Note != "" {
+			encryptedNote, err := s.encrypt(body.Note)
+			if err != nil {
+				return errInternal("Encryption failed", err)
+			}
+			bookmarkData["note"] = encryptedNote
+		}
+
+		_, _, err := s.db.From("bookmarks").
+			Insert(bookmarkData, false, "", "", "").
+			Execute()
+
+		if err != nil {
+			return errInternal("Failed to bookmark", err)
+		}
+		s.invalidateProfileCacheForMessage(messageID)
+		s.bumpViewerState(supabaseUser.ID.String())
+		c.JSON(http.StatusOK, gin.H{"status": "bookmarked"})
+		return nil
+	}))
+
+	// Update Bookmark: move between collections or edit the private note.
+	// An empty collection_id unfiles the bookmark, an empty note clears it.
+	r.PATCH("/messages/:id/bookmark", authMiddleware, handle(func(c *gin.Context) error {
+		messageID := c.Param("id")
+		user, _ := c.Get("user")
+		supabaseUser := user.(types.User)
+
+		var body struct {
+			CollectionID *string `json:"collection_id"`
+			Note         *string `json:"note" binding:"omitempty,max=500"`
+		}
+		if err := bindJSON(c, &body); err != nil {
+			return err
+		}
+
+		updateData := map[string]interface{}{}
+		if body.CollectionID != nil {
+			if *body.CollectionID == "" {
+				updateData["collection_id"] = nil
+			} else {
+				owned, err := s.ownsCollection(*body.CollectionID, supabaseUser.ID.String())
+				if err != nil {
+					return errInternal("Failed to update bookmark", err)
+				}
+				if !owned {
+					return errNotFound("Collection not found")
+				}
+				updateData["collection_id"] = *body.CollectionID
+				updateData["position"] = 0
+			}
+		}
+		if body.Note != nil {
+			if *body.Note == "" {
+				updateData["note"] = nil
+			} else {
+				encryptedNote, err := s.encrypt(*body.Note)
+				if err != nil {
+					return errInternal("Encryption failed", err)
+				}
+				updateData["note"] = encryptedNote
+			}
+		}
+		if len(updateData) == 0 {
+			return errValidation("Nothing to update")
+		}
+
+		var updated []interface{}
+		_, err := s.db.From("bookmarks").
+			Update(updateData, "", "").
+			Eq("message_id", messageID).
+			Eq("user_id", supabaseUser.ID.String()).
+			ExecuteTo(&updated)
+
+		if err != nil {
+			return errInternal("Failed to update bookmark", err)
+		}
+		if len(updated) == 0 {
+			return errNotFound("Bookmark not found")
+		}
+
+		c.JSON(http.StatusOK, gin.H{"status": "updated"})
+		return nil
+	}))
+
+	// Remove Bookmark
+	r.DELETE("/messages/:id/bookmark", authMiddleware, handle(func(c *gin.Context) error {
+		messageID := c.Param("id")
+		user, _ := c.Get("user")
+		supabaseUser := user.(types.User)
+
+		_, _, err := s.db.From("bookmarks").
+			Delete("", "").
+			Eq("message_id", messageID).
+			Eq("user_id", supabaseUser.ID.String()).
+			Execute()
+
+		if err != nil {
+			return errInternal("Failed to remove bookmark", err)
+		}
+
+		s.invalidateProfileCacheForMessage(messageID)
+		s.bumpViewerState(supabaseUser.ID.String())
+		c.JSON(http.StatusOK, gin.H{"status": "unbookmarked"})
+		return nil
+	}))
+
+	// Get user's bookmarked messages
+	r.GET("/bookmarks", authMiddleware, handle(func(c *gin.Context) error {
+		user, _ := c.Get("user")
+		supabaseUser := user.(types.User)
+
+		var bookmarkData []bookmarkRow
+
+		query := s.db.From("bookmarks").
+			Select(bookmarkSelect, "exact", false).
+			Eq("user_id", supabaseUser.ID.String())
+
+		// Optional: only one collection ("none" for unfiled bookmarks)
+		if collectionID := c.Query("collection_id"); collectionID == "none" {
+			query = query.Is("collection_id", "null")
+		} else if collectionID != "" {
+			query = query.Eq("collection_id", collectionID).
+				Order("position", &postgrest.OrderOpts{Ascending: true})
+		}
+
+		_, err := query.
+			Order("created_at", &postgrest.OrderOpts{Ascending: false}).
+			ExecuteTo(&bookmarkData)
+
+		if err != nil {
+			return errInternal("Failed to fetch bookmarks", err)
+		}
+
+		messages := s.bookmarkMessages(bookmarkData, true)
+		messages = slices.DeleteFunc(messages, func(m interface{}) bool { return !visibleAnswer(m, supabaseUser.ID.String()) })
+
+		if err := s.enrichForViewer(supabaseUser.ID.String(), messages); err != nil {
+			return errInternal("Failed to fetch viewer state", fmt.Errorf("fetching viewer state: %w", err))
+		}
+
+		c.JSON(http.StatusOK, messages)
+		return nil
+	}))
+
+	// Get user's liked messages
+	r.GET("/likes", authMiddleware, handle(func(c *gin.Context) error {
+		user, _ := c.Get("user")
+		supabaseUser := user.(types.User)
+
+		messages, err := s.fetchLikedMessages(supabaseUser.ID.String())
+		if err != nil {
+			return errInternal("Failed to fetch liked messages", err)
+		}
+
+		if err := s.enrichForViewer(supabaseUser.ID.String(), messages); err != nil {
+			return errInternal("Failed to fetch viewer state", fmt.Errorf("fetching viewer state: %w", err))
+		}
+
+		c.JSON(http.StatusOK, messages)
+		return nil
+	}))
+
+	// Send Friend Request
+	r.POST("/friends/request", authMiddleware, handle(func(c *gin.Context) error {
+		var body struct {
+			ReceiverID string `json:"receiver_id" binding:"required"`
+		}
+		if err := bindJSON(c, &body); err != nil {
+			return err
+		}
+
+		user, _ := c.Get("user")
+		supabaseUser := user.(types.User)
+
+		if body.ReceiverID == supabaseUser.ID.String() {
+			return errValidation("You cannot add yourself")
+		}
+
+		// Check if already friends or request pending
+		var existing []interface{}
+		_, err := s.db.From("friendships").
+			Select("*", "", false).
+			Or(fmt.Sprintf("and(sender_id.eq.%s,receiver_id.eq.%s),and(sender_id.eq.%s,receiver_id.eq.%s)",
+				supabaseUser.ID.String(), body.ReceiverID, body.ReceiverID, supabaseUser.ID.String()), "").
+			ExecuteTo(&existing)
+
+		if err != nil {
+			return errInternal("Failed to send request", err)
+		}
+		if len(existing) > 0 {
+			return errConflict("Request already exists or already friends")
+		}
+
+		_, _, err = s.db.From("friendships").
+			Insert(map[string]interface{}{
+				"sender_id":   supabaseUser.ID.String(),
+				"receiver_id": body.ReceiverID,
+				"status":      "pending",
+			}, false, "", "", "").
+			Execute()
+
+		if err != nil {
+			return errInternal("Failed to send request", err)
+		}
+
+		s.bumpViewerState(supabaseUser.ID.String())
+		c.JSON(http.StatusOK, gin.H{"status": "request_sent"})
+		return nil
+	}))
+
+	// Get Friend Requests
+	r.GET("/friends/requests", authMiddleware, handle(func(c *gin.Context) error {
+		user, _ := c.Get("user")
+		supabaseUser := user.(types.User)
+
+		var requests []interface{}
+		_, err := s.db.From("friendships").
+			Select("*, profiles!sender_id(username, display_name, avatar_url)", "", false).
+			Eq("receiver_id", supabaseUser.ID.String()).
+			Eq("status", "pending").
+			ExecuteTo(&requests)
+
+		if err != nil {
+			return errInternal("Failed to fetch requests", err)
+		}
+		c.JSON(http.StatusOK, requests)
+		return nil
+	}))
+
+	// Accept Friend Request
+	r.POST("/friends/accept", authMiddleware, handle(func(c *gin.Context) error {
+		var body struct {
+			RequestID string `json:"request_id" binding:"required"`
+		}
+		if err := bindJSON(c, &body); err != nil {
+			return err
+		}
+
+		user, _ := c.Get("user")
+		supabaseUser := user.(types.User)
+
+		var accepted []struct {
+			SenderID string `json:"sender_id"`
+		}
+		_, err := s.db.From("friendships").
+			Update(map[string]interface{}{"status": "accepted"}, "", "").
+			Eq("id", body.RequestID).
+			Eq("receiver_id", supabaseUser.ID.String()).
+			ExecuteTo(&accepted)
+
+		if err != nil {
+			return errInternal("Failed to accept request", err)
+		}
+
+		s.bumpViewerState(supabaseUser.ID.String())
+		for _, f := range accepted {
+			s.bumpViewerState(f.SenderID)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"status": "accepted"})
+		return nil
+	}))
+
+	// Friends Feed: Get public conversations from friends
+	r.GET("/friends/feed", authMiddleware, handle(func(c *gin.Context) error {
+		user, _ := c.Get("user")
+		supabaseUser := user.(types.User)
+
+		// 1. Get friend IDs
+		var friendships []map[string]interface{}
+		_, err := s.db.From("friendships").
+			Select("sender_id, receiver_id", "", false).
+			Eq("status", "accepted").
+			Or(fmt.Sprintf("sender_id.eq.%s,receiver_id.eq.%s", supabaseUser.ID.String(), supabaseUser.ID.String()), "").
+			ExecuteTo(&friendships)
+
+		if err != nil {
+			return errInternal("Failed to fetch friendships", err)
+		}
+
+		friendIDs := make([]string, 0)
+		for _, f := range friendships {
+			sID := f["sender_id"].(string)
+			rID := f["receiver_id"].(string)
+			if sID != supabaseUser.ID.String() {
+				friendIDs = append(friendIDs, sID)
+			} else if rID != supabaseUser.ID.String() {
+				friendIDs = append(friendIDs, rID)
+			}
+		}
+
+		if len(friendIDs) == 0 {
+			c.JSON(http.StatusOK, []interface{}{})
+			return nil
+		}
+
+		// 2. Fetch public messages for those friends
+		var messages []interface{}
+		_, err = s.db.From("messages").
+			Select(publicMessageColumns+", profiles!receiver_id(username, display_name, avatar_url), replies(content, created_at)", "exact", false).
+			In("receiver_id", friendIDs).
+			Eq("status", "replied").
+			Is("is_hidden", "false").
+			Order("created_at", &postgrest.OrderOpts{Ascending: false}).
+			Limit(30, "").
+			ExecuteTo(&messages)
+
+		if err != nil {
+			return errInternal("Failed to fetch feed", err)
+		}
+
+		// Decrypt everything
+		for i, m := range messages {
+			messages[i] = s.decryptMessageMap(m)
+		}
+
+		if err := s.enrichForViewer(supabaseUser.ID.String(), messages); err != nil {
+			return errInternal("Failed to fetch viewer state", fmt.Errorf("fetching viewer state: %w", err))
+		}
+
+		c.JSON(http.StatusOK, messages)
+		return nil
+	}))
+
+	// Get Friend List
+	r.GET("/friends/list", authMiddleware, handle(func(c *gin.Context) error {
+		user, _ := c.Get("user")
+		supabaseUser := user.(types.User)
+
+		friends, err := s.fetchFriends(supabaseUser.ID.String())
+		if err != nil {
+			return errInternal("Failed to fetch friends", err)
+		}
+
+		c.JSON(http.StatusOK, friends)
+		return nil
+	}))
+
+	// Unfriend
+	r.DELETE("/friends/:id", authMiddleware, handle(func(c *gin.Context) error {
+		friendshipID := c.Param("id")
+		user, _ := c.Get("user")
+		supabaseUser := user.(types.User)
+
+		// Verification: Ensure the friendship belongs to the user
+		var friendship map[string]interface{}
+		_, err := s.db.From("friendships").
+			Select("*", "", false).
+			Eq("id", friendshipID).
+			Single().
+			ExecuteTo(&friendship)
+
+		if isNoRows(err) {
+			return errNotFound("Friendship not found")
+		}
+		if err != nil {
+			return errInternal("Failed to unfriend", fmt.Errorf("fetching friendship: %w", err))
+		}
+
+		if friendship["sender_id"].(string) != supabaseUser.ID.String() && friendship["receiver_id"].(string) != supabaseUser.ID.String() {
+			return errForbidden("Unauthorized")
+		}
+
+		_, _, err = s.db.From("friendships").
+			Delete("", "").
+			Eq("id", friendshipID).
+			Execute()
+
+		if err != nil {
+			return errInternal("Failed to unfriend", err)
+		}
+
+		s.bumpViewerState(stringField(friendship, "sender_id"))
+		s.bumpViewerState(stringField(friendship, "receiver_id"))
+
+		c.JSON(http.StatusOK, gin.H{"status": "unfriended"})
+		return nil
+	}))
+}
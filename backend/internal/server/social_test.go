@@ -0,0 +1,140 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestLikeAndUnlike(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.addUser("alice", nil)
+	bob := e.addUser("bob", nil)
+	msg := e.addMessage(alice, "tea or coffee?", "replied", nil)
+	e.addReply(msg, alice, "tea")
+	path := "/messages/" + msg["id"].(string) + "/like"
+
+	e.request("POST", path, bob.Token, nil).expect(http.StatusOK)
+	e.request("POST", path, bob.Token, nil).expect(http.StatusConflict)
+
+	likes := e.request("GET", "/likes", bob.Token, nil).expect(http.StatusOK).list()
+	if len(likes) != 1 || likes[0]["content"] != "tea or coffee?" || likes[0]["is_liked"] != true {
+		t.Fatalf("likes = %v", likes)
+	}
+
+	// Answers hidden from the profile drop out of other people's likes
+	e.db.update("messages", row{"id": msg["id"]}, row{"is_hidden": true})
+	if likes := e.request("GET", "/likes", bob.Token, nil).expect(http.StatusOK).list(); len(likes) != 0 {
+		t.Errorf("likes of a hidden answer = %v", likes)
+	}
+	e.db.update("messages", row{"id": msg["id"]}, row{"is_hidden": false})
+
+	e.request("DELETE", path, bob.Token, nil).expect(http.StatusOK)
+	if likes := e.request("GET", "/likes", bob.Token, nil).expect(http.StatusOK).list(); len(likes) != 0 {
+		t.Errorf("likes after unlike = %v", likes)
+	}
+}
+
+func TestBookmarks(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.addUser("alice", nil)
+	bob := e.addUser("bob", nil)
+	first := e.addMessage(alice, "first", "replied", nil)
+	second := e.addMessage(alice, "second", "replied", nil)
+	collection := e.db.insert("bookmark_collections", row{"user_id": bob.ID, "name": "Favourites"})
+	foreign := e.db.insert("bookmark_collections", row{"user_id": alice.ID, "name": "Alice's"})
+	firstPath := "/messages/" + first["id"].(string) + "/bookmark"
+	secondPath := "/messages/" + second["id"].(string) + "/bookmark"
+
+	e.request("POST", firstPath, bob.Token, map[string]string{"collection_id": foreign["id"].(string)}).
+		expect(http.StatusNotFound)
+	e.request("POST", firstPath, bob.Token, map[string]string{"note": string(make([]rune, maxBookmarkNoteLength+1))}).
+		expect(http.StatusBadRequest)
+
+	e.request("POST", firstPath, bob.Token, map[string]string{"collection_id": collection["id"].(string), "note": "to reread"}).
+		expect(http.StatusOK)
+	e.request("POST", firstPath, bob.Token, nil).expect(http.StatusConflict)
+	e.request("POST", secondPath, bob.Token, nil).expect(http.StatusOK)
+
+	if stored := e.db.rows("bookmarks", row{"message_id": first["id"]})[0]; stored["note"] == "to reread" {
+		t.Error("bookmark note stored in plaintext")
+	}
+
+	all := e.request("GET", "/bookmarks", bob.Token, nil).expect(http.StatusOK).list()
+	if len(all) != 2 {
+		t.Fatalf("bookmarks = %v, want 2", all)
+	}
+	filed := e.request("GET", "/bookmarks?collection_id="+collection["id"].(string), bob.Token, nil).expect(http.StatusOK).list()
+	if len(filed) != 1 || filed[0]["bookmark_note"] != "to reread" || filed[0]["is_bookmarked"] != true {
+		t.Fatalf("filed bookmarks = %v", filed)
+	}
+	unfiled := e.request("GET", "/bookmarks?collection_id=none", bob.Token, nil).expect(http.StatusOK).list()
+	if len(unfiled) != 1 || unfiled[0]["id"] != second["id"] {
+		t.Fatalf("unfiled bookmarks = %v", unfiled)
+	}
+
+	// Unfile and clear the note
+	e.request("PATCH", firstPath, bob.Token, map[string]string{}).expect(http.StatusBadRequest)
+	e.request("PATCH", firstPath, bob.Token, map[string]string{"collection_id": "", "note": ""}).expect(http.StatusOK)
+	stored := e.db.rows("bookmarks", row{"message_id": first["id"]})[0]
+	if stored["collection_id"] != nil || stored["note"] != nil {
+		t.Errorf("bookmark after update = %v", stored)
+	}
+	e.request("PATCH", firstPath, alice.Token, map[string]string{"note": "x"}).expect(http.StatusNotFound)
+
+	e.request("DELETE", firstPath, bob.Token, nil).expect(http.StatusOK)
+	if left := e.request("GET", "/bookmarks", bob.Token, nil).expect(http.StatusOK).list(); len(left) != 1 {
+		t.Errorf("bookmarks after removal = %v", left)
+	}
+}
+
+func TestFriendships(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.addUser("alice", nil)
+	bob := e.addUser("bob", nil)
+	carol := e.addUser("carol", nil)
+	answered := e.addMessage(bob, "favourite film?", "replied", nil)
+	e.addReply(answered, bob, "Alien")
+	e.addMessage(bob, "unanswered", "pending", nil)
+	e.addMessage(bob, "hidden", "replied", row{"is_hidden": true})
+
+	e.request("POST", "/friends/request", alice.Token, map[string]string{"receiver_id": alice.ID}).expect(http.StatusBadRequest)
+	e.request("POST", "/friends/request", alice.Token, map[string]string{"receiver_id": bob.ID}).expect(http.StatusOK)
+	e.request("POST", "/friends/request", bob.Token, map[string]string{"receiver_id": alice.ID}).expect(http.StatusConflict)
+
+	requests := e.request("GET", "/friends/requests", bob.Token, nil).expect(http.StatusOK).list()
+	if len(requests) != 1 {
+		t.Fatalf("requests = %v", requests)
+	}
+	requestID := requests[0]["id"].(string)
+
+	// Only the receiver can accept
+	e.request("POST", "/friends/accept", alice.Token, map[string]string{"request_id": requestID}).expect(http.StatusOK)
+	if status := e.db.rows("friendships", row{"id": requestID})[0]["status"]; status != "pending" {
+		t.Fatalf("sender accepted their own request: status %v", status)
+	}
+	e.request("POST", "/friends/accept", bob.Token, map[string]string{"request_id": requestID}).expect(http.StatusOK)
+
+	friends := e.request("GET", "/friends/list", alice.Token, nil).expect(http.StatusOK).list()
+	if len(friends) != 1 || friends[0]["username"] != "bob" || friends[0]["friendship_id"] != requestID {
+		t.Fatalf("friends = %v", friends)
+	}
+
+	feed := e.request("GET", "/friends/feed", alice.Token, nil).expect(http.StatusOK).list()
+	if len(feed) != 1 || feed[0]["content"] != "favourite film?" {
+		t.Fatalf("feed = %v, want bob's answered message", feed)
+	}
+	if feed := e.request("GET", "/friends/feed", carol.Token, nil).expect(http.StatusOK).list(); len(feed) != 0 {
+		t.Errorf("feed without friends = %v", feed)
+	}
+
+	e.request("DELETE", "/friends/"+requestID, carol.Token, nil).expect(http.StatusForbidden)
+	e.request("DELETE", "/friends/"+requestID, bob.Token, nil).expect(http.StatusOK)
+	e.request("DELETE", "/friends/"+requestID, bob.Token, nil).expect(http.StatusNotFound)
+	if friends := e.request("GET", "/friends/list", alice.Token, nil).expect(http.StatusOK).list(); len(friends) != 0 {
+		t.Errorf("friends after unfriend = %v", friends)
+	}
+
+	// An outage is not a missing friendship
+	e.db.failTable("friendships")
+	e.request("DELETE", "/friends/"+requestID, bob.Token, nil).expect(http.StatusInternalServerError)
+}
@@ -0,0 +1,946 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/supabase-community/gotrue-go/types"
+)
+
+type row = map[string]interface{}
+
+// fakeForeignKeys lists the relationships PostgREST would use to resolve
+// embedded selects such as "replies(*)" or "sender:profiles!sender_id(...)".
+var fakeForeignKeys = []struct{ table, column, target string }{
+	{"messages", "receiver_id", "profiles"},
+	{"messages", "sender_id", "profiles"},
+	{"replies", "message_id", "messages"},
+	{"replies", "sender_id", "profiles"},
+	{"likes", "message_id", "messages"},
+	{"likes", "user_id", "profiles"},
+	{"bookmarks", "message_id", "messages"},
+	{"bookmarks", "user_id", "profiles"},
+	{"bookmarks", "collection_id", "bookmark_collections"},
+	{"bookmark_collections", "user_id", "profiles"},
+	{"friendships", "sender_id", "profiles"},
+	{"friendships", "receiver_id", "profiles"},
+	{"user_blocks", "blocker_id", "profiles"},
+	{"user_blocks", "blocked_id", "profiles"},
+	{"username_history", "profile_id", "profiles"},
+	{"data_exports", "user_id", "profiles"},
+	{"import_jobs", "user_id", "profiles"},
+	{"prompts", "profile_id", "profiles"},
+	{"messages", "prompt_id", "prompts"},
+}
+
+// fakeUniqueKeys are the unique constraints the handlers depend on.
+var fakeUniqueKeys = map[string][][]string{
+	"profiles":    {{"id"}, {"username"}},
+	"likes":       {{"message_id", "user_id"}},
+	"bookmarks":   {{"message_id", "user_id"}},
+	"user_blocks": {{"blocker_id", "blocked_id"}},
+	"messages":    {{"receiver_id", "import_hash"}},
+	"prompts":     {{"profile_id", "slug"}},
+}
+
+// fakeDefaults are column defaults applied on insert, on top of id and
+// created_at which every table gets.
+var fakeDefaults = map[string]func() row{
+	"profiles": func() row {
+		return row{"is_paused": false, "blocked_phrases": []interface{}{}, "avatar_url": nil, "display_name": nil, "bio": nil, "who_can_send": "anyone", "min_account_age_days": float64(0), "profile_sort": "newest"}
+	},
+	"messages": func() row {
+		return row{"status": "pending", "sender_id": nil, "thread_id": nil, "search_tokens": nil, "import_hash": nil, "prompt_id": nil, "pinned_at": nil, "is_hidden": false, "read_at": nil, "is_starred": false, "labels": []interface{}{}, "snoozed_until": nil}
+	},
+	"bookmarks": func() row {
+		return row{"collection_id": nil, "note": nil, "position": float64(0)}
+	},
+	"bookmark_collections": func() row {
+		return row{"is_public": false, "position": float64(0)}
+	},
+	"prompts": func() row {
+		return row{"description": nil, "opens_at": nil, "closes_at": nil, "blocked_phrases": []interface{}{}, "who_can_send": nil}
+	},
+	"friendships":      func() row { return row{"status": "pending"} },
+	"username_history": func() row { return row{"changed_at": time.Now().UTC().Format(time.RFC3339Nano)} },
+	"account_deletions": func() row {
+		return row{"status": "scheduled", "attempts": float64(0), "last_error": nil, "report": nil, "completed_at": nil}
+	},
+	"data_exports": func() row {
+		return row{"status": "queued", "progress": float64(0), "blob_key": nil, "size_bytes": nil, "error": nil, "completed_at": nil}
+	},
+	"import_jobs": func() row {
+		return row{"status": "queued", "dry_run": false, "source": "", "payload": nil, "total": float64(0), "cursor": float64(0), "imported": float64(0), "duplicates": float64(0), "invalid": float64(0), "issues": []interface{}{}, "error": nil, "completed_at": nil}
+	},
+}
+
+// fakeSupabase is an in-memory PostgREST and GoTrue. It implements the
+// subset of the query language the server uses, so handlers run their real
+// queries against it.
+type fakeSupabase struct {
+	*httptest.Server
+
+	mu           sync.Mutex
+	tables       map[string][]row
+	users        map[string]types.User // by access token
+	deletedUsers []string
+	failTables   map[string]bool
+}
+
+func newFakeSupabase(t *testing.T) *fakeSupabase {
+	f := &fakeSupabase{
+		tables:     map[string][]row{},
+		users:      map[string]types.User{},
+		failTables: map[string]bool{},
+	}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// addUser registers an auth user and returns a bearer token for it.
+func (f *fakeSupabase) addUser(email string, metadata map[string]interface{}) (types.User, string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	user := types.User{ID: uuid.New(), Email: email, UserMetadata: metadata, CreatedAt: time.Now()}
+	token := randomToken()
+	f.users[token] = user
+	return user, token
+}
+
+// insert stores rows directly, applying defaults, and returns the first.
+func (f *fakeSupabase) insert(table string, rows ...row) row {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var first row
+	for _, r := range rows {
+		stored := f.withDefaults(table, r)
+		f.tables[table] = append(f.tables[table], stored)
+		if first == nil {
+			first = stored
+		}
+	}
+	return cloneRow(first)
+}
+
+// matchesRow reports whether r has every column/value pair in match.
+func matchesRow(r, match row) bool {
+	for k, v := range match {
+		if fmt.Sprint(r[k]) != fmt.Sprint(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// rows returns copies of the rows matching every column/value pair.
+func (f *fakeSupabase) rows(table string, match row) []row {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []row
+	for _, r := range f.tables[table] {
+		if matchesRow(r, match) {
+			out = append(out, cloneRow(r))
+		}
+	}
+	return out
+}
+
+// update applies changes to every row matching match and returns how many
+// rows changed.
+func (f *fakeSupabase) update(table string, match, changes row) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, r := range f.tables[table] {
+		if matchesRow(r, match) {
+			for k, v := range changes {
+				r[k] = v
+			}
+			n++
+		}
+	}
+	return n
+}
+
+// signIn issues a JWT-shaped access token for an existing user whose amr
+// claim records a sign-in at the given time.
+func (f *fakeSupabase) signIn(userID string, at time.Time) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, user := range f.users {
+		if user.ID.String() != userID {
+			continue
+		}
+		claims, _ := json.Marshal(map[string]interface{}{
+			"sub": userID,
+			"amr": []map[string]interface{}{{"method": "password", "timestamp": at.Unix()}},
+		})
+		token := "eyJhbGciOiJIUzI1NiJ9." + base64.RawURLEncoding.EncodeToString(claims) + "." + randomToken()
+		f.users[token] = user
+		return token
+	}
+	panic("signIn: unknown user " + userID)
+}
+
+// backdateUser moves an auth user's creation time back by age.
+func (f *fakeSupabase) backdateUser(userID string, age time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for token, user := range f.users {
+		if user.ID.String() == userID {
+			user.CreatedAt = user.CreatedAt.Add(-age)
+			f.users[token] = user
+		}
+	}
+}
+
+// failTable makes every request against a table fail with a server error.
+func (f *fakeSupabase) failTable(table string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failTables[table] = true
+}
+
+func randomToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func cloneRow(r row) row {
+	if r == nil {
+		return nil
+	}
+	data, _ := json.Marshal(r)
+	var out row
+	json.Unmarshal(data, &out)
+	return out
+}
+
+func (f *fakeSupabase) withDefaults(table string, r row) row {
+	stored := row{"id": uuid.NewString(), "created_at": time.Now().UTC().Format(time.RFC3339Nano)}
+	if defaults, ok := fakeDefaults[table]; ok {
+		for k, v := range defaults() {
+			stored[k] = v
+		}
+	}
+	for k, v := range cloneRow(r) {
+		stored[k] = v
+	}
+	return stored
+}
+
+func (f *fakeSupabase) serve(w http.ResponseWriter, req *http.Request) {
+	switch {
+	case req.URL.Path == "/auth/v1/user":
+		f.serveUser(w, req)
+	case strings.HasPrefix(req.URL.Path, "/auth/v1/admin/users/"):
+		f.mu.Lock()
+		f.deletedUsers = append(f.deletedUsers, strings.TrimPrefix(req.URL.Path, "/auth/v1/admin/users/"))
+		f.mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	case strings.HasPrefix(req.URL.Path, "/rest/v1/"):
+		f.serveRest(w, req, strings.TrimPrefix(req.URL.Path, "/rest/v1/"))
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func (f *fakeSupabase) serveUser(w http.ResponseWriter, req *http.Request) {
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	f.mu.Lock()
+	user, ok := f.users[token]
+	f.mu.Unlock()
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"msg":"invalid JWT"}`))
+		return
+	}
+	json.NewEncoder(w).Encode(types.UserResponse{User: user})
+}
+
+type restError struct {
+	status  int
+	code    string
+	message string
+}
+
+func writeRestError(w http.ResponseWriter, e restError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.status)
+	json.NewEncoder(w).Encode(map[string]string{"code": e.code, "message": e.message})
+}
+
+func (f *fakeSupabase) serveRest(w http.ResponseWriter, req *http.Request, table string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.failTables[table] {
+		writeRestError(w, restError{http.StatusInternalServerError, "XX000", "simulated failure"})
+		return
+	}
+
+	query := req.URL.Query()
+	prefer := req.Header.Get("Prefer")
+	single := false
+	for _, accept := range req.Header.Values("Accept") {
+		if strings.Contains(accept, "vnd.pgrst.object") {
+			single = true
+		}
+	}
+
+	filter, err := parseFilters(query)
+	if err != nil {
+		writeRestError(w, restError{http.StatusBadRequest, "PGRST100", err.Error()})
+		return
+	}
+
+	var result []row
+	status := http.StatusOK
+	total := -1
+
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		var matched []row
+		for _, r := range f.tables[table] {
+			if filter(r) {
+				matched = append(matched, r)
+			}
+		}
+		if err := sortRows(matched, query.Get("order")); err != nil {
+			writeRestError(w, restError{http.StatusBadRequest, "PGRST100", err.Error()})
+			return
+		}
+		total = len(matched)
+		if offset, err := strconv.Atoi(query.Get("offset")); err == nil && offset < len(matched) {
+			matched = matched[offset:]
+		} else if err == nil {
+			matched = nil
+		}
+		if limit, err := strconv.Atoi(query.Get("limit")); err == nil && limit < len(matched) {
+			matched = matched[:limit]
+		}
+		result = matched
+
+	case http.MethodPost:
+		body, _ := io.ReadAll(req.Body)
+		var incoming []row
+		if err := json.Unmarshal(body, &incoming); err != nil {
+			var one row
+			if err := json.Unmarshal(body, &one); err != nil {
+				writeRestError(w, restError{http.StatusBadRequest, "PGRST102", "invalid body"})
+				return
+			}
+			incoming = []row{one}
+		}
+		upsert := strings.Contains(prefer, "resolution=merge-duplicates")
+		var onConflict []string
+		if c := query.Get("on_conflict"); c != "" {
+			onConflict = strings.Split(c, ",")
+		}
+		for _, in := range incoming {
+			if upsert {
+				if existing := f.findConflict(table, in, onConflict); existing != nil {
+					for k, v := range in {
+						existing[k] = v
+					}
+					result = append(result, existing)
+					continue
+				}
+			}
+			stored := f.withDefaults(table, in)
+			if f.violatesUnique(table, stored, nil) {
+				writeRestError(w, restError{http.StatusConflict, "23505", "duplicate key value violates unique constraint"})
+				return
+			}
+			f.tables[table] = append(f.tables[table], stored)
+			result = append(result, stored)
+		}
+		status = http.StatusCreated
+
+	case http.MethodPatch:
+		body, _ := io.ReadAll(req.Body)
+		var changes row
+		if err := json.Unmarshal(body, &changes); err != nil {
+			writeRestError(w, restError{http.StatusBadRequest, "PGRST102", "invalid body"})
+			return
+		}
+		for _, r := range f.tables[table] {
+			if !filter(r) {
+				continue
+			}
+			updated := cloneRow(r)
+			for k, v := range changes {
+				updated[k] = v
+			}
+			if f.violatesUnique(table, updated, r) {
+				writeRestError(w, restError{http.StatusConflict, "23505", "duplicate key value violates unique constraint"})
+				return
+			}
+			result = append(result, r)
+		}
+		for _, r := range result {
+			for k, v := range changes {
+				r[k] = v
+			}
+		}
+
+	case http.MethodDelete:
+		var kept []row
+		for _, r := range f.tables[table] {
+			if filter(r) {
+				result = append(result, r)
+			} else {
+				kept = append(kept, r)
+			}
+		}
+		f.tables[table] = kept
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if strings.Contains(prefer, "count=exact") {
+		if total < 0 {
+			total = len(result)
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("0-%d/%d", len(result), total))
+	}
+
+	if req.Method != http.MethodGet && req.Method != http.MethodHead && strings.Contains(prefer, "return=minimal") {
+		w.WriteHeader(status)
+		return
+	}
+
+	out := make([]row, 0, len(result))
+	for _, r := range result {
+		shaped, err := f.shape(table, r, query.Get("select"))
+		if err != nil {
+			writeRestError(w, restError{http.StatusBadRequest, "PGRST200", err.Error()})
+			return
+		}
+		out = append(out, shaped)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if single {
+		if len(out) != 1 {
+			writeRestError(w, restError{http.StatusNotAcceptable, "PGRST116", "JSON object requested, multiple (or no) rows returned"})
+			return
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(out[0])
+		return
+	}
+	w.WriteHeader(status)
+	if req.Method != http.MethodHead {
+		json.NewEncoder(w).Encode(out)
+	}
+}
+
+func (f *fakeSupabase) findConflict(table string, in row, columns []string) row {
+	if len(columns) == 0 {
+		columns = []string{"id"}
+	}
+	for _, r := range f.tables[table] {
+		match := true
+		for _, c := range columns {
+			if fmt.Sprint(r[c]) != fmt.Sprint(in[c]) {
+				match = false
+				break
+			}
+		}
+		if match {
+			return r
+		}
+	}
+	return nil
+}
+
+// violatesUnique reports whether candidate clashes with any row other than
+// self on one of the table's unique keys. NULLs never clash.
+func (f *fakeSupabase) violatesUnique(table string, candidate, self row) bool {
+	for _, key := range append(fakeUniqueKeys[table], []string{"id"}) {
+	rows:
+		for _, r := range f.tables[table] {
+			if self != nil && r["id"] == self["id"] {
+				continue
+			}
+			for _, c := range key {
+				if candidate[c] == nil || fmt.Sprint(r[c]) != fmt.Sprint(candidate[c]) {
+					continue rows
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// --- select and embedding ---
+
+type selectItem struct {
+	alias  string
+	name   string
+	hint   string
+	nested string // inner select of an embed, "" for plain columns
+	embed  bool
+}
+
+// splitTopLevel splits s on sep, ignoring separators inside parentheses
+// or double quotes.
+func splitTopLevel(s string, sep byte) []string {
+	var parts []string
+	depth, start := 0, 0
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			quoted = !quoted
+		case '(':
+			if !quoted {
+				depth++
+			}
+		case ')':
+			if !quoted {
+				depth--
+			}
+		case sep:
+			if depth == 0 && !quoted {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
+
+func parseSelect(sel string) []selectItem {
+	if sel == "" {
+		sel = "*"
+	}
+	var items []selectItem
+	for _, part := range splitTopLevel(strings.ReplaceAll(sel, " ", ""), ',') {
+		if part == "" {
+			continue
+		}
+		item := selectItem{}
+		if i := strings.Index(part, "("); i >= 0 && strings.HasSuffix(part, ")") {
+			item.embed = true
+			item.nested = part[i+1 : len(part)-1]
+			part = part[:i]
+		}
+		if i := strings.Index(part, ":"); i >= 0 {
+			item.alias, part = part[:i], part[i+1:]
+		}
+		if i := strings.Index(part, "!"); i >= 0 {
+			part, item.hint = part[:i], part[i+1:]
+		}
+		item.name = part
+		if item.alias == "" {
+			item.alias = item.name
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+func foreignKey(table, column string) (string, bool) {
+	for _, fk := range fakeForeignKeys {
+		if fk.table == table && fk.column == column {
+			return fk.target, true
+		}
+	}
+	return "", false
+}
+
+func (f *fakeSupabase) shape(table string, r row, sel string) (row, error) {
+	out := row{}
+	for _, item := range parseSelect(sel) {
+		if !item.embed {
+			if item.name == "*" {
+				for k, v := range cloneRow(r) {
+					out[k] = v
+				}
+			} else {
+				out[item.alias] = cloneRow(row{"v": r[item.name]})["v"]
+			}
+			continue
+		}
+
+		// Many-to-one: an explicit FK hint, a column name or a unique FK
+		// to the named table.
+		column, target := "", ""
+		if item.hint != "" {
+			column, target = item.hint, item.name
+		} else if t, ok := foreignKey(table, item.name); ok {
+			column, target = item.name, t
+		} else {
+			for _, fk := range fakeForeignKeys {
+				if fk.table == table && fk.target == item.name {
+					if column != "" {
+						return nil, fmt.Errorf("ambiguous embed %s on %s", item.name, table)
+					}
+					column, target = fk.column, fk.target
+				}
+			}
+		}
+		if column != "" {
+			var parent row
+			for _, p := range f.tables[target] {
+				if r[column] != nil && fmt.Sprint(p["id"]) == fmt.Sprint(r[column]) {
+					parent = p
+					break
+				}
+			}
+			if parent == nil {
+				out[item.alias] = nil
+				continue
+			}
+			shaped, err := f.shape(target, parent, item.nested)
+			if err != nil {
+				return nil, err
+			}
+			out[item.alias] = shaped
+			continue
+		}
+
+		// One-to-many: a table with a FK back to this one
+		child := ""
+		for _, fk := range fakeForeignKeys {
+			if fk.table == item.name && fk.target == table {
+				if child != "" {
+					return nil, fmt.Errorf("ambiguous embed %s on %s", item.name, table)
+				}
+				child = fk.column
+			}
+		}
+		if child == "" {
+			return nil, fmt.Errorf("no relationship between %s and %s", table, item.name)
+		}
+		var children []row
+		for _, c := range f.tables[item.name] {
+			if fmt.Sprint(c[child]) == fmt.Sprint(r["id"]) {
+				children = append(children, c)
+			}
+		}
+		if item.nested == "count" {
+			out[item.alias] = []interface{}{map[string]interface{}{"count": float64(len(children))}}
+			continue
+		}
+		list := make([]interface{}, 0, len(children))
+		for _, c := range children {
+			shaped, err := f.shape(item.name, c, item.nested)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, shaped)
+		}
+		out[item.alias] = list
+	}
+	return out, nil
+}
+
+// --- filters and ordering ---
+
+var reservedParams = map[string]bool{"select": true, "order": true, "limit": true, "offset": true, "on_conflict": true, "columns": true}
+
+func parseFilters(query map[string][]string) (func(row) bool, error) {
+	var preds []func(row) bool
+	for key, values := range query {
+		if reservedParams[key] {
+			continue
+		}
+		for _, value := range values {
+			var p func(row) bool
+			var err error
+			switch key {
+			case "or", "and":
+				p, err = parseLogical(key, strings.TrimSuffix(strings.TrimPrefix(value, "("), ")"))
+			default:
+				p, err = parseCondition(key, value)
+			}
+			if err != nil {
+				return nil, err
+			}
+			preds = append(preds, p)
+		}
+	}
+	return func(r row) bool {
+		for _, p := range preds {
+			if !p(r) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+func parseLogical(op, inner string) (func(row) bool, error) {
+	var preds []func(row) bool
+	for _, part := range splitTopLevel(inner, ',') {
+		var p func(row) bool
+		var err error
+		switch {
+		case strings.HasPrefix(part, "and("):
+			p, err = parseLogical("and", part[4:len(part)-1])
+		case strings.HasPrefix(part, "or("):
+			p, err = parseLogical("or", part[3:len(part)-1])
+		default:
+			i := strings.Index(part, ".")
+			if i < 0 {
+				return nil, fmt.Errorf("bad condition %q", part)
+			}
+			p, err = parseCondition(part[:i], part[i+1:])
+		}
+		if err != nil {
+			return nil, err
+		}
+		preds = append(preds, p)
+	}
+	return func(r row) bool {
+		for _, p := range preds {
+			if p(r) == (op == "or") {
+				return op == "or"
+			}
+		}
+		return op != "or"
+	}, nil
+}
+
+func parseCondition(column, expr string) (func(row) bool, error) {
+	negate := false
+	if strings.HasPrefix(expr, "not.") {
+		negate, expr = true, expr[4:]
+	}
+	i := strings.Index(expr, ".")
+	if i < 0 {
+		return nil, fmt.Errorf("bad filter %s=%s", column, expr)
+	}
+	op, value := expr[:i], unquoteFilterValue(expr[i+1:])
+
+	var p func(row) bool
+	switch op {
+	case "eq", "neq", "gt", "gte", "lt", "lte":
+		p = func(r row) bool {
+			v := r[column]
+			if v == nil {
+				return false
+			}
+			c := compareValues(v, value)
+			switch op {
+			case "eq":
+				return c == 0
+			case "neq":
+				return c != 0
+			case "gt":
+				return c > 0
+			case "gte":
+				return c >= 0
+			case "lt":
+				return c < 0
+			}
+			return c <= 0
+		}
+	case "is":
+		p = func(r row) bool {
+			switch value {
+			case "null":
+				return r[column] == nil
+			case "true":
+				return r[column] == true
+			case "false":
+				return r[column] == false
+			}
+			return false
+		}
+	case "in":
+		var set []string
+		for _, v := range splitTopLevel(strings.TrimSuffix(strings.TrimPrefix(value, "("), ")"), ',') {
+			set = append(set, strings.Trim(v, `"`))
+		}
+		p = func(r row) bool {
+			if r[column] == nil {
+				return false
+			}
+			for _, v := range set {
+				if compareValues(r[column], v) == 0 {
+					return true
+				}
+			}
+			return false
+		}
+	case "like", "ilike":
+		re, err := likePattern(value, op == "ilike")
+		if err != nil {
+			return nil, err
+		}
+		p = func(r row) bool {
+			s, ok := r[column].(string)
+			return ok && re.MatchString(s)
+		}
+	case "cs":
+		var want []string
+		for _, v := range splitTopLevel(strings.TrimSuffix(strings.TrimPrefix(value, "{"), "}"), ',') {
+			if v != "" {
+				want = append(want, strings.Trim(v, `"`))
+			}
+		}
+		p = func(r row) bool {
+			have, _ := r[column].([]interface{})
+			for _, w := range want {
+				found := false
+				for _, h := range have {
+					if fmt.Sprint(h) == w {
+						found = true
+						break
+					}
+				}
+				if !found {
+					return false
+				}
+			}
+			return true
+		}
+	default:
+		return nil, fmt.Errorf("unsupported operator %q", op)
+	}
+	if negate {
+		inner := p
+		p = func(r row) bool { return !inner(r) }
+	}
+	return p, nil
+}
+
+// unquoteFilterValue strips the double quotes PostgREST allows around
+// values in logical filters, undoing backslash escapes.
+func unquoteFilterValue(v string) string {
+	if len(v) < 2 || v[0] != '"' || v[len(v)-1] != '"' {
+		return v
+	}
+	var b strings.Builder
+	for i := 1; i < len(v)-1; i++ {
+		if v[i] == '\\' && i+1 < len(v)-1 {
+			i++
+		}
+		b.WriteByte(v[i])
+	}
+	return b.String()
+}
+
+func likePattern(pattern string, insensitive bool) (*regexp.Regexp, error) {
+	var b strings.Builder
+	if insensitive {
+		b.WriteString("(?i)")
+	}
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch ch := pattern[i]; ch {
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+				b.WriteString(regexp.QuoteMeta(string(pattern[i])))
+			}
+		case '%', '*':
+			b.WriteString(".*")
+		case '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(ch)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// compareValues compares a stored JSON value with a filter literal as
+// timestamps, numbers or strings, whichever both sides parse as.
+func compareValues(v interface{}, literal string) int {
+	s := fmt.Sprint(v)
+	if f, ok := v.(float64); ok {
+		s = strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	if a, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		if b, err := time.Parse(time.RFC3339Nano, literal); err == nil {
+			return a.Compare(b)
+		}
+	}
+	if a, err := strconv.ParseFloat(s, 64); err == nil {
+		if b, err := strconv.ParseFloat(literal, 64); err == nil {
+			switch {
+			case a < b:
+				return -1
+			case a > b:
+				return 1
+			}
+			return 0
+		}
+	}
+	return strings.Compare(s, literal)
+}
+
+func sortRows(rows []row, order string) error {
+	if order == "" {
+		return nil
+	}
+	type key struct {
+		column     string
+		desc       bool
+		nullsFirst bool
+	}
+	var keys []key
+	for _, part := range strings.Split(order, ",") {
+		fields := strings.Split(part, ".")
+		k := key{column: fields[0]}
+		for _, mod := range fields[1:] {
+			switch mod {
+			case "desc":
+				k.desc = true
+			case "asc":
+			case "nullsfirst":
+				k.nullsFirst = true
+			case "nullslast":
+			default:
+				return fmt.Errorf("bad order %q", part)
+			}
+		}
+		keys = append(keys, k)
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		for _, k := range keys {
+			a, b := rows[i][k.column], rows[j][k.column]
+			if a == nil || b == nil {
+				if (a == nil) == (b == nil) {
+					continue
+				}
+				return (a == nil) == k.nullsFirst
+			}
+			c := compareValues(a, fmt.Sprint(b))
+			if f, ok := b.(float64); ok {
+				c = compareValues(a, strconv.FormatFloat(f, 'f', -1, 64))
+			}
+			if c == 0 {
+				continue
+			}
+			return (c < 0) != k.desc
+		}
+		return false
+	})
+	return nil
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// taskPool runs background work (emails, exports, imports and the periodic
+// workers) so that shutdown can wait for it. At most size one-off tasks run
+// at once; the rest queue for a free slot.
+type taskPool struct {
+	ctx     context.Context
+	slots   chan struct{}
+	closing chan struct{}
+
+	mu     sync.Mutex
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// newTaskPool creates a pool whose tasks receive ctx. Cancelling ctx makes
+// queued tasks give up and tells running ones to stop.
+func newTaskPool(ctx context.Context, size int) *taskPool {
+	return &taskPool{
+		ctx:     ctx,
+		slots:   make(chan struct{}, size),
+		closing: make(chan struct{}),
+	}
+}
+
+// track registers a task with the wait group unless the pool is closed.
+func (p *taskPool) track(name string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		slog.Warn("Background task not started: shutting down", "task", name)
+		return false
+	}
+	p.wg.Add(1)
+	return true
+}
+
+// run calls fn, logging instead of crashing the server if it panics.
+func (p *taskPool) run(name string, fn func(ctx context.Context)) {
+	defer func() {
+		if r := recover(); r != nil {
+			slog.Error("Background task panicked", "task", name, "panic", r, "stack", string(debug.Stack()))
+		}
+	}()
+	fn(p.ctx)
+}
+
+// Go runs fn in the background once a slot is free. It reports false, and
+// does not run fn, when the pool is shutting down.
+func (p *taskPool) Go(name string, fn func(ctx context.Context)) bool {
+	if !p.track(name) {
+		return false
+	}
+	go func() {
+		defer p.wg.Done()
+		select {
+		case p.slots <- struct{}{}:
+		case <-p.ctx.Done():
+			slog.Warn("Background task dropped: shutting down", "task", name)
+			return
+		}
+		defer func() { <-p.slots }()
+		p.run(name, fn)
+	}()
+	return true
+}
+
+// Every runs fn now and then every interval until the pool is closed.
+// Periodic workers do not take a slot, so they never wait behind one-off
+// tasks.
+func (p *taskPool) Every(name string, interval time.Duration, fn func(ctx context.Context)) {
+	if !p.track(name) {
+		return
+	}
+	go func() {
+		defer p.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			p.run(name, fn)
+			select {
+			case <-ticker.C:
+			case <-p.closing:
+				return
+			case <-p.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Close stops the pool accepting tasks and ends the periodic workers after
+// their current run. Queued and running tasks carry on.
+func (p *taskPool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.closed {
+		p.closed = true
+		close(p.closing)
+	}
+}
+
+// Wait blocks until every task has finished or ctx expires.
+func (p *taskPool) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTaskPoolLimitsConcurrency(t *testing.T) {
+	p := newTaskPool(context.Background(), 2)
+
+	var running, peak, done int32
+	release := make(chan struct{})
+	for i := 0; i < 6; i++ {
+		p.Go("work", func(context.Context) {
+			n := atomic.AddInt32(&running, 1)
+			for {
+				old := atomic.LoadInt32(&peak)
+				if n <= old || atomic.CompareAndSwapInt32(&peak, old, n) {
+					break
+				}
+			}
+			<-release
+			atomic.AddInt32(&running, -1)
+			atomic.AddInt32(&done, 1)
+		})
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	p.Close()
+	if err := p.Wait(context.Background()); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	if peak != 2 || done != 6 {
+		t.Errorf("peak concurrency %d, finished %d; want 2 and 6", peak, done)
+	}
+}
+
+func TestTaskPoolRejectsAfterClose(t *testing.T) {
+	p := newTaskPool(context.Background(), 1)
+	p.Close()
+	if p.Go("late", func(context.Context) { t.Error("task ran after Close") }) {
+		t.Error("Go accepted a task after Close")
+	}
+	if err := p.Wait(context.Background()); err != nil {
+		t.Errorf("Wait: %v", err)
+	}
+}
+
+func TestTaskPoolWaitDeadline(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := newTaskPool(ctx, 1)
+
+	stopped := make(chan struct{})
+	p.Go("slow", func(ctx context.Context) {
+		<-ctx.Done()
+		close(stopped)
+	})
+	p.Close()
+
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer waitCancel()
+	if err := p.Wait(waitCtx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Wait = %v, want deadline exceeded", err)
+	}
+
+	// Cancelling the pool's context tells the task to stop
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("task ignored cancellation")
+	}
+}
+
+func TestTaskPoolEveryStopsOnClose(t *testing.T) {
+	p := newTaskPool(context.Background(), 1)
+	var mu sync.Mutex
+	runs := 0
+	p.Every("tick", time.Millisecond, func(context.Context) {
+		mu.Lock()
+		runs++
+		mu.Unlock()
+	})
+
+	time.Sleep(20 * time.Millisecond)
+	p.Close()
+	if err := p.Wait(context.Background()); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if runs < 2 {
+		t.Errorf("periodic task ran %d times", runs)
+	}
+}
+
+func TestTaskPoolRecoversPanics(t *testing.T) {
+	p := newTaskPool(context.Background(), 1)
+	p.Go("boom", func(context.Context) { panic("boom") })
+	ran := false
+	p.Go("after", func(context.Context) { ran = true })
+	p.Close()
+	if err := p.Wait(context.Background()); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	if !ran {
+		t.Error("pool stopped working after a panic")
+	}
+}
@@ -0,0 +1,204 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/supabase-community/postgrest-go"
+)
+
+const (
+	// Old usernames keep redirecting (and stay unclaimable) for this long
+	usernameRedirectGrace = 30 * 24 * time.Hour
+	// At most usernameChangeLimit changes per usernameChangeWindow
+	usernameChangeLimit  = 2
+	usernameChangeWindow = 30 * 24 * time.Hour
+)
+
+var usernamePattern = regexp.MustCompile(`^[a-z0-9_]{3,30}$`)
+
+// reservedUsernames clash with frontend routes or could be used to
+// impersonate the service.
+var reservedUsernames = map[string]bool{
+	"about": true, "admin": true, "administrator": true, "api": true,
+	"auth": true, "bookmarks": true, "collections": true, "friends": true,
+	"help": true, "history": true, "inbox": true, "likes": true,
+	"login": true, "logout": true, "me": true, "messages": true,
+	"moderator": true, "null": true, "privacy": true, "profile": true,
+	"replied": true, "reply": true, "report": true, "root": true,
+	"search": true, "send": true, "settings": true, "setup": true,
+	"signup": true, "staff": true, "support": true, "system": true,
+	"terms": true, "undefined": true, "users": true, "www": true,
+}
+
+// normalizeUsername lowercases and trims a username. Usernames are unique
+// case-insensitively, so they are always stored lowercase.
+func normalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// validateUsername returns a user-facing reason if a normalized username is
+// not allowed, or "" if it is.
+func validateUsername(username string) string {
+	if !usernamePattern.MatchString(username) {
+		return "Usernames must be 3-30 characters: letters, numbers and underscores"
+	}
+	if reservedUsernames[username] {
+		return "This username is reserved"
+	}
+	return ""
+}
+
+// usernameAvailable reports whether a normalized, valid username can be
+// claimed by userID. It is taken if another profile uses it (ignoring case)
+// or another profile gave it up within the redirect grace period.
+func (s *Server) usernameAvailable(username, userID string) (bool, error) {
+	var owners []struct {
+		ID string `json:"id"`
+	}
+	_, err := s.db.From("profiles").
+		Select("id", "", false).
+		Ilike("username", likeEscape(username)).
+		ExecuteTo(&owners)
+	if err != nil {
+		return false, err
+	}
+	for _, o := range owners {
+		if o.ID != userID {
+			return false, nil
+		}
+	}
+
+	var held []struct {
+		ProfileID string `json:"profile_id"`
+	}
+	_, err = s.db.From("username_history").
+		Select("profile_id", "", false).
+		Eq("username", username).
+		Gte("changed_at", time.Now().Add(-usernameRedirectGrace).UTC().Format(time.RFC3339)).
+		ExecuteTo(&held)
+	if err != nil {
+		return false, err
+	}
+	for _, h := range held {
+		if h.ProfileID != userID {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// recentUsernameChanges counts a profile's username changes inside the
+// rate-limit window.
+func (s *Server) recentUsernameChanges(profileID string) (int, error) {
+	var changes []map[string]interface{}
+	_, err := s.db.From("username_history").
+		Select("id", "", false).
+		Eq("profile_id", profileID).
+		Gte("changed_at", time.Now().Add(-usernameChangeWindow).UTC().Format(time.RFC3339)).
+		ExecuteTo(&changes)
+	if err != nil {
+		return 0, err
+	}
+	return len(changes), nil
+}
+
+// renamedUsername finds the current username of a profile that used
+// oldUsername within the grace period, or "" if there is none.
+func (s *Server) renamedUsername(oldUsername string) (string, error) {
+	var history []struct {
+		Profile struct {
+			Username string `json:"username"`
+		} `json:"profile"`
+	}
+	_, err := s.db.From("username_history").
+		Select("profile:profiles!profile_id(username)", "", false).
+		Eq("username", normalizeUsername(oldUsername)).
+		Gte("changed_at", time.Now().Add(-usernameRedirectGrace).UTC().Format(time.RFC3339)).
+		Order("changed_at", &postgrest.OrderOpts{Ascending: false}).
+		Limit(1, "").
+		ExecuteTo(&history)
+	if err != nil || len(history) == 0 {
+		return "", err
+	}
+	return history[0].Profile.Username, nil
+}
+
+// checkUsernameChange decides whether userID may switch from oldUsername
+// (empty for a new profile) to the normalized newUsername. It returns the
+// error to reject the change with, or nil if it is allowed.
+func (s *Server) checkUsernameChange(userID, oldUsername, newUsername string) error {
+	if reason := validateUsername(newUsername); reason != "" {
+		return errValidation(reason)
+	}
+
+	available, err := s.usernameAvailable(newUsername, userID)
+	if err != nil {
+		return errInternal("Failed to check username", fmt.Errorf("checking username: %w", err))
+	}
+	if !available {
+		return errConflict("This username is already taken")
+	}
+
+	if oldUsername != "" {
+		changes, err := s.recentUsernameChanges(userID)
+		if err != nil {
+			return errInternal("Failed to check username", fmt.Errorf("checking username history: %w", err))
+		}
+		if changes >= usernameChangeLimit {
+			s.metrics.rateLimited.Inc("username_change")
+			return errRateLimited("You can only change your username twice every 30 days")
+		}
+	}
+
+	return nil
+}
+
+// recordUsernameChange keeps an old username so shared links can redirect.
+func (s *Server) recordUsernameChange(profileID, oldUsername string) {
+	_, _, err := s.db.From("username_history").
+		Insert(map[string]interface{}{
+			"profile_id": profileID,
+			"username":   oldUsername,
+		}, false, "", "minimal", "").
+		Execute()
+	if err != nil {
+		slog.Error("Failed to record username history", "error", err)
+	}
+}
+
+// isUniqueViolation reports whether a PostgREST error is a Postgres unique
+// constraint violation.
+func isUniqueViolation(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "23505")
+}
+
+func (s *Server) registerUsernameRoutes(r *gin.Engine) {
+	// Check whether a username can be claimed (by the caller, if logged in)
+	r.GET("/username/available", handle(func(c *gin.Context) error {
+		username := normalizeUsername(c.Query("username"))
+
+		if reason := validateUsername(username); reason != "" {
+			c.JSON(http.StatusOK, gin.H{"username": username, "available": false, "reason": reason})
+			return nil
+		}
+
+		available, err := s.usernameAvailable(username, s.optionalViewerID(c))
+		if err != nil {
+			return errInternal("Failed to check username", fmt.Errorf("checking username: %w", err))
+		}
+
+		result := gin.H{"username": username, "available": available}
+		if !available {
+			result["reason"] = "This username is already taken"
+		}
+		c.JSON(http.StatusOK, result)
+		return nil
+	}))
+}
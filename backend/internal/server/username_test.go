@@ -0,0 +1,33 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestUsernameAvailable(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.addUser("alice", nil)
+	bob := e.addUser("bob", nil)
+	e.db.insert("username_history", row{"profile_id": bob.ID, "username": "bobby"})
+
+	cases := []struct {
+		username, token string
+		available       bool
+	}{
+		{"newname", "", true},
+		{"ALICE", "", false},
+		{"alice", alice.Token, true}, // your own name
+		{"bobby", "", false},         // recently given up
+		{"bobby", bob.Token, true},   // ...but its old owner may take it back
+		{"admin", "", false},         // reserved
+		{"a!", "", false},            // invalid
+	}
+	for _, tc := range cases {
+		res := e.request("GET", "/username/available?username="+tc.username, tc.token, nil).
+			expect(http.StatusOK).object()
+		if res["available"] != tc.available {
+			t.Errorf("%s (token %v): available = %v, want %v (%v)", tc.username, tc.token != "", res["available"], tc.available, res["reason"])
+		}
+	}
+}
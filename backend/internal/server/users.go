@@ -0,0 +1,229 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/supabase-community/gotrue-go/types"
+	"github.com/supabase-community/postgrest-go"
+)
+
+const (
+	userSearchDefaultLimit = 10
+	userSearchMaxLimit     = 25
+	userSearchMaxQuery     = 50
+)
+
+// likeEscape escapes LIKE wildcards so user input only matches literally.
+func likeEscape(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return r.Replace(s)
+}
+
+// postgrestQuote wraps a value in double quotes for use inside PostgREST
+// logical filters (or/and), where commas and parentheses are reserved.
+func postgrestQuote(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	return `"` + r.Replace(s) + `"`
+}
+
+// userSearchTiers returns the PostgREST conditions for each rank of match,
+// best first: exact username, username prefix, display name prefix, prefix
+// of a later display name word, then username and display name substrings.
+// Each tier leaves out the earlier ones, so together they list every match
+// once and can be paged through in order.
+func userSearchTiers(query string) [][]string {
+	escaped := likeEscape(query)
+	exact := "username.eq." + postgrestQuote(query)
+	userPrefix := "username.ilike." + postgrestQuote(escaped+"%")
+	namePrefix := "display_name.ilike." + postgrestQuote(escaped+"%")
+	nameWord := "display_name.ilike." + postgrestQuote("% "+escaped+"%")
+	userSubstring := "username.ilike." + postgrestQuote("%"+escaped+"%")
+	nameSubstring := "display_name.ilike." + postgrestQuote("%"+escaped+"%")
+
+	// A negated condition must still match NULL columns, which SQL
+	// comparisons never do
+	not := func(condition string) string {
+		column, rest, _ := strings.Cut(condition, ".")
+		return fmt.Sprintf("or(%s.is.null,%s.not.%s)", column, column, rest)
+	}
+	return [][]string{
+		{exact},
+		{userPrefix, not(exact)},
+		{namePrefix, not(userPrefix)},
+		{nameWord, not(userPrefix), not(namePrefix)},
+		{userSubstring, not(userPrefix), not(namePrefix), not(nameWord)},
+		{nameSubstring, not(userSubstring), not(namePrefix), not(nameWord)},
+	}
+}
+
+// blockedUserIDs returns every user that has blocked, or been blocked by, userID.
+func (s *Server) blockedUserIDs(userID string) (map[string]bool, error) {
+	var blocks []struct {
+		BlockerID string `json:"blocker_id"`
+		BlockedID string `json:"blocked_id"`
+	}
+	_, err := s.db.From("user_blocks").
+		Select("blocker_id, blocked_id", "", false).
+		Or(fmt.Sprintf("blocker_id.eq.%s,blocked_id.eq.%s", userID, userID), "").
+		ExecuteTo(&blocks)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make(map[string]bool, len(blocks))
+	for _, b := range blocks {
+		if b.BlockerID == userID {
+			ids[b.BlockedID] = true
+		} else {
+			ids[b.BlockerID] = true
+		}
+	}
+	return ids, nil
+}
+
+func (s *Server) registerUserRoutes(r *gin.Engine, authMiddleware gin.HandlerFunc) {
+	// Search Users by username or display name
+	r.GET("/users/search", authMiddleware, handle(func(c *gin.Context) error {
+		user, _ := c.Get("user")
+		supabaseUser := user.(types.User)
+		me := supabaseUser.ID.String()
+
+		// PostgREST treats * as a wildcard too, so it is dropped from the query
+		query := strings.ToLower(strings.TrimSpace(strings.ReplaceAll(c.Query("q"), "*", "")))
+		if len([]rune(query)) < 2 {
+			c.JSON(http.StatusOK, []interface{}{})
+			return nil
+		}
+		if len([]rune(query)) > userSearchMaxQuery {
+			return errValidation("Search query is too long")
+		}
+
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(userSearchDefaultLimit)))
+		if err != nil || limit < 1 || limit > userSearchMaxLimit {
+			return errValidation("Invalid limit")
+		}
+		offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+		if err != nil || offset < 0 {
+			return errValidation("Invalid offset")
+		}
+
+		blocked, err := s.blockedUserIDs(me)
+		if err != nil {
+			return errInternal("Search failed", fmt.Errorf("fetching blocks: %w", err))
+		}
+
+		// Paused profiles, the caller and anyone on either side of a block
+		// are left out in the query, so counts and offsets stay exact
+		common := []string{"id.neq." + me, "is_paused.not.is.true"}
+		if len(blocked) > 0 {
+			ids := make([]string, 0, len(blocked))
+			for id := range blocked {
+				ids = append(ids, id)
+			}
+			sort.Strings(ids)
+			common = append(common, "id.not.in.("+strings.Join(ids, ",")+")")
+		}
+
+		// Walk the tiers best first, skipping whole tiers the offset passes
+		// and filling the page from the rest
+		type match struct {
+			ID          string `json:"id"`
+			Username    string `json:"username"`
+			DisplayName string `json:"display_name"`
+			AvatarURL   string `json:"avatar_url"`
+		}
+		var page []match
+		skip := offset
+		for _, tier := range userSearchTiers(query) {
+			conditions := append(append([]string{}, common...), tier...)
+			var matches []match
+			count, err := s.db.From("profiles").
+				Select("id, username, display_name, avatar_url", "exact", false).
+				Or("and("+strings.Join(conditions, ",")+")", "").
+				Order("username", &postgrest.OrderOpts{Ascending: true}).
+				Range(skip, skip+limit-len(page)-1, "").
+				ExecuteTo(&matches)
+			if err != nil {
+				return errInternal("Search failed", fmt.Errorf("searching users: %w", err))
+			}
+			page = append(page, matches...)
+			if len(page) == limit {
+				break
+			}
+			skip = max(0, skip-int(count))
+		}
+		if len(page) == 0 {
+			c.JSON(http.StatusOK, []interface{}{})
+			return nil
+		}
+
+		// Mark existing friendships and pending requests
+		ids := make([]string, len(page))
+		for i, u := range page {
+			ids[i] = u.ID
+		}
+		var friendships []struct {
+			ID         string `json:"id"`
+			SenderID   string `json:"sender_id"`
+			ReceiverID string `json:"receiver_id"`
+			Status     string `json:"status"`
+		}
+		list := strings.Join(ids, ",")
+		_, err = s.db.From("friendships").
+			Select("id, sender_id, receiver_id, status", "", false).
+			Or(fmt.Sprintf("and(sender_id.eq.%s,receiver_id.in.(%s)),and(receiver_id.eq.%s,sender_id.in.(%s))",
+				me, list, me, list), "").
+			ExecuteTo(&friendships)
+
+		if err != nil {
+			return errInternal("Search failed", fmt.Errorf("fetching friendships: %w", err))
+		}
+
+		type relation struct {
+			status       string
+			friendshipID string
+		}
+		relations := make(map[string]relation, len(friendships))
+		for _, f := range friendships {
+			switch {
+			case f.Status == "accepted":
+				other := f.SenderID
+				if other == me {
+					other = f.ReceiverID
+				}
+				relations[other] = relation{"friends", f.ID}
+			case f.SenderID == me:
+				relations[f.ReceiverID] = relation{"request_sent", f.ID}
+			default:
+				relations[f.SenderID] = relation{"request_received", f.ID}
+			}
+		}
+
+		users := make([]gin.H, 0, len(page))
+		for _, u := range page {
+			rel, ok := relations[u.ID]
+			if !ok {
+				rel.status = "none"
+			}
+			result := gin.H{
+				"id":                u.ID,
+				"username":          u.Username,
+				"display_name":      u.DisplayName,
+				"avatar_url":        u.AvatarURL,
+				"friendship_status": rel.status,
+			}
+			if rel.friendshipID != "" {
+				result["friendship_id"] = rel.friendshipID
+			}
+			users = append(users, result)
+		}
+
+		c.JSON(http.StatusOK, users)
+		return nil
+	}))
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestUserSearch(t *testing.T) {
+	e := newTestEnv(t)
+	me := e.addUser("me", nil)
+	e.addUser("sam", row{"display_name": "Samwise"})
+	samantha := e.addUser("samantha", nil)
+	e.addUser("jo", row{"display_name": "Sammy J"})
+	e.addUser("samuel", row{"is_paused": true})
+	blocker := e.addUser("sam_blocks", nil)
+	e.db.insert("user_blocks", row{"blocker_id": blocker.ID, "blocked_id": me.ID})
+	e.db.insert("friendships", row{"sender_id": me.ID, "receiver_id": samantha.ID, "status": "pending"})
+
+	if users := e.request("GET", "/users/search?q=s", me.Token, nil).expect(http.StatusOK).list(); len(users) != 0 {
+		t.Errorf("one-letter query returned %v", users)
+	}
+	e.request("GET", "/users/search?q=sam&limit=0", me.Token, nil).expect(http.StatusBadRequest)
+	e.request("GET", "/users/search?q=sam&offset=-1", me.Token, nil).expect(http.StatusBadRequest)
+
+	users := e.request("GET", "/users/search?q=SAM", me.Token, nil).expect(http.StatusOK).list()
+	var names []string
+	for _, u := range users {
+		names = append(names, u["username"].(string))
+	}
+	// Exact username first, then username prefixes, then display names;
+	// paused and blocking users are left out
+	want := []string{"sam", "samantha", "jo"}
+	if len(names) != len(want) {
+		t.Fatalf("results = %v, want %v", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("results = %v, want %v", names, want)
+		}
+	}
+	if users[1]["friendship_status"] != "request_sent" || users[0]["friendship_status"] != "none" {
+		t.Errorf("friendship status = %v / %v", users[0]["friendship_status"], users[1]["friendship_status"])
+	}
+
+	page := e.request("GET", "/users/search?q=sam&limit=1&offset=1", me.Token, nil).expect(http.StatusOK).list()
+	if len(page) != 1 || page[0]["username"] != "samantha" {
+		t.Errorf("second page = %v", page)
+	}
+}
+
+func TestUserSearchPagesThroughManyMatches(t *testing.T) {
+	e := newTestEnv(t)
+	me := e.addUser("me", nil)
+	for i := 0; i < 120; i++ {
+		e.db.insert("profiles", row{"id": fmt.Sprintf("00000000-0000-0000-0000-%012d", i), "username": fmt.Sprintf("x%03d_sam", i)})
+	}
+	e.addUser("samz", nil)
+
+	search := func(query string) []string {
+		var names []string
+		for _, u := range e.request("GET", "/users/search?q=sam&"+query, me.Token, nil).expect(http.StatusOK).list() {
+			names = append(names, u["username"].(string))
+		}
+		return names
+	}
+	// The prefix match ranks first however many substring matches there are
+	if got := search("limit=25"); len(got) != 25 || got[0] != "samz" || got[1] != "x000_sam" {
+		t.Errorf("first page = %v", got)
+	}
+	if got := search("limit=25&offset=110"); len(got) != 11 || got[0] != "x109_sam" || got[10] != "x119_sam" {
+		t.Errorf("last page = %v", got)
+	}
+	if got := search("offset=121"); len(got) != 0 {
+		t.Errorf("page past the end = %v", got)
+	}
+}
+
+func TestSearchHidesBlockedUsers(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.addUser("alice", nil)
+	bob := e.addUser("bob", nil)
+	e.db.insert("user_blocks", row{"blocker_id": alice.ID, "blocked_id": bob.ID})
+
+	// Neither side of a block finds the other
+	if users := e.request("GET", "/users/search?q=alice", bob.Token, nil).expect(http.StatusOK).list(); len(users) != 0 {
+		t.Errorf("blocked user can still find the blocker: %v", users)
+	}
+	if users := e.request("GET", "/users/search?q=bob", alice.Token, nil).expect(http.StatusOK).list(); len(users) != 0 {
+		t.Errorf("blocker can still find the blocked user: %v", users)
+	}
+}
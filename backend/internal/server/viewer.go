@@ -0,0 +1,245 @@
+package server
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/supabase-community/gotrue-go/types"
+)
+
+const viewerStateTTL = 5 * time.Minute
+
+// viewerState holds the flags that depend on who is looking at a page of
+// messages. Message flags are keyed by message ID, following by profile ID.
+type viewerState struct {
+	Liked      map[string]bool `json:"liked"`
+	Bookmarked map[string]bool `json:"bookmarked"`
+	// Sent marks the messages the viewer sent, so public pages can offer
+	// follow-ups without publishing sender IDs
+	Sent      map[string]bool `json:"sent"`
+	Following map[string]bool `json:"following"`
+}
+
+// bearerToken extracts the token from an "Authorization: Bearer <token>" header.
+func bearerToken(c *gin.Context) (string, bool) {
+	scheme, token, ok := strings.Cut(c.GetHeader("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// optionalViewer resolves the logged-in user for public routes. It returns
+// nil for anonymous callers or invalid tokens.
+func (s *Server) optionalViewer(c *gin.Context) *types.User {
+	token, ok := bearerToken(c)
+	if !ok {
+		return nil
+	}
+	userResponse, err := s.db.Auth.WithToken(token).GetUser()
+	if err != nil {
+		return nil
+	}
+	return &userResponse.User
+}
+
+// optionalViewerID is optionalViewer's ID, or "" for anonymous callers.
+func (s *Server) optionalViewerID(c *gin.Context) string {
+	if viewer := s.optionalViewer(c); viewer != nil {
+		return viewer.ID.String()
+	}
+	return ""
+}
+
+// stringField safely reads a string value out of a decoded JSON object.
+func stringField(m interface{}, key string) string {
+	obj, ok := m.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	s, _ := obj[key].(string)
+	return s
+}
+
+// viewerStateKey builds the cache key for a viewer and a page of messages.
+// The per-viewer version is bumped whenever their likes, bookmarks or
+// friendships change, which invalidates every cached page at once.
+func (s *Server) viewerStateKey(viewerID string, messageIDs, profileIDs []string) string {
+	version := "0"
+	if s.rdb != nil {
+		if v, err := s.rdb.Get(s.ctx, "viewerstate:ver:"+viewerID).Result(); err == nil {
+			version = v
+		}
+	}
+
+	ids := append(append([]string{}, messageIDs...), "|")
+	ids = append(ids, profileIDs...)
+	sum := sha1.Sum([]byte(strings.Join(ids, ",")))
+	return fmt.Sprintf("viewerstate:%s:%s:%s", viewerID, version, hex.EncodeToString(sum[:]))
+}
+
+// bumpViewerState invalidates all cached viewer state for a user.
+func (s *Server) bumpViewerState(viewerID string) {
+	if s.rdb == nil || viewerID == "" {
+		return
+	}
+	if err := s.rdb.Incr(s.ctx, "viewerstate:ver:"+viewerID).Err(); err != nil {
+		slog.Error("Redis error", "error", err)
+	}
+}
+
+// loadViewerState fetches the viewer's likes, bookmarks, sent messages and
+// follows scoped to the given message and profile IDs.
+func (s *Server) loadViewerState(viewerID string, messageIDs, profileIDs []string) (*viewerState, error) {
+	state := &viewerState{
+		Liked:      make(map[string]bool),
+		Bookmarked: make(map[string]bool),
+		Sent:       make(map[string]bool),
+		Following:  make(map[string]bool),
+	}
+	if viewerID == "" || (len(messageIDs) == 0 && len(profileIDs) == 0) {
+		return state, nil
+	}
+
+	key := s.viewerStateKey(viewerID, messageIDs, profileIDs)
+	if s.rdb != nil {
+		if cached, err := s.rdb.Get(s.ctx, key).Bytes(); err == nil {
+			if json.Unmarshal(cached, state) == nil {
+				return state, nil
+			}
+		}
+	}
+
+	if len(messageIDs) > 0 {
+		var likesData []struct {
+			MessageID string `json:"message_id"`
+		}
+		_, err := s.db.From("likes").
+			Select("message_id", "", false).
+			Eq("user_id", viewerID).
+			In("message_id", messageIDs).
+			ExecuteTo(&likesData)
+		if err != nil {
+			return nil, fmt.Errorf("fetch likes: %w", err)
+		}
+		for _, l := range likesData {
+			state.Liked[l.MessageID] = true
+		}
+
+		var bookmarksData []struct {
+			MessageID string `json:"message_id"`
+		}
+		_, err = s.db.From("bookmarks").
+			Select("message_id", "", false).
+			Eq("user_id", viewerID).
+			In("message_id", messageIDs).
+			ExecuteTo(&bookmarksData)
+		if err != nil {
+			return nil, fmt.Errorf("fetch bookmarks: %w", err)
+		}
+		for _, b := range bookmarksData {
+			state.Bookmarked[b.MessageID] = true
+		}
+
+		var sentData []struct {
+			ID string `json:"id"`
+		}
+		_, err = s.db.From("messages").
+			Select("id", "", false).
+			Eq("sender_id", viewerID).
+			In("id", messageIDs).
+			ExecuteTo(&sentData)
+		if err != nil {
+			return nil, fmt.Errorf("fetch sent messages: %w", err)
+		}
+		for _, m := range sentData {
+			state.Sent[m.ID] = true
+		}
+	}
+
+	if len(profileIDs) > 0 {
+		// Following means the viewer has sent a friend request (pending or
+		// accepted) or has accepted one from that profile.
+		list := strings.Join(profileIDs, ",")
+		var friendships []struct {
+			SenderID   string `json:"sender_id"`
+			ReceiverID string `json:"receiver_id"`
+		}
+		_, err := s.db.From("friendships").
+			Select("sender_id, receiver_id", "", false).
+			Or(fmt.Sprintf("and(sender_id.eq.%s,receiver_id.in.(%s)),and(receiver_id.eq.%s,sender_id.in.(%s),status.eq.accepted)",
+				viewerID, list, viewerID, list), "").
+			ExecuteTo(&friendships)
+		if err != nil {
+			return nil, fmt.Errorf("fetch friendships: %w", err)
+		}
+		for _, f := range friendships {
+			if f.SenderID == viewerID {
+				state.Following[f.ReceiverID] = true
+			} else {
+				state.Following[f.SenderID] = true
+			}
+		}
+	}
+
+	if s.rdb != nil {
+		if data, err := json.Marshal(state); err == nil {
+			if err := s.rdb.Set(s.ctx, key, data, viewerStateTTL).Err(); err != nil {
+				slog.Error("Redis error", "error", err)
+			}
+		}
+	}
+
+	return state, nil
+}
+
+// enrichForViewer sets is_liked, is_bookmarked, has_reacted, is_sender and
+// is_following on each decrypted message map. Messages that are not objects or have no ID
+// are left untouched.
+func (s *Server) enrichForViewer(viewerID string, messages []interface{}) error {
+	messageIDs := make([]string, 0, len(messages))
+	seenProfiles := make(map[string]bool)
+	profileIDs := make([]string, 0)
+	for _, m := range messages {
+		if id := stringField(m, "id"); id != "" {
+			messageIDs = append(messageIDs, id)
+		}
+		if rid := stringField(m, "receiver_id"); rid != "" && rid != viewerID && !seenProfiles[rid] {
+			seenProfiles[rid] = true
+			profileIDs = append(profileIDs, rid)
+		}
+	}
+	sort.Strings(messageIDs)
+	sort.Strings(profileIDs)
+
+	state, err := s.loadViewerState(viewerID, messageIDs, profileIDs)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range messages {
+		msgMap, ok := m.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		id := stringField(msgMap, "id")
+		if id == "" {
+			continue
+		}
+		msgMap["is_liked"] = state.Liked[id]
+		msgMap["is_bookmarked"] = state.Bookmarked[id]
+		msgMap["has_reacted"] = state.Liked[id] || state.Bookmarked[id]
+		msgMap["is_sender"] = state.Sent[id]
+		msgMap["is_following"] = state.Following[stringField(msgMap, "receiver_id")]
+	}
+
+	return nil
+}
@@ -0,0 +1,50 @@
+import { Metadata } from 'next';
+import { supabase } from '@/lib/supabase';
+import PublicProfileClient from '../profile-client';
+
+interface Props {
+    params: Promise<{ username: string; prompt: string }>;
+}
+
+export async function generateMetadata({ params }: Props): Promise<Metadata> {
+    const { username, prompt: slug } = await params;
+    const { data: profile } = await supabase
+        .from('profiles')
+        .select('*')
+        .eq('username', username)
+        .single();
+
+    if (!profile) return { title: 'User Not Found | Replied' };
+
+    const { data: prompt } = await supabase
+        .from('prompts')
+        .select('title, description')
+        .eq('profile_id', profile.id)
+        .eq('slug', slug.toLowerCase())
+        .single();
+
+    if (!prompt) return { title: `Message @${username} | Replied` };
+
+    const title = `${prompt.title} | @${username} on Replied`;
+    const description = prompt.description || `Send @${username} an anonymous answer.`;
+
+    return {
+        title,
+        description,
+        openGraph: {
+            title,
+            description,
+            images: [profile.avatar_url || '/og-image.png'],
+        },
+        twitter: {
+            card: 'summary_large_image',
+            title,
+            description,
+        }
+    };
+}
+
+export default async function Page({ params }: Props) {
+    const { username, prompt } = await params;
+    return <PublicProfileClient username={username} promptSlug={prompt} />;
+}
@@ -1,13 +1,15 @@
 'use client';
 
 import { useState, useEffect } from 'react';
+import { useRouter } from 'next/navigation';
 import { supabase } from '@/lib/supabase';
+import { solveSendChallenge } from '@/lib/challenge';
 import Image from 'next/image';
 import { Button } from '@/components/ui/button';
 import { Textarea } from '@/components/ui/textarea';
 import { toast } from 'sonner';
 import { motion, AnimatePresence } from 'framer-motion';
-import { Lock, Heart, Bookmark, Activity, ArrowRight, Star } from 'lucide-react';
+import { Lock, Heart, Bookmark, Activity, ArrowRight, Star, Pin } from 'lucide-react';
 import { LoadingScreen } from '@/components/loading-screen';
 import Link from 'next/link';
 
@@ -18,6 +20,40 @@ interface Profile {
     avatar_url?: string;
     bio?: string;
     is_paused: boolean;
+    who_can_send: 'anyone' | 'signed_in' | 'friends' | 'followers';
+    min_account_age_days: number;
+}
+
+type WhoCanSend = Profile['who_can_send'];
+
+interface Prompt {
+    id: string;
+    slug: string;
+    title: string;
+    description?: string;
+    opens_at?: string;
+    closes_at?: string;
+    who_can_send?: WhoCanSend;
+}
+
+// A prompt on the profile page, with the answered messages sent to it
+interface PromptGroup extends Prompt {
+    message_ids: string[];
+}
+
+// sendRequirement explains who the owner lets send, or null for anyone.
+function sendRequirement(profile: Profile): string | null {
+    const who = {
+        anyone: null,
+        signed_in: 'Only signed-in users can send',
+        friends: 'Only friends can send',
+        followers: 'Only followers can send',
+    }[profile.who_can_send] ?? null;
+    if (profile.min_account_age_days > 0) {
+        const days = profile.min_account_age_days === 1 ? '1 day' : `${profile.min_account_age_days} days`;
+        return `${who ?? 'Only signed-in users can send'}, from accounts at least ${days} old. You'll still be anonymous.`;
+    }
+    return who && `${who}. You'll still be anonymous.`;
 }
 
 interface Conversation {
@@ -25,7 +61,9 @@ interface Conversation {
     content: string;
     created_at: string;
     thread_id: string;
-    sender_id?: string;
+    prompt_id?: string;
+    pinned_at?: string | null;
+    is_sender?: boolean;
     replies: any;
     is_liked: boolean;
     is_bookmarked: boolean;
@@ -33,7 +71,8 @@ interface Conversation {
     bookmarks_count: number;
 }
 
-export default function PublicProfileClient({ username }: { username: string }) {
+export default function PublicProfileClient({ username, promptSlug }: { username: string; promptSlug?: string }) {
+    const router = useRouter();
     const [profile, setProfile] = useState<Profile | null>(null);
     const [publishedPairs, setPublishedPairs] = useState<Conversation[]>([]);
     const [message, setMessage] = useState('');
@@ -42,6 +81,9 @@ export default function PublicProfileClient({ username }: { username: string })
     const [minLoading, setMinLoading] = useState(true);
     const [currentUserId, setCurrentUserId] = useState<string | null>(null);
     const [replyingToThread, setReplyingToThread] = useState<string | null>(null);
+    const [prompts, setPrompts] = useState<PromptGroup[]>([]);
+    const [prompt, setPrompt] = useState<Prompt | null>(null);
+    const [promptStatus, setPromptStatus] = useState<'scheduled' | 'open' | 'closed'>('open');
 
     useEffect(() => {
         const timer = setTimeout(() => setMinLoading(false), 2000);
@@ -61,11 +103,25 @@ export default function PublicProfileClient({ username }: { username: string })
                 });
                 if (response.ok) {
                     const data = await response.json();
+                    // Renamed profiles redirect to their new username
+                    if (data.profile?.username && data.profile.username !== username) {
+                        router.replace(`/${data.profile.username}`);
+                    }
                     setProfile(data.profile);
                     setPublishedPairs(data.messages || []);
+                    setPrompts(data.prompts || []);
                 } else {
                     console.error('Failed to fetch profile from backend');
                 }
+
+                if (promptSlug) {
+                    const promptResponse = await fetch(`${process.env.NEXT_PUBLIC_BACKEND_URL || 'http://localhost:8080'}/profile/${username}/prompts/${promptSlug}`);
+                    if (promptResponse.ok) {
+                        const data = await promptResponse.json();
+                        setPrompt(data.prompt);
+                        setPromptStatus(data.status);
+                    }
+                }
             } catch (err) {
                 console.error('Error fetching profile from backend:', err);
             } finally {
@@ -73,7 +129,7 @@ export default function PublicProfileClient({ username }: { username: string })
             }
         }
         fetchData();
-    }, [username]);
+    }, [username, promptSlug, router]);
 
     const handleSubmit = async () => {
         if (!profile || !message.trim()) {
@@ -85,7 +141,10 @@ export default function PublicProfileClient({ username }: { username: string })
         const { data: { session } } = await supabase.auth.getSession();
 
         try {
-            const response = await fetch(`${process.env.NEXT_PUBLIC_BACKEND_URL || 'http://localhost:8080'}/send`, {
+            const backendUrl = process.env.NEXT_PUBLIC_BACKEND_URL || 'http://localhost:8080';
+            // Signed-in senders skip the anti-abuse challenge
+            const verification = session ? undefined : await solveSendChallenge(backendUrl, profile.id);
+            const response = await fetch(`${backendUrl}/send`, {
                 method: 'POST',
                 headers: {
                     'Content-Type': 'application/json',
@@ -94,7 +153,9 @@ export default function PublicProfileClient({ username }: { username: string })
                 body: JSON.stringify({
                     receiver_id: profile.id,
                     content: message,
-                    thread_id: replyingToThread || undefined
+                    thread_id: replyingToThread || undefined,
+                    prompt_id: prompt?.id,
+                    verification
                 })
             });
 
@@ -104,7 +165,7 @@ export default function PublicProfileClient({ username }: { username: string })
                 setReplyingToThread(null);
             } else {
                 const errData = await response.json();
-                toast.error(errData.error || 'Failed to send');
+                toast.error(errData.message || 'Failed to send');
             }
         } catch {
             toast.error('Connection error');
@@ -144,7 +205,7 @@ export default function PublicProfileClient({ username }: { username: string })
             if (!response.ok) {
                 setPublishedPairs(previousPairs);
                 const errData = await response.json();
-                toast.error(errData.error || `Failed to ${type}`);
+                toast.error(errData.message || `Failed to ${type}`);
             }
         } catch {
             setPublishedPairs(previousPairs);
@@ -155,6 +216,11 @@ export default function PublicProfileClient({ username }: { username: string })
     if (loading || minLoading) return <LoadingScreen />;
     if (!profile) return <div className="min-h-screen flex items-center justify-center text-4xl font-black bg-[#FF80FF] text-black uppercase">User Not Found</div>;
 
+    // On a prompt's page the record only shows that prompt's answers, and
+    // the prompt may narrow who can send
+    const visiblePairs = prompt ? publishedPairs.filter(p => p.prompt_id === prompt.id) : publishedPairs;
+    const requirement = sendRequirement({ ...profile, who_can_send: prompt?.who_can_send ?? profile.who_can_send });
+
     return (
         <div className="min-h-screen bg-[#1C7BFF] text-black selection:bg-[#D4FF00] selection:text-black font-sans pb-24">
             <nav className="fixed top-0 left-0 right-0 z-50 px-6 py-4 bg-[#D4FF00] border-b-4 border-black">
@@ -209,12 +275,32 @@ export default function PublicProfileClient({ username }: { username: string })
                             <h3 className="text-3xl md:text-4xl font-black uppercase tracking-tighter mb-4">Inbox Paused</h3>
                             <p className="text-xl font-bold uppercase">This user has temporarily paused new messages.</p>
                         </div>
+                    ) : prompt && promptStatus !== 'open' ? (
+                        <div className="py-12 flex flex-col items-center justify-center text-center">
+                            <Lock className="w-16 h-16 fill-black mb-6" />
+                            <h3 className="text-3xl md:text-4xl font-black uppercase tracking-tighter mb-4">{prompt.title}</h3>
+                            <p className="text-xl font-bold uppercase">
+                                {promptStatus === 'scheduled' && prompt.opens_at
+                                    ? `Opens ${new Date(prompt.opens_at).toLocaleString()}`
+                                    : 'This question box has closed.'}
+                            </p>
+                        </div>
                     ) : (
                         <div className="flex flex-col gap-6">
                             <h2 className="text-2xl md:text-4xl font-black uppercase tracking-tighter flex items-center gap-4">
                                 <Star className="w-8 h-8 fill-black" />
-                                {replyingToThread ? "FOLLOW-UP QUESTION" : "START A CONVERSATION"}
+                                {replyingToThread ? "FOLLOW-UP QUESTION" : prompt ? prompt.title : "START A CONVERSATION"}
                             </h2>
+
+                            {prompt?.description && !replyingToThread && (
+                                <p className="text-lg md:text-xl font-bold">{prompt.description}</p>
+                            )}
+
+                            {requirement && (
+                                <p className="text-lg font-bold uppercase flex items-center gap-2">
+                                    <Lock className="w-5 h-5 fill-black" /> {requirement}
+                                </p>
+                            )}
                             
                             <Textarea
                                 placeholder={replyingToThread ? "Type your follow-up anonymous question..." : "Ask me anything anonymously..."}
@@ -251,6 +337,25 @@ export default function PublicProfileClient({ username }: { username: string })
                     )}
                 </section>
 
+                {/* Question boxes: each prompt has its own link */}
+                {!prompt && prompts.length > 0 && (
+                    <section className="bg-white border-4 border-black p-6 md:p-8 shadow-[8px_8px_0px_0px_rgba(0,0,0,1)] space-y-4">
+                        <h2 className="text-2xl md:text-3xl font-black uppercase tracking-tighter">Question Boxes</h2>
+                        <div className="flex flex-col gap-3">
+                            {prompts.map(p => (
+                                <Link
+                                    key={p.id}
+                                    href={`/${profile.username}/${p.slug}`}
+                                    className="flex items-center justify-between gap-4 px-4 py-3 bg-[#D4FF00] border-4 border-black font-black uppercase shadow-[4px_4px_0px_0px_rgba(0,0,0,1)] hover:bg-black hover:text-[#D4FF00] transition-colors"
+                                >
+                                    <span className="truncate">{p.title}</span>
+                                    <span className="text-sm whitespace-nowrap">{p.message_ids.length} answered</span>
+                                </Link>
+                            ))}
+                        </div>
+                    </section>
+                )}
+
                 {/* Feed Block */}
                 <section>
                     <div className="bg-black text-white inline-block px-6 py-2 border-4 border-black mb-8">
@@ -259,23 +364,20 @@ export default function PublicProfileClient({ username }: { username: string })
 
                     <div className="space-y-12">
                         <AnimatePresence mode="popLayout">
-                            {publishedPairs.length === 0 ? (
+                            {visiblePairs.length === 0 ? (
                                 <p className="text-2xl font-bold uppercase p-8 bg-white border-4 border-black shadow-[8px_8px_0px_0px_rgba(0,0,0,1)] text-center">
                                     No conversations public yet.
                                 </p>
                             ) : (
                                 (() => {
                                     const threads: Record<string, Conversation[]> = {};
-                                    publishedPairs.forEach(pair => {
+                                    visiblePairs.forEach(pair => {
                                         if (!threads[pair.thread_id]) threads[pair.thread_id] = [];
                                         threads[pair.thread_id].push(pair);
                                     });
 
-                                    return Object.entries(threads).sort((a, b) => {
-                                        const latestA = new Date(Math.max(...a[1].map(m => new Date(m.created_at).getTime()))).getTime();
-                                        const latestB = new Date(Math.max(...b[1].map(m => new Date(m.created_at).getTime()))).getTime();
-                                        return latestB - latestA;
-                                    }).map(([threadId, messages], tIdx) => (
+                                    // Threads keep the server's order: pinned first, then the owner's chosen sort
+                                    return Object.entries(threads).map(([threadId, messages], tIdx) => (
                                         <motion.div
                                             key={threadId}
                                             initial={{ opacity: 0, y: 40 }}
@@ -284,6 +386,11 @@ export default function PublicProfileClient({ username }: { username: string })
                                             className="space-y-8 bg-white border-4 border-black p-6 md:p-8 shadow-[8px_8px_0px_0px_rgba(0,0,0,1)] relative"
                                         >
                                             <div className="absolute -top-4 -right-4 bg-[#FF80FF] border-2 border-black w-8 h-8 rounded-full flex items-center justify-center font-black">{tIdx + 1}</div>
+                                            {messages.some(m => m.pinned_at) && (
+                                                <div className="absolute -top-4 left-6 bg-[#D4FF00] border-2 border-black px-3 py-1 font-black uppercase text-xs flex items-center gap-1">
+                                                    <Pin className="w-3 h-3 fill-black" /> Pinned
+                                                </div>
+                                            )}
                                             {messages.sort((a, b) => new Date(a.created_at).getTime() - new Date(b.created_at).getTime()).map((pair, idx) => (
                                                 <div key={pair.id} className="space-y-6 border-b-4 border-dashed border-black pb-8 last:border-0 last:pb-0">
                                                     {/* Q */}
@@ -334,7 +441,7 @@ export default function PublicProfileClient({ username }: { username: string })
                                                 </div>
                                             ))}
 
-                                            {messages[0].sender_id === currentUserId && (
+                                            {messages[0].is_sender && (
                                                 <button
                                                     onClick={() => {
                                                         setReplyingToThread(threadId);
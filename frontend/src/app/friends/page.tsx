@@ -83,7 +83,7 @@ export default function FriendsPage() {
         setSearching(true);
         const { data: { session } } = await supabase.auth.getSession();
         try {
-            const resp = await fetch(`${process.env.NEXT_PUBLIC_BACKEND_URL || 'http://localhost:8080'}/users/search?q=${searchQuery}`, {
+            const resp = await fetch(`${process.env.NEXT_PUBLIC_BACKEND_URL || 'http://localhost:8080'}/users/search?q=${encodeURIComponent(searchQuery)}`, {
                 headers: { 'Authorization': `Bearer ${session?.access_token}` }
             });
             if (resp.ok) setSearchResults(await resp.json());
@@ -108,7 +108,7 @@ export default function FriendsPage() {
             if (resp.ok) toast.success('Request sent!');
             else {
                 const err = await resp.json();
-                toast.error(err.error || 'Failed to send request');
+                toast.error(err.message || 'Failed to send request');
             }
         } catch (err) {
             toast.error('Connection error');
@@ -9,7 +9,7 @@ import { Card, CardHeader, CardTitle, CardContent } from '@/components/ui/card';
 import { Textarea } from '@/components/ui/textarea';
 import { toast } from 'sonner';
 import { motion, AnimatePresence } from 'framer-motion';
-import { LogOut, Inbox as InboxIcon, MessageSquareQuote, SendHorizontal, Settings, Trash2, AlertTriangle, History, Archive, CheckCircle2, XCircle, Users, Bookmark, Heart, Activity, Clock, MessageSquare, User } from 'lucide-react';
+import { LogOut, Inbox as InboxIcon, MessageSquareQuote, SendHorizontal, Settings, Trash2, AlertTriangle, History, Archive, CheckCircle2, XCircle, Users, Bookmark, Heart, Activity, Clock, MessageSquare, User, Pin, Eye, EyeOff, Star, Mail, MailOpen, AlarmClock, Tag } from 'lucide-react';
 import { Dialog, DialogContent, DialogHeader, DialogTitle, DialogTrigger, DialogFooter, DialogDescription } from '@/components/ui/dialog';
 import { Tabs, TabsList, TabsTrigger } from '@/components/ui/tabs';
 import Link from 'next/link';
@@ -20,6 +20,12 @@ interface Message {
     content: string;
     created_at: string;
     status: 'pending' | 'replied' | 'archived';
+    pinned_at?: string | null;
+    is_hidden?: boolean;
+    read_at?: string | null;
+    is_starred?: boolean;
+    labels?: string[];
+    snoozed_until?: string | null;
     replies?: {
         content: string;
         created_at: string;
@@ -55,6 +61,14 @@ function InboxPage() {
     const [friends, setFriends] = useState<any[]>([]);
     const [userProfile, setUserProfile] = useState<any>(null);
     const [archiving, setArchiving] = useState<string | null>(null);
+    const [prompts, setPrompts] = useState<{ id: string; title: string }[]>([]);
+    // '' shows everything, 'none' the general inbox, otherwise a prompt ID
+    const [promptFilter, setPromptFilter] = useState('');
+    const [inboxFilter, setInboxFilter] = useState<'' | 'unread' | 'starred' | 'snoozed'>('');
+    const [labelFilter, setLabelFilter] = useState('');
+    const [unreadCount, setUnreadCount] = useState(0);
+    const [labeling, setLabeling] = useState<string | null>(null);
+    const [labelDraft, setLabelDraft] = useState('');
     const router = useRouter();
 
     useEffect(() => {
@@ -84,6 +98,8 @@ function InboxPage() {
         fetchLikes();
         fetchFriends();
         fetchUserProfile();
+        fetchPrompts();
+        fetchUnreadCount();
 
         // Subscribe to real-time updates for new messages
         const channel = supabase
@@ -100,6 +116,7 @@ function InboxPage() {
                     const newMessage = payload.new as Message;
                     if (newMessage && newMessage.status === 'pending') {
                         fetchMessages(); // Re-fetch to get decrypted content
+                        fetchUnreadCount();
                         toast('New message received!', {
                             icon: <InboxIcon className="w-4 h-4" />
                         });
@@ -113,12 +130,39 @@ function InboxPage() {
         };
     }, [user, authLoading, hasUsername, router]);
 
+    useEffect(() => {
+        if (user) fetchMessages();
+    }, [promptFilter, inboxFilter, labelFilter]);
+
+    const fetchPrompts = async () => {
+        const { data: { session } } = await supabase.auth.getSession();
+        if (!session) return;
+
+        try {
+            const response = await fetch(`${process.env.NEXT_PUBLIC_BACKEND_URL || 'http://localhost:8080'}/prompts`, {
+                headers: {
+                    'Authorization': `Bearer ${session.access_token}`
+                }
+            });
+            if (response.ok) {
+                setPrompts(await response.json());
+            }
+        } catch (err) {
+            console.error('Error fetching prompts:', err);
+        }
+    };
+
     const fetchMessages = async () => {
         const { data: { session } } = await supabase.auth.getSession();
         if (!session) return;
 
         try {
-            const response = await fetch(`${process.env.NEXT_PUBLIC_BACKEND_URL || 'http://localhost:8080'}/inbox`, {
+            const params = new URLSearchParams();
+            if (promptFilter) params.set('prompt_id', promptFilter);
+            if (inboxFilter) params.set(inboxFilter, 'true');
+            if (labelFilter) params.set('label', labelFilter);
+            const query = params.toString() ? `?${params}` : '';
+            const response = await fetch(`${process.env.NEXT_PUBLIC_BACKEND_URL || 'http://localhost:8080'}/inbox${query}`, {
                 headers: {
                     'Authorization': `Bearer ${session.access_token}`
                 }
@@ -136,6 +180,86 @@ function InboxPage() {
         }
     };
 
+    const fetchUnreadCount = async () => {
+        const { data: { session } } = await supabase.auth.getSession();
+        if (!session) return;
+
+        try {
+            const response = await fetch(`${process.env.NEXT_PUBLIC_BACKEND_URL || 'http://localhost:8080'}/inbox/unread-count`, {
+                headers: {
+                    'Authorization': `Bearer ${session.access_token}`
+                }
+            });
+            if (response.ok) {
+                const data = await response.json();
+                setUnreadCount(data.unread || 0);
+            }
+        } catch (err) {
+            console.error('Error fetching unread count:', err);
+        }
+    };
+
+    // Read, star or snooze a message; active undoes it
+    const handleOrganize = async (msg: Message, action: 'read' | 'star' | 'snooze', active: boolean, body?: object) => {
+        const { data: { session } } = await supabase.auth.getSession();
+
+        try {
+            const response = await fetch(`${process.env.NEXT_PUBLIC_BACKEND_URL || 'http://localhost:8080'}/messages/${msg.id}/${action}`, {
+                method: active ? 'DELETE' : 'POST',
+                headers: {
+                    'Content-Type': 'application/json',
+                    'Authorization': `Bearer ${session?.access_token}`
+                },
+                body: body ? JSON.stringify(body) : undefined
+            });
+
+            if (response.ok) {
+                if (action === 'snooze' && !active) toast.success('Snoozed');
+                fetchMessages();
+                fetchUnreadCount();
+            } else {
+                const errData = await response.json();
+                toast.error(errData.message || `Failed to ${action}`);
+            }
+        } catch {
+            toast.error('Connection error');
+        }
+    };
+
+    // Snooze until 9am, one day or one week from now
+    const snoozeFor = (msg: Message, days: number) => {
+        const until = new Date();
+        until.setDate(until.getDate() + days);
+        until.setHours(9, 0, 0, 0);
+        handleOrganize(msg, 'snooze', false, { until: until.toISOString() });
+    };
+
+    const handleLabels = async (msg: Message) => {
+        const { data: { session } } = await supabase.auth.getSession();
+        const labels = labelDraft.split(',').map(l => l.trim()).filter(Boolean);
+
+        try {
+            const response = await fetch(`${process.env.NEXT_PUBLIC_BACKEND_URL || 'http://localhost:8080'}/messages/${msg.id}/labels`, {
+                method: 'PUT',
+                headers: {
+                    'Content-Type': 'application/json',
+                    'Authorization': `Bearer ${session?.access_token}`
+                },
+                body: JSON.stringify({ labels })
+            });
+
+            if (response.ok) {
+                setLabeling(null);
+                fetchMessages();
+            } else {
+                const errData = await response.json();
+                toast.error(errData.message || 'Failed to save labels');
+            }
+        } catch {
+            toast.error('Connection error');
+        }
+    };
+
     const handleReply = async (messageId: string) => {
         if (!replyContent.trim()) return;
 
@@ -161,9 +285,10 @@ function InboxPage() {
                 setReplyingTo(null);
                 // Refresh inbox
                 setMessages(prev => prev.filter(m => m.id !== messageId));
+                fetchUnreadCount();
             } else {
                 const error = await response.json();
-                toast.error(error.error || 'Failed to publish');
+                toast.error(error.message || 'Failed to publish');
             }
         } catch {
             toast.error('Connection error');
@@ -193,6 +318,29 @@ function InboxPage() {
         }
     };
 
+    // Pin or hide an answer on the public profile; active undoes it
+    const handleShowcase = async (msg: Message, action: 'pin' | 'hide', active: boolean) => {
+        const { data: { session } } = await supabase.auth.getSession();
+
+        try {
+            const response = await fetch(`${process.env.NEXT_PUBLIC_BACKEND_URL || 'http://localhost:8080'}/messages/${msg.id}/${action}`, {
+                method: active ? 'DELETE' : 'POST',
+                headers: {
+                    'Authorization': `Bearer ${session?.access_token}`
+                }
+            });
+
+            if (response.ok) {
+                fetchHistory();
+            } else {
+                const errData = await response.json();
+                toast.error(errData.message || `Failed to ${action}`);
+            }
+        } catch {
+            toast.error('Connection error');
+        }
+    };
+
     const handleArchive = async (messageId: string) => {
         setArchiving(messageId);
         const { data: { session } } = await supabase.auth.getSession();
@@ -209,6 +357,7 @@ function InboxPage() {
                 toast.success('Message archived');
                 setMessages(prev => prev.filter(m => m.id !== messageId));
                 fetchHistory(); // Refresh history
+                fetchUnreadCount();
             } else {
                 toast.error('Failed to archive');
             }
@@ -319,6 +468,7 @@ function InboxPage() {
                 toast.success('Message deleted');
                 setMessages(prev => prev.filter(m => m.id !== messageId));
                 setHistoryMessages(prev => prev.filter(m => m.id !== messageId));
+                fetchUnreadCount();
             } else {
                 toast.error('Failed to delete');
             }
@@ -404,10 +554,51 @@ function InboxPage() {
                         >
                             {tab.icon}
                             {tab.label}
+                            {tab.id === 'inbox' && unreadCount > 0 && (
+                                <span className="bg-[#FF4040] text-black border-2 border-black px-2 text-xs">{unreadCount}</span>
+                            )}
                         </button>
                     ))}
                 </div>
 
+                {view === 'inbox' && (
+                    <div className="flex flex-wrap gap-3 mb-8">
+                        {[{ id: '', title: 'All' }, { id: 'unread', title: `Unread${unreadCount ? ` (${unreadCount})` : ''}` }, { id: 'starred', title: 'Starred' }, { id: 'snoozed', title: 'Snoozed' }].map(f => (
+                            <button
+                                key={f.id || 'all'}
+                                onClick={() => setInboxFilter(f.id as any)}
+                                className={`px-4 py-2 border-4 border-black font-black uppercase text-sm shadow-[4px_4px_0px_0px_rgba(0,0,0,1)] transition-colors ${inboxFilter === f.id ? 'bg-black text-[#FF80FF]' : 'bg-white text-black hover:bg-[#FF80FF]'}`}
+                            >
+                                {f.title}
+                            </button>
+                        ))}
+                        {labelFilter && (
+                            <button
+                                onClick={() => setLabelFilter('')}
+                                title="Clear label filter"
+                                className="px-4 py-2 border-4 border-black font-black uppercase text-sm shadow-[4px_4px_0px_0px_rgba(0,0,0,1)] bg-black text-[#FF80FF] flex items-center gap-2"
+                            >
+                                <Tag className="w-4 h-4" />
+                                {labelFilter} ×
+                            </button>
+                        )}
+                    </div>
+                )}
+
+                {view === 'inbox' && prompts.length > 0 && (
+                    <div className="flex flex-wrap gap-3 mb-8">
+                        {[{ id: '', title: 'Everything' }, { id: 'none', title: 'General' }, ...prompts].map(p => (
+                            <button
+                                key={p.id || 'all'}
+                                onClick={() => setPromptFilter(p.id)}
+                                className={`px-4 py-2 border-4 border-black font-black uppercase text-sm shadow-[4px_4px_0px_0px_rgba(0,0,0,1)] transition-colors ${promptFilter === p.id ? 'bg-black text-[#D4FF00]' : 'bg-white text-black hover:bg-[#D4FF00]'}`}
+                            >
+                                {p.title}
+                            </button>
+                        ))}
+                    </div>
+                )}
+
                 <div className="grid gap-8">
                     <AnimatePresence mode="wait">
                         {view === 'inbox' ? (
@@ -440,11 +631,83 @@ function InboxPage() {
                                         >
                                             <div className="bg-white border-4 border-black p-6 md:p-8 shadow-[8px_8px_0px_0px_rgba(0,0,0,1)] flex flex-col h-full">
                                                 <div className="flex flex-col sm:flex-row sm:items-start justify-between gap-4 mb-6">
-                                                    <div className="flex items-center gap-2 bg-black text-white px-3 py-1 font-bold uppercase text-sm border-2 border-black w-fit shadow-[4px_4px_0px_0px_rgba(28,123,255,1)]">
-                                                        <MessageSquareQuote className="w-4 h-4" />
-                                                        {new Date(msg.created_at).toLocaleDateString()}
+                                                    <div className="flex flex-wrap items-center gap-2">
+                                                        <div className="flex items-center gap-2 bg-black text-white px-3 py-1 font-bold uppercase text-sm border-2 border-black w-fit shadow-[4px_4px_0px_0px_rgba(28,123,255,1)]">
+                                                            <MessageSquareQuote className="w-4 h-4" />
+                                                            {new Date(msg.created_at).toLocaleDateString()}
+                                                        </div>
+                                                        {!msg.read_at && (
+                                                            <span className="bg-[#FF4040] text-black border-2 border-black px-2 py-1 font-black uppercase text-xs">New</span>
+                                                        )}
+                                                        {msg.snoozed_until && new Date(msg.snoozed_until) > new Date() && (
+                                                            <span className="bg-white text-black border-2 border-black px-2 py-1 font-black uppercase text-xs flex items-center gap-1">
+                                                                <AlarmClock className="w-3 h-3" />
+                                                                {new Date(msg.snoozed_until).toLocaleDateString()}
+                                                            </span>
+                                                        )}
+                                                        {msg.labels?.map(label => (
+                                                            <button
+                                                                key={label}
+                                                                onClick={() => setLabelFilter(label)}
+                                                                className="bg-[#FF80FF] text-black border-2 border-black px-2 py-1 font-black uppercase text-xs hover:bg-black hover:text-[#FF80FF] transition-colors"
+                                                            >
+                                                                {label}
+                                                            </button>
+                                                        ))}
                                                     </div>
-                                                    <div className="flex items-center gap-3 self-end sm:self-auto">
+                                                    <div className="flex flex-wrap items-center gap-3 self-end sm:self-auto">
+                                                        <button
+                                                            onClick={() => handleOrganize(msg, 'star', !!msg.is_starred)}
+                                                            title={msg.is_starred ? 'Unstar' : 'Star'}
+                                                            className={`border-4 border-black p-2 transition-colors shadow-[4px_4px_0px_0px_rgba(0,0,0,1)] ${msg.is_starred ? 'bg-black text-[#D4FF00]' : 'bg-white hover:bg-black hover:text-[#D4FF00]'}`}
+                                                        >
+                                                            <Star className={`w-5 h-5 ${msg.is_starred ? 'fill-[#D4FF00]' : ''}`} />
+                                                        </button>
+                                                        <button
+                                                            onClick={() => handleOrganize(msg, 'read', !!msg.read_at)}
+                                                            title={msg.read_at ? 'Mark unread' : 'Mark read'}
+                                                            className="bg-white hover:bg-black hover:text-white border-4 border-black p-2 transition-colors shadow-[4px_4px_0px_0px_rgba(0,0,0,1)]"
+                                                        >
+                                                            {msg.read_at ? <Mail className="w-5 h-5" /> : <MailOpen className="w-5 h-5" />}
+                                                        </button>
+                                                        {msg.snoozed_until && new Date(msg.snoozed_until) > new Date() ? (
+                                                            <button
+                                                                onClick={() => handleOrganize(msg, 'snooze', true)}
+                                                                title="Unsnooze"
+                                                                className="bg-black text-white border-4 border-black p-2 transition-colors shadow-[4px_4px_0px_0px_rgba(0,0,0,1)]"
+                                                            >
+                                                                <AlarmClock className="w-5 h-5" />
+                                                            </button>
+                                                        ) : (
+                                                            <>
+                                                                <button
+                                                                    onClick={() => snoozeFor(msg, 1)}
+                                                                    title="Snooze until tomorrow"
+                                                                    className="bg-white hover:bg-black hover:text-white border-4 border-black px-3 py-2 font-black uppercase text-xs flex items-center gap-1 transition-colors shadow-[4px_4px_0px_0px_rgba(0,0,0,1)]"
+                                                                >
+                                                                    <AlarmClock className="w-4 h-4" />
+                                                                    1D
+                                                                </button>
+                                                                <button
+                                                                    onClick={() => snoozeFor(msg, 7)}
+                                                                    title="Snooze for a week"
+                                                                    className="bg-white hover:bg-black hover:text-white border-4 border-black px-3 py-2 font-black uppercase text-xs flex items-center gap-1 transition-colors shadow-[4px_4px_0px_0px_rgba(0,0,0,1)]"
+                                                                >
+                                                                    <AlarmClock className="w-4 h-4" />
+                                                                    1W
+                                                                </button>
+                                                            </>
+                                                        )}
+                                                        <button
+                                                            onClick={() => {
+                                                                setLabeling(labeling === msg.id ? null : msg.id);
+                                                                setLabelDraft((msg.labels || []).join(', '));
+                                                            }}
+                                                            title="Edit labels"
+                                                            className="bg-white hover:bg-black hover:text-[#FF80FF] border-4 border-black p-2 transition-colors shadow-[4px_4px_0px_0px_rgba(0,0,0,1)]"
+                                                        >
+                                                            <Tag className="w-5 h-5" />
+                                                        </button>
                                                         <button
                                                             disabled={archiving === msg.id}
                                                             onClick={() => handleArchive(msg.id)}
@@ -485,6 +748,25 @@ function InboxPage() {
                                                     "{msg.content}"
                                                 </h2>
 
+                                                {labeling === msg.id && (
+                                                    <div className="flex flex-col sm:flex-row gap-4 mb-8">
+                                                        <input
+                                                            autoFocus
+                                                            value={labelDraft}
+                                                            onChange={(e) => setLabelDraft(e.target.value)}
+                                                            onKeyDown={(e) => e.key === 'Enter' && handleLabels(msg)}
+                                                            placeholder="LABELS, COMMA SEPARATED"
+                                                            className="flex-1 bg-white border-4 border-black px-4 py-3 font-black uppercase text-lg focus:outline-none focus:bg-[#FF80FF] placeholder:text-black/30"
+                                                        />
+                                                        <button
+                                                            onClick={() => handleLabels(msg)}
+                                                            className="sm:w-32 bg-black text-[#FF80FF] hover:text-white border-4 border-black py-3 font-black uppercase transition-colors shadow-[4px_4px_0px_0px_rgba(255,128,255,1)]"
+                                                        >
+                                                            Save
+                                                        </button>
+                                                    </div>
+                                                )}
+
                                                 <div className="mt-auto">
                                                     {replyingTo === msg.id ? (
                                                         <motion.div initial={{ opacity: 0, y: 10 }} animate={{ opacity: 1, y: 0 }} className="space-y-4">
@@ -565,6 +847,25 @@ function InboxPage() {
                                                             {new Date(msg.created_at).toLocaleDateString()}
                                                         </span>
                                                     </div>
+                                                    {msg.status === 'replied' && (
+                                                        <div className="flex gap-2 self-end sm:self-auto">
+                                                            <button
+                                                                onClick={() => handleShowcase(msg, 'pin', !!msg.pinned_at)}
+                                                                disabled={msg.is_hidden}
+                                                                title={msg.pinned_at ? 'Unpin from profile' : 'Pin to profile'}
+                                                                className={`border-4 border-black p-2 transition-colors shadow-[4px_4px_0px_0px_rgba(0,0,0,1)] disabled:opacity-30 ${msg.pinned_at ? 'bg-black text-[#D4FF00]' : 'bg-[#D4FF00] hover:bg-black hover:text-[#D4FF00]'}`}
+                                                            >
+                                                                <Pin className="w-5 h-5" />
+                                                            </button>
+                                                            <button
+                                                                onClick={() => handleShowcase(msg, 'hide', !!msg.is_hidden)}
+                                                                title={msg.is_hidden ? 'Show on profile' : 'Hide from profile'}
+                                                                className={`border-4 border-black p-2 transition-colors shadow-[4px_4px_0px_0px_rgba(0,0,0,1)] ${msg.is_hidden ? 'bg-black text-white' : 'bg-white hover:bg-black hover:text-white'}`}
+                                                            >
+                                                                {msg.is_hidden ? <Eye className="w-5 h-5" /> : <EyeOff className="w-5 h-5" />}
+                                                            </button>
+                                                        </div>
+                                                    )}
                                                     <button
                                                         onClick={() => handleDelete(msg.id)}
                                                         className="self-end sm:self-auto bg-[#FF4040] hover:bg-black hover:text-[#FF4040] border-4 border-black p-2 transition-colors shadow-[4px_4px_0px_0px_rgba(0,0,0,1)]"
@@ -9,7 +9,7 @@ import { Textarea } from '@/components/ui/textarea';
 import { Card, CardContent, CardHeader, CardTitle, CardDescription } from '@/components/ui/card';
 import { toast } from 'sonner';
 import { motion } from 'framer-motion';
-import { User, Shield, Share2, ArrowLeft, Copy, Check, QrCode, Lock, Ghost, X, AlertTriangle, Twitter, Instagram, MessageCircle, Camera, Loader2 } from 'lucide-react';
+import { User, Shield, Share2, ArrowLeft, Copy, Check, QrCode, Lock, Ghost, Users, ArrowUpDown, X, AlertTriangle, Twitter, Instagram, MessageCircle, Camera, Loader2 } from 'lucide-react';
 import Link from 'next/link';
 import Image from 'next/image';
 import { Dialog, DialogContent, DialogHeader, DialogTitle, DialogTrigger, DialogFooter, DialogDescription } from '@/components/ui/dialog';
@@ -17,6 +17,33 @@ import { Switch } from '@/components/ui/switch';
 import { useRouter } from 'next/navigation';
 import { LoadingScreen } from '@/components/loading-screen';
 
+interface PromptSettings {
+    id: string;
+    slug: string;
+    title: string;
+    closes_at?: string | null;
+}
+
+// slugify turns a prompt title into a link: "AMA about my job!" -> "ama-about-my-job"
+const slugify = (title: string) => title.toLowerCase().replace(/[^a-z0-9]+/g, '-').replace(/^-+|-+$/g, '').slice(0, 40);
+
+type ProfileSort = 'newest' | 'most_liked' | 'most_bookmarked';
+
+const PROFILE_SORT_OPTIONS: { value: ProfileSort; label: string }[] = [
+    { value: 'newest', label: 'Newest' },
+    { value: 'most_liked', label: 'Most liked' },
+    { value: 'most_bookmarked', label: 'Most saved' },
+];
+
+type WhoCanSend = 'anyone' | 'signed_in' | 'friends' | 'followers';
+
+const WHO_CAN_SEND_OPTIONS: { value: WhoCanSend; label: string }[] = [
+    { value: 'anyone', label: 'Anyone' },
+    { value: 'signed_in', label: 'Signed in' },
+    { value: 'friends', label: 'Friends' },
+    { value: 'followers', label: 'Followers' },
+];
+
 export default function SettingsPage() {
     const { user, hasUsername, loading: authLoading } = useAuth();
     const router = useRouter();
@@ -35,6 +62,8 @@ export default function SettingsPage() {
     const [loading, setLoading] = useState(true);
     const [minLoading, setMinLoading] = useState(true);
     const [saving, setSaving] = useState(false);
+    const [prompts, setPrompts] = useState<PromptSettings[]>([]);
+    const [newPrompt, setNewPrompt] = useState({ title: '', slug: '' });
     const [uploading, setUploading] = useState(false);
 
     useEffect(() => {
@@ -44,6 +73,11 @@ export default function SettingsPage() {
     const [deleting, setDeleting] = useState(false);
     const [hasCopied, setHasCopied] = useState(false);
     const [isDeleteDialogOpen, setIsDeleteDialogOpen] = useState(false);
+    const [deleteConfirm, setDeleteConfirm] = useState('');
+    const [exportStatus, setExportStatus] = useState<{ status: string; progress: number; step: string } | null>(null);
+    const [importing, setImporting] = useState(false);
+    const [importPreview, setImportPreview] = useState<{ id: string; imported: number; duplicates: number; invalid: number } | null>(null);
+    const [scheduledDeletion, setScheduledDeletion] = useState<{ scheduled_for: string } | null>(null);
 
     const [formData, setFormData] = useState({
         display_name: '',
@@ -51,7 +85,10 @@ export default function SettingsPage() {
         username: '',
         avatar_url: '',
         is_paused: false,
-        blocked_phrases: [] as string[]
+        blocked_phrases: [] as string[],
+        who_can_send: 'anyone' as WhoCanSend,
+        min_account_age_days: 0,
+        profile_sort: 'newest' as ProfileSort
     });
 
     useEffect(() => {
@@ -72,9 +109,30 @@ export default function SettingsPage() {
                         username: data.username || '',
                         avatar_url: data.avatar_url || '',
                         is_paused: data.is_paused || false,
-                        blocked_phrases: data.blocked_phrases || []
+                        blocked_phrases: data.blocked_phrases || [],
+                        who_can_send: data.who_can_send || 'anyone',
+                        min_account_age_days: data.min_account_age_days || 0,
+                        profile_sort: data.profile_sort || 'newest'
                     });
                 }
+
+                const deletionResponse = await fetch(`${process.env.NEXT_PUBLIC_BACKEND_URL || 'http://localhost:8080'}/profile/deletion`, {
+                    headers: {
+                        'Authorization': `Bearer ${session?.access_token}`
+                    }
+                });
+                if (deletionResponse.ok) {
+                    setScheduledDeletion(await deletionResponse.json());
+                }
+
+                const promptsResponse = await fetch(`${process.env.NEXT_PUBLIC_BACKEND_URL || 'http://localhost:8080'}/prompts`, {
+                    headers: {
+                        'Authorization': `Bearer ${session?.access_token}`
+                    }
+                });
+                if (promptsResponse.ok) {
+                    setPrompts(await promptsResponse.json());
+                }
             } catch (err) {
                 console.error('Failed to fetch profile:', err);
             } finally {
@@ -124,8 +182,8 @@ export default function SettingsPage() {
             toast.error('Please upload an image file');
             return;
         }
-        if (file.size > 2 * 1024 * 1024) {
-            toast.error('Image must be less than 2MB');
+        if (file.size > 5 * 1024 * 1024) {
+            toast.error('Image must be less than 5MB');
             return;
         }
 
@@ -134,27 +192,26 @@ export default function SettingsPage() {
         if (!session) return;
 
         try {
-            const fileExt = file.name.split('.').pop();
-            const fileName = `${Date.now()}.${fileExt}`;
-            const filePath = `${session.user.id}/${fileName}`;
+            // The backend validates, resizes and stores the image, then
+            // updates the profile with the new avatar URL
+            const body = new FormData();
+            body.append('avatar', file);
 
-            // 1. Upload to Supabase Storage
-            const { error: uploadError } = await supabase.storage
-                .from('avatars')
-                .upload(filePath, file);
-
-            if (uploadError) throw uploadError;
+            const response = await fetch(`${process.env.NEXT_PUBLIC_BACKEND_URL || 'http://localhost:8080'}/profile/avatar`, {
+                method: 'POST',
+                headers: {
+                    'Authorization': `Bearer ${session.access_token}`
+                },
+                body,
+            });
 
-            // 2. Get Public URL
-            const { data: { publicUrl } } = supabase.storage
-                .from('avatars')
-                .getPublicUrl(filePath);
+            const data = await response.json();
+            if (!response.ok) throw new Error(data.message || 'Failed to upload image');
 
-            // 3. Update local state
-            setFormData(prev => ({ ...prev, avatar_url: publicUrl }));
-            toast.success('Image uploaded! Remember to save changes.');
+            setFormData(prev => ({ ...prev, avatar_url: data.avatar_url }));
+            toast.success('Avatar updated!');
         } catch (error: any) {
-            console.error('Storage error:', error);
+            console.error('Upload error:', error);
             toast.error(error.message || 'Failed to upload image');
         } finally {
             setUploading(false);
@@ -183,24 +240,142 @@ export default function SettingsPage() {
         }
     };
 
+    const handleUpdateSendPolicy = async (changes: { who_can_send?: WhoCanSend; min_account_age_days?: number }) => {
+        const previous = { who_can_send: formData.who_can_send, min_account_age_days: formData.min_account_age_days };
+        setFormData(prev => ({ ...prev, ...changes }));
+
+        const { data: { session } } = await supabase.auth.getSession();
+        try {
+            const response = await fetch(`${process.env.NEXT_PUBLIC_BACKEND_URL || 'http://localhost:8080'}/profile`, {
+                method: 'PATCH',
+                headers: {
+                    'Content-Type': 'application/json',
+                    'Authorization': `Bearer ${session?.access_token}`
+                },
+                body: JSON.stringify(changes)
+            });
+
+            if (!response.ok) {
+                setFormData(prev => ({ ...prev, ...previous }));
+                const errData = await response.json();
+                toast.error(errData.message || 'Failed to update who can send');
+            }
+        } catch {
+            setFormData(prev => ({ ...prev, ...previous }));
+            toast.error('Connection error');
+        }
+    };
+
+    const promptRequest = async (path: string, method: string, body?: object) => {
+        const { data: { session } } = await supabase.auth.getSession();
+        return fetch(`${process.env.NEXT_PUBLIC_BACKEND_URL || 'http://localhost:8080'}${path}`, {
+            method,
+            headers: {
+                'Content-Type': 'application/json',
+                'Authorization': `Bearer ${session?.access_token}`
+            },
+            body: body ? JSON.stringify(body) : undefined
+        });
+    };
+
+    const handleCreatePrompt = async () => {
+        const title = newPrompt.title.trim();
+        const slug = newPrompt.slug.trim() || slugify(title);
+        if (!title || !slug) return;
+
+        try {
+            const response = await promptRequest('/prompts', 'POST', { title, slug });
+            const data = await response.json();
+            if (response.ok) {
+                setPrompts(prev => [data, ...prev]);
+                setNewPrompt({ title: '', slug: '' });
+                toast.success('Question box created');
+            } else {
+                toast.error(data.message || 'Failed to create question box');
+            }
+        } catch {
+            toast.error('Connection error');
+        }
+    };
+
+    // Closing sets closes_at to now; reopening clears it
+    const handleTogglePrompt = async (prompt: PromptSettings) => {
+        const closed = prompt.closes_at && new Date(prompt.closes_at) <= new Date();
+        try {
+            const response = await promptRequest(`/prompts/${prompt.id}`, 'PATCH', { closes_at: closed ? '' : new Date().toISOString() });
+            const data = await response.json();
+            if (response.ok) {
+                setPrompts(prev => prev.map(p => p.id === prompt.id ? data : p));
+            } else {
+                toast.error(data.message || 'Failed to update question box');
+            }
+        } catch {
+            toast.error('Connection error');
+        }
+    };
+
+    const handleDeletePrompt = async (promptId: string) => {
+        try {
+            const response = await promptRequest(`/prompts/${promptId}`, 'DELETE');
+            if (response.ok) {
+                setPrompts(prev => prev.filter(p => p.id !== promptId));
+            } else {
+                toast.error('Failed to delete question box');
+            }
+        } catch {
+            toast.error('Connection error');
+        }
+    };
+
+    const handleUpdateProfileSort = async (sort: ProfileSort) => {
+        const previous = formData.profile_sort;
+        setFormData(prev => ({ ...prev, profile_sort: sort }));
+
+        const { data: { session } } = await supabase.auth.getSession();
+        try {
+            const response = await fetch(`${process.env.NEXT_PUBLIC_BACKEND_URL || 'http://localhost:8080'}/profile`, {
+                method: 'PATCH',
+                headers: {
+                    'Content-Type': 'application/json',
+                    'Authorization': `Bearer ${session?.access_token}`
+                },
+                body: JSON.stringify({ profile_sort: sort })
+            });
+
+            if (!response.ok) {
+                setFormData(prev => ({ ...prev, profile_sort: previous }));
+                toast.error('Failed to update answer order');
+            }
+        } catch {
+            setFormData(prev => ({ ...prev, profile_sort: previous }));
+            toast.error('Connection error');
+        }
+    };
+
     const handleSave = async () => {
         setSaving(true);
         const { data: { session } } = await supabase.auth.getSession();
 
         try {
             const response = await fetch(`${process.env.NEXT_PUBLIC_BACKEND_URL || 'http://localhost:8080'}/profile`, {
-                method: 'PUT',
+                method: 'PATCH',
                 headers: {
                     'Content-Type': 'application/json',
                     'Authorization': `Bearer ${session?.access_token}`
                 },
-                body: JSON.stringify(formData)
+                body: JSON.stringify({
+                    display_name: formData.display_name,
+                    bio: formData.bio,
+                    username: formData.username,
+                    avatar_url: formData.avatar_url,
+                })
             });
 
             if (response.ok) {
                 toast.success('Profile updated successfully');
             } else {
-                toast.error('Failed to update profile');
+                const errData = await response.json();
+                toast.error(errData.message || 'Failed to update profile');
             }
         } catch {
             toast.error('Connection error');
@@ -210,13 +385,48 @@ export default function SettingsPage() {
     };
 
     const handleDeleteAccount = async () => {
-        setIsDeleteDialogOpen(false);
+        setDeleting(true);
+        const { data: { session } } = await supabase.auth.getSession();
 
+        try {
+            const response = await fetch(`${process.env.NEXT_PUBLIC_BACKEND_URL || 'http://localhost:8080'}/profile/deletion`, {
+                method: 'POST',
+                headers: {
+                    'Content-Type': 'application/json',
+                    'Authorization': `Bearer ${session?.access_token}`
+                },
+                body: JSON.stringify({ confirm_username: deleteConfirm })
+            });
+
+            const data = await response.json();
+            if (response.ok) {
+                setIsDeleteDialogOpen(false);
+                toast.success(`Account will be deleted on ${new Date(data.scheduled_for).toLocaleDateString()}. Sign in before then to undo.`);
+                await supabase.auth.signOut();
+                window.location.href = '/';
+            } else if (data.details?.reauth_required) {
+                // Deleting needs a fresh sign-in, not just a refreshed session
+                toast.error('Please sign in again, then delete your account from settings.');
+                await supabase.auth.signInWithOAuth({
+                    provider: 'google',
+                    options: { redirectTo: `${window.location.origin}/auth/callback` }
+                });
+            } else {
+                toast.error(data.message || 'Failed to delete account');
+            }
+        } catch {
+            toast.error('Connection error');
+        } finally {
+            setDeleting(false);
+        }
+    };
+
+    const handleCancelDeletion = async () => {
         setDeleting(true);
         const { data: { session } } = await supabase.auth.getSession();
 
         try {
-            const response = await fetch(`${process.env.NEXT_PUBLIC_BACKEND_URL || 'http://localhost:8080'}/profile`, {
+            const response = await fetch(`${process.env.NEXT_PUBLIC_BACKEND_URL || 'http://localhost:8080'}/profile/deletion`, {
                 method: 'DELETE',
                 headers: {
                     'Authorization': `Bearer ${session?.access_token}`
@@ -224,11 +434,11 @@ export default function SettingsPage() {
             });
 
             if (response.ok) {
-                toast.success('Account deleted. Goodbye!');
-                await supabase.auth.signOut();
-                window.location.href = '/';
+                setScheduledDeletion(null);
+                toast.success('Account deletion cancelled. Welcome back!');
             } else {
-                toast.error('Failed to delete account');
+                const errData = await response.json();
+                toast.error(errData.message || 'Failed to cancel deletion');
             }
         } catch {
             toast.error('Connection error');
@@ -237,6 +447,127 @@ export default function SettingsPage() {
         }
     };
 
+    const handleExport = async () => {
+        const { data: { session } } = await supabase.auth.getSession();
+        const backendUrl = process.env.NEXT_PUBLIC_BACKEND_URL || 'http://localhost:8080';
+        const headers = { 'Authorization': `Bearer ${session?.access_token}` };
+
+        try {
+            const response = await fetch(`${backendUrl}/profile/export`, { method: 'POST', headers });
+            let job = await response.json();
+            if (response.status === 409) {
+                // An export is already running; follow that one instead
+                job = job.details.export;
+            } else if (!response.ok) {
+                toast.error(job.message || 'Failed to start export');
+                return;
+            }
+
+            // Poll until the archive is built
+            while (job.status === 'queued' || job.status === 'running') {
+                setExportStatus(job);
+                await new Promise(resolve => setTimeout(resolve, 2000));
+                const statusResponse = await fetch(`${backendUrl}/profile/export/${job.id}`, { headers });
+                job = await statusResponse.json();
+                if (!statusResponse.ok) throw new Error(job.message);
+            }
+
+            if (job.status === 'ready' && job.download_url) {
+                toast.success('Your data is ready!');
+                window.location.href = job.download_url;
+            } else {
+                toast.error(job.error || 'Export failed');
+            }
+        } catch {
+            toast.error('Connection error');
+        } finally {
+            setExportStatus(null);
+        }
+    };
+
+    // Polls an import job until it stops running
+    const waitForImport = async (job: any, headers: Record<string, string>) => {
+        const backendUrl = process.env.NEXT_PUBLIC_BACKEND_URL || 'http://localhost:8080';
+        while (job.status === 'queued' || job.status === 'running') {
+            await new Promise(resolve => setTimeout(resolve, 1500));
+            const response = await fetch(`${backendUrl}/profile/import/${job.id}`, { headers });
+            job = await response.json();
+            if (!response.ok) throw new Error(job.message);
+        }
+        return job;
+    };
+
+    const handleImportFile = async (e: React.ChangeEvent<HTMLInputElement>) => {
+        const file = e.target.files?.[0];
+        e.target.value = '';
+        if (!file) return;
+
+        setImporting(true);
+        setImportPreview(null);
+        const { data: { session } } = await supabase.auth.getSession();
+        const headers = { 'Authorization': `Bearer ${session?.access_token}` };
+
+        try {
+            // Dry run first so the user sees what would be imported
+            const body = new FormData();
+            body.append('file', file);
+            body.append('dry_run', 'true');
+
+            const response = await fetch(`${process.env.NEXT_PUBLIC_BACKEND_URL || 'http://localhost:8080'}/profile/import`, {
+                method: 'POST',
+                headers,
+                body,
+            });
+            const data = await response.json();
+            if (!response.ok) {
+                toast.error(data.message || 'Failed to read file');
+                return;
+            }
+
+            const job = await waitForImport(data, headers);
+            if (job.status === 'completed') {
+                setImportPreview(job);
+            } else {
+                toast.error(job.error || 'Failed to read file');
+            }
+        } catch {
+            toast.error('Connection error');
+        } finally {
+            setImporting(false);
+        }
+    };
+
+    const handleCommitImport = async () => {
+        if (!importPreview) return;
+        setImporting(true);
+        const { data: { session } } = await supabase.auth.getSession();
+        const headers = { 'Authorization': `Bearer ${session?.access_token}` };
+
+        try {
+            const response = await fetch(`${process.env.NEXT_PUBLIC_BACKEND_URL || 'http://localhost:8080'}/profile/import/${importPreview.id}/commit`, {
+                method: 'POST',
+                headers,
+            });
+            const data = await response.json();
+            if (!response.ok) {
+                toast.error(data.message || 'Failed to import');
+                return;
+            }
+
+            const job = await waitForImport(data, headers);
+            if (job.status === 'completed') {
+                toast.success(`Imported ${job.imported} answers!`);
+                setImportPreview(null);
+            } else {
+                toast.error(job.error || 'Failed to import');
+            }
+        } catch {
+            toast.error('Connection error');
+        } finally {
+            setImporting(false);
+        }
+    };
+
     const copyLink = () => {
         const link = `${window.location.origin}/${formData.username}`;
         navigator.clipboard.writeText(link);
@@ -339,6 +670,99 @@ export default function SettingsPage() {
                     </div>
                 </section>
 
+                {/* Answer Order Block */}
+                <section className="bg-white border-4 border-black p-6 md:p-8 shadow-[8px_8px_0px_0px_rgba(0,0,0,1)]">
+                    <div className="flex items-center gap-2 mb-6">
+                        <ArrowUpDown className="w-8 h-8" />
+                        <h2 className="text-2xl md:text-3xl font-black uppercase tracking-tighter">Answer Order</h2>
+                    </div>
+                    <p className="text-lg font-bold mb-6">Pinned answers always come first. Pin or hide answers from your history.</p>
+                    <div className="grid grid-cols-1 md:grid-cols-3 gap-3">
+                        {PROFILE_SORT_OPTIONS.map(({ value, label }) => (
+                            <button
+                                key={value}
+                                onClick={() => handleUpdateProfileSort(value)}
+                                className={`px-4 py-3 border-4 border-black font-black uppercase shadow-[4px_4px_0px_0px_rgba(0,0,0,1)] transition-colors ${formData.profile_sort === value ? 'bg-black text-[#D4FF00]' : 'bg-[#D4FF00] text-black hover:bg-black hover:text-[#D4FF00]'}`}
+                            >
+                                {label}
+                            </button>
+                        ))}
+                    </div>
+                </section>
+
+                {/* Question Boxes Block */}
+                <section className="bg-white border-4 border-black p-6 md:p-8 shadow-[8px_8px_0px_0px_rgba(0,0,0,1)]">
+                    <div className="flex items-center gap-2 mb-6">
+                        <MessageCircle className="w-8 h-8 fill-black" />
+                        <h2 className="text-2xl md:text-3xl font-black uppercase tracking-tighter">Question Boxes</h2>
+                    </div>
+                    <p className="text-lg font-bold mb-6">Themed boxes like &quot;AMA about my job&quot;, each with its own link.</p>
+
+                    <div className="flex flex-col md:flex-row gap-4 mb-6">
+                        <Input
+                            placeholder="Title..."
+                            value={newPrompt.title}
+                            maxLength={100}
+                            onChange={(e) => setNewPrompt(prev => ({ ...prev, title: e.target.value }))}
+                            className="flex-1 border-4 border-black bg-[#D4FF00] h-14 rounded-none text-xl font-bold shadow-inner placeholder:text-black/50"
+                        />
+                        <Input
+                            placeholder={slugify(newPrompt.title) || 'link'}
+                            value={newPrompt.slug}
+                            maxLength={40}
+                            onChange={(e) => setNewPrompt(prev => ({ ...prev, slug: e.target.value.toLowerCase() }))}
+                            className="md:w-48 border-4 border-black bg-white h-14 rounded-none text-xl font-bold shadow-inner placeholder:text-black/30"
+                        />
+                        <Button
+                            onClick={handleCreatePrompt}
+                            disabled={!newPrompt.title.trim()}
+                            className="h-14 bg-black text-white hover:bg-[#D4FF00] hover:text-black border-4 border-black rounded-none font-black uppercase text-lg px-8"
+                        >
+                            Create
+                        </Button>
+                    </div>
+
+                    <div className="space-y-3">
+                        {prompts.map(prompt => {
+                            const closed = !!prompt.closes_at && new Date(prompt.closes_at) <= new Date();
+                            return (
+                                <div key={prompt.id} className="flex flex-col md:flex-row md:items-center justify-between gap-3 p-4 border-4 border-black shadow-[4px_4px_0px_0px_rgba(0,0,0,1)]">
+                                    <div className="min-w-0">
+                                        <p className="text-xl font-black uppercase truncate">{prompt.title}</p>
+                                        <p className="font-bold opacity-60 truncate">/{formData.username}/{prompt.slug}{closed ? ' · closed' : ''}</p>
+                                    </div>
+                                    <div className="flex gap-2">
+                                        <button
+                                            onClick={() => {
+                                                navigator.clipboard.writeText(`${window.location.origin}/${formData.username}/${prompt.slug}`);
+                                                toast.success('Link copied');
+                                            }}
+                                            className="p-2 border-4 border-black bg-[#D4FF00] hover:bg-black hover:text-[#D4FF00] transition-colors"
+                                            aria-label="Copy link"
+                                        >
+                                            <Copy className="w-5 h-5" />
+                                        </button>
+                                        <button
+                                            onClick={() => handleTogglePrompt(prompt)}
+                                            className="px-3 border-4 border-black bg-white hover:bg-black hover:text-white font-black uppercase text-sm transition-colors"
+                                        >
+                                            {closed ? 'Reopen' : 'Close'}
+                                        </button>
+                                        <button
+                                            onClick={() => handleDeletePrompt(prompt.id)}
+                                            className="p-2 border-4 border-black bg-[#FF80FF] hover:bg-black hover:text-[#FF80FF] transition-colors"
+                                            aria-label="Delete question box"
+                                        >
+                                            <X className="w-5 h-5" />
+                                        </button>
+                                    </div>
+                                </div>
+                            );
+                        })}
+                        {prompts.length === 0 && <span className="font-bold text-xl uppercase opacity-50">None yet</span>}
+                    </div>
+                </section>
+
                 {/* Moderation Block */}
                 <section className="bg-[#FF80FF] border-4 border-black p-6 md:p-8 shadow-[8px_8px_0px_0px_rgba(0,0,0,1)]">
                     <div className="flex items-center gap-2 mb-6 text-black">
@@ -361,6 +785,49 @@ export default function SettingsPage() {
                             />
                         </div>
 
+                        <div className="p-6 bg-white border-4 border-black shadow-[4px_4px_0px_0px_rgba(0,0,0,1)] space-y-6">
+                            <div className="space-y-2">
+                                <h3 className="text-2xl font-black uppercase flex items-center gap-2">
+                                    <Users className="w-6 h-6 fill-black" /> Who Can Send
+                                </h3>
+                                <p className="text-lg font-bold">Signed-in senders still show up as anonymous.</p>
+                            </div>
+
+                            <div className="grid grid-cols-2 md:grid-cols-4 gap-3">
+                                {WHO_CAN_SEND_OPTIONS.map(({ value, label }) => (
+                                    <button
+                                        key={value}
+                                        onClick={() => handleUpdateSendPolicy({ who_can_send: value })}
+                                        className={`px-4 py-3 border-4 border-black font-black uppercase shadow-[4px_4px_0px_0px_rgba(0,0,0,1)] transition-colors ${formData.who_can_send === value ? 'bg-black text-[#D4FF00]' : 'bg-[#D4FF00] text-black hover:bg-black hover:text-[#D4FF00]'}`}
+                                    >
+                                        {label}
+                                    </button>
+                                ))}
+                            </div>
+
+                            <div className="flex flex-col md:flex-row md:items-center gap-4">
+                                <label htmlFor="min-account-age" className="text-lg font-bold uppercase">Minimum account age (days)</label>
+                                <Input
+                                    id="min-account-age"
+                                    type="number"
+                                    min={0}
+                                    max={365}
+                                    defaultValue={formData.min_account_age_days}
+                                    key={formData.min_account_age_days}
+                                    className="md:w-32 border-4 border-black bg-[#D4FF00] h-14 rounded-none text-xl font-bold shadow-inner"
+                                    onBlur={(e) => {
+                                        const days = Math.max(0, Math.min(365, Math.floor(Number(e.currentTarget.value) || 0)));
+                                        if (days !== formData.min_account_age_days) {
+                                            handleUpdateSendPolicy({ min_account_age_days: days });
+                                        }
+                                    }}
+                                />
+                            </div>
+                            {formData.min_account_age_days > 0 && formData.who_can_send === 'anyone' && (
+                                <p className="font-bold opacity-70">Anonymous senders can&apos;t meet an age requirement, so only signed-in users can send.</p>
+                            )}
+                        </div>
+
                         <div className="p-6 bg-white border-4 border-black shadow-[4px_4px_0px_0px_rgba(0,0,0,1)] space-y-6">
                             <div className="space-y-2">
                                 <h3 className="text-2xl font-black uppercase flex items-center gap-2">
@@ -484,6 +951,57 @@ export default function SettingsPage() {
                     </div>
                 </section>
 
+                {/* Your Data */}
+                <section className="bg-white border-4 border-black p-6 md:p-8 shadow-[8px_8px_0px_0px_rgba(0,0,0,1)] mb-12">
+                    <h2 className="text-2xl md:text-3xl font-black uppercase tracking-tighter mb-4">YOUR DATA</h2>
+                    <p className="font-bold mb-6">
+                        Download everything: your profile, messages, replies, likes, bookmarks and friends as JSON with a readable HTML copy.
+                    </p>
+                    <button
+                        disabled={exportStatus !== null}
+                        onClick={handleExport}
+                        className="w-full bg-[#D4FF00] text-black hover:bg-black hover:text-white border-4 border-black h-16 text-2xl font-black uppercase tracking-widest transition-colors shadow-[4px_4px_0px_0px_rgba(0,0,0,1)] disabled:opacity-50"
+                    >
+                        {exportStatus ? `${exportStatus.step.toUpperCase()}... ${exportStatus.progress}%` : 'EXPORT MY DATA'}
+                    </button>
+                </section>
+
+                {/* Import */}
+                <section className="bg-white border-4 border-black p-6 md:p-8 shadow-[8px_8px_0px_0px_rgba(0,0,0,1)] mb-12">
+                    <h2 className="text-2xl md:text-3xl font-black uppercase tracking-tighter mb-4">IMPORT Q&amp;A</h2>
+                    <p className="font-bold mb-6">
+                        Bring your answered questions from NGL, Tellonym or CuriousCat. Upload a JSON or CSV file with question, answer and timestamp columns.
+                    </p>
+                    {importPreview ? (
+                        <div className="space-y-4">
+                            <p className="text-xl font-black uppercase">
+                                {importPreview.imported} NEW · {importPreview.duplicates} ALREADY HERE · {importPreview.invalid} INVALID
+                            </p>
+                            <div className="flex flex-col sm:flex-row gap-4">
+                                <button
+                                    onClick={() => setImportPreview(null)}
+                                    disabled={importing}
+                                    className="flex-1 bg-white hover:bg-black hover:text-white border-4 border-black py-4 text-xl font-black uppercase transition-colors shadow-[4px_4px_0px_0px_rgba(0,0,0,1)]"
+                                >
+                                    CANCEL
+                                </button>
+                                <button
+                                    onClick={handleCommitImport}
+                                    disabled={importing || importPreview.imported === 0}
+                                    className="flex-1 bg-[#D4FF00] hover:bg-black hover:text-white border-4 border-black py-4 text-xl font-black uppercase transition-colors shadow-[4px_4px_0px_0px_rgba(0,0,0,1)] disabled:opacity-50"
+                                >
+                                    {importing ? 'IMPORTING...' : 'IMPORT'}
+                                </button>
+                            </div>
+                        </div>
+                    ) : (
+                        <label className="w-full flex items-center justify-center bg-[#D4FF00] text-black hover:bg-black hover:text-white border-4 border-black h-16 text-2xl font-black uppercase tracking-widest transition-colors shadow-[4px_4px_0px_0px_rgba(0,0,0,1)] cursor-pointer">
+                            {importing ? 'CHECKING...' : 'CHOOSE FILE'}
+                            <input type="file" accept=".json,.csv,application/json,text/csv" className="hidden" disabled={importing} onChange={handleImportFile} />
+                        </label>
+                    )}
+                </section>
+
                 {/* Danger Zone */}
                 <section className="bg-[#FF4040] border-4 border-black p-6 md:p-8 shadow-[8px_8px_0px_0px_rgba(0,0,0,1)] mb-12">
                     <div className="flex items-center gap-2 mb-6">
@@ -491,6 +1009,20 @@ export default function SettingsPage() {
                         <h2 className="text-2xl md:text-3xl font-black uppercase tracking-tighter">DANGER ZONE</h2>
                     </div>
                     
+                    {scheduledDeletion ? (
+                        <div className="space-y-6">
+                            <p className="text-xl font-bold uppercase leading-tight">
+                                YOUR ACCOUNT WILL BE DELETED ON {new Date(scheduledDeletion.scheduled_for).toLocaleDateString()}.
+                            </p>
+                            <button
+                                disabled={deleting}
+                                onClick={handleCancelDeletion}
+                                className="w-full bg-white text-black hover:bg-black hover:text-white border-4 border-black h-16 text-2xl font-black uppercase tracking-widest transition-colors shadow-[4px_4px_0px_0px_rgba(0,0,0,1)]"
+                            >
+                                {deleting ? 'CANCELLING...' : 'CANCEL DELETION'}
+                            </button>
+                        </div>
+                    ) : (
                     <Dialog open={isDeleteDialogOpen} onOpenChange={setIsDeleteDialogOpen}>
                         <DialogTrigger asChild>
                             <button
@@ -506,8 +1038,14 @@ export default function SettingsPage() {
                             </DialogHeader>
                             <div className="p-8 space-y-8 bg-white text-black">
                                 <p className="text-2xl font-bold uppercase leading-tight">
-                                    THIS WILL DELETE EVERYTHING. CANNOT UNDO.
+                                    THIS WILL DELETE EVERYTHING. YOU CAN UNDO IT FOR A FEW DAYS, THEN IT&apos;S GONE.
                                 </p>
+                                <Input
+                                    value={deleteConfirm}
+                                    onChange={(e) => setDeleteConfirm(e.target.value)}
+                                    placeholder={`TYPE ${formData.username.toUpperCase()} TO CONFIRM`}
+                                    className="border-4 border-black rounded-none h-14 text-lg font-bold"
+                                />
                                 <div className="flex flex-col sm:flex-row gap-4">
                                     <button
                                         onClick={() => setIsDeleteDialogOpen(false)}
@@ -517,7 +1055,7 @@ export default function SettingsPage() {
                                     </button>
                                     <button
                                         onClick={handleDeleteAccount}
-                                        disabled={deleting}
+                                        disabled={deleting || deleteConfirm.trim().toLowerCase() !== formData.username}
                                         className="flex-1 bg-red-600 hover:bg-black text-white border-4 border-black py-4 text-xl font-black uppercase transition-colors shadow-[4px_4px_0px_0px_rgba(0,0,0,1)]"
                                     >
                                         {deleting ? 'DELETING...' : 'BURN IT'}
@@ -526,6 +1064,7 @@ export default function SettingsPage() {
                             </div>
                         </DialogContent>
                     </Dialog>
+                    )}
                 </section>
             </main>
         </div>
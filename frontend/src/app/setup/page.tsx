@@ -15,6 +15,7 @@ export default function SetupPage() {
     const [username, setUsername] = useState('');
     const [checking, setChecking] = useState(false);
     const [available, setAvailable] = useState<boolean | null>(null);
+    const [unavailableReason, setUnavailableReason] = useState<string | null>(null);
     const [loading, setLoading] = useState(false);
     const [checkingAuth, setCheckingAuth] = useState(true);
     const [minLoading, setMinLoading] = useState(true);
@@ -58,14 +59,18 @@ export default function SetupPage() {
 
         const timeoutId = setTimeout(async () => {
             setChecking(true);
-            const { data, error } = await supabase
-                .from('profiles')
-                .select('username')
-                .eq('username', username.toLowerCase())
-                .maybeSingle();
-
-            if (!error) {
-                setAvailable(!data);
+            try {
+                const { data: { session } } = await supabase.auth.getSession();
+                const response = await fetch(`${process.env.NEXT_PUBLIC_BACKEND_URL || 'http://localhost:8080'}/username/available?username=${encodeURIComponent(username.toLowerCase())}`, {
+                    headers: { 'Authorization': `Bearer ${session?.access_token || ''}` }
+                });
+                if (response.ok) {
+                    const data = await response.json();
+                    setAvailable(data.available);
+                    setUnavailableReason(data.reason || null);
+                }
+            } catch (err) {
+                console.error('Failed to check username:', err);
             }
             setChecking(false);
         }, 500);
@@ -88,18 +93,16 @@ export default function SetupPage() {
 
         try {
             const response = await fetch(`${process.env.NEXT_PUBLIC_BACKEND_URL || 'http://localhost:8080'}/profile`, {
-                method: 'PUT',
+                method: 'POST',
                 headers: {
                     'Content-Type': 'application/json',
                     'Authorization': `Bearer ${session.access_token}`
                 },
                 body: JSON.stringify({
-                    id: session.user.id,
                     username: username.toLowerCase(),
                     display_name: username.toLowerCase(),
                     avatar_url: session.user.user_metadata.avatar_url || '',
                     email: session.user.email,
-                    updated_at: new Date().toISOString(),
                 })
             });
 
@@ -108,7 +111,7 @@ export default function SetupPage() {
                 router.push('/inbox');
             } else {
                 const errData = await response.json();
-                toast.error(errData.error || 'Failed to create profile');
+                toast.error(errData.message || 'Failed to create profile');
             }
         } catch (err) {
             toast.error('Connection error');
@@ -172,7 +175,7 @@ export default function SetupPage() {
 
                             {available === false && username.length >= 3 && (
                                 <p className="text-red-500 text-xs font-mono uppercase tracking-widest pl-1">
-                                    Handle already taken
+                                    {unavailableReason || 'Handle already taken'}
                                 </p>
                             )}
 
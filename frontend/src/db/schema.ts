@@ -1,18 +1,38 @@
-import { pgTable, text, timestamp, boolean, uuid } from "drizzle-orm/pg-core";
+import { pgTable, text, timestamp, boolean, uuid, integer, unique, jsonb } from "drizzle-orm/pg-core";
 
 export const profiles = pgTable("profiles", {
     id: uuid("id").primaryKey(), // Usually mapped to auth.users.id
     username: text("username").unique().notNull(),
     displayName: text("display_name"),
     avatarUrl: text("avatar_url"),
+    avatarVariants: jsonb("avatar_variants"), // { small, medium, large } URLs of uploaded avatars
+    avatarKeys: text("avatar_keys").array(), // Blob store keys of the uploaded variants
     bio: text("bio"),
     email: text("email"),
     isPaused: boolean("is_paused").default(false),
     blockedPhrases: text("blocked_phrases").array().default([]),
+    whoCanSend: text("who_can_send", { enum: ["anyone", "signed_in", "friends", "followers"] }).default("anyone").notNull(),
+    minAccountAgeDays: integer("min_account_age_days").default(0).notNull(),
+    profileSort: text("profile_sort", { enum: ["newest", "most_liked", "most_bookmarked"] }).default("newest").notNull(), // Order of answers below the pinned ones
     createdAt: timestamp("created_at").defaultNow().notNull(),
     updatedAt: timestamp("updated_at").defaultNow().notNull(),
 });
 
+// Themed question boxes on a profile, each with its own link at /:username/:slug
+export const prompts = pgTable("prompts", {
+    id: uuid("id").defaultRandom().primaryKey(),
+    profileId: uuid("profile_id").references(() => profiles.id, { onDelete: 'cascade' }).notNull(),
+    slug: text("slug").notNull(),
+    title: text("title").notNull(),
+    description: text("description"),
+    opensAt: timestamp("opens_at", { withTimezone: true }), // Null: open from creation
+    closesAt: timestamp("closes_at", { withTimezone: true }), // Null: open until deleted
+    blockedPhrases: text("blocked_phrases").array().default([]).notNull(), // On top of the profile's
+    whoCanSend: text("who_can_send", { enum: ["anyone", "signed_in", "friends", "followers"] }), // Null: the profile's setting
+    createdAt: timestamp("created_at").defaultNow().notNull(),
+    updatedAt: timestamp("updated_at").defaultNow().notNull(),
+}, (t) => [unique().on(t.profileId, t.slug)]);
+
 export const messages = pgTable("messages", {
     id: uuid("id").defaultRandom().primaryKey(),
     receiverId: uuid("receiver_id").references(() => profiles.id, { onDelete: 'cascade' }).notNull(),
@@ -21,8 +41,17 @@ export const messages = pgTable("messages", {
     status: text("status", { enum: ["pending", "replied", "archived"] }).default("pending").notNull(),
     senderId: uuid("sender_id").references(() => profiles.id, { onDelete: 'set null' }),
     threadId: uuid("thread_id").defaultRandom().notNull(),
+    searchTokens: text("search_tokens").array(), // Blind index: keyed HMACs of normalized words
+    importHash: text("import_hash"), // Keyed hash of imported Q&A, for deduplication
+    pinnedAt: timestamp("pinned_at", { withTimezone: true }), // Pinned to the top of the receiver's profile
+    isHidden: boolean("is_hidden").default(false).notNull(), // Answered but kept off the profile
+    promptId: uuid("prompt_id").references(() => prompts.id, { onDelete: 'set null' }), // Null: the general inbox
+    readAt: timestamp("read_at", { withTimezone: true }), // Null: unread
+    isStarred: boolean("is_starred").default(false).notNull(),
+    labels: text("labels").array().default([]).notNull(), // Lowercased, at most 10
+    snoozedUntil: timestamp("snoozed_until", { withTimezone: true }), // Hidden from the inbox until then
     createdAt: timestamp("created_at").defaultNow().notNull(),
-});
+}, (t) => [unique().on(t.receiverId, t.importHash)]);
 
 export const replies = pgTable("replies", {
     id: uuid("id").defaultRandom().primaryKey(),
@@ -47,9 +76,87 @@ export const likes = pgTable("likes", {
     createdAt: timestamp("created_at").defaultNow().notNull(),
 });
 
+export const bookmarkCollections = pgTable("bookmark_collections", {
+    id: uuid("id").defaultRandom().primaryKey(),
+    userId: uuid("user_id").references(() => profiles.id, { onDelete: 'cascade' }).notNull(),
+    name: text("name").notNull(),
+    isPublic: boolean("is_public").default(false).notNull(),
+    position: integer("position").default(0).notNull(),
+    createdAt: timestamp("created_at").defaultNow().notNull(),
+    updatedAt: timestamp("updated_at").defaultNow().notNull(),
+});
+
 export const bookmarks = pgTable("bookmarks", {
     id: uuid("id").defaultRandom().primaryKey(),
     userId: uuid("user_id").references(() => profiles.id, { onDelete: 'cascade' }).notNull(),
     messageId: uuid("message_id").references(() => messages.id, { onDelete: 'cascade' }).notNull(),
+    collectionId: uuid("collection_id").references(() => bookmarkCollections.id, { onDelete: 'set null' }),
+    note: text("note"), // Encrypted, private to the owner
+    position: integer("position").default(0).notNull(),
+    createdAt: timestamp("created_at").defaultNow().notNull(),
+});
+
+// Only read by user search, which hides both sides of a block; blocks have no API of their own yet
+export const userBlocks = pgTable("user_blocks", {
+    id: uuid("id").defaultRandom().primaryKey(),
+    blockerId: uuid("blocker_id").references(() => profiles.id, { onDelete: 'cascade' }).notNull(),
+    blockedId: uuid("blocked_id").references(() => profiles.id, { onDelete: 'cascade' }).notNull(),
+    createdAt: timestamp("created_at").defaultNow().notNull(),
+}, (t) => [unique().on(t.blockerId, t.blockedId)]);
+
+export const usernameHistory = pgTable("username_history", {
+    id: uuid("id").defaultRandom().primaryKey(),
+    profileId: uuid("profile_id").references(() => profiles.id, { onDelete: 'cascade' }).notNull(),
+    username: text("username").notNull(), // The old username, kept for redirects
+    changedAt: timestamp("changed_at").defaultNow().notNull(),
+});
+
+// No foreign key: rows outlive the profile so the deletion report is kept
+export const accountDeletions = pgTable("account_deletions", {
+    id: uuid("id").defaultRandom().primaryKey(),
+    userId: uuid("user_id").notNull(),
+    username: text("username").notNull(),
+    status: text("status", { enum: ["scheduled", "running", "completed", "cancelled", "failed"] }).default("scheduled").notNull(),
+    wasPaused: boolean("was_paused").default(false).notNull(), // Restored if the deletion is cancelled
+    attempts: integer("attempts").default(0).notNull(),
+    lastError: text("last_error"),
+    report: jsonb("report"), // Row counts removed by the purge
+    requestedAt: timestamp("requested_at").defaultNow().notNull(),
+    scheduledFor: timestamp("scheduled_for").notNull(),
+    completedAt: timestamp("completed_at"),
+    updatedAt: timestamp("updated_at").defaultNow().notNull(), // Stale running purges are requeued
+});
+
+export const dataExports = pgTable("data_exports", {
+    id: uuid("id").defaultRandom().primaryKey(),
+    userId: uuid("user_id").references(() => profiles.id, { onDelete: 'cascade' }).notNull(),
+    status: text("status", { enum: ["queued", "running", "ready", "failed", "expired"] }).default("queued").notNull(),
+    progress: integer("progress").default(0).notNull(), // 0-100
+    step: text("step"),
+    blobKey: text("blob_key"), // Archive in the private exports bucket
+    sizeBytes: integer("size_bytes"),
+    error: text("error"),
+    createdAt: timestamp("created_at").defaultNow().notNull(),
+    updatedAt: timestamp("updated_at").defaultNow().notNull(),
+    completedAt: timestamp("completed_at"),
+    expiresAt: timestamp("expires_at"),
+});
+
+export const importJobs = pgTable("import_jobs", {
+    id: uuid("id").defaultRandom().primaryKey(),
+    userId: uuid("user_id").references(() => profiles.id, { onDelete: 'cascade' }).notNull(),
+    status: text("status", { enum: ["queued", "running", "completed", "failed", "cancelled"] }).default("queued").notNull(),
+    dryRun: boolean("dry_run").default(false).notNull(),
+    source: text("source"), // Where the Q&A came from, as named by the user
+    payload: text("payload"), // Encrypted validated rows, cleared once imported
+    total: integer("total").default(0).notNull(),
+    cursor: integer("cursor").default(0).notNull(), // Rows processed so far, for resuming
+    imported: integer("imported").default(0).notNull(),
+    duplicates: integer("duplicates").default(0).notNull(),
+    invalid: integer("invalid").default(0).notNull(),
+    issues: jsonb("issues"), // First rejected rows with reasons
+    error: text("error"),
     createdAt: timestamp("created_at").defaultNow().notNull(),
+    updatedAt: timestamp("updated_at").defaultNow().notNull(),
+    completedAt: timestamp("completed_at"),
 });
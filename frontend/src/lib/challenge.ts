@@ -0,0 +1,31 @@
+// Anonymous senders solve a proof-of-work puzzle before POST /send when the
+// backend asks for one: find a nonce so SHA-256(token + ":" + nonce) starts
+// with `difficulty` zero bits.
+
+function leadingZeroBits(digest: Uint8Array): number {
+    let bits = 0;
+    for (const byte of digest) {
+        if (byte === 0) {
+            bits += 8;
+            continue;
+        }
+        return bits + Math.clz32(byte) - 24;
+    }
+    return bits;
+}
+
+// Returns the `verification` value for /send, or undefined if the backend
+// doesn't require one.
+export async function solveSendChallenge(backendUrl: string, receiverId: string): Promise<string | undefined> {
+    const response = await fetch(`${backendUrl}/send/challenge?receiver_id=${encodeURIComponent(receiverId)}`);
+    if (!response.ok) throw new Error('Failed to fetch challenge');
+    const challenge = await response.json();
+    if (challenge.type !== 'pow') return undefined;
+
+    const encoder = new TextEncoder();
+    for (let nonce = 0; ; nonce++) {
+        const solution = `${challenge.token}:${nonce}`;
+        const digest = new Uint8Array(await crypto.subtle.digest('SHA-256', encoder.encode(solution)));
+        if (leadingZeroBits(digest) >= challenge.difficulty) return solution;
+    }
+}